@@ -0,0 +1,200 @@
+// Package audit records security relevant events such as key rotations, token issuance,
+// MFA validations and rejected requests. Events are written to one or more sinks,
+// PostgresSink chains events with keyed hashes so modifications of the trail can be detected.
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/ctxlog"
+)
+
+// Common actions, applications can use their own in addition to these.
+const (
+	ActionKeyRotate    = "key.rotate"
+	ActionTokenIssue   = "token.issue"
+	ActionAPIKeyIssue  = "apikey.issue"
+	ActionAPIKeyRevoke = "apikey.revoke"
+	ActionMFAVerify    = "mfa.verify"
+	ActionCSRFReject   = "csrf.reject"
+	ActionHTTPRequest  = "http.request"
+)
+
+// Outcome tells whether the audited action succeeded.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+	// OutcomeDenied is used when action was rejected by authentication or authorization.
+	OutcomeDenied Outcome = "denied"
+)
+
+// Request contains metadata of the HTTP request which caused the event.
+type Request struct {
+	ID        string `json:"id,omitempty"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Route     string `json:"route,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	RemoteIP  string `json:"remote_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Event is a single audit record.
+type Event struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Actor is the subject who performed the action in format of auth.User.MakeSub, empty for anonymous requests.
+	// For delegated tokens it is the current actor of the act claim.
+	Actor string `json:"actor,omitempty"`
+	// ActingFor contains parties the actor acts on behalf of, ending with subject of the token.
+	ActingFor []string          `json:"acting_for,omitempty"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target,omitempty"`
+	Outcome   Outcome           `json:"outcome"`
+	Reason    string            `json:"reason,omitempty"`
+	Request   *Request          `json:"request,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// Recorder records audit events.
+type Recorder interface {
+	Record(ctx context.Context, e Event) error
+}
+
+// RecordResult records event of an action which returned err. Outcome is OutcomeFailure with err
+// as reason when err is not nil, otherwise the given outcome or OutcomeSuccess. Nothing is recorded
+// with nil recorder and recording failures are only logged so they don't affect the action.
+func RecordResult(ctx context.Context, r Recorder, e Event, err error) {
+	if r == nil {
+		return
+	}
+	if err != nil {
+		e.Outcome = OutcomeFailure
+		e.Reason = err.Error()
+	}
+	if e.Outcome == "" {
+		e.Outcome = OutcomeSuccess
+	}
+	if err := r.Record(ctx, e); err != nil {
+		ctxlog.Error(ctx, "recording audit event failed", slog.String("error", err.Error()))
+	}
+}
+
+// RecorderFunc is an adapter to allow the use of ordinary functions as Recorder.
+type RecorderFunc func(ctx context.Context, e Event) error
+
+// Record calls fn(ctx, e).
+func (fn RecorderFunc) Record(ctx context.Context, e Event) error {
+	return fn(ctx, e)
+}
+
+// ErrMissingAction is returned when event doesn't have action.
+var ErrMissingAction = errors.New("audit event must have action")
+
+// Logger completes events and writes them to all sinks.
+type Logger struct {
+	sinks []Recorder
+}
+
+// New creates Logger writing to given sinks.
+func New(sinks ...Recorder) *Logger {
+	return &Logger{sinks: sinks}
+}
+
+// Record fills in missing ID, time, actor and request metadata and writes event to every sink.
+// Actor is taken from claims stored by auth/middleware and request from Middleware when found from ctx.
+// Every sink is tried even if some of them fail.
+func (l *Logger) Record(ctx context.Context, e Event) error {
+	if e.Action == "" {
+		return ErrMissingAction
+	}
+	if e.ID == "" {
+		id, err := auth.NewTokenID()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	// postgres stores microseconds, truncating keeps hashes stable after round trip
+	e.Time = e.Time.UTC().Truncate(time.Microsecond)
+	if e.Outcome == "" {
+		e.Outcome = OutcomeSuccess
+	}
+	if e.Actor == "" {
+		if claims, ok := middleware.ClaimsFromContext(ctx); ok {
+			e.Actor, e.ActingFor = Actor(claims)
+		}
+	}
+	if e.Request == nil {
+		if r, ok := ctx.Value(requestKey{}).(*Request); ok {
+			cp := *r
+			e.Request = &cp
+		}
+	}
+
+	var errs []error
+	for _, s := range l.sinks {
+		if err := s.Record(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("recording audit event failed: %w", err)
+	}
+	return nil
+}
+
+// Actor returns the party making requests with the claims and the parties it acts for, see Event.
+func Actor(claims *auth.UserJWTClaims) (string, []string) {
+	sub := claims.Subject
+	if sub == "" && claims.User != nil {
+		sub = claims.MakeSub()
+	}
+	var actingFor []string
+	for _, a := range claims.ActorChain() {
+		actingFor = append(actingFor, a.Subject)
+	}
+	if len(actingFor) == 0 {
+		return sub, nil
+	}
+	// the party doing the request is the current actor, the token subject is who it acts for
+	return actingFor[0], append(actingFor[1:], sub)
+}
+
+// TokenIssued returns ActionTokenIssue event for token issued with claims, it should be
+// recorded by the token endpoint after signing. Actor is the party the token was issued to.
+func TokenIssued(claims *auth.UserJWTClaims) Event {
+	e := Event{
+		Action:  ActionTokenIssue,
+		Target:  claims.ID,
+		Outcome: OutcomeSuccess,
+		Metadata: map[string]string{
+			"audience": strings.Join(claims.Audience, " "),
+			"scope":    claims.Scope,
+		},
+	}
+	if claims.ExpiresAt != nil {
+		e.Metadata["expires_at"] = claims.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	e.Actor, e.ActingFor = Actor(claims)
+	return e
+}
+
+type requestKey struct{}
+
+// WithRequest returns context which makes Logger attach r to events recorded with it.
+func WithRequest(ctx context.Context, r *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
@@ -0,0 +1,120 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	mem := audit.NewMemorySink()
+	buf := &bytes.Buffer{}
+	logger := audit.New(mem, audit.NewJSONSink(buf))
+
+	claims := &auth.UserJWTClaims{
+		User:             &auth.User{Email: common.Ptr("user@example.com")},
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "email=user@example.com"},
+		Actor:            &auth.Actor{Subject: "m2m=support-api", Actor: &auth.Actor{Subject: "email=admin@example.com"}},
+	}
+	ctx := middleware.WithClaims(context.Background(), claims)
+	ctx = audit.WithRequest(ctx, &audit.Request{ID: "req-1", Method: "POST", Path: "/users/1"})
+	require.NoError(t, logger.Record(ctx, audit.Event{Action: "user.delete", Target: "users/1"}))
+	require.ErrorIs(t, logger.Record(ctx, audit.Event{}), audit.ErrMissingAction)
+
+	events := mem.Events()
+	require.Len(t, events, 1)
+	e := events[0]
+	require.NotEmpty(t, e.ID)
+	require.WithinDuration(t, time.Now(), e.Time, time.Second)
+	require.Equal(t, time.UTC, e.Time.Location())
+	require.Equal(t, audit.OutcomeSuccess, e.Outcome)
+	require.Equal(t, "m2m=support-api", e.Actor)
+	require.Equal(t, []string{"email=admin@example.com", "email=user@example.com"}, e.ActingFor)
+	require.Equal(t, &audit.Request{ID: "req-1", Method: "POST", Path: "/users/1"}, e.Request)
+	require.Equal(t, events, mem.Find("user.delete"))
+	require.Empty(t, mem.Find(audit.ActionKeyRotate))
+
+	var decoded audit.Event
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, e, decoded)
+	require.Equal(t, 1, strings.Count(buf.String(), "\n"))
+
+	// explicitly set actor isn't replaced
+	require.NoError(t, logger.Record(ctx, audit.Event{Action: audit.ActionKeyRotate, Actor: "system"}))
+	require.Equal(t, "system", mem.Find(audit.ActionKeyRotate)[0].Actor)
+}
+
+func TestLoggerSinkFailure(t *testing.T) {
+	errSink := errors.New("sink unavailable")
+	mem := audit.NewMemorySink()
+	logger := audit.New(audit.RecorderFunc(func(context.Context, audit.Event) error {
+		return errSink
+	}), mem)
+
+	err := logger.Record(context.Background(), audit.Event{Action: audit.ActionCSRFReject, Outcome: audit.OutcomeDenied})
+	require.ErrorIs(t, err, errSink)
+	require.Len(t, mem.Events(), 1, "remaining sinks are written")
+	require.Empty(t, mem.Events()[0].Actor)
+}
+
+func TestRecordResult(t *testing.T) {
+	ctx := context.Background()
+	mem := audit.NewMemorySink()
+	audit.RecordResult(ctx, mem, audit.Event{Action: audit.ActionKeyRotate}, nil)
+	audit.RecordResult(ctx, mem, audit.Event{Action: audit.ActionKeyRotate}, errors.New("boom"))
+	audit.RecordResult(ctx, mem, audit.Event{Action: audit.ActionCSRFReject, Outcome: audit.OutcomeDenied}, nil)
+	audit.RecordResult(ctx, nil, audit.Event{Action: audit.ActionKeyRotate}, nil)
+
+	events := mem.Events()
+	require.Len(t, events, 3)
+	require.Equal(t, audit.OutcomeSuccess, events[0].Outcome)
+	require.Equal(t, audit.OutcomeFailure, events[1].Outcome)
+	require.Equal(t, "boom", events[1].Reason)
+	require.Equal(t, audit.OutcomeDenied, events[2].Outcome)
+
+	// recording failure doesn't panic or propagate
+	audit.RecordResult(ctx, audit.New(), audit.Event{}, nil)
+}
+
+func TestTokenIssued(t *testing.T) {
+	claims := &auth.UserJWTClaims{
+		User: &auth.User{Email: common.Ptr("user@example.com")},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-1",
+			Audience:  jwt.ClaimStrings{"api", "worker"},
+			ExpiresAt: jwt.NewNumericDate(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)),
+		},
+		Scope: "openid email",
+	}
+	e := audit.TokenIssued(claims)
+	require.Equal(t, audit.ActionTokenIssue, e.Action)
+	require.Equal(t, "jti-1", e.Target)
+	require.Equal(t, "email=user@example.com", e.Actor)
+	require.Equal(t, map[string]string{
+		"audience":   "api worker",
+		"scope":      "openid email",
+		"expires_at": "2030-01-01T00:00:00Z",
+	}, e.Metadata)
+}
+
+func TestChainHash(t *testing.T) {
+	key := []byte("key")
+	first := audit.ChainHash(key, "", []byte(`{"id":"1"}`))
+	second := audit.ChainHash(key, first, []byte(`{"id":"2"}`))
+	require.Len(t, first, 64)
+	require.Equal(t, second, audit.ChainHash(key, first, []byte(`{"id":"2"}`)))
+	require.NotEqual(t, second, audit.ChainHash(key, "", []byte(`{"id":"2"}`)))
+	require.NotEqual(t, second, audit.ChainHash(key, first, []byte(`{"id":"3"}`)))
+	require.NotEqual(t, second, audit.ChainHash([]byte("other"), first, []byte(`{"id":"2"}`)))
+}
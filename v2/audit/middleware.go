@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader is read to correlate audit events with other logs of the request.
+const RequestIDHeader = "X-Request-ID"
+
+var mutatingMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// Middleware records ActionHTTPRequest event for every mutating request and attaches
+// request metadata to events recorded by handlers. It should run before authentication
+// and CSRF middlewares so requests rejected by them are recorded as denied.
+// Recording failures are logged and don't affect the response.
+func Middleware(r Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := &Request{
+			ID:        c.GetHeader(RequestIDHeader),
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			RemoteIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}
+		c.Request = c.Request.WithContext(WithRequest(c.Request.Context(), req))
+		c.Next()
+
+		if !slices.Contains(mutatingMethods, req.Method) {
+			return
+		}
+		done := *req
+		done.Route = c.FullPath()
+		done.Status = c.Writer.Status()
+		e := Event{
+			Action:  ActionHTTPRequest,
+			Target:  done.Path,
+			Outcome: outcome(done.Status),
+			Request: &done,
+		}
+		if len(c.Errors) > 0 {
+			e.Reason = c.Errors.Last().Error()
+		}
+		// request ctx may already be canceled when client has gone away
+		RecordResult(context.WithoutCancel(c.Request.Context()), r, e, nil)
+	}
+}
+
+func outcome(status int) Outcome {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return OutcomeDenied
+	case status >= http.StatusBadRequest:
+		return OutcomeFailure
+	default:
+		return OutcomeSuccess
+	}
+}
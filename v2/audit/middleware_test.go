@@ -0,0 +1,107 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mem := audit.NewMemorySink()
+	logger := audit.New(mem)
+	verifier := middleware.VerifierFunc(func(_ context.Context, raw string, _ ...jwt.ParserOption) (*auth.UserJWTClaims, error) {
+		if raw != "valid" {
+			return nil, errors.New("invalid token")
+		}
+		return &auth.UserJWTClaims{User: &auth.User{Email: common.Ptr("user@example.com")}}, nil
+	})
+
+	r := gin.New()
+	r.Use(audit.Middleware(logger), middleware.New(verifier).Gin())
+	r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.DELETE("/items/:id", func(c *gin.Context) {
+		require.NoError(t, logger.Record(c.Request.Context(), audit.Event{Action: "item.delete", Target: c.Param("id")}))
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/items", func(c *gin.Context) {
+		_ = c.Error(errors.New("name is required"))
+		c.Status(http.StatusBadRequest)
+	})
+
+	do := func(method, path, token string) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(audit.RequestIDHeader, "req-1")
+		req.Header.Set("User-Agent", "test")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	do(http.MethodGet, "/items", "valid")
+	require.Empty(t, mem.Events(), "safe methods are not recorded")
+
+	do(http.MethodDelete, "/items/42", "valid")
+	events := mem.Events()
+	require.Len(t, events, 2)
+	require.Equal(t, "item.delete", events[0].Action)
+	require.Equal(t, "42", events[0].Target)
+	require.Equal(t, "email=user@example.com", events[0].Actor)
+	require.Equal(t, "req-1", events[0].Request.ID)
+	require.Equal(t, audit.ActionHTTPRequest, events[1].Action)
+	require.Equal(t, audit.OutcomeSuccess, events[1].Outcome)
+	require.Equal(t, "email=user@example.com", events[1].Actor)
+	require.Equal(t, &audit.Request{
+		ID:        "req-1",
+		Method:    http.MethodDelete,
+		Path:      "/items/42",
+		Route:     "/items/:id",
+		Status:    http.StatusNoContent,
+		RemoteIP:  "192.0.2.1",
+		UserAgent: "test",
+	}, events[1].Request)
+
+	do(http.MethodDelete, "/items/42", "invalid")
+	e := mem.Events()[2]
+	require.Equal(t, audit.OutcomeDenied, e.Outcome)
+	require.Empty(t, e.Actor)
+	require.Equal(t, http.StatusUnauthorized, e.Request.Status)
+
+	do(http.MethodPost, "/items", "valid")
+	e = mem.Events()[3]
+	require.Equal(t, audit.OutcomeFailure, e.Outcome)
+	require.Equal(t, "name is required", e.Reason)
+
+	// event is recorded after request ctx is canceled
+	ctxSink := audit.RecorderFunc(func(ctx context.Context, e audit.Event) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return mem.Record(ctx, e)
+	})
+	r = gin.New()
+	r.Use(func(c *gin.Context) {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Set("cancel", cancel)
+	}, audit.Middleware(audit.New(ctxSink)))
+	r.PUT("/items/:id", func(c *gin.Context) {
+		// client goes away while handler is running
+		cancel, _ := c.Get("cancel")
+		cancel.(context.CancelFunc)()
+		c.Status(http.StatusOK)
+	})
+	do(http.MethodPut, "/items/42", "valid")
+	e = mem.Events()[4]
+	require.Equal(t, "/items/42", e.Target)
+	require.Equal(t, "req-1", e.Request.ID)
+}
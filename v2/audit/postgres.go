@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elisasre/go-common/v2/sqlxutil"
+)
+
+// PostgresSchema contains append-only table required by PostgresSink.
+// Updates and deletes are rejected by trigger, retention should be handled by partitioning or
+// by archiving the table as a whole.
+const PostgresSchema = `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		seq bigserial PRIMARY KEY,
+		id text NOT NULL UNIQUE,
+		time timestamp with time zone NOT NULL,
+		actor text NOT NULL,
+		action text NOT NULL,
+		target text NOT NULL,
+		outcome text NOT NULL,
+		data text NOT NULL,
+		prev_hash text NOT NULL,
+		hash text NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor, time);
+	CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action, time);
+	CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_events is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+	DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+	CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+		FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();`
+
+// ErrChainBroken is returned by Verify when stored events don't match their hashes.
+var ErrChainBroken = errors.New("audit hash chain is broken")
+
+// advisory lock key serializing appends so every event is chained to its predecessor
+const chainLockKey = 0x61756469740a
+
+// ChainHash returns HMAC-SHA256 of event data chained to hash of the previous event,
+// prev is empty for the first event.
+func ChainHash(key []byte, prev string, data []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(prev))
+	h.Write([]byte{'\n'})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PostgresSink stores events in audit_events table, see PostgresSchema.
+// Every row contains keyed hash of the event chained to hash of the previous row.
+type PostgresSink struct {
+	db  *sqlxutil.DB
+	key []byte
+}
+
+// NewPostgresSink creates PostgresSink which chains events using key. The key must be kept
+// secret and stored outside the database, otherwise the whole chain can be recomputed after tampering.
+func NewPostgresSink(db *sqlxutil.DB, key []byte) *PostgresSink {
+	return &PostgresSink{db: db, key: key}
+}
+
+// Record appends event to the chain.
+func (s *PostgresSink) Record(ctx context.Context, e Event) error {
+	// time column must match the hashed data after round trip, see Verify
+	e.Time = e.Time.UTC().Truncate(time.Microsecond)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding audit event failed: %w", err)
+	}
+	return s.db.WithTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, chainLockKey); err != nil {
+			return fmt.Errorf("locking audit chain failed: %w", err)
+		}
+		var prev string
+		err := tx.GetContext(ctx, &prev, `SELECT hash FROM audit_events ORDER BY seq DESC LIMIT 1`)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("selecting previous audit event failed: %w", err)
+		}
+		const query = `
+			INSERT INTO audit_events (id, time, actor, action, target, outcome, data, prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+		_, err = tx.ExecContext(ctx, query, e.ID, e.Time, e.Actor, e.Action, e.Target, string(e.Outcome),
+			string(data), prev, ChainHash(s.key, prev, data))
+		if err != nil {
+			return fmt.Errorf("inserting audit event failed: %w", sqlxutil.ConflictWrap(err))
+		}
+		return nil
+	})
+}
+
+type rawEvent struct {
+	Seq      int64     `db:"seq"`
+	ID       string    `db:"id"`
+	Time     time.Time `db:"time"`
+	Actor    string    `db:"actor"`
+	Action   string    `db:"action"`
+	Target   string    `db:"target"`
+	Outcome  string    `db:"outcome"`
+	Data     string    `db:"data"`
+	PrevHash string    `db:"prev_hash"`
+	Hash     string    `db:"hash"`
+}
+
+// matchesData reports whether the queryable columns contain the same values as the hashed data.
+func (r rawEvent) matchesData() bool {
+	var e Event
+	if err := json.Unmarshal([]byte(r.Data), &e); err != nil {
+		return false
+	}
+	return r.ID == e.ID && r.Time.Equal(e.Time) && r.Actor == e.Actor && r.Action == e.Action &&
+		r.Target == e.Target && r.Outcome == string(e.Outcome)
+}
+
+// Events returns events recorded after since ordered by time, at most limit events.
+func (s *PostgresSink) Events(ctx context.Context, since time.Time, limit int) ([]Event, error) {
+	const query = `SELECT data FROM audit_events WHERE time > $1 ORDER BY seq LIMIT $2`
+	var rows []string
+	if err := s.db.SelectContext(ctx, &rows, query, since, limit); err != nil {
+		return nil, fmt.Errorf("selecting audit events failed: %w", err)
+	}
+	events := make([]Event, 0, len(rows))
+	for _, data := range rows {
+		var e Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, fmt.Errorf("decoding audit event failed: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Verify walks the whole chain and returns number of verified events.
+// ErrChainBroken is returned when any row has been modified, removed or inserted by someone without the key,
+// including rows whose hashes have been recomputed. Only data column is hashed, so the other columns
+// are checked against it. Removal of the newest rows isn't detected, it can be detected only by
+// comparing count to an earlier result. Anyone with the key can rewrite the whole chain.
+func (s *PostgresSink) Verify(ctx context.Context) (int, error) {
+	const query = `
+		SELECT seq, id, time, actor, action, target, outcome, data, prev_hash, hash
+		FROM audit_events ORDER BY seq`
+	rows, err := s.db.QueryxContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("selecting audit events failed: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	prev := ""
+	for rows.Next() {
+		var r rawEvent
+		if err := rows.StructScan(&r); err != nil {
+			return n, fmt.Errorf("scanning audit event failed: %w", err)
+		}
+		if r.PrevHash != prev || ChainHash(s.key, prev, []byte(r.Data)) != r.Hash || !r.matchesData() {
+			return n, fmt.Errorf("%w: at seq %d", ErrChainBroken, r.Seq)
+		}
+		prev = r.Hash
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("reading audit events failed: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,94 @@
+package audit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/elisasre/go-common/v2/sqlxutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	postgrestc "github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func TestPostgresSink(t *testing.T) {
+	ctx := context.Background()
+	postgresContainer, err := postgrestc.Run(ctx,
+		"postgres:16",
+		postgrestc.WithDatabase("postgres"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(5*time.Second)),
+	)
+	require.NoError(t, err)
+
+	dsn, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+	db, err := sqlx.Open("postgres", dsn)
+	require.NoError(t, err)
+	_, err = db.Exec(audit.PostgresSchema)
+	require.NoError(t, err)
+	// schema can be applied again on every startup
+	_, err = db.Exec(audit.PostgresSchema)
+	require.NoError(t, err)
+
+	sink := audit.NewPostgresSink(sqlxutil.Instrument(db), []byte("key"))
+	logger := audit.New(sink)
+	start := time.Now().Add(-time.Second)
+	for _, action := range []string{audit.ActionKeyRotate, audit.ActionMFAVerify, audit.ActionAPIKeyIssue} {
+		require.NoError(t, logger.Record(ctx, audit.Event{
+			Action:   action,
+			Actor:    "email=user@example.com",
+			Metadata: map[string]string{"k": "v"},
+		}))
+	}
+	n, err := sink.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	events, err := sink.Events(ctx, start, 2)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, audit.ActionKeyRotate, events[0].Action)
+	require.Equal(t, map[string]string{"k": "v"}, events[0].Metadata)
+
+	require.ErrorIs(t, sink.Record(ctx, events[0]), sqlxutil.ErrConflict)
+
+	// time with nanoseconds is stored so that it matches the data
+	require.NoError(t, sink.Record(ctx, audit.Event{
+		ID:      "direct",
+		Time:    time.Date(2026, 1, 2, 3, 4, 5, 123456789, time.UTC),
+		Action:  audit.ActionKeyRotate,
+		Outcome: audit.OutcomeSuccess,
+	}))
+	n, err = sink.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	// chain can't be verified, or recomputed, without the key
+	_, err = audit.NewPostgresSink(sqlxutil.Instrument(db), []byte("other")).Verify(ctx)
+	require.ErrorIs(t, err, audit.ErrChainBroken)
+
+	_, err = db.Exec(`UPDATE audit_events SET actor = 'someone' WHERE seq = 2`)
+	require.ErrorContains(t, err, "append-only")
+	_, err = db.Exec(`DELETE FROM audit_events WHERE seq = 2`)
+	require.ErrorContains(t, err, "append-only")
+
+	// privileged user can bypass the trigger but modification is detected
+	_, err = db.Exec(`ALTER TABLE audit_events DISABLE TRIGGER audit_events_append_only`)
+	require.NoError(t, err)
+	// columns which aren't hashed are checked against the data
+	_, err = db.Exec(`UPDATE audit_events SET actor = 'someone' WHERE seq = 3`)
+	require.NoError(t, err)
+	n, err = sink.Verify(ctx)
+	require.ErrorIs(t, err, audit.ErrChainBroken)
+	require.Equal(t, 2, n)
+	_, err = db.Exec(`UPDATE audit_events SET data = replace(data, 'user@example.com', 'other@example.com') WHERE seq = 2`)
+	require.NoError(t, err)
+	n, err = sink.Verify(ctx)
+	require.ErrorIs(t, err, audit.ErrChainBroken)
+	require.Equal(t, 1, n)
+}
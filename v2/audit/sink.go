@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// MemorySink keeps events in memory, it is meant for tests.
+type MemorySink struct {
+	events []Event
+	mu     sync.Mutex
+}
+
+// NewMemorySink creates empty MemorySink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// Record appends event to the sink.
+func (s *MemorySink) Record(_ context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+// Events returns recorded events in the order they were recorded.
+func (s *MemorySink) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.events)
+}
+
+// Find returns recorded events with given action.
+func (s *MemorySink) Find(action string) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []Event
+	for _, e := range s.events {
+		if e.Action == action {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// JSONSink writes events to w as newline delimited JSON, for example to stdout for log shipping.
+type JSONSink struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewJSONSink creates JSONSink writing to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+// Record writes event as a single line.
+func (s *JSONSink) Record(_ context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding audit event failed: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing audit event failed: %w", err)
+	}
+	return nil
+}
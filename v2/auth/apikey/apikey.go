@@ -0,0 +1,303 @@
+// Package apikey provides API keys for service accounts. Keys are prefixed and checksummed so
+// they can be recognized by secret scanners and rejected without storage lookup when mistyped.
+// Only SHA-256 hash of the key is stored.
+// Storage implementations can be found under: github.com/elisasre/go-common/v2/auth/store.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DefaultPrefix is the prefix of issued keys unless WithPrefix is used.
+	DefaultPrefix = "sak"
+
+	alphabet       = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	secretLength   = 32
+	checksumLength = 6
+)
+
+var (
+	ErrInvalidKey            = errors.New("invalid api key")
+	ErrKeyNotFound           = errors.New("api key not found")
+	ErrKeyExpired            = errors.New("api key has expired")
+	ErrKeyRevoked            = errors.New("api key has been revoked")
+	ErrMissingServiceAccount = errors.New("missing service account")
+)
+
+// Key is the stored state of API key. Key value itself is never stored, only its hash is.
+type Key struct {
+	// ID is public identifier used for managing the key.
+	ID string
+	// Hash is the hash of the key value.
+	Hash string
+	// Hint contains prefix and last characters of the key so users can recognize it.
+	Hint           string
+	Name           string
+	ServiceAccount string
+	Scopes         []string
+	CreatedAt      time.Time
+	// ExpiresAt is nil for keys which never expire.
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// User returns service account user of the key, its MakeSub returns "m2m=<service account>".
+func (k Key) User() *auth.User {
+	return &auth.User{Email: common.Ptr(k.ServiceAccount + auth.ServiceAccountPrefix)}
+}
+
+// Claims returns the key as claims so it can be used in place of verified access token.
+// Key ID is used as jti claim.
+func (k Key) Claims() *auth.UserJWTClaims {
+	user := k.User()
+	claims := &auth.UserJWTClaims{
+		User: user,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  user.MakeSub(),
+			IssuedAt: jwt.NewNumericDate(k.CreatedAt),
+			ID:       k.ID,
+		},
+		Scope: strings.Join(k.Scopes, " "),
+	}
+	if k.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*k.ExpiresAt)
+	}
+	return claims
+}
+
+// Store represents required storage interface.
+type Store interface {
+	// SaveAPIKey stores new API key.
+	SaveAPIKey(ctx context.Context, key Key) error
+	// APIKey returns API key by hash, ErrKeyNotFound is returned for unknown keys.
+	APIKey(ctx context.Context, hash string) (Key, error)
+	// ServiceAccountAPIKeys returns all keys of the service account ordered by creation time.
+	ServiceAccountAPIKeys(ctx context.Context, serviceAccount string) ([]Key, error)
+	// RevokeAPIKey revokes API key by ID, ErrKeyNotFound is returned for unknown keys.
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	// TouchAPIKey sets last used time of API key.
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// Manager issues and verifies API keys.
+type Manager struct {
+	store         Store
+	prefix        string
+	touchInterval time.Duration
+	recorder      audit.Recorder
+}
+
+type Opt func(*Manager)
+
+// WithPrefix sets prefix of issued keys, defaults to DefaultPrefix. Keys with other prefixes are rejected
+// so changing the prefix invalidates existing keys.
+func WithPrefix(prefix string) Opt {
+	return func(m *Manager) {
+		m.prefix = prefix
+	}
+}
+
+// WithLastUsedInterval limits how often last used time is updated, defaults to 1 minute.
+func WithLastUsedInterval(d time.Duration) Opt {
+	return func(m *Manager) {
+		m.touchInterval = d
+	}
+}
+
+// WithAuditRecorder records audit.ActionAPIKeyIssue and audit.ActionAPIKeyRevoke events, key values are never recorded.
+func WithAuditRecorder(r audit.Recorder) Opt {
+	return func(m *Manager) {
+		m.recorder = r
+	}
+}
+
+// New creates Manager using given store.
+func New(store Store, opts ...Opt) *Manager {
+	m := &Manager{store: store, prefix: DefaultPrefix, touchInterval: time.Minute}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Issue creates new API key for service account and returns the key value which is shown only once.
+// Service account may be given with or without auth.ServiceAccountPrefix, zero ttl creates key which never expires.
+func (m *Manager) Issue(ctx context.Context, serviceAccount, name string, scopes []string, ttl time.Duration) (string, Key, error) {
+	serviceAccount = strings.ToLower(strings.TrimSuffix(serviceAccount, auth.ServiceAccountPrefix))
+	value, key, err := m.issue(ctx, serviceAccount, name, scopes, ttl)
+	m.audit(ctx, audit.ActionAPIKeyIssue, key.ID, map[string]string{
+		"service_account": serviceAccount,
+		"name":            name,
+		"scopes":          strings.Join(scopes, " "),
+	}, err)
+	return value, key, err
+}
+
+func (m *Manager) issue(ctx context.Context, serviceAccount, name string, scopes []string, ttl time.Duration) (string, Key, error) {
+	if serviceAccount == "" {
+		return "", Key{}, ErrMissingServiceAccount
+	}
+	id, err := auth.NewTokenID()
+	if err != nil {
+		return "", Key{}, err
+	}
+	secret, err := randomString(secretLength)
+	if err != nil {
+		return "", Key{}, err
+	}
+	body := m.prefix + "_" + secret
+	value := body + checksum(body)
+
+	key := Key{
+		ID:             id,
+		Hash:           HashKey(value),
+		Hint:           m.prefix + "_..." + value[len(value)-4:],
+		Name:           name,
+		ServiceAccount: serviceAccount,
+		Scopes:         scopes,
+		CreatedAt:      time.Now().Round(time.Millisecond).UTC(),
+	}
+	if ttl != 0 {
+		key.ExpiresAt = common.Ptr(key.CreatedAt.Add(ttl))
+	}
+	if err := m.store.SaveAPIKey(ctx, key); err != nil {
+		return "", Key{}, fmt.Errorf("saving api key failed: %w", err)
+	}
+	return value, key, nil
+}
+
+// IsKey reports whether value looks like a key issued by the manager and has valid checksum.
+func (m *Manager) IsKey(value string) bool {
+	body, sum, ok := cutChecksum(value)
+	return ok && strings.HasPrefix(body, m.prefix+"_") && len(body) == len(m.prefix)+1+secretLength && checksum(body) == sum
+}
+
+// Lookup validates the key value and returns its state. Last used time is updated
+// if it is older than the interval set with WithLastUsedInterval.
+func (m *Manager) Lookup(ctx context.Context, value string) (Key, error) {
+	if !m.IsKey(value) {
+		return Key{}, ErrInvalidKey
+	}
+	key, err := m.store.APIKey(ctx, HashKey(value))
+	if err != nil {
+		return Key{}, err
+	}
+
+	now := time.Now()
+	switch {
+	case key.RevokedAt != nil:
+		return Key{}, ErrKeyRevoked
+	case key.ExpiresAt != nil && now.After(*key.ExpiresAt):
+		return Key{}, ErrKeyExpired
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= m.touchInterval {
+		// failing to track usage must not prevent using the key
+		if err := m.store.TouchAPIKey(ctx, key.ID, now); err != nil {
+			ctxlog.Warn(ctx, "updating api key last used time failed",
+				slog.String("id", key.ID),
+				slog.String("error", err.Error()),
+			)
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+	return key, nil
+}
+
+// Verify implements middleware.Verifier so API keys can be used with middleware.Authenticator.
+// JWT parser options are ignored since keys are not tokens.
+func (m *Manager) Verify(ctx context.Context, raw string, _ ...jwt.ParserOption) (*auth.UserJWTClaims, error) {
+	key, err := m.Lookup(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	return key.Claims(), nil
+}
+
+// Verifier returns middleware.Verifier which verifies API keys with the manager and other tokens with next,
+// so the same Authenticator accepts both access tokens and API keys.
+func (m *Manager) Verifier(next middleware.Verifier) middleware.Verifier {
+	return middleware.VerifierFunc(func(ctx context.Context, raw string, options ...jwt.ParserOption) (*auth.UserJWTClaims, error) {
+		if strings.HasPrefix(raw, m.prefix+"_") {
+			return m.Verify(ctx, raw)
+		}
+		return next.Verify(ctx, raw, options...)
+	})
+}
+
+// Revoke revokes API key by ID.
+func (m *Manager) Revoke(ctx context.Context, id string) error {
+	err := m.store.RevokeAPIKey(ctx, id, time.Now())
+	m.audit(ctx, audit.ActionAPIKeyRevoke, id, nil, err)
+	return err
+}
+
+func (m *Manager) audit(ctx context.Context, action, id string, metadata map[string]string, err error) {
+	audit.RecordResult(ctx, m.recorder, audit.Event{Action: action, Target: id, Metadata: metadata}, err)
+}
+
+// List returns keys of the service account.
+func (m *Manager) List(ctx context.Context, serviceAccount string) ([]Key, error) {
+	return m.store.ServiceAccountAPIKeys(ctx, strings.ToLower(strings.TrimSuffix(serviceAccount, auth.ServiceAccountPrefix)))
+}
+
+// HashKey returns identifier under which API key is stored.
+func HashKey(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func checksum(body string) string {
+	n := crc32.ChecksumIEEE([]byte(body))
+	b := make([]byte, checksumLength)
+	for i := checksumLength - 1; i >= 0; i-- {
+		b[i] = alphabet[n%uint32(len(alphabet))]
+		n /= uint32(len(alphabet))
+	}
+	return string(b)
+}
+
+func cutChecksum(value string) (string, string, bool) {
+	if len(value) <= checksumLength {
+		return "", "", false
+	}
+	return value[:len(value)-checksumLength], value[len(value)-checksumLength:], true
+}
+
+// randomString returns uniformly random base62 string.
+func randomString(n int) (string, error) {
+	// largest multiple of alphabet length which fits in a byte, larger values are rejected to avoid bias
+	const limit = 256 - 256%len(alphabet)
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("generating api key failed: %w", err)
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(out) < n {
+				out = append(out, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(out), nil
+}
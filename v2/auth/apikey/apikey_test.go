@@ -0,0 +1,169 @@
+package apikey_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/apikey"
+	"github.com/elisasre/go-common/v2/auth/cache"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/auth/policy"
+	"github.com/elisasre/go-common/v2/auth/store/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyFormat(t *testing.T) {
+	ctx := context.Background()
+	m := apikey.New(memory.New())
+	value, key, err := m.Issue(ctx, "deploy-bot", "ci", nil, time.Hour)
+	require.NoError(t, err)
+
+	require.True(t, strings.HasPrefix(value, apikey.DefaultPrefix+"_"))
+	require.Len(t, value, len(apikey.DefaultPrefix)+1+32+6)
+	require.True(t, m.IsKey(value))
+	require.Equal(t, apikey.HashKey(value), key.Hash)
+	require.NotContains(t, key.Hash, value)
+	require.Equal(t, apikey.DefaultPrefix+"_..."+value[len(value)-4:], key.Hint)
+
+	// any single character change is caught by the checksum
+	for _, i := range []int{len(apikey.DefaultPrefix) + 1, len(value) - 7, len(value) - 1} {
+		b := []byte(value)
+		b[i] ^= 0x01
+		require.False(t, m.IsKey(string(b)))
+		_, err := m.Lookup(ctx, string(b))
+		require.ErrorIs(t, err, apikey.ErrInvalidKey)
+	}
+	require.False(t, apikey.New(memory.New(), apikey.WithPrefix("other")).IsKey(value))
+	require.False(t, m.IsKey(""))
+
+	_, _, err = m.Issue(ctx, auth.ServiceAccountPrefix, "", nil, 0)
+	require.ErrorIs(t, err, apikey.ErrMissingServiceAccount)
+}
+
+func TestClaims(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	key := apikey.Key{
+		ID:             "key-1",
+		ServiceAccount: "deploy-bot",
+		Scopes:         []string{"read", "write"},
+		CreatedAt:      time.Now().Truncate(time.Second),
+		ExpiresAt:      &expiresAt,
+	}
+
+	user := key.User()
+	require.True(t, user.IsServiceAccount())
+	require.Equal(t, "m2m=deploy-bot", user.MakeSub())
+
+	claims := key.Claims()
+	require.Equal(t, "m2m=deploy-bot", claims.Subject)
+	require.Equal(t, "key-1", claims.ID)
+	require.Equal(t, []string{"read", "write"}, claims.Scopes())
+	require.True(t, expiresAt.Equal(claims.ExpiresAt.Time))
+}
+
+type countingStore struct {
+	*memory.Memory
+	touches int
+}
+
+func (s *countingStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	s.touches++
+	return s.Memory.TouchAPIKey(ctx, id, at)
+}
+
+func TestLastUsedInterval(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{Memory: memory.New()}
+	m := apikey.New(store, apikey.WithLastUsedInterval(time.Hour))
+	value, _, err := m.Issue(ctx, "deploy-bot", "", nil, 0)
+	require.NoError(t, err)
+
+	for range 3 {
+		_, err := m.Lookup(ctx, value)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, store.touches)
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	sink := audit.NewMemorySink()
+	m := apikey.New(memory.New(), apikey.WithAuditRecorder(audit.New(sink)))
+	value, key, err := m.Issue(ctx, "Deploy-Bot@oauth2", "ci", []string{"deploy", "read"}, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, m.Revoke(ctx, key.ID))
+	_, _, err = m.Issue(ctx, "", "ci", nil, 0)
+	require.ErrorIs(t, err, apikey.ErrMissingServiceAccount)
+
+	events := sink.Events()
+	require.Len(t, events, 3)
+	require.Equal(t, audit.ActionAPIKeyIssue, events[0].Action)
+	require.Equal(t, key.ID, events[0].Target)
+	require.Equal(t, map[string]string{"service_account": "deploy-bot", "name": "ci", "scopes": "deploy read"}, events[0].Metadata)
+	require.Equal(t, audit.ActionAPIKeyRevoke, events[1].Action)
+	require.Equal(t, key.ID, events[1].Target)
+	require.Equal(t, audit.OutcomeFailure, events[2].Outcome)
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		require.NoError(t, err)
+		require.NotContains(t, string(data), value)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+	m := apikey.New(memory.New())
+	value, _, err := m.Issue(ctx, "deploy-bot", "ci", []string{"deploy"}, time.Hour)
+	require.NoError(t, err)
+	readOnly, _, err := m.Issue(ctx, "reader", "ci", []string{"read"}, time.Hour)
+	require.NoError(t, err)
+
+	keys, err := cache.New(ctx, memory.New())
+	require.NoError(t, err)
+	token, err := auth.NewToken(&auth.User{Email: common.Ptr("user@example.com")}).SignExpires(keys.GetCurrentKey(), auth.SignClaims{
+		Exp:    time.Now().Add(time.Hour).Unix(),
+		Scopes: []string{auth.ScopeOpenID, auth.ScopeEmail, "deploy"},
+	})
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.New(m.Verifier(middleware.LocalVerifier(keys))).Gin())
+	r.POST("/deploy", policy.Gin(policy.RequireScopes("deploy")), func(c *gin.Context) {
+		user, _ := middleware.UserFromContext(c)
+		c.String(http.StatusOK, user.MakeSub())
+	})
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+		body  string
+	}{
+		{name: "api key", token: value, code: http.StatusOK, body: "m2m=deploy-bot"},
+		{name: "access token", token: token, code: http.StatusOK, body: "email=user@example.com"},
+		{name: "missing scope", token: readOnly, code: http.StatusForbidden},
+		{name: "invalid checksum", token: value[:len(value)-1] + "x", code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/deploy", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.code, w.Code)
+			if tt.body != "" {
+				require.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
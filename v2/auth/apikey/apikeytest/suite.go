@@ -0,0 +1,59 @@
+package apikeytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth/apikey"
+	"github.com/stretchr/testify/require"
+)
+
+func RunSuite(t *testing.T, store apikey.Store) {
+	ctx := context.Background()
+	m := apikey.New(store, apikey.WithLastUsedInterval(time.Hour))
+
+	value, key, err := m.Issue(ctx, "Deploy-Bot@oauth2", "ci", []string{"read", "write"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, "deploy-bot", key.ServiceAccount)
+	require.Nil(t, key.ExpiresAt)
+
+	got, err := m.Lookup(ctx, value)
+	require.NoError(t, err)
+	require.Equal(t, key.ID, got.ID)
+	require.Equal(t, key.Hint, got.Hint)
+	require.Equal(t, "ci", got.Name)
+	require.Equal(t, []string{"read", "write"}, got.Scopes)
+	require.True(t, key.CreatedAt.Equal(got.CreatedAt))
+	require.NotNil(t, got.LastUsedAt)
+
+	_, err = store.APIKey(ctx, apikey.HashKey("unknown"))
+	require.ErrorIs(t, err, apikey.ErrKeyNotFound)
+
+	// last used time is stored
+	keys, err := m.List(ctx, "deploy-bot")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.NotNil(t, keys[0].LastUsedAt)
+
+	// creation times are stored in millisecond precision
+	time.Sleep(2 * time.Millisecond)
+	expiring, _, err := m.Issue(ctx, "deploy-bot", "expiring", nil, -time.Second)
+	require.NoError(t, err)
+	_, err = m.Lookup(ctx, expiring)
+	require.ErrorIs(t, err, apikey.ErrKeyExpired)
+
+	_, _, err = m.Issue(ctx, "other", "", nil, time.Hour)
+	require.NoError(t, err)
+	keys, err = m.List(ctx, "deploy-bot@oauth2")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, key.ID, keys[0].ID)
+	require.Equal(t, "expiring", keys[1].Name)
+
+	require.NoError(t, m.Revoke(ctx, key.ID))
+	require.NoError(t, m.Revoke(ctx, key.ID), "revoking twice is allowed")
+	_, err = m.Lookup(ctx, value)
+	require.ErrorIs(t, err, apikey.ErrKeyRevoked)
+	require.ErrorIs(t, m.Revoke(ctx, "unknown"), apikey.ErrKeyNotFound)
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5" //nolint:gosec // G501: Blocklisted import crypto/md5: weak cryptographic primitive
@@ -16,22 +17,20 @@ import (
 	"math/big"
 	"net/http"
 	"net/url"
-	"os"
-	"strings"
 	"sync"
 	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/clientcredentials"
 )
 
 // JWTKey is struct for storing auth private keys.
 type JWTKey struct {
-	CreatedAt  time.Time       `yaml:"created_at" json:"created_at"`
-	KID        string          `yaml:"kid" json:"kid"`
-	PrivateKey *rsa.PrivateKey `yaml:"-" json:"-"`
-	PublicKey  *rsa.PublicKey  `yaml:"-" json:"-"`
+	CreatedAt time.Time `yaml:"created_at" json:"created_at"`
+	KID       string    `yaml:"kid" json:"kid"`
+	// Algorithm is the JWS signing algorithm of the key, empty value is derived from key type, see Alg.
+	Algorithm  string           `yaml:"alg" json:"alg,omitempty"`
+	PrivateKey crypto.Signer    `yaml:"-" json:"-"`
+	PublicKey  crypto.PublicKey `yaml:"-" json:"-"`
 }
 type OAuth2 struct {
 	ClientID         string
@@ -46,74 +45,50 @@ type ClientConfiguration struct {
 	OAuth2
 }
 
+// NewClient returns http.Client which authenticates requests using OAuth2 client credentials grant.
+// ClientSecretFile is watched for changes until ctx is done, so ctx must be cancelled to stop the watcher.
+// Configuration errors are returned by requests and the client is created again on the next request,
+// which allows the secret file to appear after NewClient. Use NewOAuth2Client to get errors immediately,
+// to stop watching with Close and to configure other client authentication methods.
 func NewClient(ctx context.Context, conf *ClientConfiguration) *http.Client {
-	rt := newOauth2RoundTripper(conf)
-	return &http.Client{Transport: otelhttp.NewTransport(rt)}
+	c, err := NewOAuth2Client(ctx, conf.OAuth2)
+	if err != nil {
+		return &http.Client{Transport: otelhttp.NewTransport(&lazyTransport{ctx: ctx, conf: conf.OAuth2})}
+	}
+	return c.HTTPClient()
 }
 
-type oauth2RoundTripper struct {
-	config *ClientConfiguration
-	rt     http.RoundTripper
-	secret string
-	mtx    sync.RWMutex
-	client *http.Client
-}
+// lazyTransport creates OAuth2Client on request until creating it succeeds once.
+type lazyTransport struct {
+	ctx  context.Context
+	conf OAuth2
 
-func newOauth2RoundTripper(config *ClientConfiguration) http.RoundTripper {
-	return &oauth2RoundTripper{
-		config: config,
-	}
+	mu sync.Mutex
+	rt http.RoundTripper
 }
 
-func (r *oauth2RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	var (
-		secret  string
-		changed bool
-	)
-
-	if r.config.ClientSecretFile != "" {
-		data, err := os.ReadFile(r.config.ClientSecretFile)
-		if err != nil {
-			return nil, fmt.Errorf("unable to read oauth2 client secret file %s: %w", r.config.ClientSecretFile, err)
+func (t *lazyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt, err := t.transport()
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
 		}
-		secret = strings.TrimSpace(string(data))
-		r.mtx.RLock()
-		changed = secret != r.secret
-		r.mtx.RUnlock()
-	} else {
-		secret = r.config.ClientSecret
+		return nil, err
 	}
+	return rt.RoundTrip(req)
+}
 
-	if changed || r.rt == nil {
-		config := &clientcredentials.Config{
-			ClientID:       r.config.ClientID,
-			ClientSecret:   secret,
-			Scopes:         r.config.Scopes,
-			TokenURL:       r.config.TokenURL,
-			EndpointParams: r.config.EndpointParams,
-		}
-
-		client := &http.Client{}
-		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
-		tokenSource := config.TokenSource(ctx)
-
-		r.mtx.Lock()
-		r.secret = secret
-		r.rt = &oauth2.Transport{
-			Base:   nil,
-			Source: tokenSource,
-		}
-		if r.client != nil {
-			r.client.CloseIdleConnections()
+func (t *lazyTransport) transport() (http.RoundTripper, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rt == nil {
+		c, err := NewOAuth2Client(t.ctx, t.conf)
+		if err != nil {
+			return nil, err
 		}
-		r.client = client
-		r.mtx.Unlock()
+		t.rt = c.Transport()
 	}
-
-	r.mtx.RLock()
-	currentRT := r.rt
-	r.mtx.RUnlock()
-	return currentRT.RoundTrip(req)
+	return t.rt, nil
 }
 
 // BasicAuth returns a base64 encoded string of the user and password.
@@ -139,6 +114,8 @@ func createHash(key string) string {
 
 // Encrypt the secret input with passphrase
 // source https://www.thepolyglotdeveloper.com/2018/02/encrypt-decrypt-data-golang-application-crypto-packages/
+//
+// Deprecated: key is derived using MD5 and output has no version, use github.com/elisasre/go-common/v2/secretbox.
 func Encrypt(data []byte, passphrase string) ([]byte, error) {
 	block, err := aes.NewCipher([]byte(createHash(passphrase)))
 	if err != nil {
@@ -157,6 +134,8 @@ func Encrypt(data []byte, passphrase string) ([]byte, error) {
 }
 
 // Decrypt the encrypted secret with passphrase.
+//
+// Deprecated: use github.com/elisasre/go-common/v2/secretbox with WithLegacyPassphrase.
 func Decrypt(data []byte, passphrase string) ([]byte, error) {
 	key := []byte(createHash(passphrase))
 	block, err := aes.NewCipher(key)
@@ -179,28 +158,9 @@ func Decrypt(data []byte, passphrase string) ([]byte, error) {
 	return plaintext, nil
 }
 
-// GenerateNewKeyPair generates new private and public keys.
+// GenerateNewKeyPair generates new RS256 private and public keys.
 func GenerateNewKeyPair() (JWTKey, error) {
-	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return JWTKey{}, fmt.Errorf("error generating RSA private key: %w", err)
-	}
-
-	err = rsaKey.Validate()
-	if err != nil {
-		return JWTKey{}, err
-	}
-
-	serial, err := BuildPKISerial()
-	if err != nil {
-		return JWTKey{}, err
-	}
-
-	return JWTKey{
-		KID:        serial.String(),
-		PrivateKey: rsaKey,
-		PublicKey:  &rsaKey.PublicKey,
-	}, nil
+	return GenerateNewKeyPairWithAlgorithm(AlgRS256)
 }
 
 // BuildPKISerial generates random big.Int.
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -85,6 +87,38 @@ func TestNewClientToken(t *testing.T) {
 	}
 }
 
+func TestNewClientMissingSecretFile(t *testing.T) {
+	secret := "late"
+	srv := mockSrv(secret)
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	file := filepath.Join(t.TempDir(), "secret")
+	c := auth.NewClient(ctx, &auth.ClientConfiguration{
+		OAuth2: auth.OAuth2{
+			ClientID: "clientid",
+			TokenURL: fmt.Sprintf("%s/oauth2/token", srv.URL),
+			Scopes:   []string{"openid", "email", "groups"},
+			EndpointParams: url.Values{
+				"groups": []string{"test"},
+			},
+			ClientSecretFile: file,
+		},
+	})
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// secret is read again on next request
+	require.NoError(t, os.WriteFile(file, []byte(secret), 0o600))
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
 type tokenRequest struct {
 	GrantType    string `form:"grant_type" json:"grant_type"`
 	Scope        string `form:"scope" json:"scope"`
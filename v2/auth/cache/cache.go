@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/elisasre/go-common/v2/audit"
 	"github.com/elisasre/go-common/v2/auth"
 )
 
@@ -18,15 +19,38 @@ type Datastore interface {
 
 // Cache provides in-memory owerlay for JWT key storage with key rotation functionality.
 type Cache struct {
-	keys   []auth.JWTKey
-	store  Datastore
-	keysMu sync.RWMutex
+	keys      []auth.JWTKey
+	store     Datastore
+	keysMu    sync.RWMutex
+	algorithm string
+	recorder  audit.Recorder
+}
+
+type Opt func(*Cache)
+
+// WithAlgorithm sets signing algorithm used for new keys created by RotateKeys, defaults to auth.AlgRS256.
+// Existing keys keep their own algorithm so tokens signed with them stay valid.
+func WithAlgorithm(alg string) Opt {
+	return func(c *Cache) {
+		c.algorithm = alg
+	}
+}
+
+// WithAuditRecorder records audit.ActionKeyRotate event for every key rotation attempt.
+func WithAuditRecorder(r audit.Recorder) Opt {
+	return func(c *Cache) {
+		c.recorder = r
+	}
 }
 
 // New init new database interface.
-func New(ctx context.Context, store Datastore) (*Cache, error) {
+func New(ctx context.Context, store Datastore, opts ...Opt) (*Cache, error) {
 	db := &Cache{
-		store: store,
+		store:     store,
+		algorithm: auth.AlgRS256,
+	}
+	for _, opt := range opts {
+		opt(db)
 	}
 	keys, err := db.store.ListJWTKeys(ctx)
 	if err != nil {
@@ -60,23 +84,40 @@ func (db *Cache) RotateKeys(ctx context.Context) error {
 	db.keysMu.Lock()
 	defer db.keysMu.Unlock()
 	start := time.Now()
-	keys, err := auth.GenerateNewKeyPair()
+	kid, err := db.rotateKeys(ctx)
+	db.audit(ctx, kid, err)
 	if err != nil {
-		return fmt.Errorf("error GenerateNewKeyPair: %w", err)
+		return err
+	}
+
+	slog.Info("JWT RotateKeys finished",
+		slog.Duration("duration", time.Since(start)),
+	)
+	return nil
+}
+
+func (db *Cache) rotateKeys(ctx context.Context) (string, error) {
+	keys, err := auth.GenerateNewKeyPairWithAlgorithm(db.algorithm)
+	if err != nil {
+		return "", fmt.Errorf("error GenerateNewKeyPair: %w", err)
 	}
 
 	if err := db.store.RotateJWTKeys(ctx, keys); err != nil {
-		return err
+		return keys.KID, err
 	}
 
 	if _, err := db.refreshKeys(ctx, true); err != nil {
-		return err
+		return keys.KID, err
 	}
+	return keys.KID, nil
+}
 
-	slog.Info("JWT RotateKeys finished",
-		slog.Duration("duration", time.Since(start)),
-	)
-	return nil
+func (db *Cache) audit(ctx context.Context, kid string, err error) {
+	audit.RecordResult(ctx, db.recorder, audit.Event{
+		Action:   audit.ActionKeyRotate,
+		Target:   kid,
+		Metadata: map[string]string{"algorithm": db.algorithm},
+	}, err)
 }
 
 func (db *Cache) refreshKeys(ctx context.Context, reload bool) ([]auth.JWTKey, error) {
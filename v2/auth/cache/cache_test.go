@@ -2,8 +2,10 @@ package cache_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
+	"github.com/elisasre/go-common/v2/audit"
 	"github.com/elisasre/go-common/v2/auth"
 	"github.com/elisasre/go-common/v2/auth/cache"
 	"github.com/stretchr/testify/require"
@@ -86,3 +88,47 @@ func TestGetAndRefresh(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, db.GetKeys(), data)
 }
+
+func TestRotateKeysWithAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	store := &DB{}
+	rs, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	require.Equal(t, auth.AlgRS256, rs.GetCurrentKey().Alg())
+
+	es, err := cache.New(ctx, store, cache.WithAlgorithm(auth.AlgES256))
+	require.NoError(t, err)
+	require.NoError(t, es.RotateKeys(ctx))
+	// test store appends new keys to the end
+	keys := es.GetKeys()
+	require.Len(t, keys, 2)
+	require.Equal(t, auth.AlgRS256, keys[0].Alg(), "existing keys keep their algorithm")
+	require.Equal(t, auth.AlgES256, keys[1].Alg())
+}
+
+type failingDB struct {
+	DB
+}
+
+func (store *failingDB) RotateJWTKeys(context.Context, auth.JWTKey) error {
+	return errors.New("database is read-only")
+}
+
+func TestRotateKeysAudit(t *testing.T) {
+	ctx := context.Background()
+	sink := audit.NewMemorySink()
+	db, err := cache.New(ctx, &DB{}, cache.WithAuditRecorder(audit.New(sink)))
+	require.NoError(t, err)
+	events := sink.Find(audit.ActionKeyRotate)
+	require.Len(t, events, 1)
+	require.Equal(t, audit.OutcomeSuccess, events[0].Outcome)
+	require.Equal(t, db.GetCurrentKey().KID, events[0].Target)
+	require.Equal(t, auth.AlgRS256, events[0].Metadata["algorithm"])
+
+	_, err = cache.New(ctx, &failingDB{}, cache.WithAuditRecorder(audit.New(sink)))
+	require.Error(t, err)
+	events = sink.Find(audit.ActionKeyRotate)
+	require.Len(t, events, 2)
+	require.Equal(t, audit.OutcomeFailure, events[1].Outcome)
+	require.Equal(t, "database is read-only", events[1].Reason)
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ScopeClaims maps scopes to claims which are included in token only when the scope is granted.
+// Claims which are not listed under any scope are always included.
+type ScopeClaims map[string][]string
+
+// DefaultScopeClaims contains standard OpenID Connect scopes and internal scope for claims of User.
+var DefaultScopeClaims = ScopeClaims{
+	ScopeEmail:    {"email", "email_verified"},
+	ScopeGroups:   {"groups"},
+	ScopeProfile:  {"name"},
+	ScopeInternal: {"internal"},
+}
+
+// Filter removes claims of scopes not granted in space separated scope claim.
+func (sc ScopeClaims) Filter(claims map[string]any) {
+	scope, _ := claims["scope"].(string)
+	granted := strings.Fields(scope)
+	for s, names := range sc {
+		if slices.Contains(granted, s) {
+			continue
+		}
+		for _, name := range names {
+			delete(claims, name)
+		}
+	}
+}
+
+type signConfig struct {
+	scopeClaims ScopeClaims
+}
+
+type SignOpt func(*signConfig)
+
+// WithScopeClaims removes claims of scopes which are not granted in scope claim of the token.
+func WithScopeClaims(sc ScopeClaims) SignOpt {
+	return func(c *signConfig) {
+		c.scopeClaims = sc
+	}
+}
+
+// SignWithClaims signs application specific claims. Claims are encoded using their JSON tags so
+// registered claims are typically provided by embedding jwt.RegisteredClaims. Given claims are never modified.
+func SignWithClaims[T any](key JWTKey, claims T, opts ...SignOpt) (string, error) {
+	cfg := &signConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if key.PrivateKey == nil {
+		return "", fmt.Errorf("privatekey is nil for key %s", key.KID)
+	}
+	method, err := signingMethod(key.Alg())
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("encoding claims failed: %w", err)
+	}
+	// numbers are kept as json.Number so large integers survive the round trip
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	body := jwt.MapClaims{}
+	if err := dec.Decode(&body); err != nil {
+		return "", fmt.Errorf("claims must be encoded as JSON object: %w", err)
+	}
+	if cfg.scopeClaims != nil {
+		cfg.scopeClaims.Filter(body)
+	}
+
+	token := jwt.Token{
+		Header: map[string]any{
+			"typ": "JWT",
+			"alg": method.Alg(),
+			"kid": key.KID,
+		},
+		Claims: body,
+		Method: method,
+	}
+	return token.SignedString(key.PrivateKey)
+}
+
+// ParseWithClaims verifies token signed with one of the keys and decodes its claims to T.
+// Expiration, not before and issued at claims are validated when present, use parser
+// options to require them or to validate audience and issuer.
+func ParseWithClaims[T any, PT interface {
+	*T
+	jwt.Claims
+}](raw string, keys []JWTKey, options ...jwt.ParserOption) (PT, error) {
+	options = append([]jwt.ParserOption{jwt.WithValidMethods(SupportedAlgorithms)}, options...)
+	claims := PT(new(T))
+	parsed, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		val, ok := t.Header["kid"]
+		if !ok {
+			return nil, errors.New("could not find kid from headers")
+		}
+		key, err := findKidFromArray(keys, val)
+		if err != nil {
+			return nil, err
+		}
+		// algorithm is pinned per key to prevent algorithm confusion
+		if t.Method.Alg() != key.Alg() {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key.PublicKey, nil
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+	if !parsed.Valid {
+		return nil, errors.New("jwt token was not valid")
+	}
+	return claims, nil
+}
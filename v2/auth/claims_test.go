@@ -0,0 +1,109 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+type tenantClaims struct {
+	jwt.RegisteredClaims
+	Scope    string   `json:"scope,omitempty"`
+	TenantID string   `json:"tenant_id"`
+	Roles    []string `json:"roles,omitempty"`
+	Billing  *int64   `json:"billing_account,omitempty"`
+}
+
+func TestSignWithClaims(t *testing.T) {
+	key, err := auth.GenerateNewKeyPairWithAlgorithm(auth.AlgES256)
+	require.NoError(t, err)
+
+	claims := tenantClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "email=user@example.com",
+			Audience:  jwt.ClaimStrings{"api", "worker"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+		Scope:    "openid roles",
+		TenantID: "tenant-1",
+		Roles:    []string{"admin"},
+		Billing:  common.Ptr(int64(9007199254740993)),
+	}
+	scopeClaims := auth.ScopeClaims{"roles": {"roles"}, "billing": {"billing_account"}}
+	raw, err := auth.SignWithClaims(key, claims, auth.WithScopeClaims(scopeClaims))
+	require.NoError(t, err)
+	require.NotNil(t, claims.Billing, "input claims must not be modified")
+
+	parsed, err := auth.ParseWithClaims[tenantClaims](raw, []auth.JWTKey{key}, jwt.WithAudience("worker"))
+	require.NoError(t, err)
+	require.Equal(t, "tenant-1", parsed.TenantID)
+	require.Equal(t, []string{"admin"}, parsed.Roles)
+	require.Nil(t, parsed.Billing, "billing scope isn't granted")
+	require.Equal(t, jwt.ClaimStrings{"api", "worker"}, parsed.Audience)
+
+	_, err = auth.ParseWithClaims[tenantClaims](raw, []auth.JWTKey{key}, jwt.WithAudience("other"))
+	require.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	// large integers are not rounded through float64
+	claims.Scope = "openid billing"
+	raw, err = auth.SignWithClaims(key, claims, auth.WithScopeClaims(scopeClaims))
+	require.NoError(t, err)
+	parsed, err = auth.ParseWithClaims[tenantClaims](raw, []auth.JWTKey{key})
+	require.NoError(t, err)
+	require.Equal(t, int64(9007199254740993), *parsed.Billing)
+	require.Nil(t, parsed.Roles)
+
+	other, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+	_, err = auth.ParseWithClaims[tenantClaims](raw, []auth.JWTKey{other})
+	require.Error(t, err)
+}
+
+func TestNotBefore(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+
+	raw, err := auth.NewToken(&auth.User{Email: common.Ptr("user@example.com")}).SignExpires(key, auth.SignClaims{
+		Exp:    time.Now().Add(time.Hour).Unix(),
+		Nbf:    time.Now().Add(time.Minute).Unix(),
+		Scopes: []string{auth.ScopeOpenID},
+	})
+	require.NoError(t, err)
+	_, err = auth.ParseToken(raw, []auth.JWTKey{key})
+	require.ErrorIs(t, err, jwt.ErrTokenNotValidYet)
+	_, err = auth.ParseToken(raw, []auth.JWTKey{key}, jwt.WithLeeway(2*time.Minute))
+	require.NoError(t, err)
+}
+
+func TestSignExpiresMultipleAudiences(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+	user := &auth.User{
+		Email:  common.Ptr("User@Example.com"),
+		Groups: []string{"admins"},
+		Name:   common.Ptr("User"),
+	}
+
+	raw, err := auth.NewToken(user).SignExpires(key, auth.SignClaims{
+		Aud:       "api",
+		Audiences: []string{"worker"},
+		Exp:       time.Now().Add(time.Hour).Unix(),
+		Scopes:    []string{auth.ScopeOpenID, auth.ScopeEmail},
+	})
+	require.NoError(t, err)
+	claims, err := auth.ParseToken(raw, []auth.JWTKey{key}, jwt.WithAudience("worker"))
+	require.NoError(t, err)
+	require.Equal(t, jwt.ClaimStrings{"api", "worker"}, claims.Audience)
+	require.Equal(t, "user@example.com", *claims.Email)
+	require.Nil(t, claims.Groups)
+
+	// signing doesn't drop claims from the caller's user
+	require.Equal(t, "User@Example.com", *user.Email)
+	require.Equal(t, []string{"admins"}, user.Groups)
+	require.Equal(t, "User", *user.Name)
+}
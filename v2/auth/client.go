@@ -0,0 +1,578 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/oauth2"
+)
+
+// Client authentication methods supported by OAuth2Client.
+const (
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+	AuthMethodTLSClientAuth     = "tls_client_auth"
+)
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+var (
+	ErrMissingTokenURL          = errors.New("missing oauth2 token url")
+	ErrMissingClientCredentials = errors.New("missing oauth2 client secret, private key or client certificate")
+	ErrInvalidAuthMethod        = errors.New("invalid oauth2 client authentication method")
+)
+
+// OAuth2Client fetches access tokens using client credentials grant and authenticates requests with them.
+// Tokens are shared with other clients using the same credentials through TokenCache.
+type OAuth2Client struct {
+	conf        OAuth2
+	authMethod  string
+	privateKey  *JWTKey
+	cert        *tls.Certificate
+	base        http.RoundTripper
+	tokenClient *http.Client
+	timeout     time.Duration
+	backoff     httputil.Backoff
+	cache       *TokenCache
+
+	secret   atomic.Pointer[string]
+	detected atomic.Pointer[string]
+	watcher  *fsnotify.Watcher
+	stopOnce sync.Once
+}
+
+type ClientOpt func(*OAuth2Client)
+
+// WithAuthMethod sets how client authenticates to token endpoint. By default client_secret_basic
+// is tried first and client_secret_post is used if server rejects it.
+func WithAuthMethod(method string) ClientOpt {
+	return func(c *OAuth2Client) {
+		c.authMethod = method
+	}
+}
+
+// WithPrivateKey enables private_key_jwt client authentication (RFC 7523) using the key.
+func WithPrivateKey(key JWTKey) ClientOpt {
+	return func(c *OAuth2Client) {
+		c.privateKey = &key
+	}
+}
+
+// WithClientCertificate sets TLS client certificate used for token and resource requests.
+// Without other credentials tls_client_auth (RFC 8705) is used. Certificate is added to the base
+// transport if it's *http.Transport, other transports must present the certificate themselves.
+func WithClientCertificate(cert tls.Certificate) ClientOpt {
+	return func(c *OAuth2Client) {
+		c.cert = &cert
+	}
+}
+
+// WithBaseTransport sets transport used for token and resource requests, defaults to http.DefaultTransport.
+func WithBaseTransport(rt http.RoundTripper) ClientOpt {
+	return func(c *OAuth2Client) {
+		c.base = rt
+	}
+}
+
+// WithTokenTimeout sets timeout of a single token request, defaults to 10 seconds.
+func WithTokenTimeout(d time.Duration) ClientOpt {
+	return func(c *OAuth2Client) {
+		c.timeout = d
+	}
+}
+
+// WithTokenBackoff sets retry strategy for token requests, the delay doubles after every try.
+// Defaults to 3 tries starting with 500ms delay.
+func WithTokenBackoff(b httputil.Backoff) ClientOpt {
+	return func(c *OAuth2Client) {
+		c.backoff = b
+	}
+}
+
+// WithTokenCache sets cache used for sharing tokens, defaults to process wide cache.
+func WithTokenCache(cache *TokenCache) ClientOpt {
+	return func(c *OAuth2Client) {
+		c.cache = cache
+	}
+}
+
+// NewOAuth2Client creates OAuth2Client. If ClientSecretFile is set the file is read
+// immediately and then watched for changes until ctx is done or Close is called.
+func NewOAuth2Client(ctx context.Context, conf OAuth2, opts ...ClientOpt) (*OAuth2Client, error) {
+	c := &OAuth2Client{
+		conf:    conf,
+		timeout: 10 * time.Second,
+		backoff: httputil.Backoff{Duration: 500 * time.Millisecond, MaxTries: 3},
+		cache:   defaultTokenCache,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if conf.TokenURL == "" {
+		return nil, ErrMissingTokenURL
+	}
+	c.secret.Store(&conf.ClientSecret)
+	if conf.ClientSecretFile != "" {
+		if err := c.reloadSecret(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.resolveAuthMethod(); err != nil {
+		return nil, err
+	}
+
+	if c.base == nil {
+		c.base = http.DefaultTransport
+	}
+	if t, ok := c.base.(*http.Transport); ok && c.cert != nil {
+		t = t.Clone()
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{*c.cert}
+		c.base = t
+	}
+	c.tokenClient = &http.Client{Transport: otelhttp.NewTransport(c.base), Timeout: c.timeout}
+
+	if conf.ClientSecretFile != "" {
+		if err := c.watchSecret(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *OAuth2Client) resolveAuthMethod() error {
+	hasSecret := c.conf.ClientSecret != "" || c.conf.ClientSecretFile != ""
+	if c.authMethod == "" {
+		switch {
+		case c.privateKey != nil:
+			c.authMethod = AuthMethodPrivateKeyJWT
+		case hasSecret:
+			return nil
+		case c.cert != nil:
+			c.authMethod = AuthMethodTLSClientAuth
+		default:
+			return ErrMissingClientCredentials
+		}
+	}
+
+	switch {
+	case c.authMethod == AuthMethodPrivateKeyJWT && c.privateKey == nil,
+		c.authMethod == AuthMethodTLSClientAuth && c.cert == nil && c.base == nil,
+		(c.authMethod == AuthMethodClientSecretBasic || c.authMethod == AuthMethodClientSecretPost) && !hasSecret:
+		return fmt.Errorf("%w: credentials required by %s are missing", ErrMissingClientCredentials, c.authMethod)
+	case !slices.Contains([]string{
+		AuthMethodClientSecretBasic,
+		AuthMethodClientSecretPost,
+		AuthMethodPrivateKeyJWT,
+		AuthMethodTLSClientAuth,
+	}, c.authMethod):
+		return fmt.Errorf("%w: '%s'", ErrInvalidAuthMethod, c.authMethod)
+	}
+	return nil
+}
+
+// watchSecret watches directory of the secret file because mounted secrets are usually
+// replaced by swapping symlinks which doesn't produce events for the file itself.
+func (c *OAuth2Client) watchSecret(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating oauth2 client secret watcher failed: %w", err)
+	}
+	if err := w.Add(filepath.Dir(c.conf.ClientSecretFile)); err != nil {
+		_ = w.Close()
+		return fmt.Errorf("watching oauth2 client secret file %s failed: %w", c.conf.ClientSecretFile, err)
+	}
+	c.watcher = w
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				_ = c.Close()
+				return
+			case _, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if err := c.reloadSecret(); err != nil {
+					slog.Warn("reloading oauth2 client secret failed",
+						slog.String("file", c.conf.ClientSecretFile),
+						slog.String("error", err.Error()),
+					)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("watching oauth2 client secret failed",
+					slog.String("file", c.conf.ClientSecretFile),
+					slog.String("error", err.Error()),
+				)
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *OAuth2Client) reloadSecret() error {
+	data, err := os.ReadFile(c.conf.ClientSecretFile)
+	if err != nil {
+		return fmt.Errorf("unable to read oauth2 client secret file %s: %w", c.conf.ClientSecretFile, err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return fmt.Errorf("oauth2 client secret file %s is empty", c.conf.ClientSecretFile)
+	}
+	c.secret.Store(&secret)
+	return nil
+}
+
+// Close stops watching the secret file.
+func (c *OAuth2Client) Close() error {
+	var err error
+	c.stopOnce.Do(func() {
+		if c.watcher != nil {
+			err = c.watcher.Close()
+		}
+	})
+	return err
+}
+
+// HTTPClient returns instrumented http.Client which authenticates requests with access token.
+func (c *OAuth2Client) HTTPClient() *http.Client {
+	return &http.Client{Transport: otelhttp.NewTransport(c.Transport())}
+}
+
+// Transport returns http.RoundTripper which authenticates requests with access token.
+// Cached token is dropped if resource server responds with 401 so next request fetches new one.
+func (c *OAuth2Client) Transport() http.RoundTripper {
+	return &oauth2Transport{client: c}
+}
+
+// TokenSource returns oauth2.TokenSource which fetches tokens using ctx.
+func (c *OAuth2Client) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return tokenSourceFunc(func() (*oauth2.Token, error) {
+		return c.Token(ctx)
+	})
+}
+
+// Token returns cached token or fetches new one if cached token has expired.
+// Concurrent calls for the same credentials fetch only one token.
+func (c *OAuth2Client) Token(ctx context.Context) (*oauth2.Token, error) {
+	entry := c.cache.entry(c.cacheKey())
+	if err := entry.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer entry.unlock()
+	if entry.token.Valid() {
+		return entry.token, nil
+	}
+
+	token, err := c.fetchToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entry.token = token
+	return token, nil
+}
+
+// cacheKey identifies credentials and requested token so only identical clients share tokens.
+func (c *OAuth2Client) cacheKey() string {
+	h := sha256.New()
+	scopes := slices.Sorted(slices.Values(c.conf.Scopes))
+	parts := []string{c.conf.TokenURL, c.conf.ClientID, c.authMethod, strings.Join(scopes, " "), c.conf.EndpointParams.Encode(), *c.secret.Load()}
+	if c.privateKey != nil {
+		parts = append(parts, c.privateKey.KID)
+	}
+	if c.cert != nil && len(c.cert.Certificate) > 0 {
+		parts = append(parts, string(c.cert.Certificate[0]))
+	}
+	for _, p := range parts {
+		_, _ = fmt.Fprintf(h, "%d:%s", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fetchToken requests token retrying server errors and network failures with exponential backoff.
+func (c *OAuth2Client) fetchToken(ctx context.Context) (*oauth2.Token, error) {
+	delay := c.backoff.Duration
+	for try := 1; ; try++ {
+		token, retry, err := c.requestToken(ctx)
+		if err == nil {
+			return token, nil
+		}
+		if !retry || try >= c.backoff.MaxTries {
+			return nil, err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		delay *= 2
+	}
+}
+
+func (c *OAuth2Client) requestToken(ctx context.Context) (*oauth2.Token, bool, error) {
+	method := c.authMethod
+	if method == "" {
+		method = AuthMethodClientSecretBasic
+		if detected := c.detected.Load(); detected != nil {
+			method = *detected
+		}
+	}
+
+	token, status, err := c.doTokenRequest(ctx, method)
+	if err != nil && c.authMethod == "" && method == AuthMethodClientSecretBasic &&
+		(status == http.StatusBadRequest || status == http.StatusUnauthorized) {
+		// server doesn't support basic authentication, fall back to credentials in request body
+		method = AuthMethodClientSecretPost
+		token, status, err = c.doTokenRequest(ctx, method)
+	}
+	if err != nil {
+		retry := status >= http.StatusInternalServerError || status == http.StatusTooManyRequests || (status == 0 && ctx.Err() == nil)
+		return nil, retry, err
+	}
+	if c.authMethod == "" {
+		c.detected.Store(&method)
+	}
+	return token, false, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type tokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	ErrorURI         string `json:"error_uri"`
+}
+
+// doTokenRequest makes single token request, returned status is zero if server didn't respond.
+func (c *OAuth2Client) doTokenRequest(ctx context.Context, method string) (*oauth2.Token, int, error) {
+	form := url.Values{}
+	for k, v := range c.conf.EndpointParams {
+		form[k] = slices.Clone(v)
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(c.conf.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.conf.Scopes, " "))
+	}
+
+	secret := *c.secret.Load()
+	switch method {
+	case AuthMethodClientSecretPost:
+		form.Set("client_id", c.conf.ClientID)
+		form.Set("client_secret", secret)
+	case AuthMethodPrivateKeyJWT:
+		assertion, err := c.clientAssertion()
+		if err != nil {
+			return nil, 0, err
+		}
+		form.Set("client_id", c.conf.ClientID)
+		form.Set("client_assertion_type", clientAssertionType)
+		form.Set("client_assertion", assertion)
+	case AuthMethodTLSClientAuth:
+		form.Set("client_id", c.conf.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.conf.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating oauth2 token request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if method == AuthMethodClientSecretBasic {
+		req.SetBasicAuth(url.QueryEscape(c.conf.ClientID), url.QueryEscape(secret))
+	}
+
+	resp, err := c.tokenClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("oauth2 token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("reading oauth2 token response failed: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retrieveErr := &oauth2.RetrieveError{Response: resp, Body: body}
+		var errResp tokenErrorResponse
+		if json.Unmarshal(body, &errResp) == nil {
+			retrieveErr.ErrorCode = errResp.Error
+			retrieveErr.ErrorDescription = errResp.ErrorDescription
+			retrieveErr.ErrorURI = errResp.ErrorURI
+		}
+		return nil, resp.StatusCode, retrieveErr
+	}
+
+	var tr tokenResponse
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" || mediaType == "text/plain" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("parsing oauth2 token response failed: %w", err)
+		}
+		tr.AccessToken = values.Get("access_token")
+		tr.TokenType = values.Get("token_type")
+		_, _ = fmt.Sscan(values.Get("expires_in"), &tr.ExpiresIn)
+	} else if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("parsing oauth2 token response failed: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return nil, resp.StatusCode, errors.New("oauth2 token response doesn't contain access_token")
+	}
+
+	token := &oauth2.Token{AccessToken: tr.AccessToken, TokenType: tr.TokenType}
+	if tr.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return token, resp.StatusCode, nil
+}
+
+// clientAssertion creates short lived JWT authenticating the client as described in RFC 7523.
+func (c *OAuth2Client) clientAssertion() (string, error) {
+	method, err := signingMethod(c.privateKey.Alg())
+	if err != nil {
+		return "", err
+	}
+	id, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Issuer:    c.conf.ClientID,
+		Subject:   c.conf.ClientID,
+		Audience:  jwt.ClaimStrings{c.conf.TokenURL},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		ID:        id,
+	})
+	token.Header["kid"] = c.privateKey.KID
+	signed, err := token.SignedString(c.privateKey.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("signing client assertion failed: %w", err)
+	}
+	return signed, nil
+}
+
+type oauth2Transport struct {
+	client *OAuth2Client
+}
+
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.client.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	authReq := req.Clone(req.Context())
+	token.SetAuthHeader(authReq)
+	resp, err := t.client.base.RoundTrip(authReq)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.client.cache.entry(t.client.cacheKey()).invalidate(token)
+	}
+	return resp, err
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) { return f() }
+
+// TokenCache shares access tokens between OAuth2Clients using the same credentials.
+type TokenCache struct {
+	mu      sync.Mutex
+	entries map[string]*tokenEntry
+}
+
+var defaultTokenCache = NewTokenCache()
+
+// NewTokenCache creates empty TokenCache.
+func NewTokenCache() *TokenCache {
+	return &TokenCache{entries: map[string]*tokenEntry{}}
+}
+
+type tokenEntry struct {
+	sem   chan struct{}
+	token *oauth2.Token
+}
+
+func (tc *TokenCache) entry(key string) *tokenEntry {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if e, ok := tc.entries[key]; ok {
+		return e
+	}
+	// drop entries of rotated credentials once their tokens have expired
+	for k, e := range tc.entries {
+		if !e.tryLock() {
+			continue
+		}
+		if e.token != nil && !e.token.Valid() {
+			delete(tc.entries, k)
+		}
+		e.unlock()
+	}
+	e := &tokenEntry{sem: make(chan struct{}, 1)}
+	tc.entries[key] = e
+	return e
+}
+
+func (e *tokenEntry) lock(ctx context.Context) error {
+	select {
+	case e.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *tokenEntry) tryLock() bool {
+	select {
+	case e.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *tokenEntry) unlock() {
+	<-e.sem
+}
+
+func (e *tokenEntry) invalidate(token *oauth2.Token) {
+	_ = e.lock(context.Background())
+	defer e.unlock()
+	if e.token == token {
+		e.token = nil
+	}
+}
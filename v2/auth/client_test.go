@@ -0,0 +1,327 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// tokenServer is fake OAuth2 server which issues tokens for valid client credentials
+// and accepts them on its resource endpoint.
+type tokenServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	secret    string
+	verify    func(r *http.Request) bool
+	failures  int
+	requests  atomic.Int32
+	issued    atomic.Int32
+	reject401 atomic.Bool
+	tokens    map[string]bool
+}
+
+func newTokenServer(t *testing.T, secret string) *tokenServer {
+	t.Helper()
+	s := &tokenServer{secret: secret, tokens: map[string]bool{}}
+	s.Server = httptest.NewServer(s.handler())
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		ok := false
+		if s.verify != nil {
+			ok = s.verify(r)
+		} else {
+			ok = r.PostForm.Get("client_id") == "clientid" && r.PostForm.Get("client_secret") == s.secret
+		}
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"bad credentials"}`))
+			return
+		}
+		token := fmt.Sprintf("token-%d", s.issued.Add(1))
+		s.tokens[token] = true
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": token, "token_type": "Bearer", "expires_in": 3600})
+	})
+	mux.HandleFunc("GET /resource", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		valid := s.tokens[r.Header.Get("Authorization")[len("Bearer "):]]
+		s.mu.Unlock()
+		if !valid || s.reject401.CompareAndSwap(true, false) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func (s *tokenServer) conf(secret string) auth.OAuth2 {
+	return auth.OAuth2{
+		ClientID:     "clientid",
+		ClientSecret: secret,
+		TokenURL:     s.URL + "/token",
+		Scopes:       []string{"openid"},
+	}
+}
+
+func get(t *testing.T, c *http.Client, url string) int {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return resp.StatusCode
+}
+
+func TestOAuth2ClientSharedCache(t *testing.T) {
+	ctx := context.Background()
+	srv := newTokenServer(t, "secret")
+	cache := auth.NewTokenCache()
+
+	first, err := auth.NewOAuth2Client(ctx, srv.conf("secret"), auth.WithTokenCache(cache), auth.WithAuthMethod(auth.AuthMethodClientSecretPost))
+	require.NoError(t, err)
+	second, err := auth.NewOAuth2Client(ctx, srv.conf("secret"), auth.WithTokenCache(cache), auth.WithAuthMethod(auth.AuthMethodClientSecretPost))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for _, c := range []*auth.OAuth2Client{first, second, first, second} {
+		wg.Go(func() {
+			_, err := c.Token(ctx)
+			errs <- err
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, http.StatusOK, get(t, second.HTTPClient(), srv.URL+"/resource"))
+	require.Equal(t, int32(1), srv.issued.Load(), "clients with same credentials share token")
+
+	other := srv.conf("secret")
+	other.Scopes = []string{"openid", "email"}
+	third, err := auth.NewOAuth2Client(ctx, other, auth.WithTokenCache(cache), auth.WithAuthMethod(auth.AuthMethodClientSecretPost))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, get(t, third.HTTPClient(), srv.URL+"/resource"))
+	require.Equal(t, int32(2), srv.issued.Load(), "different scopes use separate token")
+
+	// token rejected by resource server is not reused
+	srv.reject401.Store(true)
+	require.Equal(t, http.StatusUnauthorized, get(t, first.HTTPClient(), srv.URL+"/resource"))
+	require.Equal(t, http.StatusOK, get(t, second.HTTPClient(), srv.URL+"/resource"))
+	require.Equal(t, int32(3), srv.issued.Load())
+}
+
+func TestOAuth2ClientAuthMethodDetection(t *testing.T) {
+	srv := newTokenServer(t, "secret")
+	c, err := auth.NewOAuth2Client(context.Background(), srv.conf("secret"), auth.WithTokenCache(auth.NewTokenCache()))
+	require.NoError(t, err)
+	_, err = c.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(2), srv.requests.Load(), "basic auth is tried first")
+
+	_, err = auth.NewOAuth2Client(context.Background(), srv.conf("secret"), auth.WithAuthMethod("unknown"))
+	require.ErrorIs(t, err, auth.ErrInvalidAuthMethod)
+	_, err = auth.NewOAuth2Client(context.Background(), srv.conf(""))
+	require.ErrorIs(t, err, auth.ErrMissingClientCredentials)
+	_, err = auth.NewOAuth2Client(context.Background(), auth.OAuth2{ClientSecret: "secret"})
+	require.ErrorIs(t, err, auth.ErrMissingTokenURL)
+}
+
+func TestOAuth2ClientSecretFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	srv := newTokenServer(t, "first")
+	file := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(file, []byte("first\n"), 0o600))
+
+	conf := srv.conf("")
+	conf.ClientSecretFile = file
+	cache := auth.NewTokenCache()
+	c, err := auth.NewOAuth2Client(ctx, conf, auth.WithTokenCache(cache), auth.WithAuthMethod(auth.AuthMethodClientSecretPost))
+	require.NoError(t, err)
+	_, err = c.Token(ctx)
+	require.NoError(t, err)
+
+	srv.mu.Lock()
+	srv.secret = "second"
+	srv.mu.Unlock()
+	require.NoError(t, os.WriteFile(file, []byte("second\n"), 0o600))
+	require.Eventually(t, func() bool {
+		// token cached for old secret is not reused after rotation
+		token, err := c.Token(ctx)
+		return err == nil && token.AccessToken == "token-2"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	conf.ClientSecretFile = filepath.Join(t.TempDir(), "missing")
+	_, err = auth.NewOAuth2Client(ctx, conf)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestOAuth2ClientRetries(t *testing.T) {
+	ctx := context.Background()
+	srv := newTokenServer(t, "secret")
+	srv.failures = 2
+	opts := []auth.ClientOpt{
+		auth.WithTokenCache(auth.NewTokenCache()),
+		auth.WithAuthMethod(auth.AuthMethodClientSecretPost),
+		auth.WithTokenBackoff(httputil.Backoff{Duration: time.Millisecond, MaxTries: 3}),
+	}
+	c, err := auth.NewOAuth2Client(ctx, srv.conf("secret"), opts...)
+	require.NoError(t, err)
+	_, err = c.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), srv.requests.Load())
+
+	// client errors are not retried
+	c, err = auth.NewOAuth2Client(ctx, srv.conf("wrong"), opts...)
+	require.NoError(t, err)
+	_, err = c.Token(ctx)
+	retrieveErr := &oauth2.RetrieveError{}
+	require.ErrorAs(t, err, &retrieveErr)
+	require.Equal(t, "invalid_client", retrieveErr.ErrorCode)
+	require.Equal(t, int32(4), srv.requests.Load())
+}
+
+func TestOAuth2ClientContext(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(block) })
+
+	c, err := auth.NewOAuth2Client(context.Background(), auth.OAuth2{ClientID: "clientid", ClientSecret: "secret", TokenURL: srv.URL},
+		auth.WithTokenCache(auth.NewTokenCache()),
+		auth.WithTokenTimeout(50*time.Millisecond),
+		auth.WithTokenBackoff(httputil.Backoff{Duration: time.Millisecond, MaxTries: 2}),
+	)
+	require.NoError(t, err)
+	_, err = c.Token(context.Background())
+	require.Error(t, err, "token request times out")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Token(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestOAuth2ClientPrivateKeyJWT(t *testing.T) {
+	key, err := auth.GenerateNewKeyPairWithAlgorithm(auth.AlgES256)
+	require.NoError(t, err)
+	srv := newTokenServer(t, "")
+	srv.verify = func(r *http.Request) bool {
+		if r.PostForm.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+			return false
+		}
+		claims := jwt.RegisteredClaims{}
+		_, err := jwt.ParseWithClaims(r.PostForm.Get("client_assertion"), &claims, func(*jwt.Token) (any, error) {
+			return key.PublicKey, nil
+		}, jwt.WithAudience(srv.URL+"/token"), jwt.WithIssuer("clientid"), jwt.WithExpirationRequired())
+		return err == nil && claims.Subject == "clientid" && claims.ID != ""
+	}
+
+	c, err := auth.NewOAuth2Client(context.Background(), srv.conf(""), auth.WithPrivateKey(key), auth.WithTokenCache(auth.NewTokenCache()))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, get(t, c.HTTPClient(), srv.URL+"/resource"))
+}
+
+func TestOAuth2ClientMTLS(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "clientid"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, clientKey.Public(), caKey)
+	require.NoError(t, err)
+
+	srv := &tokenServer{tokens: map[string]bool{}}
+	srv.verify = func(r *http.Request) bool {
+		return r.PostForm.Get("client_id") == "clientid" &&
+			len(r.TLS.PeerCertificates) == 1 &&
+			r.TLS.PeerCertificates[0].Subject.CommonName == "clientid"
+	}
+	srv.Server = httptest.NewUnstartedServer(srv.handler())
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	c, err := auth.NewOAuth2Client(context.Background(), srv.conf(""),
+		auth.WithClientCertificate(tls.Certificate{Certificate: [][]byte{der}, PrivateKey: clientKey}),
+		auth.WithBaseTransport(srv.Client().Transport),
+		auth.WithTokenCache(auth.NewTokenCache()),
+	)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, get(t, c.HTTPClient(), srv.URL+"/resource"))
+
+	// without certificate TLS handshake fails
+	c, err = auth.NewOAuth2Client(context.Background(), srv.conf(""),
+		auth.WithAuthMethod(auth.AuthMethodTLSClientAuth),
+		auth.WithBaseTransport(srv.Client().Transport),
+		auth.WithTokenCache(auth.NewTokenCache()),
+		auth.WithTokenBackoff(httputil.Backoff{Duration: time.Millisecond, MaxTries: 1}),
+	)
+	require.NoError(t, err)
+	_, err = c.Token(context.Background())
+	require.Error(t, err)
+	require.False(t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// OAuth 2.0 Token Exchange (RFC 8693) identifiers.
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// DefaultExchangeTTL is the lifetime of exchanged tokens when ExchangeRequest doesn't set TTL.
+const DefaultExchangeTTL = 5 * time.Minute
+
+var (
+	ErrInvalidExchange = errors.New("invalid token exchange request")
+	ErrScopeNotGranted = errors.New("requested scope is not granted to the subject token")
+)
+
+// Actor is RFC 8693 act claim identifying party acting on behalf of the subject.
+// Nested Actor is the previous actor in delegation chain.
+type Actor struct {
+	Subject string `json:"sub"`
+	Issuer  string `json:"iss,omitempty"`
+	Actor   *Actor `json:"act,omitempty"`
+}
+
+// ActorChain returns actors of the token starting from the current actor, empty when
+// token is used by the subject itself. Returned actors don't contain nested actors.
+func (c *UserJWTClaims) ActorChain() []Actor {
+	var chain []Actor
+	for a := c.Actor; a != nil; a = a.Actor {
+		chain = append(chain, Actor{Subject: a.Subject, Issuer: a.Issuer})
+	}
+	return chain
+}
+
+// ExchangeRequest describes token requested in token exchange.
+type ExchangeRequest struct {
+	// Subject contains verified claims of the subject token. New token is issued to the same user
+	// and can't outlive it or contain scopes it doesn't have.
+	Subject *UserJWTClaims
+	// Actor contains verified claims of the actor token when token is requested on behalf of the subject.
+	// It is recorded in act claim with actors of the subject token nested in it.
+	Actor *UserJWTClaims
+	// Audience is the only audience of the new token.
+	Audience string
+	// Scopes defaults to scopes of the subject token, openid is always included.
+	Scopes []string
+	// TTL defaults to DefaultExchangeTTL.
+	TTL    time.Duration
+	Issuer string
+}
+
+// Exchange issues token for subject of req.Subject with narrowed scopes, single audience and limited lifetime.
+// Verifying subject and actor tokens and deciding whether actor may act for the subject is up to the caller.
+func Exchange(key JWTKey, req ExchangeRequest) (string, error) {
+	if req.Subject == nil {
+		return "", fmt.Errorf("%w: missing subject", ErrInvalidExchange)
+	}
+	if req.Audience == "" {
+		return "", fmt.Errorf("%w: missing audience", ErrInvalidExchange)
+	}
+
+	granted := req.Subject.Scopes()
+	scopes := []string{ScopeOpenID}
+	if len(req.Scopes) == 0 {
+		req.Scopes = granted
+	}
+	for _, s := range req.Scopes {
+		if !slices.Contains(granted, s) {
+			return "", fmt.Errorf("%w: '%s'", ErrScopeNotGranted, s)
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = DefaultExchangeTTL
+	}
+	now := time.Now()
+	exp := now.Add(ttl)
+	for _, c := range []*UserJWTClaims{req.Subject, req.Actor} {
+		if c != nil && c.ExpiresAt != nil && c.ExpiresAt.Before(exp) {
+			exp = c.ExpiresAt.Time
+		}
+	}
+	if !exp.After(now) {
+		return "", fmt.Errorf("%w: token has expired", ErrInvalidExchange)
+	}
+
+	actor := req.Subject.Actor
+	if req.Actor != nil {
+		if req.Actor.Subject == "" {
+			return "", fmt.Errorf("%w: actor token doesn't contain sub claim", ErrInvalidExchange)
+		}
+		actor = &Actor{Subject: req.Actor.Subject, Issuer: req.Actor.Issuer, Actor: req.Subject.Actor}
+	}
+
+	sub := req.Subject.Subject
+	if sub == "" {
+		sub = req.Subject.MakeSub()
+	}
+	return NewToken(req.Subject.User).SignExpires(key, SignClaims{
+		Subject: sub,
+		Aud:     req.Audience,
+		Exp:     exp.Unix(),
+		Iat:     now.Unix(),
+		Issuer:  req.Issuer,
+		Scopes:  scopes,
+		Actor:   actor,
+	})
+}
+
+// Impersonate issues token for user on behalf of actor, for example to let administrator act as the user.
+// Token can't contain scopes or outlive the actor token. Authorizing the impersonation is up to the caller.
+func Impersonate(key JWTKey, user *User, actor *UserJWTClaims, req ExchangeRequest) (string, error) {
+	if user == nil || actor == nil {
+		return "", fmt.Errorf("%w: missing user or actor", ErrInvalidExchange)
+	}
+	req.Subject = &UserJWTClaims{User: user, Scope: actor.Scope}
+	req.Actor = actor
+	return Exchange(key, req)
+}
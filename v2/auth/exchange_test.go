@@ -0,0 +1,108 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func signTestToken(t *testing.T, key auth.JWTKey, user auth.User, ttl time.Duration, scopes ...string) *auth.UserJWTClaims {
+	t.Helper()
+	raw, err := auth.NewToken(&user).SignExpires(key, auth.SignClaims{
+		Aud:    "frontend",
+		Exp:    time.Now().Add(ttl).Unix(),
+		Issuer: "https://auth.example.com",
+		Scopes: append([]string{auth.ScopeOpenID}, scopes...),
+	})
+	require.NoError(t, err)
+	claims, err := auth.ParseToken(raw, []auth.JWTKey{key})
+	require.NoError(t, err)
+	return claims
+}
+
+func TestExchangeDownscope(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+	subject := signTestToken(t, key, auth.User{
+		Email:  common.Ptr("user@example.com"),
+		Groups: []string{"devs"},
+	}, time.Hour, auth.ScopeEmail, auth.ScopeGroups)
+
+	raw, err := auth.Exchange(key, auth.ExchangeRequest{
+		Subject:  subject,
+		Audience: "billing",
+		Scopes:   []string{auth.ScopeEmail},
+		Issuer:   "https://auth.example.com",
+	})
+	require.NoError(t, err)
+
+	claims, err := auth.ParseToken(raw, []auth.JWTKey{key}, jwt.WithAudience("billing"))
+	require.NoError(t, err)
+	require.Equal(t, subject.Subject, claims.Subject)
+	require.Equal(t, []string{auth.ScopeOpenID, auth.ScopeEmail}, claims.Scopes())
+	require.Equal(t, jwt.ClaimStrings{"billing"}, claims.Audience)
+	require.Nil(t, claims.Groups, "groups scope was dropped")
+	require.Nil(t, claims.Actor)
+	require.Empty(t, claims.ActorChain())
+	require.WithinDuration(t, time.Now().Add(auth.DefaultExchangeTTL), claims.ExpiresAt.Time, 2*time.Second)
+	require.Equal(t, []string{"devs"}, subject.Groups, "subject claims must not be modified")
+
+	_, err = auth.Exchange(key, auth.ExchangeRequest{Subject: subject, Audience: "billing", Scopes: []string{auth.ScopeInternal}})
+	require.ErrorIs(t, err, auth.ErrScopeNotGranted)
+	_, err = auth.Exchange(key, auth.ExchangeRequest{Subject: subject})
+	require.ErrorIs(t, err, auth.ErrInvalidExchange)
+	_, err = auth.Exchange(key, auth.ExchangeRequest{Audience: "billing"})
+	require.ErrorIs(t, err, auth.ErrInvalidExchange)
+}
+
+func TestExchangeLifetime(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+	subject := signTestToken(t, key, auth.User{Email: common.Ptr("user@example.com")}, time.Minute)
+
+	// exchanged token can't outlive the subject token
+	raw, err := auth.Exchange(key, auth.ExchangeRequest{Subject: subject, Audience: "billing", TTL: time.Hour})
+	require.NoError(t, err)
+	claims, err := auth.ParseToken(raw, []auth.JWTKey{key})
+	require.NoError(t, err)
+	require.Equal(t, subject.ExpiresAt.Unix(), claims.ExpiresAt.Unix())
+
+	subject.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second))
+	_, err = auth.Exchange(key, auth.ExchangeRequest{Subject: subject, Audience: "billing"})
+	require.ErrorIs(t, err, auth.ErrInvalidExchange)
+}
+
+func TestImpersonate(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+	admin := signTestToken(t, key, auth.User{Email: common.Ptr("admin@example.com")}, 10*time.Minute, auth.ScopeEmail)
+	user := &auth.User{Email: common.Ptr("User@example.com"), Name: common.Ptr("User")}
+
+	raw, err := auth.Impersonate(key, user, admin, auth.ExchangeRequest{Audience: "support", TTL: time.Hour})
+	require.NoError(t, err)
+	claims, err := auth.ParseToken(raw, []auth.JWTKey{key})
+	require.NoError(t, err)
+	require.Equal(t, "email=user@example.com", claims.Subject)
+	require.Equal(t, []auth.Actor{{Subject: "email=admin@example.com", Issuer: "https://auth.example.com"}}, claims.ActorChain())
+	require.Nil(t, claims.Name, "profile scope isn't granted to the actor")
+	require.Equal(t, admin.ExpiresAt.Unix(), claims.ExpiresAt.Unix())
+
+	_, err = auth.Impersonate(key, user, admin, auth.ExchangeRequest{Audience: "support", Scopes: []string{auth.ScopeProfile}})
+	require.ErrorIs(t, err, auth.ErrScopeNotGranted)
+
+	// service called by the impersonated token exchanges it further, previous actor is nested
+	service := signTestToken(t, key, auth.User{Email: common.Ptr("support-api@oauth2")}, time.Hour)
+	raw, err = auth.Exchange(key, auth.ExchangeRequest{Subject: claims, Actor: service, Audience: "billing"})
+	require.NoError(t, err)
+	claims, err = auth.ParseToken(raw, []auth.JWTKey{key})
+	require.NoError(t, err)
+	require.Equal(t, "email=user@example.com", claims.Subject)
+	require.Equal(t, []auth.Actor{
+		{Subject: "m2m=support-api", Issuer: "https://auth.example.com"},
+		{Subject: "email=admin@example.com", Issuer: "https://auth.example.com"},
+	}, claims.ActorChain())
+}
@@ -0,0 +1,273 @@
+package jwks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrKeyNotFound   = errors.New("signing key not found")
+	ErrUnknownIssuer = errors.New("unknown token issuer")
+)
+
+// RemoteKeySet fetches keys from remote JWKS URL and caches them.
+// Keys are refetched when cache TTL expires or when key with unknown kid is requested.
+// Refetching on unknown kid is rate limited so invalid tokens can't be used to flood the issuer.
+type RemoteKeySet struct {
+	url                string
+	client             httputil.HTTPClient
+	backoff            httputil.Backoff
+	ttl                time.Duration
+	minRefreshInterval time.Duration
+
+	mu          sync.Mutex
+	keys        []auth.JWTKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+	group       singleflight.Group
+}
+
+type RemoteOpt func(*RemoteKeySet)
+
+// WithHTTPClient sets client used for fetching keys, defaults to http.Client with 10 second timeout.
+func WithHTTPClient(c httputil.HTTPClient) RemoteOpt {
+	return func(s *RemoteKeySet) {
+		s.client = c
+	}
+}
+
+// WithBackoff sets retry strategy for fetching keys, defaults to 3 tries with 1 second interval.
+func WithBackoff(b httputil.Backoff) RemoteOpt {
+	return func(s *RemoteKeySet) {
+		s.backoff = b
+	}
+}
+
+// WithTTL sets how long fetched keys are cached, defaults to 15 minutes.
+func WithTTL(d time.Duration) RemoteOpt {
+	return func(s *RemoteKeySet) {
+		s.ttl = d
+	}
+}
+
+// WithMinRefreshInterval sets minimum interval between fetches triggered by unknown kid
+// or retrying a failed fetch, defaults to 30 seconds.
+func WithMinRefreshInterval(d time.Duration) RemoteOpt {
+	return func(s *RemoteKeySet) {
+		s.minRefreshInterval = d
+	}
+}
+
+// NewRemoteKeySet creates RemoteKeySet for given JWKS URL. Keys are fetched lazily on first use.
+func NewRemoteKeySet(url string, opts ...RemoteOpt) *RemoteKeySet {
+	s := &RemoteKeySet{
+		url:                url,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		backoff:            httputil.Backoff{Duration: time.Second, MaxTries: 3},
+		ttl:                15 * time.Minute,
+		minRefreshInterval: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewRemoteKeySetFromIssuer resolves JWKS URL using issuer's OpenID discovery document.
+func NewRemoteKeySetFromIssuer(ctx context.Context, issuer string, opts ...RemoteOpt) (*RemoteKeySet, error) {
+	s := NewRemoteKeySet("", opts...)
+	md := ProviderMetadata{}
+	_, err := httputil.MakeRequest(ctx, httputil.Request{
+		Method: http.MethodGet,
+		URL:    strings.TrimSuffix(issuer, "/") + DiscoveryPath,
+		OKCode: []int{http.StatusOK},
+	}, &md, s.client, s.backoff)
+	if err != nil {
+		return nil, fmt.Errorf("fetching discovery document for %s failed: %w", issuer, err)
+	}
+	if md.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s doesn't contain jwks_uri", issuer)
+	}
+	s.url = md.JWKSURI
+	return s, nil
+}
+
+// GetKeys returns cached keys without fetching, it's safe to use as KeySource.
+func (s *RemoteKeySet) GetKeys() []auth.JWTKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]auth.JWTKey(nil), s.keys...)
+}
+
+// Keys returns cached keys, fetching them first if cache is empty or expired.
+// Stale keys are returned if fetching fails and keys have been fetched before.
+// If keys have never been fetched, failed fetch is retried at most once per minimum refresh interval
+// and the error of the last fetch is returned in between.
+func (s *RemoteKeySet) Keys(ctx context.Context) ([]auth.JWTKey, error) {
+	s.mu.Lock()
+	keys := s.keys
+	throttled := time.Since(s.lastAttempt) < s.minRefreshInterval
+	if keys == nil && s.lastErr != nil && throttled {
+		err := s.lastErr
+		s.mu.Unlock()
+		return nil, err
+	}
+	// callers without keys join the fetch in progress
+	refresh := keys == nil || time.Since(s.fetchedAt) > s.ttl && !throttled
+	if refresh {
+		s.lastAttempt = time.Now()
+	}
+	s.mu.Unlock()
+
+	if refresh {
+		fresh, err := s.refresh(ctx)
+		if err == nil {
+			return fresh, nil
+		}
+		if keys == nil {
+			return nil, err
+		}
+	}
+	return append([]auth.JWTKey(nil), keys...), nil
+}
+
+// Key returns key with given kid, refetching keys if kid is unknown and rate limit allows it.
+func (s *RemoteKeySet) Key(ctx context.Context, kid string) (auth.JWTKey, error) {
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		return auth.JWTKey{}, err
+	}
+	if key, ok := findKey(keys, kid); ok {
+		return key, nil
+	}
+
+	s.mu.Lock()
+	// another goroutine might have already fetched the key
+	if key, ok := findKey(s.keys, kid); ok {
+		s.mu.Unlock()
+		return key, nil
+	}
+	if time.Since(s.lastAttempt) < s.minRefreshInterval {
+		s.mu.Unlock()
+		return auth.JWTKey{}, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+	}
+	s.lastAttempt = time.Now()
+	s.mu.Unlock()
+
+	keys, err = s.refresh(ctx)
+	if err != nil {
+		return auth.JWTKey{}, err
+	}
+	if key, ok := findKey(keys, kid); ok {
+		return key, nil
+	}
+	return auth.JWTKey{}, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+}
+
+// refresh fetches keys without holding s.mu so that verifications using cached keys aren't blocked.
+// Concurrent callers share the same fetch, it isn't cancelled when ctx of the caller is done.
+func (s *RemoteKeySet) refresh(ctx context.Context) ([]auth.JWTKey, error) {
+	ch := s.group.DoChan("jwks", func() (any, error) {
+		fetchCtx := context.WithoutCancel(ctx)
+		set := Set{}
+		_, err := httputil.MakeRequest(fetchCtx, httputil.Request{
+			Method: http.MethodGet,
+			URL:    s.url,
+			OKCode: []int{http.StatusOK},
+		}, &set, s.client, s.backoff)
+		if err != nil {
+			ctxlog.Error(fetchCtx, "fetching JWKS failed", slog.String("url", s.url), slog.String("error", err.Error()))
+			err = fmt.Errorf("fetching JWKS from %s failed: %w", s.url, err)
+			s.mu.Lock()
+			s.lastErr = err
+			s.mu.Unlock()
+			return nil, err
+		}
+
+		keys := set.JWTKeys()
+		s.mu.Lock()
+		s.keys = keys
+		s.fetchedAt = time.Now()
+		s.lastErr = nil
+		s.mu.Unlock()
+		return keys, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return append([]auth.JWTKey(nil), res.Val.([]auth.JWTKey)...), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func findKey(keys []auth.JWTKey, kid string) (auth.JWTKey, bool) {
+	for _, k := range keys {
+		if k.KID == kid {
+			return k, true
+		}
+	}
+	return auth.JWTKey{}, false
+}
+
+// Verifier verifies tokens issued by one or more remote issuers.
+type Verifier struct {
+	issuers map[string]*RemoteKeySet
+}
+
+type VerifierOpt func(*Verifier)
+
+// WithIssuer trusts tokens whose iss claim equals issuer and which are signed with keys from given key set.
+func WithIssuer(issuer string, keys *RemoteKeySet) VerifierOpt {
+	return func(v *Verifier) {
+		v.issuers[issuer] = keys
+	}
+}
+
+// NewVerifier creates Verifier for trusted issuers configured with WithIssuer.
+func NewVerifier(opts ...VerifierOpt) *Verifier {
+	v := &Verifier{issuers: map[string]*RemoteKeySet{}}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Verify validates raw token and returns user with jwt claims like auth.ParseToken.
+// Issuer is always validated, additional validation such as audience can be added using options.
+func (v *Verifier) Verify(ctx context.Context, raw string, options ...jwt.ParserOption) (*auth.UserJWTClaims, error) {
+	unverified := &jwt.RegisteredClaims{}
+	token, _, err := jwt.NewParser().ParseUnverified(raw, unverified)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, ok := v.issuers[unverified.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownIssuer, unverified.Issuer)
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, err := keys.Key(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	options = append(options, jwt.WithIssuer(unverified.Issuer))
+	return auth.ParseToken(raw, []auth.JWTKey{key}, options...)
+}
@@ -0,0 +1,210 @@
+package jwks_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/cache"
+	"github.com/elisasre/go-common/v2/auth/jwks"
+	"github.com/elisasre/go-common/v2/auth/store/memory"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// issuer is httptest stand-in for token issuer publishing its keys.
+type issuer struct {
+	*httptest.Server
+	keys    *cache.Cache
+	fetches atomic.Int32
+}
+
+func newIssuer(t *testing.T, alg string) *issuer {
+	t.Helper()
+	c, err := cache.New(context.Background(), memory.New(), cache.WithAlgorithm(alg))
+	require.NoError(t, err)
+
+	iss := &issuer{keys: c}
+	mux := http.NewServeMux()
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	jwks.NewServer(c, jwks.WithMetadata(jwks.ProviderMetadata{Issuer: iss.URL})).Register(mux)
+	mux.HandleFunc("GET "+jwks.JWKSPath+"/counted", func(w http.ResponseWriter, r *http.Request) {
+		iss.fetches.Add(1)
+		r.URL.Path = jwks.JWKSPath
+		mux.ServeHTTP(w, r)
+	})
+	return iss
+}
+
+func (iss *issuer) sign(t *testing.T, aud string) string {
+	t.Helper()
+	token, err := auth.NewToken(&auth.User{Name: common.Ptr("Test User")}).SignExpires(iss.keys.GetCurrentKey(), auth.SignClaims{
+		Aud:    aud,
+		Exp:    time.Now().Add(time.Hour).Unix(),
+		Issuer: iss.URL,
+		Scopes: auth.AllScopes,
+	})
+	require.NoError(t, err)
+	return token
+}
+
+func (iss *issuer) keySet(opts ...jwks.RemoteOpt) *jwks.RemoteKeySet {
+	opts = append([]jwks.RemoteOpt{jwks.WithBackoff(httputil.Backoff{Duration: time.Millisecond, MaxTries: 1})}, opts...)
+	return jwks.NewRemoteKeySet(iss.URL+jwks.JWKSPath+"/counted", opts...)
+}
+
+func TestVerifier(t *testing.T) {
+	ctx := context.Background()
+	iss := newIssuer(t, auth.AlgES256)
+	v := jwks.NewVerifier(jwks.WithIssuer(iss.URL, iss.keySet(jwks.WithMinRefreshInterval(time.Hour))))
+
+	claims, err := v.Verify(ctx, iss.sign(t, "app"), jwt.WithAudience("app"))
+	require.NoError(t, err)
+	require.Equal(t, "Test User", *claims.Name)
+	require.Equal(t, iss.URL, claims.Issuer)
+
+	_, err = v.Verify(ctx, iss.sign(t, "other"), jwt.WithAudience("app"))
+	require.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+	require.EqualValues(t, 1, iss.fetches.Load(), "keys should be cached")
+
+	// new key is not found because refresh was done recently
+	require.NoError(t, iss.keys.RotateKeys(ctx))
+	_, err = v.Verify(ctx, iss.sign(t, "app"))
+	require.ErrorIs(t, err, jwks.ErrKeyNotFound)
+	require.EqualValues(t, 1, iss.fetches.Load())
+}
+
+func TestVerifierRefreshOnUnknownKid(t *testing.T) {
+	ctx := context.Background()
+	iss := newIssuer(t, auth.AlgEdDSA)
+	v := jwks.NewVerifier(jwks.WithIssuer(iss.URL, iss.keySet(jwks.WithMinRefreshInterval(0))))
+
+	_, err := v.Verify(ctx, iss.sign(t, "app"))
+	require.NoError(t, err)
+
+	require.NoError(t, iss.keys.RotateKeys(ctx))
+	_, err = v.Verify(ctx, iss.sign(t, "app"))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, iss.fetches.Load())
+}
+
+func TestVerifierMultipleIssuers(t *testing.T) {
+	ctx := context.Background()
+	iss1 := newIssuer(t, auth.AlgRS256)
+	iss2 := newIssuer(t, auth.AlgES256)
+	ks2, err := jwks.NewRemoteKeySetFromIssuer(ctx, iss2.URL)
+	require.NoError(t, err)
+
+	v := jwks.NewVerifier(
+		jwks.WithIssuer(iss1.URL, iss1.keySet()),
+		jwks.WithIssuer(iss2.URL, ks2),
+	)
+	for _, iss := range []*issuer{iss1, iss2} {
+		claims, err := v.Verify(ctx, iss.sign(t, "app"))
+		require.NoError(t, err)
+		require.Equal(t, iss.URL, claims.Issuer)
+	}
+
+	unknown := newIssuer(t, auth.AlgRS256)
+	_, err = v.Verify(ctx, unknown.sign(t, "app"))
+	require.ErrorIs(t, err, jwks.ErrUnknownIssuer)
+}
+
+func TestRemoteKeySetTTL(t *testing.T) {
+	ctx := context.Background()
+	iss := newIssuer(t, auth.AlgRS256)
+	ks := iss.keySet(jwks.WithTTL(time.Millisecond), jwks.WithMinRefreshInterval(0))
+
+	require.Empty(t, ks.GetKeys())
+	keys, err := ks.Keys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, keys, ks.GetKeys())
+
+	time.Sleep(5 * time.Millisecond)
+	iss.Close()
+	keys, err = ks.Keys(ctx)
+	require.NoError(t, err, "stale keys are used when issuer is unavailable")
+	require.Len(t, keys, 1)
+	require.EqualValues(t, 1, iss.fetches.Load())
+}
+
+func TestRemoteKeySetFetchErrorThrottled(t *testing.T) {
+	ctx := context.Background()
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	ks := jwks.NewRemoteKeySet(srv.URL,
+		jwks.WithBackoff(httputil.Backoff{Duration: time.Millisecond, MaxTries: 1}),
+		jwks.WithMinRefreshInterval(50*time.Millisecond),
+	)
+
+	_, err := ks.Keys(ctx)
+	require.Error(t, err)
+	for range 10 {
+		_, err2 := ks.Key(ctx, "kid")
+		require.Equal(t, err, err2, "error of the last fetch is returned")
+	}
+	require.EqualValues(t, 1, fetches.Load())
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = ks.Keys(ctx)
+	require.Error(t, err)
+	require.EqualValues(t, 2, fetches.Load(), "fetch is retried after minimum refresh interval")
+}
+
+func TestRemoteKeySetSlowFetch(t *testing.T) {
+	ctx := context.Background()
+	iss := newIssuer(t, auth.AlgRS256)
+	block := make(chan struct{})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-block
+		}
+		r.URL.Path = jwks.JWKSPath
+		iss.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	ks := jwks.NewRemoteKeySet(srv.URL, jwks.WithMinRefreshInterval(0))
+	v := jwks.NewVerifier(jwks.WithIssuer(iss.URL, ks))
+	token := iss.sign(t, "app")
+	_, err := v.Verify(ctx, token)
+	require.NoError(t, err)
+
+	// unknown kid triggers fetches which hang until block is closed
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := ks.Key(ctx, "unknown")
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+
+	// verification with cached key isn't blocked by the fetch
+	_, err = v.Verify(ctx, token)
+	require.NoError(t, err)
+
+	// caller stops waiting when its ctx is done
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = ks.Key(timeoutCtx, "unknown")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(block)
+	for range 2 {
+		require.ErrorIs(t, <-errs, jwks.ErrKeyNotFound)
+	}
+	require.EqualValues(t, 2, fetches.Load(), "concurrent callers share the fetch")
+}
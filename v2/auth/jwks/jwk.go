@@ -0,0 +1,171 @@
+// Package jwks provides JSON Web Key Set handling for auth.JWTKey, both for publishing and consuming keys.
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/elisasre/go-common/v2/auth"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported key")
+
+// UseSignature is the "use" value of keys used for verifying signatures.
+const UseSignature = "sig"
+
+// JWK is a public JSON Web Key as defined in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Set is a JSON Web Key Set.
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewSet converts public parts of keys into JWK Set.
+func NewSet(keys []auth.JWTKey) (Set, error) {
+	set := Set{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwk, err := FromJWTKey(key)
+		if err != nil {
+			return Set{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// FromJWTKey converts public part of the key into JWK.
+func FromJWTKey(key auth.JWTKey) (JWK, error) {
+	jwk := JWK{Use: UseSignature, Alg: key.Alg(), Kid: key.KID}
+
+	pub := key.PublicKey
+	if pub == nil && key.PrivateKey != nil {
+		pub = key.PrivateKey.Public()
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encode(pub.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return JWK{}, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, pub.Curve.Params().Name)
+		}
+		raw, err := pub.Bytes()
+		if err != nil {
+			return JWK{}, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+		}
+		// uncompressed point: 0x04 || X || Y
+		size := (len(raw) - 1) / 2
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = encode(raw[1 : 1+size])
+		jwk.Y = encode(raw[1+size:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encode(pub)
+	default:
+		return JWK{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+	}
+	return jwk, nil
+}
+
+// JWTKey converts JWK into auth.JWTKey which can be used with auth.ParseToken.
+func (k JWK) JWTKey() (auth.JWTKey, error) {
+	key := auth.JWTKey{KID: k.Kid, Algorithm: k.Alg}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return auth.JWTKey{}, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return auth.JWTKey{}, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return auth.JWTKey{}, fmt.Errorf("%w: invalid RSA exponent", ErrUnsupportedKey)
+		}
+		key.PublicKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+	case "EC":
+		if k.Crv != "P-256" {
+			return auth.JWTKey{}, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return auth.JWTKey{}, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return auth.JWTKey{}, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return auth.JWTKey{}, fmt.Errorf("%w: invalid P-256 coordinates", ErrUnsupportedKey)
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return auth.JWTKey{}, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+		}
+		key.PublicKey = pub
+	case "OKP":
+		x, err := decode(k.X)
+		if err != nil {
+			return auth.JWTKey{}, err
+		}
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return auth.JWTKey{}, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, k.Crv)
+		}
+		key.PublicKey = ed25519.PublicKey(x)
+	default:
+		return auth.JWTKey{}, fmt.Errorf("%w: kty %s", ErrUnsupportedKey, k.Kty)
+	}
+	return key, nil
+}
+
+// JWTKeys converts all signature keys of the set into auth.JWTKeys.
+// Keys with unsupported types or other uses are skipped.
+func (s Set) JWTKeys() []auth.JWTKey {
+	keys := make([]auth.JWTKey, 0, len(s.Keys))
+	for _, jwk := range s.Keys {
+		if jwk.Use != "" && jwk.Use != UseSignature {
+			continue
+		}
+		key, err := jwk.JWTKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func encode(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func decode(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid base64url value: %w", ErrUnsupportedKey, err)
+	}
+	return b, nil
+}
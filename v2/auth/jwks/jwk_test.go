@@ -0,0 +1,53 @@
+package jwks_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/jwks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	for _, alg := range auth.SupportedAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			key, err := auth.GenerateNewKeyPairWithAlgorithm(alg)
+			require.NoError(t, err)
+
+			set, err := jwks.NewSet([]auth.JWTKey{key})
+			require.NoError(t, err)
+			data, err := json.Marshal(set)
+			require.NoError(t, err)
+			require.NotContains(t, string(data), `"d"`, "private key must not be published")
+
+			var decoded jwks.Set
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			require.Equal(t, jwks.UseSignature, decoded.Keys[0].Use)
+			keys := decoded.JWTKeys()
+			require.Len(t, keys, 1)
+			require.Equal(t, key.KID, keys[0].KID)
+			require.Equal(t, alg, keys[0].Alg())
+			require.Equal(t, key.PublicKey, keys[0].PublicKey)
+
+			token, err := auth.NewToken(&auth.User{}).SignExpires(key, auth.SignClaims{
+				Exp:    time.Now().Add(time.Hour).Unix(),
+				Scopes: auth.AllScopes,
+			})
+			require.NoError(t, err)
+			_, err = auth.ParseToken(token, keys)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestJWKUnsupported(t *testing.T) {
+	_, err := jwks.JWK{Kty: "oct", Kid: "1"}.JWTKey()
+	require.ErrorIs(t, err, jwks.ErrUnsupportedKey)
+	_, err = jwks.JWK{Kty: "EC", Crv: "P-384"}.JWTKey()
+	require.ErrorIs(t, err, jwks.ErrUnsupportedKey)
+
+	set := jwks.Set{Keys: []jwks.JWK{{Kty: "oct"}, {Kty: "RSA", Use: "enc"}}}
+	require.Empty(t, set.JWTKeys())
+}
@@ -0,0 +1,190 @@
+package jwks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// JWKSPath is the well-known path of the JWK Set.
+	JWKSPath = "/.well-known/jwks.json"
+	// DiscoveryPath is the well-known path of the OpenID Provider Metadata.
+	DiscoveryPath = "/.well-known/openid-configuration"
+)
+
+// KeySource provides keys which are published, *cache.Cache implements it.
+type KeySource interface {
+	GetKeys() []auth.JWTKey
+}
+
+// ProviderMetadata is OpenID Connect Discovery 1.0 provider metadata.
+// Empty JWKSURI, IDTokenSigningAlgValuesSupported, ScopesSupported, ResponseTypesSupported and SubjectTypesSupported are filled by Server.
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint,omitempty"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	ClaimsSupported                   []string `json:"claims_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// Server publishes keys from KeySource as JWK Set and OpenID Provider Metadata.
+type Server struct {
+	keys     KeySource
+	metadata ProviderMetadata
+	maxAge   time.Duration
+}
+
+type Opt func(*Server)
+
+// WithMaxAge sets how long clients may cache responses, defaults to 5 minutes.
+// It should be clearly shorter than key rotation interval so that clients pick up new keys before they are used.
+func WithMaxAge(d time.Duration) Opt {
+	return func(s *Server) {
+		s.maxAge = d
+	}
+}
+
+// WithMetadata sets provider metadata served from DiscoveryPath.
+func WithMetadata(md ProviderMetadata) Opt {
+	return func(s *Server) {
+		s.metadata = md
+	}
+}
+
+// NewServer creates Server publishing keys from given source.
+func NewServer(keys KeySource, opts ...Opt) *Server {
+	s := &Server{keys: keys, maxAge: 5 * time.Minute}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register adds JWKS and discovery handlers to mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+JWKSPath, s.JWKS)
+	mux.HandleFunc("GET "+DiscoveryPath, s.Discovery)
+}
+
+// RegisterGin adds JWKS and discovery handlers to gin router.
+func (s *Server) RegisterGin(r gin.IRoutes) {
+	r.GET(JWKSPath, gin.WrapF(s.JWKS))
+	r.GET(DiscoveryPath, gin.WrapF(s.Discovery))
+}
+
+// JWKS serves public keys as JWK Set.
+// ETag is derived from the response so it changes whenever keys are rotated.
+func (s *Server) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys := s.keys.GetKeys()
+	set, err := NewSet(keys)
+	if err != nil {
+		slog.Error("building JWKS failed", slog.String("error", err.Error()))
+		writeJSON(w, http.StatusInternalServerError, httputil.ErrorResponse{Code: http.StatusInternalServerError, Message: "internal server error"})
+		return
+	}
+	s.serveCached(w, r, lastModified(keys), set)
+}
+
+// Discovery serves OpenID Provider Metadata.
+func (s *Server) Discovery(w http.ResponseWriter, r *http.Request) {
+	s.serveCached(w, r, time.Time{}, s.providerMetadata())
+}
+
+func (s *Server) providerMetadata() ProviderMetadata {
+	md := s.metadata
+	issuer := strings.TrimSuffix(md.Issuer, "/")
+	if md.JWKSURI == "" && issuer != "" {
+		md.JWKSURI = issuer + JWKSPath
+	}
+	if md.ScopesSupported == nil {
+		md.ScopesSupported = auth.AllScopes
+	}
+	if md.ResponseTypesSupported == nil {
+		md.ResponseTypesSupported = []string{"code"}
+	}
+	if md.SubjectTypesSupported == nil {
+		md.SubjectTypesSupported = []string{"public"}
+	}
+	if md.IDTokenSigningAlgValuesSupported == nil {
+		algs := []string{}
+		for _, k := range s.keys.GetKeys() {
+			if alg := k.Alg(); !slices.Contains(algs, alg) {
+				algs = append(algs, alg)
+			}
+		}
+		slices.Sort(algs)
+		md.IDTokenSigningAlgValuesSupported = algs
+	}
+	return md
+}
+
+// serveCached writes body with ETag computed from it so that any change of the response is seen by clients.
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request, modified time.Time, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		slog.Error("encoding response failed", slog.String("error", err.Error()))
+		writeJSON(w, http.StatusInternalServerError, httputil.ErrorResponse{Code: http.StatusInternalServerError, Message: "internal server error"})
+		return
+	}
+	tag := etag(data)
+
+	h := w.Header()
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, must-revalidate", int(s.maxAge.Seconds())))
+	h.Set("ETag", tag)
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && match == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		slog.Error("writing response failed", slog.String("error", err.Error()))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("writing response failed", slog.String("error", err.Error()))
+	}
+}
+
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func lastModified(keys []auth.JWTKey) time.Time {
+	var latest time.Time
+	for _, k := range keys {
+		if k.CreatedAt.After(latest) {
+			latest = k.CreatedAt
+		}
+	}
+	return latest
+}
@@ -0,0 +1,108 @@
+package jwks_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/cache"
+	"github.com/elisasre/go-common/v2/auth/jwks"
+	"github.com/elisasre/go-common/v2/auth/store/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerJWKS(t *testing.T) {
+	ctx := context.Background()
+	c, err := cache.New(ctx, memory.New(), cache.WithAlgorithm(auth.AlgES256))
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	jwks.NewServer(c, jwks.WithMaxAge(time.Minute)).Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, set := getJWKS(t, srv.URL, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "public, max-age=60, must-revalidate", resp.Header.Get("Cache-Control"))
+	require.Len(t, set.Keys, 1)
+	require.Equal(t, c.GetCurrentKey().KID, set.Keys[0].Kid)
+	require.Equal(t, auth.AlgES256, set.Keys[0].Alg)
+	tag := resp.Header.Get("ETag")
+	require.NotEmpty(t, tag)
+
+	resp, _ = getJWKS(t, srv.URL, tag)
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// rotation changes ETag so clients fetch new keys
+	require.NoError(t, c.RotateKeys(ctx))
+	resp, set = getJWKS(t, srv.URL, tag)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEqual(t, tag, resp.Header.Get("ETag"))
+	require.Len(t, set.Keys, 2)
+}
+
+func TestServerDiscovery(t *testing.T) {
+	c, err := cache.New(context.Background(), memory.New())
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	jwks.NewServer(c, jwks.WithMetadata(jwks.ProviderMetadata{
+		Issuer:        "https://auth.example.com/",
+		TokenEndpoint: "https://auth.example.com/token",
+	})).RegisterGin(r)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, jwks.DiscoveryPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var md jwks.ProviderMetadata
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &md))
+	require.Equal(t, "https://auth.example.com/.well-known/jwks.json", md.JWKSURI)
+	require.Equal(t, "https://auth.example.com/token", md.TokenEndpoint)
+	require.Equal(t, []string{auth.AlgRS256}, md.IDTokenSigningAlgValuesSupported)
+	require.Equal(t, auth.AllScopes, md.ScopesSupported)
+
+	tag := w.Header().Get("ETag")
+	require.NotEmpty(t, tag)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, jwks.JWKSPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// any change of metadata changes ETag, not only the algorithms
+	r = gin.New()
+	jwks.NewServer(c, jwks.WithMetadata(jwks.ProviderMetadata{
+		Issuer:        "https://auth.example.com/",
+		TokenEndpoint: "https://auth.example.com/oauth2/token",
+	})).RegisterGin(r)
+	req := httptest.NewRequest(http.MethodGet, jwks.DiscoveryPath, nil)
+	req.Header.Set("If-None-Match", tag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEqual(t, tag, w.Header().Get("ETag"))
+}
+
+func getJWKS(t *testing.T, baseURL, etag string) (*http.Response, jwks.Set) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, baseURL+jwks.JWKSPath, nil)
+	require.NoError(t, err)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var set jwks.Set
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	}
+	return resp, set
+}
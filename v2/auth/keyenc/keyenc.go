@@ -0,0 +1,148 @@
+// Package keyenc provides envelope encryption for private keys stored in databases.
+// Every value is encrypted with a random data key which is in turn wrapped by a key encryption key (KEK).
+// KEK can be derived locally from a secret or held by AWS KMS, GCP KMS or Vault Transit.
+package keyenc
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Version is the current envelope format version.
+	Version byte = 1
+
+	dataKeySize = 32
+	headerSize  = len(magic) + 1 + 2
+	// gcmSize is the size of AES-GCM nonce and authentication tag.
+	gcmSize = 12 + 16
+
+	// magic identifies envelope encrypted values, legacy auth.Encrypt output has no header.
+	magic = "JKE"
+)
+
+var (
+	ErrInvalidCiphertext  = errors.New("invalid ciphertext")
+	ErrUnsupportedVersion = errors.New("unsupported envelope version")
+)
+
+// KeyEncrypter encrypts and decrypts values. Associated data is authenticated but not encrypted,
+// decryption fails unless the same associated data is given.
+type KeyEncrypter interface {
+	Encrypt(ctx context.Context, plaintext, aad []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext, aad []byte) ([]byte, error)
+}
+
+// KeyWrapper encrypts data keys with key encryption key.
+type KeyWrapper interface {
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Envelope is KeyEncrypter which encrypts every value with a new random data key wrapped by KeyWrapper.
+//
+// Format: "JKE" | version | uint16 length of wrapped key | wrapped key | nonce | AES-256-GCM ciphertext.
+type Envelope struct {
+	wrapper KeyWrapper
+}
+
+// NewEnvelope creates Envelope which wraps data keys using given wrapper.
+func NewEnvelope(wrapper KeyWrapper) *Envelope {
+	return &Envelope{wrapper: wrapper}
+}
+
+// IsEnvelope reports whether data looks like output of Envelope.Encrypt. Besides the magic prefix
+// version and length of the wrapped key are checked, so random legacy ciphertexts are unlikely to match.
+// Callers supporting legacy formats should still try them if decrypting an envelope fails.
+func IsEnvelope(data []byte) bool {
+	if len(data) <= headerSize || !bytes.HasPrefix(data, []byte(magic)) || data[len(magic)] != Version {
+		return false
+	}
+	n := int(binary.BigEndian.Uint16(data[len(magic)+1:]))
+	return n > 0 && len(data) >= headerSize+n+gcmSize
+}
+
+func (e *Envelope) Encrypt(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("generating data key failed: %w", err)
+	}
+	wrapped, err := e.wrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key failed: %w", err)
+	}
+	if len(wrapped) > 0xffff {
+		return nil, fmt.Errorf("wrapped data key is too large: %d bytes", len(wrapped))
+	}
+
+	header := make([]byte, 0, headerSize+len(wrapped))
+	header = append(header, magic...)
+	header = append(header, Version)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	return seal(dataKey, header, plaintext, append(header[:len(magic)+1:len(magic)+1], aad...))
+}
+
+func (e *Envelope) Decrypt(ctx context.Context, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) <= headerSize || !bytes.HasPrefix(ciphertext, []byte(magic)) {
+		return nil, ErrInvalidCiphertext
+	}
+	if v := ciphertext[len(magic)]; v != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	n := int(binary.BigEndian.Uint16(ciphertext[len(magic)+1:]))
+	if len(ciphertext) < headerSize+n {
+		return nil, ErrInvalidCiphertext
+	}
+	wrapped := ciphertext[headerSize : headerSize+n]
+	dataKey, err := e.wrapper.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key failed: %w", err)
+	}
+	prefix := ciphertext[: len(magic)+1 : len(magic)+1]
+	return open(dataKey, ciphertext[headerSize+n:], append(prefix, aad...))
+}
+
+// seal encrypts plaintext with AES-256-GCM and appends nonce and ciphertext to dst.
+func seal(key, dst, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce failed: %w", err)
+	}
+	return gcm.Seal(append(dst, nonce...), nonce, plaintext, aad), nil
+}
+
+// open decrypts nonce prefixed AES-256-GCM ciphertext.
+func open(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
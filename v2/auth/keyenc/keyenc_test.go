@@ -0,0 +1,77 @@
+package keyenc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elisasre/go-common/v2/auth/keyenc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	hkdfWrapper, err := keyenc.NewLocalWrapper([]byte("secret"))
+	require.NoError(t, err)
+	argonWrapper, err := keyenc.NewLocalWrapper([]byte("passphrase"), keyenc.WithArgon2id(keyenc.Argon2Params{Time: 1, Memory: 64, Threads: 1}))
+	require.NoError(t, err)
+
+	for name, wrapper := range map[string]keyenc.KeyWrapper{
+		"hkdf":     hkdfWrapper,
+		"argon2id": argonWrapper,
+	} {
+		t.Run(name, func(t *testing.T) {
+			enc := keyenc.NewEnvelope(wrapper)
+			plaintext := []byte("private key")
+			ciphertext, err := enc.Encrypt(ctx, plaintext, []byte("kid1"))
+			require.NoError(t, err)
+			require.True(t, keyenc.IsEnvelope(ciphertext))
+			require.NotContains(t, string(ciphertext), string(plaintext))
+
+			again, err := enc.Encrypt(ctx, plaintext, []byte("kid1"))
+			require.NoError(t, err)
+			require.NotEqual(t, ciphertext, again, "every encryption must use new data key and nonce")
+
+			decrypted, err := enc.Decrypt(ctx, ciphertext, []byte("kid1"))
+			require.NoError(t, err)
+			require.Equal(t, plaintext, decrypted)
+
+			_, err = enc.Decrypt(ctx, ciphertext, []byte("kid2"))
+			require.ErrorIs(t, err, keyenc.ErrInvalidCiphertext)
+
+			tampered := append([]byte(nil), ciphertext...)
+			tampered[len(tampered)-1] ^= 1
+			_, err = enc.Decrypt(ctx, tampered, []byte("kid1"))
+			require.ErrorIs(t, err, keyenc.ErrInvalidCiphertext)
+
+			tampered = append([]byte(nil), ciphertext...)
+			tampered[3] = 2
+			_, err = enc.Decrypt(ctx, tampered, []byte("kid1"))
+			require.ErrorIs(t, err, keyenc.ErrUnsupportedVersion)
+		})
+	}
+}
+
+func TestLocalWrapperWrongSecret(t *testing.T) {
+	ctx := context.Background()
+	w1, err := keyenc.NewLocalWrapper([]byte("secret1"))
+	require.NoError(t, err)
+	w2, err := keyenc.NewLocalWrapper([]byte("secret2"))
+	require.NoError(t, err)
+
+	ciphertext, err := keyenc.NewEnvelope(w1).Encrypt(ctx, []byte("data"), nil)
+	require.NoError(t, err)
+	_, err = keyenc.NewEnvelope(w2).Decrypt(ctx, ciphertext, nil)
+	require.ErrorIs(t, err, keyenc.ErrInvalidCiphertext)
+
+	_, err = keyenc.NewLocalWrapper(nil)
+	require.ErrorIs(t, err, keyenc.ErrEmptySecret)
+}
+
+func TestIsEnvelope(t *testing.T) {
+	require.False(t, keyenc.IsEnvelope(nil))
+	require.False(t, keyenc.IsEnvelope([]byte("JKE")))
+	require.False(t, keyenc.IsEnvelope([]byte("random legacy ciphertext")))
+	// legacy ciphertext starting with the magic by chance
+	require.False(t, keyenc.IsEnvelope(append([]byte("JKE\x07\x00\x10"), make([]byte, 64)...)), "unknown version")
+	require.False(t, keyenc.IsEnvelope(append([]byte("JKE\x01\xff\xff"), make([]byte, 64)...)), "wrapped key longer than data")
+}
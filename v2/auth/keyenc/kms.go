@@ -0,0 +1,248 @@
+package keyenc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/elisasre/go-common/v2/httputil"
+)
+
+// backend contains settings shared by remote KMS wrappers.
+type backend struct {
+	client   httputil.HTTPClient
+	endpoint string
+	mount    string
+}
+
+type BackendOpt func(*backend)
+
+// WithHTTPClient sets client used for calling the KMS, defaults to http.Client with 10 second timeout.
+// GCP KMS requires client which authenticates requests, e.g. one created by golang.org/x/oauth2/google.
+func WithHTTPClient(c httputil.HTTPClient) BackendOpt {
+	return func(b *backend) {
+		b.client = c
+	}
+}
+
+// WithEndpoint overrides KMS endpoint, useful for local emulators and tests.
+func WithEndpoint(endpoint string) BackendOpt {
+	return func(b *backend) {
+		b.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// WithMount sets mount path of Vault Transit secrets engine, defaults to "transit".
+func WithMount(mount string) BackendOpt {
+	return func(b *backend) {
+		b.mount = strings.Trim(mount, "/")
+	}
+}
+
+func newBackend(endpoint string, opts []BackendOpt) backend {
+	b := backend{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: endpoint,
+		mount:    "transit",
+	}
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b
+}
+
+// do sends request and decodes JSON response into out.
+func (b backend) do(req *http.Request, out any) error {
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("reading response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func newJSONRequest(ctx context.Context, url string, body any) (*http.Request, []byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, data, nil
+}
+
+// AWSKMS wraps data keys with AWS KMS symmetric key.
+type AWSKMS struct {
+	backend
+	keyID  string
+	region string
+	creds  aws.CredentialsProvider
+	signer *v4.Signer
+}
+
+// NewAWSKMS creates AWSKMS using key ID or ARN, region and credentials from cfg.
+// Config is typically loaded using github.com/aws/aws-sdk-go-v2/config.LoadDefaultConfig.
+func NewAWSKMS(keyID string, cfg aws.Config, opts ...BackendOpt) *AWSKMS {
+	return &AWSKMS{
+		backend: newBackend(fmt.Sprintf("https://kms.%s.amazonaws.com", cfg.Region), opts),
+		keyID:   keyID,
+		region:  cfg.Region,
+		creds:   cfg.Credentials,
+		signer:  v4.NewSigner(),
+	}
+}
+
+// awsKMSMessage contains fields used by both Encrypt and Decrypt actions, []byte is base64 encoded like AWS expects.
+type awsKMSMessage struct {
+	KeyID          string `json:"KeyId"`
+	Plaintext      []byte `json:",omitempty"`
+	CiphertextBlob []byte `json:",omitempty"`
+}
+
+func (k *AWSKMS) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	out := awsKMSMessage{}
+	if err := k.call(ctx, "Encrypt", awsKMSMessage{KeyID: k.keyID, Plaintext: dataKey}, &out); err != nil {
+		return nil, fmt.Errorf("aws kms encrypt failed: %w", err)
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *AWSKMS) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out := awsKMSMessage{}
+	if err := k.call(ctx, "Decrypt", awsKMSMessage{KeyID: k.keyID, CiphertextBlob: wrapped}, &out); err != nil {
+		return nil, fmt.Errorf("aws kms decrypt failed: %w", err)
+	}
+	return out.Plaintext, nil
+}
+
+func (k *AWSKMS) call(ctx context.Context, action string, in, out any) error {
+	req, body, err := newJSONRequest(ctx, k.endpoint+"/", in)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	creds, err := k.creds.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving aws credentials failed: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := k.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "kms", k.region, time.Now()); err != nil {
+		return fmt.Errorf("signing request failed: %w", err)
+	}
+	return k.do(req, out)
+}
+
+// GCPKMS wraps data keys with Google Cloud KMS symmetric key.
+type GCPKMS struct {
+	backend
+	keyName string
+}
+
+// NewGCPKMS creates GCPKMS using key resource name of form
+// projects/P/locations/L/keyRings/R/cryptoKeys/K. Client authenticating requests must be set using WithHTTPClient.
+func NewGCPKMS(keyName string, opts ...BackendOpt) *GCPKMS {
+	return &GCPKMS{
+		backend: newBackend("https://cloudkms.googleapis.com", opts),
+		keyName: keyName,
+	}
+}
+
+type gcpKMSMessage struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+func (k *GCPKMS) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	out := gcpKMSMessage{}
+	if err := k.call(ctx, "encrypt", gcpKMSMessage{Plaintext: dataKey}, &out); err != nil {
+		return nil, fmt.Errorf("gcp kms encrypt failed: %w", err)
+	}
+	return out.Ciphertext, nil
+}
+
+func (k *GCPKMS) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out := gcpKMSMessage{}
+	if err := k.call(ctx, "decrypt", gcpKMSMessage{Ciphertext: wrapped}, &out); err != nil {
+		return nil, fmt.Errorf("gcp kms decrypt failed: %w", err)
+	}
+	return out.Plaintext, nil
+}
+
+func (k *GCPKMS) call(ctx context.Context, method string, in, out any) error {
+	req, _, err := newJSONRequest(ctx, fmt.Sprintf("%s/v1/%s:%s", k.endpoint, k.keyName, method), in)
+	if err != nil {
+		return err
+	}
+	return k.do(req, out)
+}
+
+// VaultTransit wraps data keys with HashiCorp Vault Transit secrets engine key.
+type VaultTransit struct {
+	backend
+	keyName string
+	token   string
+}
+
+// NewVaultTransit creates VaultTransit for Vault at addr using given token and transit key name.
+func NewVaultTransit(addr, token, keyName string, opts ...BackendOpt) *VaultTransit {
+	return &VaultTransit{
+		backend: newBackend(strings.TrimSuffix(addr, "/"), opts),
+		keyName: keyName,
+		token:   token,
+	}
+}
+
+type vaultTransitResponse struct {
+	Data struct {
+		Ciphertext string `json:"ciphertext"`
+		Plaintext  []byte `json:"plaintext"`
+	} `json:"data"`
+}
+
+func (k *VaultTransit) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	out := vaultTransitResponse{}
+	if err := k.call(ctx, "encrypt", map[string][]byte{"plaintext": dataKey}, &out); err != nil {
+		return nil, fmt.Errorf("vault transit encrypt failed: %w", err)
+	}
+	return []byte(out.Data.Ciphertext), nil
+}
+
+func (k *VaultTransit) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out := vaultTransitResponse{}
+	if err := k.call(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &out); err != nil {
+		return nil, fmt.Errorf("vault transit decrypt failed: %w", err)
+	}
+	return out.Data.Plaintext, nil
+}
+
+func (k *VaultTransit) call(ctx context.Context, action string, in, out any) error {
+	u := fmt.Sprintf("%s/v1/%s/%s/%s", k.endpoint, k.mount, action, url.PathEscape(k.keyName))
+	req, _, err := newJSONRequest(ctx, u, in)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", k.token)
+	return k.do(req, out)
+}
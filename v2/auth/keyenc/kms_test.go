@@ -0,0 +1,118 @@
+package keyenc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/elisasre/go-common/v2/auth/keyenc"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKMS "encrypts" by prefixing plaintext, it's enough for verifying the protocol.
+const fakePrefix = "wrapped:"
+
+func fakeWrap(b []byte) []byte { return append([]byte(fakePrefix), b...) }
+
+func fakeUnwrap(t *testing.T, b []byte) []byte {
+	require.True(t, bytes.HasPrefix(b, []byte(fakePrefix)))
+	return bytes.TrimPrefix(b, []byte(fakePrefix))
+}
+
+func TestAWSKMS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Contains(t, r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+		require.Contains(t, r.Header.Get("Authorization"), "/eu-north-1/kms/aws4_request")
+		require.Equal(t, "application/x-amz-json-1.1", r.Header.Get("Content-Type"))
+
+		in := struct {
+			KeyID          string `json:"KeyId"`
+			Plaintext      []byte
+			CiphertextBlob []byte
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		require.Equal(t, "alias/jwt", in.KeyID)
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			_ = json.NewEncoder(w).Encode(map[string][]byte{"CiphertextBlob": fakeWrap(in.Plaintext)})
+		case "TrentService.Decrypt":
+			_ = json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": fakeUnwrap(t, in.CiphertextBlob)})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := aws.Config{
+		Region:      "eu-north-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}
+	testWrapper(t, keyenc.NewAWSKMS("alias/jwt", cfg, keyenc.WithEndpoint(srv.URL)))
+}
+
+func TestGCPKMS(t *testing.T) {
+	const keyName = "projects/p/locations/europe-north1/keyRings/r/cryptoKeys/k"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := map[string][]byte{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		switch r.URL.Path {
+		case "/v1/" + keyName + ":encrypt":
+			_ = json.NewEncoder(w).Encode(map[string][]byte{"ciphertext": fakeWrap(in["plaintext"])})
+		case "/v1/" + keyName + ":decrypt":
+			_ = json.NewEncoder(w).Encode(map[string][]byte{"plaintext": fakeUnwrap(t, in["ciphertext"])})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	testWrapper(t, keyenc.NewGCPKMS(keyName, keyenc.WithEndpoint(srv.URL), keyenc.WithHTTPClient(srv.Client())))
+}
+
+func TestVaultTransit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secrets/encrypt/jwt":
+			in := map[string][]byte{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"ciphertext": "vault:v1:" + base64.StdEncoding.EncodeToString(fakeWrap(in["plaintext"]))}})
+		case "/v1/secrets/decrypt/jwt":
+			in := map[string]string{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+			wrapped, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(in["ciphertext"], "vault:v1:"))
+			require.NoError(t, err)
+			plaintext := fakeUnwrap(t, wrapped)
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string][]byte{"plaintext": plaintext}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	testWrapper(t, keyenc.NewVaultTransit(srv.URL, "token", "jwt", keyenc.WithMount("secrets")))
+
+	_, err := keyenc.NewVaultTransit(srv.URL, "invalid", "jwt", keyenc.WithMount("secrets")).WrapKey(context.Background(), []byte("key"))
+	require.ErrorContains(t, err, "unexpected status 403")
+}
+
+func testWrapper(t *testing.T, wrapper keyenc.KeyWrapper) {
+	t.Helper()
+	ctx := context.Background()
+	enc := keyenc.NewEnvelope(wrapper)
+	ciphertext, err := enc.Encrypt(ctx, []byte("private key"), []byte("kid"))
+	require.NoError(t, err)
+	plaintext, err := enc.Decrypt(ctx, ciphertext, []byte("kid"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("private key"), plaintext)
+}
@@ -0,0 +1,130 @@
+package keyenc
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	kdfHKDF     byte = 1
+	kdfArgon2id byte = 2
+
+	saltSize         = 16
+	argon2ParamsSize = 4 + 4 + 1
+	hkdfInfo         = "go-common keyenc kek"
+	// maxArgon2Memory limits memory stored parameters can make us allocate, 1 GiB.
+	maxArgon2Memory = 1024 * 1024
+)
+
+var ErrEmptySecret = errors.New("secret must not be empty")
+
+// Argon2Params are Argon2id cost parameters, they are stored along with wrapped keys
+// so they can be increased later without breaking existing values.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+// DefaultArgon2Params follow the second recommended option of RFC 9106.
+var DefaultArgon2Params = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// LocalWrapper wraps data keys with KEK derived from a secret held by the application.
+// KEK is derived with HKDF-SHA256 by default which is suitable for high entropy secrets,
+// low entropy passphrases should use WithArgon2id instead.
+//
+// Format: kdf | salt | argon2 params (Argon2id only) | nonce | AES-256-GCM ciphertext.
+type LocalWrapper struct {
+	secret []byte
+	kdf    byte
+	argon2 Argon2Params
+}
+
+type LocalOpt func(*LocalWrapper)
+
+// WithArgon2id derives KEK using Argon2id with given parameters.
+func WithArgon2id(params Argon2Params) LocalOpt {
+	return func(w *LocalWrapper) {
+		w.kdf = kdfArgon2id
+		w.argon2 = params
+	}
+}
+
+// NewLocalWrapper creates LocalWrapper using given secret.
+func NewLocalWrapper(secret []byte, opts ...LocalOpt) (*LocalWrapper, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	w := &LocalWrapper{secret: secret, kdf: kdfHKDF}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w, nil
+}
+
+func (w *LocalWrapper) WrapKey(_ context.Context, dataKey []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating salt failed: %w", err)
+	}
+	header := append([]byte{w.kdf}, salt...)
+	if w.kdf == kdfArgon2id {
+		header = binary.BigEndian.AppendUint32(header, w.argon2.Time)
+		header = binary.BigEndian.AppendUint32(header, w.argon2.Memory)
+		header = append(header, w.argon2.Threads)
+	}
+
+	kek, err := w.deriveKey(w.kdf, salt, w.argon2)
+	if err != nil {
+		return nil, err
+	}
+	return seal(kek, header, dataKey, header)
+}
+
+func (w *LocalWrapper) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 1+saltSize {
+		return nil, ErrInvalidCiphertext
+	}
+	kdf, salt := wrapped[0], wrapped[1:1+saltSize]
+	headerSize := 1 + saltSize
+	var params Argon2Params
+	if kdf == kdfArgon2id {
+		headerSize += argon2ParamsSize
+		if len(wrapped) < headerSize {
+			return nil, ErrInvalidCiphertext
+		}
+		p := wrapped[1+saltSize:]
+		params = Argon2Params{
+			Time:    binary.BigEndian.Uint32(p),
+			Memory:  binary.BigEndian.Uint32(p[4:]),
+			Threads: p[8],
+		}
+	}
+
+	kek, err := w.deriveKey(kdf, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	return open(kek, wrapped[headerSize:], wrapped[:headerSize])
+}
+
+func (w *LocalWrapper) deriveKey(kdf byte, salt []byte, params Argon2Params) ([]byte, error) {
+	switch kdf {
+	case kdfHKDF:
+		return hkdf.Key(sha256.New, w.secret, salt, hkdfInfo, dataKeySize)
+	case kdfArgon2id:
+		if params.Time == 0 || params.Memory == 0 || params.Threads == 0 || params.Memory > maxArgon2Memory {
+			return nil, fmt.Errorf("%w: invalid argon2id parameters", ErrInvalidCiphertext)
+		}
+		return argon2.IDKey(w.secret, salt, params.Time, params.Memory, params.Threads, dataKeySize), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown kdf %d", ErrInvalidCiphertext, kdf)
+	}
+}
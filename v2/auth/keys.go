@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported JWT signing algorithms.
+const (
+	AlgRS256 = "RS256"
+	AlgPS256 = "PS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SupportedAlgorithms lists algorithms which can be used for signing and verifying tokens.
+var SupportedAlgorithms = []string{AlgRS256, AlgPS256, AlgES256, AlgEdDSA}
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnsupportedKeyType   = errors.New("unsupported key type")
+)
+
+// Alg returns signing algorithm of the key.
+// When Algorithm is not set it's derived from key type, RSA keys default to RS256 for backwards compatibility.
+func (k JWTKey) Alg() string {
+	if k.Algorithm != "" {
+		return k.Algorithm
+	}
+	pub := k.PublicKey
+	if pub == nil && k.PrivateKey != nil {
+		pub = k.PrivateKey.Public()
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return AlgES256
+	case ed25519.PublicKey:
+		return AlgEdDSA
+	default:
+		return AlgRS256
+	}
+}
+
+// GenerateNewKeyPairWithAlgorithm generates new private and public keys for given algorithm.
+// RSA keys are 2048 bits, ES256 uses P-256 curve.
+func GenerateNewKeyPairWithAlgorithm(alg string) (JWTKey, error) {
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch alg {
+	case AlgRS256, AlgPS256:
+		var rsaKey *rsa.PrivateKey
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err == nil {
+			err = rsaKey.Validate()
+		}
+		signer = rsaKey
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return JWTKey{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return JWTKey{}, fmt.Errorf("error generating %s private key: %w", alg, err)
+	}
+
+	serial, err := BuildPKISerial()
+	if err != nil {
+		return JWTKey{}, err
+	}
+
+	return JWTKey{
+		KID:        serial.String(),
+		Algorithm:  alg,
+		PrivateKey: signer,
+		PublicKey:  signer.Public(),
+	}, nil
+}
+
+// MarshalPrivateKey encodes private key into PEM block.
+// RSA keys use PKCS #1 to stay compatible with EncodePrivateKeyToPEM, other keys use PKCS #8.
+func MarshalPrivateKey(key crypto.Signer) (*pem.Block, error) {
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKeyType, err)
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
+
+// MarshalPublicKey encodes public key into PEM block.
+// RSA keys use PKCS #1 to stay compatible with EncodePublicKeyToPEM, other keys use PKIX.
+func MarshalPublicKey(pub crypto.PublicKey) (*pem.Block, error) {
+	if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+		return &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(rsaKey)}, nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKeyType, err)
+	}
+	return &pem.Block{Type: "PUBLIC KEY", Bytes: der}, nil
+}
+
+// ParsePrivateKey decodes private key from PEM block created by MarshalPrivateKey.
+func ParsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	if block == nil {
+		return nil, fmt.Errorf("missing PEM block")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKeyType, key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKey decodes public key from PEM block created by MarshalPublicKey.
+func ParsePublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	if block == nil {
+		return nil, fmt.Errorf("missing PEM block")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// signerMethod implements jwt.SigningMethod on top of crypto.Signer so keys
+// don't need to be in memory, e.g. KMS backed signers work as well.
+// Verification is delegated to the standard jwt signing methods.
+type signerMethod struct {
+	alg string
+}
+
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	if slices.Contains(SupportedAlgorithms, alg) {
+		return signerMethod{alg: alg}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+}
+
+func (m signerMethod) Alg() string { return m.alg }
+
+func (m signerMethod) Verify(signingString string, sig []byte, key any) error {
+	return jwt.GetSigningMethod(m.alg).Verify(signingString, sig, key)
+}
+
+func (m signerMethod) Sign(signingString string, key any) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, jwt.ErrInvalidKeyType
+	}
+
+	if m.alg == AlgEdDSA {
+		return signer.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+	switch m.alg {
+	case AlgRS256:
+		return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgPS256:
+		return signer.Sign(rand.Reader, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+	case AlgES256:
+		der, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsaRawSignature(der, 32)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, m.alg)
+	}
+}
+
+// ecdsaRawSignature converts ASN.1 encoded ECDSA signature returned by crypto.Signer into R || S format used by JWS.
+func ecdsaRawSignature(der []byte, size int) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("invalid ECDSA signature: %w", err)
+	}
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+	return out, nil
+}
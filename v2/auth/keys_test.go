@@ -0,0 +1,53 @@
+package auth_test
+
+import (
+	"crypto/rsa"
+	"encoding/pem"
+	"testing"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalParseKeys(t *testing.T) {
+	for _, alg := range auth.SupportedAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			key, err := auth.GenerateNewKeyPairWithAlgorithm(alg)
+			require.NoError(t, err)
+
+			privBlock, err := auth.MarshalPrivateKey(key.PrivateKey)
+			require.NoError(t, err)
+			priv, err := auth.ParsePrivateKey(decodePEM(t, privBlock))
+			require.NoError(t, err)
+			require.Equal(t, key.PrivateKey, priv)
+
+			pubBlock, err := auth.MarshalPublicKey(key.PublicKey)
+			require.NoError(t, err)
+			pub, err := auth.ParsePublicKey(decodePEM(t, pubBlock))
+			require.NoError(t, err)
+			require.Equal(t, key.PublicKey, pub)
+
+			// algorithm is derived from key type when it's not stored
+			require.Equal(t, alg == auth.AlgPS256, auth.JWTKey{PublicKey: pub}.Alg() != alg)
+		})
+	}
+}
+
+func TestMarshalLegacyRSAKeys(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+
+	rsaPub, ok := key.PublicKey.(*rsa.PublicKey)
+	require.True(t, ok)
+	block, _ := pem.Decode(auth.EncodePublicKeyToPEM(rsaPub))
+	pub, err := auth.ParsePublicKey(block)
+	require.NoError(t, err)
+	require.Equal(t, key.PublicKey, pub)
+}
+
+func decodePEM(t *testing.T, block *pem.Block) *pem.Block {
+	t.Helper()
+	decoded, rest := pem.Decode(pem.EncodeToMemory(block))
+	require.Empty(t, rest)
+	return decoded
+}
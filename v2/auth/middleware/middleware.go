@@ -0,0 +1,238 @@
+// Package middleware provides gin and net/http middlewares for authenticating requests with bearer tokens.
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/jwks"
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// UserKey is the gin context key under which authenticated *auth.User is stored.
+	UserKey = "user"
+	// ClaimsKey is the gin context key under which verified *auth.UserJWTClaims are stored.
+	ClaimsKey = "claims"
+)
+
+var ErrMissingToken = errors.New("missing bearer token")
+
+// Verifier verifies raw token, *jwks.Verifier implements it for remote issuers.
+type Verifier interface {
+	Verify(ctx context.Context, raw string, options ...jwt.ParserOption) (*auth.UserJWTClaims, error)
+}
+
+// VerifierFunc allows using ordinary function as Verifier.
+type VerifierFunc func(ctx context.Context, raw string, options ...jwt.ParserOption) (*auth.UserJWTClaims, error)
+
+func (fn VerifierFunc) Verify(ctx context.Context, raw string, options ...jwt.ParserOption) (*auth.UserJWTClaims, error) {
+	return fn(ctx, raw, options...)
+}
+
+// LocalVerifier verifies tokens using keys from local key source such as *cache.Cache.
+func LocalVerifier(keys jwks.KeySource) Verifier {
+	return VerifierFunc(func(_ context.Context, raw string, options ...jwt.ParserOption) (*auth.UserJWTClaims, error) {
+		return auth.ParseToken(raw, keys.GetKeys(), options...)
+	})
+}
+
+// Authenticator extracts bearer tokens from requests and verifies them.
+type Authenticator struct {
+	verifier   Verifier
+	audience   string
+	issuer     string
+	leeway     time.Duration
+	cookieName string
+	optional   bool
+}
+
+type Opt func(*Authenticator)
+
+// WithAudience requires tokens to contain given audience.
+func WithAudience(aud string) Opt {
+	return func(a *Authenticator) {
+		a.audience = aud
+	}
+}
+
+// WithIssuer requires tokens to be issued by given issuer.
+func WithIssuer(iss string) Opt {
+	return func(a *Authenticator) {
+		a.issuer = iss
+	}
+}
+
+// WithLeeway sets allowed clock skew when validating exp, nbf and iat claims, defaults to 30 seconds.
+func WithLeeway(d time.Duration) Opt {
+	return func(a *Authenticator) {
+		a.leeway = d
+	}
+}
+
+// WithCookie enables reading token from cookie with given name when Authorization header is missing.
+func WithCookie(name string) Opt {
+	return func(a *Authenticator) {
+		a.cookieName = name
+	}
+}
+
+// WithOptional lets requests without token through unauthenticated, requests with invalid token are still rejected.
+func WithOptional() Opt {
+	return func(a *Authenticator) {
+		a.optional = true
+	}
+}
+
+// New creates Authenticator using given verifier.
+func New(v Verifier, opts ...Opt) *Authenticator {
+	a := &Authenticator{verifier: v, leeway: 30 * time.Second}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Authenticate extracts token from request and verifies it.
+// ErrMissingToken is returned if request doesn't contain token.
+func (a *Authenticator) Authenticate(r *http.Request) (*auth.UserJWTClaims, error) {
+	raw := a.token(r)
+	if raw == "" {
+		return nil, ErrMissingToken
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(a.leeway),
+	}
+	if a.audience != "" {
+		options = append(options, jwt.WithAudience(a.audience))
+	}
+	if a.issuer != "" {
+		options = append(options, jwt.WithIssuer(a.issuer))
+	}
+
+	claims, err := a.verifier.Verify(r.Context(), raw, options...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.User == nil {
+		claims.User = &auth.User{}
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) token(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, ok := strings.Cut(h, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if a.cookieName != "" {
+		if c, err := r.Cookie(a.cookieName); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// authenticate returns request with claims in context or writes 401 response.
+func (a *Authenticator) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, *auth.UserJWTClaims, bool) {
+	claims, err := a.Authenticate(r)
+	switch {
+	case err == nil:
+		return r.WithContext(WithClaims(r.Context(), claims)), claims, true
+	case errors.Is(err, ErrMissingToken) && a.optional:
+		return r, nil, true
+	}
+
+	ctxlog.Debug(r.Context(), "authentication failed", slog.String("error", err.Error()))
+	resp := httputil.ErrorResponse{Code: http.StatusUnauthorized, Message: "invalid token", ErrorType: "invalid_token"}
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	if errors.Is(err, ErrMissingToken) {
+		resp = httputil.ErrorResponse{Code: http.StatusUnauthorized, Message: "authentication required"}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeJSON(w, http.StatusUnauthorized, resp)
+	return r, nil, false
+}
+
+// Handler returns net/http middleware which rejects unauthenticated requests with 401.
+func (a *Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Gin returns gin middleware which rejects unauthenticated requests with 401.
+// User is stored under UserKey and claims under ClaimsKey in addition to request context.
+func (a *Authenticator) Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, claims, ok := a.authenticate(c.Writer, c.Request)
+		if !ok {
+			c.Abort()
+			return
+		}
+		if claims != nil {
+			c.Request = r
+			c.Set(UserKey, claims.User)
+			c.Set(ClaimsKey, claims)
+		}
+		c.Next()
+	}
+}
+
+type claimsKey struct{}
+
+// WithClaims returns context containing verified claims.
+func WithClaims(ctx context.Context, claims *auth.UserJWTClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns verified claims stored by the middleware.
+// For gin requests both *gin.Context and request context work.
+func ClaimsFromContext(ctx context.Context) (*auth.UserJWTClaims, bool) {
+	if gCtx, ok := ctx.(*gin.Context); ok {
+		if v, ok := gCtx.Get(ClaimsKey); ok {
+			claims, ok := v.(*auth.UserJWTClaims)
+			return claims, ok
+		}
+		if gCtx.Request == nil {
+			return nil, false
+		}
+		ctx = gCtx.Request.Context()
+	}
+	claims, ok := ctx.Value(claimsKey{}).(*auth.UserJWTClaims)
+	return claims, ok
+}
+
+// UserFromContext returns authenticated user stored by the middleware.
+func UserFromContext(ctx context.Context) (*auth.User, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.User == nil {
+		return nil, false
+	}
+	return claims.User, true
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
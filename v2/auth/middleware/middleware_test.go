@@ -0,0 +1,132 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/cache"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/auth/store/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGin(t *testing.T) {
+	c, err := cache.New(context.Background(), memory.New())
+	require.NoError(t, err)
+	a := middleware.New(middleware.LocalVerifier(c),
+		middleware.WithAudience("app"),
+		middleware.WithIssuer("https://auth.example.com"),
+		middleware.WithCookie("token"),
+	)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(a.Gin())
+	r.GET("/", func(c *gin.Context) {
+		u, ok := c.Get(middleware.UserKey)
+		require.True(t, ok)
+		ginUser, ok := u.(*auth.User)
+		require.True(t, ok)
+		user, ok := middleware.UserFromContext(c.Request.Context())
+		require.True(t, ok)
+		require.Same(t, ginUser, user)
+		claims, ok := middleware.ClaimsFromContext(c)
+		require.True(t, ok)
+		c.String(http.StatusOK, claims.Subject)
+	})
+
+	valid := sign(t, c.GetCurrentKey(), "app", "https://auth.example.com", time.Hour)
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		code   int
+		body   string
+	}{
+		{name: "header", header: "Bearer " + valid, code: http.StatusOK, body: "email=user@example.com"},
+		{name: "cookie", cookie: valid, code: http.StatusOK, body: "email=user@example.com"},
+		{name: "missing", code: http.StatusUnauthorized, body: `{"code":401,"message":"authentication required"}`},
+		{name: "wrong scheme", header: "Basic " + valid, code: http.StatusUnauthorized},
+		{name: "garbage", header: "Bearer foo", code: http.StatusUnauthorized, body: `{"code":401,"message":"invalid token","error_type":"invalid_token"}`},
+		{name: "audience", header: "Bearer " + sign(t, c.GetCurrentKey(), "other", "https://auth.example.com", time.Hour), code: http.StatusUnauthorized},
+		{name: "issuer", header: "Bearer " + sign(t, c.GetCurrentKey(), "app", "https://evil.example.com", time.Hour), code: http.StatusUnauthorized},
+		{name: "expired", header: "Bearer " + sign(t, c.GetCurrentKey(), "app", "https://auth.example.com", -time.Minute), code: http.StatusUnauthorized},
+		{name: "within leeway", header: "Bearer " + sign(t, c.GetCurrentKey(), "app", "https://auth.example.com", -time.Second), code: http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "token", Value: tc.cookie})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, tc.code, w.Code)
+			if tc.body != "" {
+				require.Equal(t, tc.body, stripNewline(w.Body.String()))
+			}
+			if tc.code == http.StatusUnauthorized {
+				require.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c, err := cache.New(context.Background(), memory.New())
+	require.NoError(t, err)
+	a := middleware.New(middleware.LocalVerifier(c), middleware.WithOptional())
+
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := middleware.UserFromContext(r.Context()); ok {
+			_, _ = w.Write([]byte(user.MakeSub()))
+			return
+		}
+		_, _ = w.Write([]byte("anonymous"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, "anonymous", w.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, c.GetCurrentKey(), "app", "", time.Hour))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, "email=user@example.com", w.Body.String())
+
+	// invalid tokens are rejected even when authentication is optional
+	req.Header.Set("Authorization", "Bearer invalid")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func sign(t *testing.T, key auth.JWTKey, aud, iss string, validFor time.Duration) string {
+	t.Helper()
+	token, err := auth.NewToken(&auth.User{Email: common.Ptr("user@example.com")}).SignExpires(key, auth.SignClaims{
+		Aud:    aud,
+		Exp:    time.Now().Add(validFor).Unix(),
+		Iat:    time.Now().Add(-time.Hour).Unix(),
+		Issuer: iss,
+		Scopes: auth.AllScopes,
+	})
+	require.NoError(t, err)
+	return token
+}
+
+func stripNewline(s string) string {
+	if len(s) > 0 && s[len(s)-1] == '\n' {
+		return s[:len(s)-1]
+	}
+	return s
+}
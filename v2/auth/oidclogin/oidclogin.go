@@ -0,0 +1,301 @@
+// Package oidclogin provides gin handlers for logging users in with OpenID Connect
+// authorization code flow and PKCE. Logged in users are kept in encrypted cookies.
+package oidclogin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/jwks"
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/elisasre/go-common/v2/secretbox"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrMissingIssuer      = errors.New("missing issuer")
+	ErrMissingClientID    = errors.New("missing client id")
+	ErrMissingRedirectURL = errors.New("missing redirect url")
+	ErrMissingBox         = errors.New("missing secretbox for encrypting cookies")
+	ErrIssuerMismatch     = errors.New("discovery document issuer does not match")
+	ErrInvalidState       = errors.New("invalid login state")
+	ErrInvalidNonce       = errors.New("invalid nonce")
+	ErrNotLoggedIn        = errors.New("not logged in")
+)
+
+// Config contains OpenID Connect client registration.
+type Config struct {
+	// Issuer is the IdP issuer URL used for discovery.
+	Issuer   string
+	ClientID string
+	// ClientSecret is optional, without it client authenticates as public client using PKCE only.
+	ClientSecret string
+	// RedirectURL is absolute URL of the callback handler registered to the IdP.
+	RedirectURL string
+	// Scopes defaults to openid, email and profile.
+	Scopes []string
+}
+
+// ClaimsMapper maps verified ID token claims to user.
+type ClaimsMapper func(ctx context.Context, claims []byte) (*auth.User, error)
+
+// Login implements login, callback and logout handlers.
+type Login struct {
+	conf       Config
+	provider   jwks.ProviderMetadata
+	keys       *jwks.RemoteKeySet
+	box        *secretbox.Box
+	client     httputil.HTTPClient
+	mapper     ClaimsMapper
+	cookieName string
+	secure     bool
+	sessionTTL time.Duration
+	flowTTL    time.Duration
+	loginPath  string
+	logoutURL  string
+	leeway     time.Duration
+}
+
+type Opt func(*Login)
+
+// WithHTTPClient sets client used for discovery, keys and token requests, defaults to http.Client with 10 second timeout.
+func WithHTTPClient(c httputil.HTTPClient) Opt {
+	return func(l *Login) {
+		l.client = c
+	}
+}
+
+// WithClaimsMapper replaces default mapping which decodes claims to auth.User using its JSON tags.
+func WithClaimsMapper(m ClaimsMapper) Opt {
+	return func(l *Login) {
+		l.mapper = m
+	}
+}
+
+// WithCookieName sets name of the session cookie, defaults to "session".
+// Login state is kept in cookie with "_login" suffix.
+func WithCookieName(name string) Opt {
+	return func(l *Login) {
+		l.cookieName = name
+	}
+}
+
+// WithInsecureCookies allows cookies over plain HTTP, it should be used only in local development.
+func WithInsecureCookies() Opt {
+	return func(l *Login) {
+		l.secure = false
+	}
+}
+
+// WithSessionTTL sets how long user stays logged in, defaults to 8 hours.
+func WithSessionTTL(d time.Duration) Opt {
+	return func(l *Login) {
+		l.sessionTTL = d
+	}
+}
+
+// WithLoginPath sets path of login handler where RequireLogin redirects, defaults to "/login".
+func WithLoginPath(path string) Opt {
+	return func(l *Login) {
+		l.loginPath = path
+	}
+}
+
+// WithPostLogoutRedirectURL sets where user is sent after logout. It must be absolute URL
+// if IdP supports RP-initiated logout, defaults to "/".
+func WithPostLogoutRedirectURL(u string) Opt {
+	return func(l *Login) {
+		l.logoutURL = u
+	}
+}
+
+// New discovers IdP configuration and creates Login. Box encrypts the cookies, use multiple keys for rotation.
+func New(ctx context.Context, conf Config, box *secretbox.Box, opts ...Opt) (*Login, error) {
+	switch {
+	case conf.Issuer == "":
+		return nil, ErrMissingIssuer
+	case conf.ClientID == "":
+		return nil, ErrMissingClientID
+	case conf.RedirectURL == "":
+		return nil, ErrMissingRedirectURL
+	case box == nil:
+		return nil, ErrMissingBox
+	}
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{auth.ScopeOpenID, auth.ScopeEmail, auth.ScopeProfile}
+	}
+
+	l := &Login{
+		conf:       conf,
+		box:        box,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		mapper:     DefaultClaimsMapper,
+		cookieName: "session",
+		secure:     true,
+		sessionTTL: 8 * time.Hour,
+		flowTTL:    10 * time.Minute,
+		loginPath:  "/login",
+		logoutURL:  "/",
+		leeway:     30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	_, err := httputil.MakeRequest(ctx, httputil.Request{
+		Method: http.MethodGet,
+		URL:    strings.TrimSuffix(conf.Issuer, "/") + jwks.DiscoveryPath,
+		OKCode: []int{http.StatusOK},
+	}, &l.provider, l.client, httputil.Backoff{Duration: time.Second, MaxTries: 3})
+	if err != nil {
+		return nil, fmt.Errorf("fetching discovery document for %s failed: %w", conf.Issuer, err)
+	}
+	if l.provider.Issuer != conf.Issuer {
+		return nil, fmt.Errorf("%w: '%s'", ErrIssuerMismatch, l.provider.Issuer)
+	}
+	l.keys = jwks.NewRemoteKeySet(l.provider.JWKSURI, jwks.WithHTTPClient(l.client))
+	return l, nil
+}
+
+// Register adds GET /login, GET /callback and GET and POST /logout handlers.
+func (l *Login) Register(r gin.IRoutes) {
+	r.GET("/login", l.Login)
+	r.GET("/callback", l.Callback)
+	r.GET("/logout", l.Logout)
+	r.POST("/logout", l.Logout)
+}
+
+// loginFlow is the state of pending login kept in cookie between login and callback.
+type loginFlow struct {
+	State     string    `json:"state"`
+	Nonce     string    `json:"nonce"`
+	Verifier  string    `json:"verifier"`
+	ReturnTo  string    `json:"return_to"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// Login redirects user to the IdP. Query parameter return_to sets local path where user is sent after login.
+func (l *Login) Login(c *gin.Context) {
+	flow := loginFlow{
+		ReturnTo:  safeReturnTo(c.Query("return_to")),
+		ExpiresAt: time.Now().Add(l.flowTTL),
+	}
+	for _, v := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		var err error
+		if *v, err = randomString(); err != nil {
+			l.abort(c, http.StatusInternalServerError, "login failed", err)
+			return
+		}
+	}
+	if err := l.setCookie(c, l.flowCookie(), flow, l.flowTTL); err != nil {
+		l.abort(c, http.StatusInternalServerError, "login failed", err)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", l.conf.ClientID)
+	q.Set("redirect_uri", l.conf.RedirectURL)
+	q.Set("scope", strings.Join(l.conf.Scopes, " "))
+	q.Set("state", flow.State)
+	q.Set("nonce", flow.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	c.Redirect(http.StatusFound, appendQuery(l.provider.AuthorizationEndpoint, q))
+}
+
+// Callback completes login by exchanging authorization code to tokens and verifying ID token.
+func (l *Login) Callback(c *gin.Context) {
+	var flow loginFlow
+	err := l.readCookie(c.Request, l.flowCookie(), &flow)
+	l.clearCookie(c, l.flowCookie())
+	if err != nil || time.Now().After(flow.ExpiresAt) {
+		l.abort(c, http.StatusBadRequest, "login has expired", fmt.Errorf("%w: %w", ErrInvalidState, err))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(flow.State)) != 1 {
+		l.abort(c, http.StatusBadRequest, "invalid login state", ErrInvalidState)
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		l.abort(c, http.StatusUnauthorized, "login failed", fmt.Errorf("idp returned error '%s': %s", e, c.Query("error_description")))
+		return
+	}
+
+	rawIDToken, err := l.exchange(c.Request.Context(), c.Query("code"), flow.Verifier)
+	if err != nil {
+		l.abort(c, http.StatusUnauthorized, "login failed", err)
+		return
+	}
+	sess, err := l.verifyIDToken(c.Request.Context(), rawIDToken, flow.Nonce)
+	if err != nil {
+		l.abort(c, http.StatusUnauthorized, "login failed", err)
+		return
+	}
+	if err := l.setCookie(c, l.cookieName, sess, l.sessionTTL); err != nil {
+		l.abort(c, http.StatusInternalServerError, "login failed", err)
+		return
+	}
+	c.Redirect(http.StatusFound, flow.ReturnTo)
+}
+
+// Logout clears session and redirects to IdP end session endpoint if it has one.
+func (l *Login) Logout(c *gin.Context) {
+	l.clearCookie(c, l.cookieName)
+	if l.provider.EndSessionEndpoint == "" {
+		c.Redirect(http.StatusFound, l.logoutURL)
+		return
+	}
+	q := url.Values{}
+	q.Set("client_id", l.conf.ClientID)
+	if u, err := url.Parse(l.logoutURL); err == nil && u.IsAbs() {
+		q.Set("post_logout_redirect_uri", l.logoutURL)
+	}
+	c.Redirect(http.StatusFound, appendQuery(l.provider.EndSessionEndpoint, q))
+}
+
+func (l *Login) flowCookie() string {
+	return l.cookieName + "_login"
+}
+
+func (l *Login) abort(c *gin.Context, code int, msg string, err error) {
+	ctxlog.Warn(c.Request.Context(), "oidc login failed", slog.String("error", err.Error()))
+	c.AbortWithStatusJSON(code, httputil.ErrorResponse{Code: uint(code), Message: msg}) //nolint:gosec // G115: status codes are positive
+}
+
+// safeReturnTo accepts only local absolute paths so login can't be used as open redirect.
+func safeReturnTo(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	return p
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating random value failed: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func appendQuery(endpoint string, q url.Values) string {
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+	return endpoint + sep + q.Encode()
+}
@@ -0,0 +1,321 @@
+package oidclogin_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/cache"
+	"github.com/elisasre/go-common/v2/auth/jwks"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/auth/oidclogin"
+	"github.com/elisasre/go-common/v2/auth/store/memory"
+	"github.com/elisasre/go-common/v2/secretbox"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+const clientID = "app"
+
+type authRequest struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+// stubIdP implements enough of OpenID Connect provider for testing the login flow.
+type stubIdP struct {
+	*httptest.Server
+	keys  *cache.Cache
+	user  auth.User
+	nonce string
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	keys, err := cache.New(context.Background(), memory.New())
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	idp := &stubIdP{
+		Server: httptest.NewServer(mux),
+		keys:   keys,
+		user: auth.User{
+			Email:        common.Ptr("Test.User@example.com"),
+			Name:         common.Ptr("Test User"),
+			ImportGroups: []string{"admins"},
+			Department:   "sre",
+		},
+		codes: map[string]authRequest{},
+	}
+	t.Cleanup(idp.Close)
+
+	jwks.NewServer(keys, jwks.WithMetadata(jwks.ProviderMetadata{
+		Issuer:                idp.URL,
+		AuthorizationEndpoint: idp.URL + "/authorize",
+		TokenEndpoint:         idp.URL + "/token",
+		EndSessionEndpoint:    idp.URL + "/logout",
+	})).Register(mux)
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+	return idp
+}
+
+func (s *stubIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != clientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	code, err := auth.NewTokenID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	s.codes[code] = authRequest{
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	s.mu.Unlock()
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (s *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	req, ok := s.codes[r.PostFormValue("code")]
+	delete(s.codes, r.PostFormValue("code"))
+	s.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("client_id") != clientID || r.PostFormValue("redirect_uri") != req.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != req.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := req.nonce
+	if s.nonce != "" {
+		nonce = s.nonce
+	}
+	user := s.user
+	idToken, err := auth.NewToken(&user).SignExpires(s.keys.GetCurrentKey(), auth.SignClaims{
+		Aud:    clientID,
+		Issuer: s.URL,
+		Exp:    time.Now().Add(time.Hour).Unix(),
+		Nonce:  nonce,
+		Scopes: []string{auth.ScopeOpenID, auth.ScopeEmail, auth.ScopeProfile},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+func newApp(t *testing.T, idp *stubIdP) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	app := httptest.NewServer(r)
+	t.Cleanup(app.Close)
+
+	key, err := secretbox.GenerateKey()
+	require.NoError(t, err)
+	box, err := secretbox.New(secretbox.WithKey("1", key))
+	require.NoError(t, err)
+
+	l, err := oidclogin.New(context.Background(), oidclogin.Config{
+		Issuer:      idp.URL,
+		ClientID:    clientID,
+		RedirectURL: app.URL + "/callback",
+	}, box, oidclogin.WithInsecureCookies(), oidclogin.WithPostLogoutRedirectURL(app.URL+"/"))
+	require.NoError(t, err)
+
+	l.Register(r)
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "home") })
+	r.GET("/private", l.RequireLogin(), func(c *gin.Context) {
+		user, ok := middleware.UserFromContext(c.Request.Context())
+		if !ok {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusOK, user)
+	})
+	return app
+}
+
+func newClient(t *testing.T, follow bool) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	if !follow {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return client
+}
+
+func get(t *testing.T, client *http.Client, u string, headers ...string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, u, nil)
+	require.NoError(t, err)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := newStubIdP(t)
+	app := newApp(t, idp)
+	client := newClient(t, true)
+
+	resp := get(t, client, app.URL+"/private?tab=1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "/private", resp.Request.URL.Path)
+	require.Equal(t, "tab=1", resp.Request.URL.RawQuery)
+
+	var user auth.User
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&user))
+	require.Equal(t, "test.user@example.com", *user.Email)
+	require.Equal(t, "Test User", *user.Name)
+	require.Equal(t, []string{"admins"}, user.Groups)
+	require.Equal(t, "sre", user.Department)
+
+	// API requests get 401 instead of redirect
+	resp = get(t, newClient(t, true), app.URL+"/private", "Accept", "application/json")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestLoginRejectsOpenRedirect(t *testing.T) {
+	idp := newStubIdP(t)
+	app := newApp(t, idp)
+
+	for _, returnTo := range []string{"//evil.example.com", "https://evil.example.com", "/\\evil.example.com"} {
+		resp := get(t, newClient(t, true), app.URL+"/login?"+url.Values{"return_to": {returnTo}}.Encode())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, app.URL+"/", resp.Request.URL.String())
+	}
+}
+
+func TestCallbackInvalidState(t *testing.T) {
+	idp := newStubIdP(t)
+	app := newApp(t, idp)
+	client := newClient(t, false)
+
+	resp := get(t, client, app.URL+"/login")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	authorize, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, clientID, authorize.Query().Get("client_id"))
+	require.Equal(t, "S256", authorize.Query().Get("code_challenge_method"))
+
+	resp = get(t, client, resp.Header.Get("Location"))
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	q := callback.Query()
+	q.Set("state", "forged")
+	callback.RawQuery = q.Encode()
+
+	resp = get(t, client, callback.String())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// login cookie is cleared after the first callback
+	q.Set("state", authorize.Query().Get("state"))
+	callback.RawQuery = q.Encode()
+	resp = get(t, client, callback.String())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestCallbackInvalidNonce(t *testing.T) {
+	idp := newStubIdP(t)
+	idp.nonce = "replayed"
+	app := newApp(t, idp)
+
+	resp := get(t, newClient(t, true), app.URL+"/private")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, "/callback", resp.Request.URL.Path)
+}
+
+func TestTamperedSession(t *testing.T) {
+	idp := newStubIdP(t)
+	app := newApp(t, idp)
+	client := newClient(t, true)
+
+	resp := get(t, client, app.URL+"/private")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	appURL, err := url.Parse(app.URL)
+	require.NoError(t, err)
+	for _, c := range client.Jar.Cookies(appURL) {
+		if c.Name == "session" {
+			c.Value = c.Value[:len(c.Value)-4] + "AAAA"
+			client.Jar.SetCookies(appURL, []*http.Cookie{c})
+		}
+	}
+
+	resp = get(t, client, app.URL+"/private", "Accept", "application/json")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestLogout(t *testing.T) {
+	idp := newStubIdP(t)
+	app := newApp(t, idp)
+	client := newClient(t, true)
+
+	resp := get(t, client, app.URL+"/private")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp = get(t, client, app.URL+"/logout")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	logout, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, idp.URL+"/logout", logout.Scheme+"://"+logout.Host+logout.Path)
+	require.Equal(t, clientID, logout.Query().Get("client_id"))
+	require.Equal(t, app.URL+"/", logout.Query().Get("post_logout_redirect_uri"))
+
+	resp = get(t, client, app.URL+"/private", "Accept", "application/json")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestNewValidation(t *testing.T) {
+	box, err := secretbox.New(secretbox.WithKey("1", make([]byte, 32)))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = oidclogin.New(ctx, oidclogin.Config{ClientID: clientID, RedirectURL: "http://localhost/callback"}, box)
+	require.ErrorIs(t, err, oidclogin.ErrMissingIssuer)
+	_, err = oidclogin.New(ctx, oidclogin.Config{Issuer: "http://localhost", ClientID: clientID, RedirectURL: "http://localhost/callback"}, nil)
+	require.ErrorIs(t, err, oidclogin.ErrMissingBox)
+
+	idp := newStubIdP(t)
+	_, err = oidclogin.New(ctx, oidclogin.Config{Issuer: idp.URL + "/", ClientID: clientID, RedirectURL: "http://localhost/callback"}, box)
+	require.ErrorIs(t, err, oidclogin.ErrIssuerMismatch)
+}
@@ -0,0 +1,131 @@
+package oidclogin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// maxCookieSize is the smallest cookie size limit browsers are required to support.
+const maxCookieSize = 4096
+
+var ErrCookieTooLarge = errors.New("session cookie is too large")
+
+// Session is logged in user stored in the session cookie.
+type Session struct {
+	User      *auth.User `json:"user"`
+	Subject   string     `json:"sub"`
+	ExpiresAt time.Time  `json:"exp"`
+}
+
+// Session returns session of the request. ErrNotLoggedIn is returned if session is missing, invalid or expired.
+func (l *Login) Session(r *http.Request) (*Session, error) {
+	var sess Session
+	if err := l.readCookie(r, l.cookieName, &sess); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotLoggedIn, err)
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		return nil, fmt.Errorf("%w: session has expired", ErrNotLoggedIn)
+	}
+	if sess.User == nil {
+		sess.User = &auth.User{}
+	}
+	return &sess, nil
+}
+
+// RequireLogin returns gin middleware which stores logged in user like middleware.Authenticator
+// so middleware.UserFromContext works. Browsers are redirected to login when session is missing,
+// other requests get 401.
+func (l *Login) RequireLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sess, err := l.Session(c.Request)
+		if err != nil {
+			if c.Request.Method == http.MethodGet && c.GetHeader("Accept") != "application/json" {
+				c.Redirect(http.StatusFound, l.loginPath+"?"+url.Values{"return_to": {c.Request.URL.RequestURI()}}.Encode())
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, httputil.ErrorResponse{
+				Code:    http.StatusUnauthorized,
+				Message: "authentication required",
+			})
+			return
+		}
+
+		claims := &auth.UserJWTClaims{
+			User: sess.User,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   sess.Subject,
+				Issuer:    l.provider.Issuer,
+				ExpiresAt: jwt.NewNumericDate(sess.ExpiresAt),
+			},
+		}
+		c.Request = c.Request.WithContext(middleware.WithClaims(c.Request.Context(), claims))
+		c.Set(middleware.UserKey, claims.User)
+		c.Set(middleware.ClaimsKey, claims)
+		c.Next()
+	}
+}
+
+// setCookie encrypts value using cookie name as additional data so values can't be swapped between cookies.
+func (l *Login) setCookie(c *gin.Context, name string, value any, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding cookie failed: %w", err)
+	}
+	sealed, err := l.box.Seal(data, []byte(name))
+	if err != nil {
+		return fmt.Errorf("encrypting cookie failed: %w", err)
+	}
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    base64.RawURLEncoding.EncodeToString(sealed),
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   l.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(cookie.String()) > maxCookieSize {
+		return fmt.Errorf("%w: %d bytes", ErrCookieTooLarge, len(cookie.String()))
+	}
+	http.SetCookie(c.Writer, cookie)
+	return nil
+}
+
+func (l *Login) readCookie(r *http.Request, name string, value any) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return fmt.Errorf("decoding cookie failed: %w", err)
+	}
+	data, err := l.box.Open(sealed, []byte(name))
+	if err != nil {
+		return fmt.Errorf("decrypting cookie failed: %w", err)
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (l *Login) clearCookie(c *gin.Context, name string) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   l.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
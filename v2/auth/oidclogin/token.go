@@ -0,0 +1,142 @@
+package oidclogin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// idTokenClaims contains claims validated in addition to registered claims.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce string `json:"nonce"`
+	AZP   string `json:"azp"`
+}
+
+// exchange redeems authorization code and returns raw ID token.
+func (l *Login) exchange(ctx context.Context, code, verifier string) (string, error) {
+	if code == "" {
+		return "", errors.New("callback is missing authorization code")
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", l.conf.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if l.conf.ClientSecret == "" {
+		form.Set("client_id", l.conf.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if l.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(l.conf.ClientID), url.QueryEscape(l.conf.ClientSecret))
+	}
+
+	// authorization codes are single use so the request is never retried
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("reading token response failed: %w", err)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return "", fmt.Errorf("token request failed with status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d: %s %s", resp.StatusCode, tr.Error, tr.ErrorDescription)
+	}
+	if tr.IDToken == "" {
+		return "", errors.New("token response doesn't contain id_token")
+	}
+	return tr.IDToken, nil
+}
+
+// verifyIDToken validates ID token as described in OpenID Connect Core 3.1.3.7 and maps its claims to session.
+func (l *Login) verifyIDToken(ctx context.Context, raw, nonce string) (*Session, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := l.keys.Key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.Alg() != t.Method.Alg() {
+			return nil, fmt.Errorf("token algorithm %s doesn't match key algorithm %s", t.Method.Alg(), key.Alg())
+		}
+		return key.PublicKey, nil
+	},
+		jwt.WithValidMethods(auth.SupportedAlgorithms),
+		jwt.WithIssuer(l.provider.Issuer),
+		jwt.WithAudience(l.conf.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(l.leeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+	if len(claims.Audience) > 1 && claims.AZP != l.conf.ClientID {
+		return nil, fmt.Errorf("invalid id token: authorized party '%s' is not the client", claims.AZP)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrInvalidNonce
+	}
+
+	// signature is already verified so the payload can be decoded directly
+	parts := strings.Split(raw, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("decoding id token claims failed: %w", err)
+	}
+	user, err := l.mapper(ctx, payload)
+	if err != nil {
+		return nil, fmt.Errorf("mapping id token claims failed: %w", err)
+	}
+	return &Session{
+		User:      user,
+		Subject:   claims.Subject,
+		ExpiresAt: time.Now().Add(l.sessionTTL).Truncate(time.Second),
+	}, nil
+}
+
+// DefaultClaimsMapper decodes claims to auth.User. Cognito groups are used as groups
+// when the IdP doesn't return groups claim.
+func DefaultClaimsMapper(_ context.Context, claims []byte) (*auth.User, error) {
+	user := &auth.User{}
+	if err := json.Unmarshal(claims, user); err != nil {
+		return nil, err
+	}
+	if len(user.Groups) == 0 {
+		user.Groups = user.ImportGroups
+	}
+	// internal claims are issued by our own token service, never trust them from the IdP
+	user.Internal = nil
+	return user, nil
+}
@@ -0,0 +1,183 @@
+// Package policy provides declarative authorization requirements for authenticated users.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// DeniedError is returned by requirements which are not met, Reason is safe to show to the caller.
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string { return "forbidden: " + e.Reason }
+
+func (e *DeniedError) Is(target error) bool { return target == ErrForbidden }
+
+// Deny creates DeniedError with given reason.
+func Deny(format string, args ...any) error {
+	return &DeniedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Requirement checks whether verified claims are authorized.
+// Check returns nil when access is allowed and DeniedError explaining the reason otherwise.
+type Requirement interface {
+	Check(claims *auth.UserJWTClaims) error
+}
+
+// RequirementFunc allows using ordinary function as Requirement.
+type RequirementFunc func(claims *auth.UserJWTClaims) error
+
+func (fn RequirementFunc) Check(claims *auth.UserJWTClaims) error { return fn(claims) }
+
+// RequireScopes requires token to be granted all given scopes.
+func RequireScopes(scopes ...string) Requirement {
+	return RequirementFunc(func(claims *auth.UserJWTClaims) error {
+		granted := claims.Scopes()
+		for _, s := range scopes {
+			if !slices.Contains(granted, s) {
+				return Deny("missing scope '%s'", s)
+			}
+		}
+		return nil
+	})
+}
+
+// RequireAnyGroup requires user to be member of at least one of given groups.
+// Both Groups and ImportGroups are considered.
+func RequireAnyGroup(groups ...string) Requirement {
+	return RequirementFunc(func(claims *auth.UserJWTClaims) error {
+		if u := claims.User; u != nil {
+			for _, g := range groups {
+				if slices.Contains(u.Groups, g) || slices.Contains(u.ImportGroups, g) {
+					return nil
+				}
+			}
+		}
+		return Deny("requires membership in one of groups: %s", strings.Join(groups, ", "))
+	})
+}
+
+// RequireMFA requires token to be issued using multi-factor authentication.
+func RequireMFA() Requirement {
+	return RequirementFunc(func(claims *auth.UserJWTClaims) error {
+		if claims.User == nil || !claims.TokenMFA() {
+			return Deny("multi-factor authentication required")
+		}
+		return nil
+	})
+}
+
+// RequireServiceAccount requires caller to be a service account.
+func RequireServiceAccount() Requirement {
+	return RequirementFunc(func(claims *auth.UserJWTClaims) error {
+		if claims.User == nil || !claims.IsServiceAccount() {
+			return Deny("service account required")
+		}
+		return nil
+	})
+}
+
+// RequireNoActor requires token to be used by the subject itself, denying impersonated and delegated tokens.
+func RequireNoActor() Requirement {
+	return RequirementFunc(func(claims *auth.UserJWTClaims) error {
+		if claims.Actor != nil {
+			return Deny("not allowed on behalf of another user")
+		}
+		return nil
+	})
+}
+
+// All requires all of the given requirements to be met. First failing requirement is reported.
+func All(reqs ...Requirement) Requirement {
+	return RequirementFunc(func(claims *auth.UserJWTClaims) error {
+		for _, r := range reqs {
+			if err := r.Check(claims); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Any requires at least one of the given requirements to be met. Reasons of all failures are reported.
+func Any(reqs ...Requirement) Requirement {
+	return RequirementFunc(func(claims *auth.UserJWTClaims) error {
+		reasons := make([]string, 0, len(reqs))
+		for _, r := range reqs {
+			err := r.Check(claims)
+			if err == nil {
+				return nil
+			}
+			var denied *DeniedError
+			if !errors.As(err, &denied) {
+				return err
+			}
+			reasons = append(reasons, denied.Reason)
+		}
+		return Deny("%s", strings.Join(reasons, " or "))
+	})
+}
+
+// Authorize checks requirement against claims. Missing claims are always denied.
+func Authorize(claims *auth.UserJWTClaims, req Requirement) error {
+	if claims == nil {
+		return Deny("authentication required")
+	}
+	return req.Check(claims)
+}
+
+func errorResponse(claims *auth.UserJWTClaims, err error) (int, httputil.ErrorResponse) {
+	if claims == nil {
+		return http.StatusUnauthorized, httputil.ErrorResponse{Code: http.StatusUnauthorized, Message: "authentication required"}
+	}
+	var denied *DeniedError
+	if errors.As(err, &denied) {
+		return http.StatusForbidden, httputil.ErrorResponse{Code: http.StatusForbidden, Message: denied.Reason, ErrorType: "forbidden"}
+	}
+	return http.StatusForbidden, httputil.ErrorResponse{Code: http.StatusForbidden, Message: "forbidden", ErrorType: "forbidden"}
+}
+
+// Gin returns gin middleware enforcing requirement for claims stored by auth/middleware.
+// Requests are rejected with 403 and reason when requirement isn't met and with 401 when they are unauthenticated.
+func Gin(req Requirement) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := middleware.ClaimsFromContext(c)
+		if err := Authorize(claims, req); err != nil {
+			code, resp := errorResponse(claims, err)
+			c.JSON(code, resp)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Handler returns net/http middleware enforcing requirement for claims stored by auth/middleware.
+func Handler(req Requirement) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := middleware.ClaimsFromContext(r.Context())
+			if err := Authorize(claims, req); err != nil {
+				code, resp := errorResponse(claims, err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(code)
+				_ = json.NewEncoder(w).Encode(resp)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
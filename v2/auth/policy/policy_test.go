@@ -0,0 +1,94 @@
+package policy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/auth/policy"
+	"github.com/elisasre/go-common/v2/auth/policy/policytest"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequirements(t *testing.T) {
+	user := policytest.Claims()
+
+	policytest.AssertDenied(t, policy.RequireScopes(auth.ScopeGroups), user, "missing scope 'groups'")
+	policytest.AssertAllowed(t, policy.RequireScopes(auth.ScopeOpenID, auth.ScopeGroups), policytest.Claims(policytest.WithScopes(auth.ScopeGroups)))
+
+	policytest.AssertDenied(t, policy.RequireAnyGroup("admins", "ops"), user, "admins, ops")
+	policytest.AssertAllowed(t, policy.RequireAnyGroup("admins", "ops"), policytest.Claims(policytest.WithGroups("ops")))
+	imported := policytest.Claims()
+	imported.ImportGroups = []string{"admins"}
+	policytest.AssertAllowed(t, policy.RequireAnyGroup("admins"), imported)
+
+	policytest.AssertDenied(t, policy.RequireMFA(), user, "multi-factor")
+	policytest.AssertAllowed(t, policy.RequireMFA(), policytest.Claims(policytest.WithMFA()))
+
+	policytest.AssertDenied(t, policy.RequireServiceAccount(), user, "service account")
+	policytest.AssertAllowed(t, policy.RequireServiceAccount(), policytest.Claims(policytest.AsServiceAccount("deployer")))
+
+	policytest.AssertDenied(t, policy.RequireNoActor(), policytest.Claims(policytest.WithActor("email=admin@example.com")), "on behalf")
+	policytest.AssertAllowed(t, policy.RequireNoActor(), user)
+
+	policytest.AssertDenied(t, policy.RequireMFA(), nil, "authentication required")
+}
+
+func TestComposition(t *testing.T) {
+	// admins with MFA or service accounts with deploy scope
+	p := policy.Any(
+		policy.All(policy.RequireAnyGroup("admins"), policy.RequireMFA()),
+		policy.All(policy.RequireServiceAccount(), policy.RequireScopes("deploy")),
+	)
+
+	policytest.AssertAllowed(t, p, policytest.Claims(policytest.WithGroups("admins"), policytest.WithMFA()))
+	policytest.AssertAllowed(t, p, policytest.Claims(policytest.AsServiceAccount("ci"), policytest.WithScopes("deploy")))
+	policytest.AssertDenied(t, p, policytest.Claims(policytest.WithGroups("admins")), "multi-factor authentication required or service account required")
+	policytest.AssertDenied(t, p, policytest.Claims(policytest.AsServiceAccount("ci")), "missing scope 'deploy'")
+}
+
+func TestGin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	claims := policytest.Claims()
+	r.Use(func(c *gin.Context) {
+		if c.GetHeader("X-Anonymous") == "" {
+			c.Set(middleware.ClaimsKey, claims)
+		}
+	})
+	r.GET("/admin", policy.Gin(policy.RequireAnyGroup("admins")), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/user", policy.Gin(policy.RequireScopes(auth.ScopeOpenID)), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.JSONEq(t, `{"code":403,"message":"requires membership in one of groups: admins","error_type":"forbidden"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("X-Anonymous", "true")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandler(t *testing.T) {
+	h := policy.Handler(policy.RequireMFA())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for claims, code := range map[*auth.UserJWTClaims]int{
+		policytest.Claims():                     http.StatusForbidden,
+		policytest.Claims(policytest.WithMFA()): http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(middleware.WithClaims(req.Context(), claims))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		require.Equal(t, code, w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+// Package policytest provides helpers for testing authorization policies against fabricated users.
+package policytest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/policy"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type Opt func(*auth.UserJWTClaims)
+
+// WithScopes grants scopes to the token.
+func WithScopes(scopes ...string) Opt {
+	return func(c *auth.UserJWTClaims) {
+		c.Scope = strings.Join(append(c.Scopes(), scopes...), " ")
+	}
+}
+
+// WithGroups adds user to groups.
+func WithGroups(groups ...string) Opt {
+	return func(c *auth.UserJWTClaims) {
+		c.Groups = append(c.Groups, groups...)
+	}
+}
+
+// WithMFA marks token to be issued using multi-factor authentication.
+func WithMFA() Opt {
+	return func(c *auth.UserJWTClaims) {
+		if c.Internal == nil {
+			c.Internal = &auth.Internal{}
+		}
+		c.Internal.MFA = common.Ptr(true)
+	}
+}
+
+// AsServiceAccount turns user into service account with given name.
+func AsServiceAccount(name string) Opt {
+	return func(c *auth.UserJWTClaims) {
+		c.Email = common.Ptr(name + auth.ServiceAccountPrefix)
+	}
+}
+
+// WithActor marks token to be used by given actor on behalf of the user.
+func WithActor(sub string) Opt {
+	return func(c *auth.UserJWTClaims) {
+		c.Actor = &auth.Actor{Subject: sub, Actor: c.Actor}
+	}
+}
+
+// Claims fabricates verified claims for user@example.com with openid scope.
+func Claims(opts ...Opt) *auth.UserJWTClaims {
+	c := &auth.UserJWTClaims{
+		User:  &auth.User{Email: common.Ptr("user@example.com")},
+		Scope: auth.ScopeOpenID,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.RegisteredClaims = jwt.RegisteredClaims{Subject: c.MakeSub()}
+	return c
+}
+
+// AssertAllowed asserts that requirement allows claims.
+func AssertAllowed(t testing.TB, req policy.Requirement, claims *auth.UserJWTClaims) bool {
+	t.Helper()
+	return assert.NoError(t, policy.Authorize(claims, req))
+}
+
+// AssertDenied asserts that requirement denies claims with reason containing given text.
+func AssertDenied(t testing.TB, req policy.Requirement, claims *auth.UserJWTClaims, reason string) bool {
+	t.Helper()
+	err := policy.Authorize(claims, req)
+	var denied *policy.DeniedError
+	if !assert.True(t, errors.As(err, &denied), "expected requirement to deny access, got: %v", err) {
+		return false
+	}
+	return assert.Contains(t, denied.Reason, reason)
+}
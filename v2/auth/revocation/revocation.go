@@ -0,0 +1,206 @@
+// Package revocation provides access token revocation and rotating refresh tokens with reuse detection.
+// Storage implementations can be found under: github.com/elisasre/go-common/v2/auth/store.
+package revocation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrRevoked                = errors.New("token has been revoked")
+	ErrRefreshTokenNotFound   = errors.New("refresh token not found")
+	ErrRefreshTokenExpired    = errors.New("refresh token has expired")
+	ErrRefreshTokenReused     = errors.New("refresh token reuse detected")
+	ErrRefreshTokenRevoked    = errors.New("refresh token has been revoked")
+	ErrMissingTokenIdentifier = errors.New("token doesn't contain jti claim")
+)
+
+// RefreshToken is the stored state of a single refresh token.
+// Token value itself is never stored, only its hash is.
+type RefreshToken struct {
+	// ID is the hash of the token value.
+	ID string
+	// FamilyID is shared by all tokens rotated from the same original token.
+	FamilyID  string
+	Subject   string
+	User      *auth.User
+	Scopes    []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// Store represents required storage interface.
+type Store interface {
+	// RevokeToken revokes access token with given jti until it expires.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeSubject revokes all access tokens issued to subject before given time and all its refresh tokens.
+	// Since iat has second precision, time is truncated to whole seconds and tokens issued
+	// during the same second as the revocation stay valid.
+	RevokeSubject(ctx context.Context, sub string, before time.Time) error
+	// IsRevoked reports whether token with given jti, issued to subject at issuedAt, has been revoked.
+	IsRevoked(ctx context.Context, jti, sub string, issuedAt time.Time) (bool, error)
+
+	// SaveRefreshToken stores new refresh token.
+	SaveRefreshToken(ctx context.Context, token RefreshToken) error
+	// UseRefreshToken atomically marks refresh token used and returns its state before the call.
+	// ErrRefreshTokenNotFound is returned for unknown tokens.
+	UseRefreshToken(ctx context.Context, id string, at time.Time) (RefreshToken, error)
+	// RevokeRefreshFamily revokes all refresh tokens of the family.
+	RevokeRefreshFamily(ctx context.Context, familyID string, at time.Time) error
+}
+
+// Check returns ErrRevoked if claims belong to a revoked token.
+func Check(ctx context.Context, store Store, claims *auth.UserJWTClaims) error {
+	if claims.ID == "" {
+		return ErrMissingTokenIdentifier
+	}
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	revoked, err := store.IsRevoked(ctx, claims.ID, claims.Subject, issuedAt)
+	if err != nil {
+		return fmt.Errorf("checking token revocation failed: %w", err)
+	}
+	if revoked {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// Revoke revokes access token described by claims.
+func Revoke(ctx context.Context, store Store, claims *auth.UserJWTClaims) error {
+	if claims.ID == "" {
+		return ErrMissingTokenIdentifier
+	}
+	expiresAt := time.Now().Add(24 * time.Hour)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return store.RevokeToken(ctx, claims.ID, expiresAt)
+}
+
+// Verifier wraps middleware.Verifier with revocation check.
+func Verifier(v middleware.Verifier, store Store) middleware.Verifier {
+	return middleware.VerifierFunc(func(ctx context.Context, raw string, options ...jwt.ParserOption) (*auth.UserJWTClaims, error) {
+		claims, err := v.Verify(ctx, raw, options...)
+		if err != nil {
+			return nil, err
+		}
+		if err := Check(ctx, store, claims); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	})
+}
+
+// Refresher issues and rotates refresh tokens.
+// Every refresh token can be used exactly once, using it again revokes the whole family
+// since it means that either the token or its successor has been stolen.
+type Refresher struct {
+	store Store
+	ttl   time.Duration
+}
+
+type Opt func(*Refresher)
+
+// WithTTL sets lifetime of refresh tokens, defaults to 30 days.
+func WithTTL(d time.Duration) Opt {
+	return func(r *Refresher) {
+		r.ttl = d
+	}
+}
+
+// NewRefresher creates Refresher using given store.
+func NewRefresher(store Store, opts ...Opt) *Refresher {
+	r := &Refresher{store: store, ttl: 30 * 24 * time.Hour}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Issue creates new refresh token family for user and returns the token value.
+func (r *Refresher) Issue(ctx context.Context, user *auth.User, scopes []string) (string, error) {
+	familyID, err := auth.NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	value, _, err := r.issue(ctx, RefreshToken{
+		FamilyID: familyID,
+		Subject:  user.MakeSub(),
+		User:     user,
+		Scopes:   scopes,
+	})
+	return value, err
+}
+
+// Rotate exchanges refresh token into a new one from the same family.
+// Returned RefreshToken contains user and scopes for signing new access token.
+func (r *Refresher) Rotate(ctx context.Context, token string) (string, RefreshToken, error) {
+	now := time.Now()
+	old, err := r.store.UseRefreshToken(ctx, HashToken(token), now)
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+
+	switch {
+	case old.RevokedAt != nil:
+		return "", RefreshToken{}, ErrRefreshTokenRevoked
+	case old.UsedAt != nil:
+		ctxlog.Warn(ctx, "refresh token reuse detected, revoking token family",
+			slog.String("family", old.FamilyID),
+			slog.String("sub", old.Subject),
+		)
+		if err := r.store.RevokeRefreshFamily(ctx, old.FamilyID, now); err != nil {
+			return "", RefreshToken{}, fmt.Errorf("revoking refresh token family failed: %w", err)
+		}
+		return "", RefreshToken{}, ErrRefreshTokenReused
+	case now.After(old.ExpiresAt):
+		return "", RefreshToken{}, ErrRefreshTokenExpired
+	}
+
+	return r.issue(ctx, RefreshToken{
+		FamilyID: old.FamilyID,
+		Subject:  old.Subject,
+		User:     old.User,
+		Scopes:   old.Scopes,
+	})
+}
+
+func (r *Refresher) issue(ctx context.Context, t RefreshToken) (string, RefreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", RefreshToken{}, fmt.Errorf("generating refresh token failed: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+
+	t.ID = HashToken(value)
+	t.CreatedAt = time.Now().Round(time.Millisecond).UTC()
+	t.ExpiresAt = t.CreatedAt.Add(r.ttl)
+	if err := r.store.SaveRefreshToken(ctx, t); err != nil {
+		return "", RefreshToken{}, fmt.Errorf("saving refresh token failed: %w", err)
+	}
+	return value, t, nil
+}
+
+// HashToken returns identifier under which refresh token is stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,43 @@
+package revocation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/cache"
+	"github.com/elisasre/go-common/v2/auth/middleware"
+	"github.com/elisasre/go-common/v2/auth/revocation"
+	"github.com/elisasre/go-common/v2/auth/store/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	c, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	v := revocation.Verifier(middleware.LocalVerifier(c), store)
+
+	sign := func() string {
+		token, err := auth.NewToken(&auth.User{}).SignExpires(c.GetCurrentKey(), auth.SignClaims{
+			Exp:    time.Now().Add(time.Hour).Unix(),
+			Scopes: auth.AllScopes,
+		})
+		require.NoError(t, err)
+		return token
+	}
+
+	token := sign()
+	claims, err := v.Verify(ctx, token)
+	require.NoError(t, err)
+	require.NotEmpty(t, claims.ID)
+
+	other := sign()
+	require.NoError(t, revocation.Revoke(ctx, store, claims))
+	_, err = v.Verify(ctx, token)
+	require.ErrorIs(t, err, revocation.ErrRevoked)
+	_, err = v.Verify(ctx, other)
+	require.NoError(t, err, "only revoked token is rejected")
+}
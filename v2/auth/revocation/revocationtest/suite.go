@@ -0,0 +1,80 @@
+package revocationtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/revocation"
+	"github.com/stretchr/testify/require"
+)
+
+func RunSuite(t *testing.T, store revocation.Store) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	// single token
+	revoked, err := store.IsRevoked(ctx, "jti-1", "email=user@example.com", now)
+	require.NoError(t, err)
+	require.False(t, revoked)
+	require.NoError(t, store.RevokeToken(ctx, "jti-1", now.Add(time.Hour)))
+	require.NoError(t, store.RevokeToken(ctx, "jti-1", now.Add(time.Hour)), "revoking twice is allowed")
+	revoked, err = store.IsRevoked(ctx, "jti-1", "email=user@example.com", now)
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	// subject
+	require.NoError(t, store.RevokeSubject(ctx, "email=user@example.com", now))
+	revoked, err = store.IsRevoked(ctx, "jti-2", "email=user@example.com", now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.True(t, revoked, "tokens issued before subject revocation are revoked")
+	revoked, err = store.IsRevoked(ctx, "jti-3", "email=user@example.com", now.Add(time.Minute))
+	require.NoError(t, err)
+	require.False(t, revoked, "tokens issued after subject revocation are valid")
+	require.NoError(t, store.RevokeSubject(ctx, "email=user@example.com", now.Add(-time.Hour)))
+	revoked, err = store.IsRevoked(ctx, "jti-2", "email=user@example.com", now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.True(t, revoked, "older revocation doesn't undo newer one")
+	require.NoError(t, store.RevokeSubject(ctx, "email=new@example.com", now.Add(500*time.Millisecond)))
+	revoked, err = store.IsRevoked(ctx, "jti-4", "email=new@example.com", now)
+	require.NoError(t, err)
+	require.False(t, revoked, "tokens issued during the same second after revocation are valid")
+	revoked, err = store.IsRevoked(ctx, "jti-4", "email=new@example.com", now.Add(-time.Second))
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	// refresh token rotation and reuse detection
+	r := revocation.NewRefresher(store)
+	user := &auth.User{Email: common.Ptr("other@example.com")}
+	first, err := r.Issue(ctx, user, []string{auth.ScopeOpenID, auth.ScopeEmail})
+	require.NoError(t, err)
+
+	second, rt, err := r.Rotate(ctx, first)
+	require.NoError(t, err)
+	require.Equal(t, user, rt.User)
+	require.Equal(t, []string{auth.ScopeOpenID, auth.ScopeEmail}, rt.Scopes)
+	require.Equal(t, "email=other@example.com", rt.Subject)
+
+	_, _, err = r.Rotate(ctx, first)
+	require.ErrorIs(t, err, revocation.ErrRefreshTokenReused)
+	_, _, err = r.Rotate(ctx, second)
+	require.ErrorIs(t, err, revocation.ErrRefreshTokenRevoked, "whole family is revoked after reuse")
+
+	_, _, err = r.Rotate(ctx, "unknown")
+	require.ErrorIs(t, err, revocation.ErrRefreshTokenNotFound)
+
+	// subject revocation revokes refresh tokens as well
+	third, err := r.Issue(ctx, user, []string{auth.ScopeOpenID})
+	require.NoError(t, err)
+	require.NoError(t, store.RevokeSubject(ctx, user.MakeSub(), time.Now()))
+	_, _, err = r.Rotate(ctx, third)
+	require.ErrorIs(t, err, revocation.ErrRefreshTokenRevoked)
+
+	// expiry
+	expiring, err := revocation.NewRefresher(store, revocation.WithTTL(-time.Second)).Issue(ctx, &auth.User{}, nil)
+	require.NoError(t, err)
+	_, _, err = r.Rotate(ctx, expiring)
+	require.ErrorIs(t, err, revocation.ErrRefreshTokenExpired)
+}
@@ -0,0 +1,212 @@
+// Package rotator provides scheduled JWT key rotation as a service module.
+// Rotation is coordinated between replicas using Locker so only one instance rotates,
+// other instances pick up new keys by refreshing periodically or when Notifier tells them to.
+package rotator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth/cache"
+)
+
+var ErrMissingCache = errors.New("rotator.Rotator missing key cache")
+
+// Locker provides mutual exclusion between replicas, *postgres.DB implements it with advisory lock.
+type Locker interface {
+	// TryLock runs fn while holding the lock. If lock is held by someone else fn isn't run and false is returned.
+	TryLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
+}
+
+// Notifier notifies about key rotations made by other replicas, *postgres.RotationListener implements it.
+type Notifier interface {
+	// Notify returns channel which receives a value after keys have been rotated, it's closed when ctx is done.
+	Notify(ctx context.Context) (<-chan struct{}, error)
+}
+
+// Rotator rotates keys of cache when current key gets older than rotation interval.
+type Rotator struct {
+	cache           *cache.Cache
+	locker          Locker
+	notifier        Notifier
+	interval        time.Duration
+	refreshInterval time.Duration
+	// required to avoid concurrency issues, only used privately
+	ctx    context.Context //nolint: containedctx
+	cancel func()
+	opts   []Opt
+}
+
+// New creates Rotator for given cache.
+func New(c *cache.Cache, opts ...Opt) *Rotator {
+	return &Rotator{
+		cache:           c,
+		interval:        24 * time.Hour,
+		refreshInterval: time.Minute,
+		cancel:          func() {},
+		opts:            opts,
+	}
+}
+
+func (r *Rotator) Init() error {
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	for _, opt := range r.opts {
+		if err := opt(r); err != nil {
+			return fmt.Errorf("rotator.Rotator Option error: %w", err)
+		}
+	}
+	if r.cache == nil {
+		return ErrMissingCache
+	}
+	if r.locker == nil {
+		r.locker = &localLocker{}
+	}
+	return nil
+}
+
+func (r *Rotator) Run() error {
+	var notifications <-chan struct{}
+	if r.notifier != nil {
+		ch, err := r.notifier.Notify(r.ctx)
+		if err != nil {
+			return fmt.Errorf("subscribing key rotation notifications failed: %w", err)
+		}
+		notifications = ch
+	}
+
+	t := time.NewTicker(r.refreshInterval)
+	defer t.Stop()
+	r.check(r.ctx)
+	for {
+		select {
+		case <-t.C:
+			r.check(r.ctx)
+		case _, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
+			}
+			if _, err := r.cache.RefreshKeys(r.ctx, true); err != nil {
+				slog.Error("refreshing JWT keys failed", slog.String("error", err.Error()))
+			}
+		case <-r.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *Rotator) Stop() error {
+	r.cancel()
+	return nil
+}
+
+func (r *Rotator) Name() string {
+	return "rotator.Rotator"
+}
+
+// ID exists for compatibility with github.com/go-srvc/srvc.Module.
+func (r *Rotator) ID() string { return r.Name() }
+
+// check logs errors instead of returning them so temporary database issues don't stop the service.
+func (r *Rotator) check(ctx context.Context) {
+	if err := r.Check(ctx); err != nil {
+		slog.Error("JWT key rotation check failed", slog.String("error", err.Error()))
+	}
+}
+
+// Check refreshes keys and rotates them if current key is older than rotation interval.
+// Rotation is skipped if another replica holds the lock, it's expected to rotate instead.
+// With Notifier keys are refreshed only when notified and before rotating, reloading them on every
+// check would decrypt every key repeatedly, which is costly with KMS backed key encryption.
+func (r *Rotator) Check(ctx context.Context) error {
+	if r.notifier == nil {
+		if _, err := r.cache.RefreshKeys(ctx, true); err != nil {
+			return err
+		}
+	}
+	if !r.due() {
+		return nil
+	}
+
+	locked, err := r.locker.TryLock(ctx, func(ctx context.Context) error {
+		// another replica might have rotated keys while we were waiting
+		if _, err := r.cache.RefreshKeys(ctx, true); err != nil {
+			return err
+		}
+		if !r.due() {
+			return nil
+		}
+		return r.cache.RotateKeys(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	if !locked {
+		slog.Debug("JWT key rotation lock is held by another instance")
+	}
+	return nil
+}
+
+func (r *Rotator) due() bool {
+	if len(r.cache.GetKeys()) == 0 {
+		return true
+	}
+	return time.Since(r.cache.GetCurrentKey().CreatedAt) >= r.interval
+}
+
+type Opt func(*Rotator) error
+
+// WithInterval sets how old current key can get before it's rotated, defaults to 24 hours.
+// Store retention should be at least the lifetime of issued tokens so rotated keys stay valid until tokens expire.
+func WithInterval(d time.Duration) Opt {
+	return func(r *Rotator) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid rotation interval: %s", d)
+		}
+		r.interval = d
+		return nil
+	}
+}
+
+// WithRefreshInterval sets how often keys are refreshed and rotation is checked, defaults to 1 minute.
+func WithRefreshInterval(d time.Duration) Opt {
+	return func(r *Rotator) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid refresh interval: %s", d)
+		}
+		r.refreshInterval = d
+		return nil
+	}
+}
+
+// WithLocker sets lock shared by all replicas. Without it rotation is only coordinated within the process.
+func WithLocker(l Locker) Opt {
+	return func(r *Rotator) error {
+		r.locker = l
+		return nil
+	}
+}
+
+// WithNotifier makes Rotator refresh keys immediately when another replica rotates them.
+func WithNotifier(n Notifier) Opt {
+	return func(r *Rotator) error {
+		r.notifier = n
+		return nil
+	}
+}
+
+type localLocker struct {
+	mu sync.Mutex
+}
+
+func (l *localLocker) TryLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	if !l.mu.TryLock() {
+		return false, nil
+	}
+	defer l.mu.Unlock()
+	return true, fn(ctx)
+}
@@ -0,0 +1,123 @@
+package rotator_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/cache"
+	"github.com/elisasre/go-common/v2/auth/rotator"
+	"github.com/elisasre/go-common/v2/auth/store/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(memory.WithRetention(time.Hour))
+	c, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	kid := c.GetCurrentKey().KID
+
+	r := rotator.New(c, rotator.WithInterval(time.Hour), rotator.WithLocker(store))
+	require.NoError(t, r.Init())
+	require.NoError(t, r.Check(ctx))
+	require.Equal(t, kid, c.GetCurrentKey().KID)
+
+	r = rotator.New(c, rotator.WithInterval(time.Nanosecond), rotator.WithLocker(store))
+	require.NoError(t, r.Init())
+	require.NoError(t, r.Check(ctx))
+	require.NotEqual(t, kid, c.GetCurrentKey().KID)
+	require.Len(t, c.GetKeys(), 2)
+}
+
+func TestCheckLocked(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	c, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	kid := c.GetCurrentKey().KID
+
+	r := rotator.New(c, rotator.WithInterval(time.Nanosecond), rotator.WithLocker(store))
+	require.NoError(t, r.Init())
+
+	locked, err := store.TryLock(ctx, func(ctx context.Context) error {
+		return r.Check(ctx)
+	})
+	require.NoError(t, err)
+	require.True(t, locked)
+	require.Equal(t, kid, c.GetCurrentKey().KID, "rotation must be skipped while lock is held elsewhere")
+}
+
+type countingStore struct {
+	*memory.Memory
+	lists atomic.Int32
+}
+
+func (s *countingStore) ListJWTKeys(ctx context.Context) ([]auth.JWTKey, error) {
+	s.lists.Add(1)
+	return s.Memory.ListJWTKeys(ctx)
+}
+
+func TestCheckWithNotifier(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{Memory: memory.New()}
+	c, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	store.lists.Store(0)
+
+	r := rotator.New(c, rotator.WithInterval(time.Hour), rotator.WithLocker(store), rotator.WithNotifier(store))
+	require.NoError(t, r.Init())
+	for range 3 {
+		require.NoError(t, r.Check(ctx))
+	}
+	require.Zero(t, store.lists.Load(), "keys are reloaded only when notified")
+
+	r = rotator.New(c, rotator.WithInterval(time.Hour), rotator.WithLocker(store))
+	require.NoError(t, r.Init())
+	require.NoError(t, r.Check(ctx))
+	require.EqualValues(t, 1, store.lists.Load(), "keys are reloaded on every check without notifier")
+}
+
+func TestRunReplicas(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(memory.WithRetention(time.Hour))
+	leader, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	follower, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	kid := leader.GetCurrentKey().KID
+
+	r1 := rotator.New(leader,
+		rotator.WithInterval(50*time.Millisecond),
+		rotator.WithRefreshInterval(10*time.Millisecond),
+		rotator.WithLocker(store),
+	)
+	// follower never rotates by itself and refreshes only on notifications
+	r2 := rotator.New(follower,
+		rotator.WithInterval(time.Hour),
+		rotator.WithRefreshInterval(time.Hour),
+		rotator.WithLocker(store),
+		rotator.WithNotifier(store),
+	)
+	for _, r := range []*rotator.Rotator{r1, r2} {
+		require.NoError(t, r.Init())
+		errCh := make(chan error, 1)
+		go func() { errCh <- r.Run() }()
+		t.Cleanup(func() {
+			require.NoError(t, r.Stop())
+			require.NoError(t, <-errCh)
+		})
+	}
+
+	require.Eventually(t, func() bool {
+		return leader.GetCurrentKey().KID != kid && follower.GetCurrentKey().KID == leader.GetCurrentKey().KID
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestInitErrors(t *testing.T) {
+	require.ErrorIs(t, rotator.New(nil).Init(), rotator.ErrMissingCache)
+	require.Error(t, rotator.New(&cache.Cache{}, rotator.WithInterval(0)).Init())
+	require.Error(t, rotator.New(&cache.Cache{}, rotator.WithRefreshInterval(-time.Second)).Init())
+}
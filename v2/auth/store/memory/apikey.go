@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth/apikey"
+)
+
+// SaveAPIKey stores new API key.
+func (m *Memory) SaveAPIKey(_ context.Context, key apikey.Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.apiKeys {
+		if k.ID == key.ID || k.Hash == key.Hash {
+			return fmt.Errorf("api key %s already exists", key.ID)
+		}
+	}
+	key.Scopes = slices.Clone(key.Scopes)
+	m.apiKeys[key.ID] = key
+	return nil
+}
+
+// APIKey returns API key by hash.
+func (m *Memory) APIKey(_ context.Context, hash string) (apikey.Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.apiKeys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return apikey.Key{}, apikey.ErrKeyNotFound
+}
+
+// ServiceAccountAPIKeys returns all keys of the service account ordered by creation time.
+func (m *Memory) ServiceAccountAPIKeys(_ context.Context, serviceAccount string) ([]apikey.Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []apikey.Key{}
+	for _, k := range m.apiKeys {
+		if k.ServiceAccount == serviceAccount {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b apikey.Key) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey revokes API key by ID.
+func (m *Memory) RevokeAPIKey(_ context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.apiKeys[id]
+	if !ok {
+		return apikey.ErrKeyNotFound
+	}
+	if k.RevokedAt == nil {
+		k.RevokedAt = &at
+		m.apiKeys[id] = k
+	}
+	return nil
+}
+
+// TouchAPIKey sets last used time of API key.
+func (m *Memory) TouchAPIKey(_ context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.apiKeys[id]
+	if !ok {
+		return apikey.ErrKeyNotFound
+	}
+	k.LastUsedAt = &at
+	m.apiKeys[id] = k
+	return nil
+}
@@ -2,24 +2,61 @@ package memory
 
 import (
 	"context"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/apikey"
+	"github.com/elisasre/go-common/v2/auth/revocation"
 )
 
 // Memory is im-memory storage for JWT keys which can be used as storage provider for Cache.
 type Memory struct {
-	keys []auth.JWTKey
+	keysMu      sync.Mutex
+	keys        []auth.JWTKey
+	retention   time.Duration
+	rotationMu  sync.Mutex
+	subscribers []chan struct{}
+
+	// mu guards revocation state
+	mu              sync.Mutex
+	revokedTokens   map[string]time.Time
+	revokedSubjects map[string]time.Time
+	refreshTokens   map[string]revocation.RefreshToken
+	apiKeys         map[string]apikey.Key
+}
+
+type Opt func(*Memory)
+
+// WithRetention keeps retired public keys for given duration after they have been replaced by a newer key.
+// By default 3 latest keys are kept regardless of their age.
+func WithRetention(d time.Duration) Opt {
+	return func(m *Memory) {
+		m.retention = d
+	}
 }
 
 // New creates new storage jwt key in-memory.
 // Memory is meant for testing purposes, do NOT use in production.
-func New() *Memory {
-	return &Memory{keys: make([]auth.JWTKey, 0, 3)}
+func New(opts ...Opt) *Memory {
+	m := &Memory{
+		keys:            make([]auth.JWTKey, 0, 3),
+		revokedTokens:   map[string]time.Time{},
+		revokedSubjects: map[string]time.Time{},
+		refreshTokens:   map[string]revocation.RefreshToken{},
+		apiKeys:         map[string]apikey.Key{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 // GetKeys fetch all keys from cache.
 func (m *Memory) ListJWTKeys(context.Context) ([]auth.JWTKey, error) {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
 	data := make([]auth.JWTKey, len(m.keys))
 	copy(data, m.keys)
 	return data, nil
@@ -27,6 +64,8 @@ func (m *Memory) ListJWTKeys(context.Context) ([]auth.JWTKey, error) {
 
 // RotateKeys rotates the jwt secrets.
 func (m *Memory) RotateJWTKeys(_ context.Context, key auth.JWTKey) error {
+	m.keysMu.Lock()
+	defer m.keysMu.Unlock()
 	key.CreatedAt = time.Now().Round(time.Millisecond).UTC()
 	m.keys = append([]auth.JWTKey{key}, m.keys...)
 
@@ -37,9 +76,62 @@ func (m *Memory) RotateJWTKeys(_ context.Context, key auth.JWTKey) error {
 		}
 	}
 
-	// keep 3 latest public keys
-	if len(m.keys) > 3 {
-		m.keys = m.keys[0:3]
-	}
+	m.deleteRetiredKeys()
+	m.notify()
 	return nil
 }
+
+func (m *Memory) deleteRetiredKeys() {
+	if m.retention <= 0 {
+		// keep 3 latest public keys
+		if len(m.keys) > 3 {
+			m.keys = m.keys[0:3]
+		}
+		return
+	}
+
+	// key is retired when the next key is created
+	cutoff := time.Now().Add(-m.retention)
+	for i := 1; i < len(m.keys); i++ {
+		if m.keys[i-1].CreatedAt.Before(cutoff) {
+			m.keys = m.keys[0:i]
+			return
+		}
+	}
+}
+
+// TryLock runs fn while holding key rotation lock. If lock is already held, fn isn't run and false is returned.
+func (m *Memory) TryLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	if !m.rotationMu.TryLock() {
+		return false, nil
+	}
+	defer m.rotationMu.Unlock()
+	return true, fn(ctx)
+}
+
+// Notify returns channel which receives a value after every key rotation. Channel is closed when ctx is done.
+func (m *Memory) Notify(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	m.keysMu.Lock()
+	m.subscribers = append(m.subscribers, ch)
+	m.keysMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.keysMu.Lock()
+		defer m.keysMu.Unlock()
+		m.subscribers = slices.DeleteFunc(m.subscribers, func(c chan struct{}) bool { return c == ch })
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// notify wakes up subscribers, m.keysMu must be held.
+func (m *Memory) notify() {
+	for _, ch := range m.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -1,12 +1,47 @@
 package memory_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/elisasre/go-common/v2/auth/apikey/apikeytest"
+	"github.com/elisasre/go-common/v2/auth/cache"
 	"github.com/elisasre/go-common/v2/auth/cache/cachetest"
+	"github.com/elisasre/go-common/v2/auth/revocation/revocationtest"
 	"github.com/elisasre/go-common/v2/auth/store/memory"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRotateKeys(t *testing.T) {
 	cachetest.RunSuite(t, memory.New())
 }
+
+func TestRevocation(t *testing.T) {
+	revocationtest.RunSuite(t, memory.New())
+}
+
+func TestAPIKeys(t *testing.T) {
+	apikeytest.RunSuite(t, memory.New())
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(memory.WithRetention(time.Hour))
+	c, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, c.RotateKeys(ctx))
+	}
+	require.Len(t, c.GetKeys(), 6, "keys retired within retention must be kept")
+
+	store = memory.New(memory.WithRetention(10 * time.Millisecond))
+	c, err = cache.New(ctx, store)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.RotateKeys(ctx))
+	}
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, c.RotateKeys(ctx))
+	require.Len(t, c.GetKeys(), 2, "only the new key and the one it replaced should be kept")
+}
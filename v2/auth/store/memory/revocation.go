@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth/revocation"
+)
+
+// RevokeToken revokes access token with given jti until it expires.
+func (m *Memory) RevokeToken(_ context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, exp := range m.revokedTokens {
+		if exp.Before(now) {
+			delete(m.revokedTokens, id)
+		}
+	}
+	m.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+// RevokeSubject revokes all tokens issued to subject before given time.
+// Time is truncated to whole seconds to match precision of iat claim.
+func (m *Memory) RevokeSubject(_ context.Context, sub string, before time.Time) error {
+	before = before.Truncate(time.Second)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// an older revocation must not undo a newer one
+	if prev, ok := m.revokedSubjects[sub]; !ok || before.After(prev) {
+		m.revokedSubjects[sub] = before
+	}
+	for id, t := range m.refreshTokens {
+		if t.Subject == sub && t.RevokedAt == nil {
+			t.RevokedAt = &before
+			m.refreshTokens[id] = t
+		}
+	}
+	return nil
+}
+
+// IsRevoked reports whether token has been revoked.
+func (m *Memory) IsRevoked(_ context.Context, jti, sub string, issuedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.revokedTokens[jti]; ok {
+		return true, nil
+	}
+	before, ok := m.revokedSubjects[sub]
+	return ok && issuedAt.Before(before), nil
+}
+
+// SaveRefreshToken stores new refresh token.
+func (m *Memory) SaveRefreshToken(_ context.Context, token revocation.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshTokens[token.ID] = token
+	return nil
+}
+
+// UseRefreshToken marks refresh token used and returns its previous state.
+func (m *Memory) UseRefreshToken(_ context.Context, id string, at time.Time) (revocation.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.refreshTokens[id]
+	if !ok {
+		return revocation.RefreshToken{}, revocation.ErrRefreshTokenNotFound
+	}
+	prev := t
+	if t.UsedAt == nil {
+		t.UsedAt = &at
+		m.refreshTokens[id] = t
+	}
+	return prev, nil
+}
+
+// RevokeRefreshFamily revokes all refresh tokens of the family.
+func (m *Memory) RevokeRefreshFamily(_ context.Context, familyID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, t := range m.refreshTokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &at
+			m.refreshTokens[id] = t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth/apikey"
+)
+
+// APIKeySchema contains tables required by apikey.Store methods.
+const APIKeySchema = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id text PRIMARY KEY,
+		hash text NOT NULL UNIQUE,
+		hint text NOT NULL DEFAULT '',
+		name text NOT NULL DEFAULT '',
+		service_account text NOT NULL,
+		scopes text NOT NULL DEFAULT '',
+		created_at timestamp with time zone NOT NULL,
+		expires_at timestamp with time zone,
+		last_used_at timestamp with time zone,
+		revoked_at timestamp with time zone
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_service_account_idx ON api_keys (service_account);`
+
+type rawAPIKey struct {
+	ID             string     `db:"id"`
+	Hash           string     `db:"hash"`
+	Hint           string     `db:"hint"`
+	Name           string     `db:"name"`
+	ServiceAccount string     `db:"service_account"`
+	Scopes         string     `db:"scopes"`
+	CreatedAt      time.Time  `db:"created_at"`
+	ExpiresAt      *time.Time `db:"expires_at"`
+	LastUsedAt     *time.Time `db:"last_used_at"`
+	RevokedAt      *time.Time `db:"revoked_at"`
+}
+
+func (r rawAPIKey) key() apikey.Key {
+	return apikey.Key{
+		ID:             r.ID,
+		Hash:           r.Hash,
+		Hint:           r.Hint,
+		Name:           r.Name,
+		ServiceAccount: r.ServiceAccount,
+		Scopes:         strings.Fields(r.Scopes),
+		CreatedAt:      r.CreatedAt,
+		ExpiresAt:      r.ExpiresAt,
+		LastUsedAt:     r.LastUsedAt,
+		RevokedAt:      r.RevokedAt,
+	}
+}
+
+// SaveAPIKey stores new API key.
+func (db *DB) SaveAPIKey(ctx context.Context, key apikey.Key) error {
+	const query = `
+		INSERT INTO api_keys (
+			id,
+			hash,
+			hint,
+			name,
+			service_account,
+			scopes,
+			created_at,
+			expires_at
+		) VALUES (
+			:id,
+			:hash,
+			:hint,
+			:name,
+			:service_account,
+			:scopes,
+			:created_at,
+			:expires_at
+		)`
+	_, err := db.db.NamedExecContext(ctx, query, rawAPIKey{
+		ID:             key.ID,
+		Hash:           key.Hash,
+		Hint:           key.Hint,
+		Name:           key.Name,
+		ServiceAccount: key.ServiceAccount,
+		Scopes:         strings.Join(key.Scopes, " "),
+		CreatedAt:      key.CreatedAt,
+		ExpiresAt:      key.ExpiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("saving api key failed: %w", err)
+	}
+	return nil
+}
+
+// APIKey returns API key by hash.
+func (db *DB) APIKey(ctx context.Context, hash string) (apikey.Key, error) {
+	const query = `SELECT * FROM api_keys WHERE hash = $1`
+	var raw rawAPIKey
+	if err := db.db.GetContext(ctx, &raw, query, hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apikey.Key{}, apikey.ErrKeyNotFound
+		}
+		return apikey.Key{}, fmt.Errorf("selecting api key failed: %w", err)
+	}
+	return raw.key(), nil
+}
+
+// ServiceAccountAPIKeys returns all keys of the service account ordered by creation time.
+func (db *DB) ServiceAccountAPIKeys(ctx context.Context, serviceAccount string) ([]apikey.Key, error) {
+	const query = `
+		SELECT * FROM api_keys
+		WHERE service_account = $1
+		ORDER BY created_at, id`
+	var raws []rawAPIKey
+	if err := db.db.SelectContext(ctx, &raws, query, serviceAccount); err != nil {
+		return nil, fmt.Errorf("selecting service account api keys failed: %w", err)
+	}
+	keys := make([]apikey.Key, 0, len(raws))
+	for _, raw := range raws {
+		keys = append(keys, raw.key())
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes API key by ID.
+func (db *DB) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	const query = `
+		UPDATE api_keys
+		SET revoked_at = COALESCE(revoked_at, $2)
+		WHERE id = $1`
+	res, err := db.db.ExecContext(ctx, query, id, at)
+	if err != nil {
+		return fmt.Errorf("revoking api key failed: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return apikey.ErrKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey sets last used time of API key.
+func (db *DB) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	const query = `
+		UPDATE api_keys
+		SET last_used_at = $2
+		WHERE id = $1
+		AND (last_used_at IS NULL OR last_used_at < $2)`
+	if _, err := db.db.ExecContext(ctx, query, id, at); err != nil {
+		return fmt.Errorf("updating api key last used time failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/keyenc"
+	"github.com/elisasre/go-common/v2/secretbox"
+	"github.com/elisasre/go-common/v2/sqlxutil"
+)
+
+// WithKeyEncrypter sets encrypter used for private keys, e.g. keyenc.Envelope backed by cloud KMS.
+// Keys encrypted with auth.Encrypt can still be read as long as WithSecret is given.
+// Releases which don't support envelope encryption can't read keys written with it, so it
+// should be enabled only after every replica has been upgraded.
+func WithKeyEncrypter(enc keyenc.KeyEncrypter) Opt {
+	return func(d *DB) {
+		d.encrypter = enc
+	}
+}
+
+func localEncrypter(secret string) (*keyenc.Envelope, error) {
+	wrapper, err := keyenc.NewLocalWrapper([]byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	return keyenc.NewEnvelope(wrapper), nil
+}
+
+// encryptPrivateKey encrypts private key with key encrypter, or with legacy auth.Encrypt if it isn't configured.
+func (db *DB) encryptPrivateKey(ctx context.Context, kid string, plaintext []byte) ([]byte, error) {
+	if db.encrypter != nil {
+		return db.encrypter.Encrypt(ctx, plaintext, []byte(kid))
+	}
+	if db.secret == "" {
+		return nil, ErrMissingCryptKey
+	}
+	return auth.Encrypt(plaintext, keySecret(kid, db.secret)) //nolint:staticcheck // readable by previous releases
+}
+
+// decryptPrivateKey decrypts private key using key encrypter or legacy auth.Decrypt based on its format.
+// Key id is used as associated data so encrypted keys can't be swapped between rows.
+func (db *DB) decryptPrivateKey(ctx context.Context, key RawKey) ([]byte, error) {
+	if keyenc.IsEnvelope(key.PrivateKey) {
+		if db.envelope == nil {
+			return nil, fmt.Errorf("private key of %s is envelope encrypted: %w", key.KID, ErrMissingKeyEncrypter)
+		}
+		plaintext, err := db.envelope.Decrypt(ctx, key.PrivateKey, []byte(key.KID))
+		if err != nil && db.secret != "" {
+			// random nonce of legacy ciphertext might look like envelope header
+			if legacy, legacyErr := db.decryptLegacy(key); legacyErr == nil {
+				return legacy, nil
+			}
+		}
+		return plaintext, err
+	}
+	return db.decryptLegacy(key)
+}
+
+func (db *DB) decryptLegacy(key RawKey) ([]byte, error) {
+	if db.secret == "" {
+		return nil, fmt.Errorf("private key of %s is encrypted with legacy format: %w", key.KID, ErrMissingCryptKey)
+	}
+	box, err := secretbox.New(secretbox.WithLegacyPassphrase(keySecret(key.KID, db.secret)))
+	if err != nil {
+		return nil, err
+	}
+	return box.Open(key.PrivateKey, nil)
+}
+
+func keySecret(kid string, secret string) string {
+	return fmt.Sprintf("%s.%s", secret, kid)
+}
+
+// ReencryptJWTKeys re-encrypts private keys stored using legacy auth.Encrypt format with the key encrypter
+// set by WithKeyEncrypter and returns number of updated keys. It's safe to run on every start up.
+func (db *DB) ReencryptJWTKeys(ctx context.Context) (int, error) {
+	if db.encrypter == nil {
+		return 0, ErrMissingKeyEncrypter
+	}
+	updated := 0
+	err := db.db.WithTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
+		const selectQuery = `
+			SELECT * FROM jwt_keys
+			WHERE private_key_as_bytes IS NOT NULL
+			FOR UPDATE`
+		keys := make([]RawKey, 0)
+		if err := tx.SelectContext(ctx, &keys, selectQuery); err != nil {
+			return fmt.Errorf("selecting keys failed: %w", err)
+		}
+
+		for _, key := range keys {
+			if keyenc.IsEnvelope(key.PrivateKey) {
+				continue
+			}
+			plaintext, err := db.decryptPrivateKey(ctx, key)
+			if err != nil {
+				return fmt.Errorf("decrypting key %s failed: %w", key.KID, err)
+			}
+			ciphertext, err := db.encrypter.Encrypt(ctx, plaintext, []byte(key.KID))
+			if err != nil {
+				return fmt.Errorf("encrypting key %s failed: %w", key.KID, err)
+			}
+
+			const updateQuery = `
+				UPDATE jwt_keys
+				SET private_key_as_bytes = $2, updated_at = $3
+				WHERE id = $1`
+			if _, err := tx.ExecContext(ctx, updateQuery, key.ID, ciphertext, sqlxutil.Now()); err != nil {
+				return fmt.Errorf("updating key %s failed: %w", key.KID, err)
+			}
+			updated++
+		}
+		return nil
+	})
+	return updated, err
+}
@@ -2,19 +2,23 @@ package postgres
 
 import (
 	"context"
-	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"time"
 
 	"github.com/elisasre/go-common/v2/auth"
-	"github.com/elisasre/go-common/v2/sentryutil"
+	"github.com/elisasre/go-common/v2/auth/keyenc"
 	"github.com/elisasre/go-common/v2/sqlxutil"
 	"github.com/jmoiron/sqlx"
 )
 
 type DB struct {
-	db     *sqlx.DB
-	secret string
+	db        *sqlxutil.DB
+	secret    string
+	encrypter keyenc.KeyEncrypter
+	// envelope decrypts envelope encrypted keys, it's encrypter or derived from secret.
+	envelope  keyenc.KeyEncrypter
+	retention time.Duration
 }
 
 type Opt func(*DB)
@@ -22,6 +26,7 @@ type Opt func(*DB)
 var (
 	ErrMissingDBConnection = fmt.Errorf("missing db connection")
 	ErrMissingCryptKey     = fmt.Errorf("missing secret")
+	ErrMissingKeyEncrypter = fmt.Errorf("missing key encrypter")
 )
 
 func New(opts ...Opt) (*DB, error) {
@@ -33,22 +38,51 @@ func New(opts ...Opt) (*DB, error) {
 	if d.db == nil {
 		return nil, ErrMissingDBConnection
 	}
+	// secret and key encrypter are needed only by key rotation, other stores work without them
+	d.envelope = d.encrypter
+	if d.envelope == nil && d.secret != "" {
+		enc, err := localEncrypter(d.secret)
+		if err != nil {
+			return nil, err
+		}
+		d.envelope = enc
+	}
 
 	return d, nil
 }
 
+// WithSqlxDB sets database connection, queries are instrumented using sqlxutil defaults.
 func WithSqlxDB(db *sqlx.DB) Opt {
+	return func(d *DB) {
+		d.db = sqlxutil.Instrument(db)
+	}
+}
+
+// WithInstrumentedDB sets already instrumented database connection.
+func WithInstrumentedDB(db *sqlxutil.DB) Opt {
 	return func(d *DB) {
 		d.db = db
 	}
 }
 
+// WithSecret sets secret used for encrypting keys with auth.Encrypt format, which every release can read.
+// If WithKeyEncrypter is given new keys are envelope encrypted instead and the secret is only used
+// for reading keys stored in the legacy format.
 func WithSecret(secret string) Opt {
 	return func(d *DB) {
 		d.secret = secret
 	}
 }
 
+// WithRetention keeps retired public keys for given duration after they have been replaced by a newer key.
+// It should be at least the maximum lifetime of issued tokens so every token can be verified until it expires.
+// By default 3 latest keys are kept regardless of their age.
+func WithRetention(retention time.Duration) Opt {
+	return func(d *DB) {
+		d.retention = retention
+	}
+}
+
 type RawKey struct {
 	sqlxutil.Model
 	KID        string `db:"k_id"`
@@ -58,23 +92,20 @@ type RawKey struct {
 
 // ListJWTKeys lists the keys from database.
 func (db *DB) ListJWTKeys(c context.Context) ([]auth.JWTKey, error) {
-	span := sentryutil.MakeSpan(c, 1)
-	defer span.Finish()
-
 	const query = `
 		SELECT * FROM jwt_keys
 		WHERE deleted_at IS NULL
 		ORDER BY id DESC`
 
 	keys := make([]RawKey, 0)
-	err := db.db.SelectContext(c, &keys, query)
+	err := db.selector(c).SelectContext(c, &keys, query)
 	if err != nil {
 		return nil, fmt.Errorf("selecting keys failed: %w", err)
 	}
 
 	response := []auth.JWTKey{}
 	for _, key := range keys {
-		keyAsDecrypted, err := DecryptRawKey(key, db.secret)
+		keyAsDecrypted, err := db.decryptRawKey(c, key)
 		if err != nil {
 			return nil, err
 		}
@@ -85,15 +116,12 @@ func (db *DB) ListJWTKeys(c context.Context) ([]auth.JWTKey, error) {
 
 // RotateJWTKeys rotates the JWT keys in database.
 func (db *DB) RotateJWTKeys(ctx context.Context, new auth.JWTKey) error {
-	span := sentryutil.MakeSpan(ctx, 1)
-	defer span.Finish()
-
-	key, err := prepareRawKey(new, db.secret)
+	key, err := db.prepareRawKey(ctx, new)
 	if err != nil {
 		return err
 	}
 
-	return sqlxutil.WithTx(ctx, db.db, func(ctx context.Context, tx *sqlx.Tx) error {
+	return db.withTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
 		const addQuery = `
 			INSERT INTO jwt_keys (
 				k_id,
@@ -121,6 +149,20 @@ func (db *DB) RotateJWTKeys(ctx context.Context, new auth.JWTKey) error {
 			return fmt.Errorf("resetting old jwt keys failed: %w", err)
 		}
 
+		if err := db.deleteRetiredKeys(ctx, tx); err != nil {
+			return err
+		}
+
+		// notification is delivered to listeners when transaction commits
+		if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, RotationChannel, new.KID); err != nil {
+			return fmt.Errorf("notifying jwt key rotation failed: %w", err)
+		}
+		return nil
+	})
+}
+
+func (db *DB) deleteRetiredKeys(ctx context.Context, tx *sqlxutil.Tx) error {
+	if db.retention <= 0 {
 		// keep 3 latest ones
 		const deleteQuery = `
 			DELETE FROM jwt_keys
@@ -134,12 +176,43 @@ func (db *DB) RotateJWTKeys(ctx context.Context, new auth.JWTKey) error {
 			return fmt.Errorf("deleting old jwt keys failed: %w", err)
 		}
 		return nil
-	})
+	}
+
+	// key is retired when the next key is created
+	const deleteQuery = `
+		DELETE FROM jwt_keys k
+		WHERE EXISTS (
+			SELECT 1
+			FROM jwt_keys n
+			WHERE n.id > k.id
+			AND n.created_at < $1
+		)`
+	if _, err := tx.ExecContext(ctx, deleteQuery, time.Now().Add(-db.retention)); err != nil {
+		return fmt.Errorf("deleting retired jwt keys failed: %w", err)
+	}
+	return nil
 }
 
+// algorithmHeader is PEM header used for storing signing algorithm of the key along with the public key.
+const algorithmHeader = "Algorithm"
+
+// DecryptRawKey decrypts key encrypted either with auth.Encrypt or with envelope encryption using default
+// KEK derived from secret.
 func DecryptRawKey(key RawKey, secret string) (auth.JWTKey, error) {
+	db := &DB{secret: secret}
+	if secret != "" {
+		enc, err := localEncrypter(secret)
+		if err != nil {
+			return auth.JWTKey{}, err
+		}
+		db.envelope = enc
+	}
+	return db.decryptRawKey(context.Background(), key)
+}
+
+func (db *DB) decryptRawKey(ctx context.Context, key RawKey) (auth.JWTKey, error) {
 	pubBlock, _ := pem.Decode(key.PublicKey)
-	pub, err := x509.ParsePKCS1PublicKey(pubBlock.Bytes)
+	pub, err := auth.ParsePublicKey(pubBlock)
 	if err != nil {
 		return auth.JWTKey{}, fmt.Errorf("unable to parse public key %w", err)
 	}
@@ -147,17 +220,18 @@ func DecryptRawKey(key RawKey, secret string) (auth.JWTKey, error) {
 	response := auth.JWTKey{
 		CreatedAt: key.CreatedAt,
 		KID:       key.KID,
+		Algorithm: pubBlock.Headers[algorithmHeader],
 		PublicKey: pub,
 	}
 
 	if len(key.PrivateKey) > 0 {
-		privKey, err := auth.Decrypt(key.PrivateKey, keySecret(key.KID, secret))
+		privKey, err := db.decryptPrivateKey(ctx, key)
 		if err != nil {
 			return auth.JWTKey{}, err
 		}
 
 		privBlock, _ := pem.Decode(privKey)
-		priv, err := x509.ParsePKCS1PrivateKey(privBlock.Bytes)
+		priv, err := auth.ParsePrivateKey(privBlock)
 		if err != nil {
 			return auth.JWTKey{}, fmt.Errorf("unable to parse private key %w", err)
 		}
@@ -166,19 +240,25 @@ func DecryptRawKey(key RawKey, secret string) (auth.JWTKey, error) {
 	return response, nil
 }
 
-func keySecret(kid string, secret string) string {
-	return fmt.Sprintf("%s.%s", secret, kid)
-}
+func (db *DB) prepareRawKey(ctx context.Context, key auth.JWTKey) (*RawKey, error) {
+	privBlock, err := auth.MarshalPrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	privKey, err := db.encryptPrivateKey(ctx, key.KID, pem.EncodeToMemory(privBlock))
+	if err != nil {
+		return nil, fmt.Errorf("encrypting private key failed: %w", err)
+	}
 
-func prepareRawKey(key auth.JWTKey, secret string) (*RawKey, error) {
-	privKey, err := auth.Encrypt(auth.EncodePrivateKeyToPEM(key.PrivateKey), keySecret(key.KID, secret))
+	pubBlock, err := auth.MarshalPublicKey(key.PublicKey)
 	if err != nil {
 		return nil, err
 	}
+	pubBlock.Headers = map[string]string{algorithmHeader: key.Alg()}
 
 	return &RawKey{
 		KID:        key.KID,
 		PrivateKey: privKey,
-		PublicKey:  auth.EncodePublicKeyToPEM(key.PublicKey),
+		PublicKey:  pem.EncodeToMemory(pubBlock),
 	}, nil
 }
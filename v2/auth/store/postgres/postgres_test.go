@@ -2,11 +2,20 @@ package postgres_test
 
 import (
 	"context"
+	"crypto/rsa"
+	"encoding/pem"
 	"testing"
 	"time"
 
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/apikey/apikeytest"
+	"github.com/elisasre/go-common/v2/auth/cache"
 	"github.com/elisasre/go-common/v2/auth/cache/cachetest"
+	"github.com/elisasre/go-common/v2/auth/keyenc"
+	"github.com/elisasre/go-common/v2/auth/revocation/revocationtest"
+	"github.com/elisasre/go-common/v2/auth/rotator"
 	"github.com/elisasre/go-common/v2/auth/store/postgres"
+	"github.com/elisasre/go-common/v2/mfa/mfatest"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
@@ -51,4 +60,151 @@ func TestRotateKeys(t *testing.T) {
 	require.NoError(t, err)
 
 	cachetest.RunSuite(t, store)
+	testReencrypt(t, db, store)
+	testRotateSingleConn(t, db, store)
+
+	locked, err := store.TryLock(context.Background(), func(ctx context.Context) error {
+		nested, err := store.TryLock(ctx, func(context.Context) error { return nil })
+		require.NoError(t, err)
+		require.False(t, nested, "lock must not be acquired twice")
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, locked)
+
+	_, err = db.Exec(postgres.RevocationSchema)
+	require.NoError(t, err)
+	revocationtest.RunSuite(t, store)
+
+	_, err = db.Exec(postgres.WebAuthnSchema)
+	require.NoError(t, err)
+	mfatest.RunCredentialSuite(t, store)
+
+	_, err = db.Exec(postgres.APIKeySchema)
+	require.NoError(t, err)
+	apikeytest.RunSuite(t, store)
+}
+
+// testRotateSingleConn checks that rotation doesn't need a second connection while holding the lock.
+func testRotateSingleConn(t *testing.T, db *sqlx.DB, store *postgres.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db.SetMaxOpenConns(1)
+	defer db.SetMaxOpenConns(0)
+
+	c, err := cache.New(ctx, store)
+	require.NoError(t, err)
+	kid := c.GetCurrentKey().KID
+	r := rotator.New(c, rotator.WithInterval(time.Nanosecond), rotator.WithLocker(store))
+	require.NoError(t, r.Init())
+	require.NoError(t, r.Check(ctx))
+	require.NotEqual(t, kid, c.GetCurrentKey().KID)
+}
+
+func testReencrypt(t *testing.T, db *sqlx.DB, store *postgres.DB) {
+	ctx := context.Background()
+	// keys are written in legacy format unless key encrypter is given so older releases can read them
+	var raw []byte
+	require.NoError(t, db.Get(&raw, `SELECT private_key_as_bytes FROM jwt_keys WHERE private_key_as_bytes IS NOT NULL`))
+	require.False(t, keyenc.IsEnvelope(raw))
+	_, err := store.ReencryptJWTKeys(ctx)
+	require.ErrorIs(t, err, postgres.ErrMissingKeyEncrypter)
+
+	wrapper, err := keyenc.NewLocalWrapper([]byte("secret"))
+	require.NoError(t, err)
+	envelopeStore, err := postgres.New(
+		postgres.WithSqlxDB(db),
+		postgres.WithSecret("secret"),
+		postgres.WithKeyEncrypter(keyenc.NewEnvelope(wrapper)),
+	)
+	require.NoError(t, err)
+
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+	block, err := auth.MarshalPrivateKey(key.PrivateKey)
+	require.NoError(t, err)
+	legacy, err := auth.Encrypt(pem.EncodeToMemory(block), "secret."+key.KID) //nolint:staticcheck // legacy format is still supported
+	require.NoError(t, err)
+	pubBlock, err := auth.MarshalPublicKey(key.PublicKey)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`
+		INSERT INTO jwt_keys (k_id, private_key_as_bytes, public_key_as_bytes, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())`, key.KID, legacy, pem.EncodeToMemory(pubBlock))
+	require.NoError(t, err)
+
+	updated, err := envelopeStore.ReencryptJWTKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, updated)
+
+	require.NoError(t, db.Get(&raw, `SELECT private_key_as_bytes FROM jwt_keys WHERE k_id = $1`, key.KID))
+	require.True(t, keyenc.IsEnvelope(raw))
+
+	// store with secret can read keys envelope encrypted with key derived from the same secret
+	keys, err := store.ListJWTKeys(ctx)
+	require.NoError(t, err)
+	require.Equal(t, key.KID, keys[0].KID)
+	require.True(t, key.PrivateKey.(*rsa.PrivateKey).Equal(keys[0].PrivateKey))
+
+	updated, err = envelopeStore.ReencryptJWTKeys(ctx)
+	require.NoError(t, err)
+	require.Zero(t, updated)
+}
+
+func TestDecryptRawKey(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+	block, err := auth.MarshalPrivateKey(key.PrivateKey)
+	require.NoError(t, err)
+	pubBlock, err := auth.MarshalPublicKey(key.PublicKey)
+	require.NoError(t, err)
+
+	legacy, err := auth.Encrypt(pem.EncodeToMemory(block), "secret."+key.KID) //nolint:staticcheck // legacy format is still supported
+	require.NoError(t, err)
+	wrapper, err := keyenc.NewLocalWrapper([]byte("secret"))
+	require.NoError(t, err)
+	envelope, err := keyenc.NewEnvelope(wrapper).Encrypt(context.Background(), pem.EncodeToMemory(block), []byte(key.KID))
+	require.NoError(t, err)
+
+	for name, priv := range map[string][]byte{"legacy": legacy, "envelope": envelope} {
+		t.Run(name, func(t *testing.T) {
+			decrypted, err := postgres.DecryptRawKey(postgres.RawKey{
+				KID:        key.KID,
+				PrivateKey: priv,
+				PublicKey:  pem.EncodeToMemory(pubBlock),
+			}, "secret")
+			require.NoError(t, err)
+			// rsa.PrivateKey contains precomputed values which may differ after round trip
+			require.True(t, key.PrivateKey.(*rsa.PrivateKey).Equal(decrypted.PrivateKey))
+		})
+	}
+
+	_, err = postgres.DecryptRawKey(postgres.RawKey{
+		KID:        "other",
+		PrivateKey: envelope,
+		PublicKey:  pem.EncodeToMemory(pubBlock),
+	}, "secret")
+	require.ErrorIs(t, err, keyenc.ErrInvalidCiphertext, "key must not decrypt under another kid")
+}
+
+func TestNewWithoutSecret(t *testing.T) {
+	// store can be used for other data than keys without secret
+	_, err := postgres.New(postgres.WithSqlxDB(&sqlx.DB{}))
+	require.NoError(t, err)
+
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+	block, err := auth.MarshalPrivateKey(key.PrivateKey)
+	require.NoError(t, err)
+	pubBlock, err := auth.MarshalPublicKey(key.PublicKey)
+	require.NoError(t, err)
+	legacy, err := auth.Encrypt(pem.EncodeToMemory(block), "secret."+key.KID) //nolint:staticcheck // legacy format is still supported
+	require.NoError(t, err)
+
+	_, err = postgres.DecryptRawKey(postgres.RawKey{
+		KID:        key.KID,
+		PrivateKey: legacy,
+		PublicKey:  pem.EncodeToMemory(pubBlock),
+	}, "")
+	require.ErrorIs(t, err, postgres.ErrMissingCryptKey)
 }
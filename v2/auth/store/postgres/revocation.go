@@ -0,0 +1,211 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/revocation"
+	"github.com/elisasre/go-common/v2/sqlxutil"
+)
+
+// RevocationSchema contains tables required by revocation.Store methods.
+const RevocationSchema = `
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti text PRIMARY KEY,
+		expires_at timestamp with time zone NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS revoked_subjects (
+		sub text PRIMARY KEY,
+		revoked_before timestamp with time zone NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id text PRIMARY KEY,
+		family_id text NOT NULL,
+		sub text NOT NULL,
+		user_info jsonb,
+		scopes text NOT NULL DEFAULT '',
+		created_at timestamp with time zone NOT NULL,
+		expires_at timestamp with time zone NOT NULL,
+		used_at timestamp with time zone,
+		revoked_at timestamp with time zone
+	);
+	CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+	CREATE INDEX IF NOT EXISTS refresh_tokens_sub_idx ON refresh_tokens (sub);`
+
+type rawRefreshToken struct {
+	ID        string     `db:"id"`
+	FamilyID  string     `db:"family_id"`
+	Subject   string     `db:"sub"`
+	User      []byte     `db:"user_info"`
+	Scopes    string     `db:"scopes"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+// RevokeToken revokes access token with given jti until it expires.
+// Revocations of already expired tokens are cleaned up at the same time.
+func (db *DB) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return db.db.WithTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
+		const insertQuery = `
+			INSERT INTO revoked_tokens (jti, expires_at)
+			VALUES ($1, $2)
+			ON CONFLICT (jti) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, insertQuery, jti, expiresAt); err != nil {
+			return fmt.Errorf("revoking token failed: %w", err)
+		}
+
+		const cleanupQuery = `DELETE FROM revoked_tokens WHERE expires_at < NOW()`
+		if _, err := tx.ExecContext(ctx, cleanupQuery); err != nil {
+			return fmt.Errorf("deleting expired revocations failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// RevokeSubject revokes all tokens issued to subject before given time.
+// Time is truncated to whole seconds to match precision of iat claim.
+func (db *DB) RevokeSubject(ctx context.Context, sub string, before time.Time) error {
+	before = before.Truncate(time.Second)
+	return db.db.WithTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
+		const upsertQuery = `
+			INSERT INTO revoked_subjects (sub, revoked_before)
+			VALUES ($1, $2)
+			ON CONFLICT (sub) DO UPDATE
+			SET revoked_before = GREATEST(revoked_subjects.revoked_before, EXCLUDED.revoked_before)`
+		if _, err := tx.ExecContext(ctx, upsertQuery, sub, before); err != nil {
+			return fmt.Errorf("revoking subject failed: %w", err)
+		}
+
+		const refreshQuery = `
+			UPDATE refresh_tokens
+			SET revoked_at = $2
+			WHERE sub = $1
+			AND revoked_at IS NULL`
+		if _, err := tx.ExecContext(ctx, refreshQuery, sub, before); err != nil {
+			return fmt.Errorf("revoking refresh tokens of subject failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// IsRevoked reports whether token has been revoked.
+func (db *DB) IsRevoked(ctx context.Context, jti, sub string, issuedAt time.Time) (bool, error) {
+	const query = `
+		SELECT
+			EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR EXISTS (SELECT 1 FROM revoked_subjects WHERE sub = $2 AND revoked_before > $3)`
+	var revoked bool
+	if err := db.db.GetContext(ctx, &revoked, query, jti, sub, issuedAt); err != nil {
+		return false, fmt.Errorf("checking revocation failed: %w", err)
+	}
+	return revoked, nil
+}
+
+// SaveRefreshToken stores new refresh token.
+func (db *DB) SaveRefreshToken(ctx context.Context, token revocation.RefreshToken) error {
+	user, err := json.Marshal(token.User)
+	if err != nil {
+		return fmt.Errorf("encoding refresh token user failed: %w", err)
+	}
+
+	const query = `
+		INSERT INTO refresh_tokens (
+			id,
+			family_id,
+			sub,
+			user_info,
+			scopes,
+			created_at,
+			expires_at
+		) VALUES (
+			:id,
+			:family_id,
+			:sub,
+			:user_info,
+			:scopes,
+			:created_at,
+			:expires_at
+		)`
+	_, err = db.db.NamedExecContext(ctx, query, rawRefreshToken{
+		ID:        token.ID,
+		FamilyID:  token.FamilyID,
+		Subject:   token.Subject,
+		User:      user,
+		Scopes:    strings.Join(token.Scopes, " "),
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("saving refresh token failed: %w", err)
+	}
+	return nil
+}
+
+// UseRefreshToken marks refresh token used and returns its previous state.
+func (db *DB) UseRefreshToken(ctx context.Context, id string, at time.Time) (revocation.RefreshToken, error) {
+	var raw rawRefreshToken
+	err := db.db.WithTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
+		const selectQuery = `
+			SELECT * FROM refresh_tokens
+			WHERE id = $1
+			FOR UPDATE`
+		if err := tx.GetContext(ctx, &raw, selectQuery, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return revocation.ErrRefreshTokenNotFound
+			}
+			return fmt.Errorf("selecting refresh token failed: %w", err)
+		}
+
+		const updateQuery = `
+			UPDATE refresh_tokens
+			SET used_at = $2
+			WHERE id = $1
+			AND used_at IS NULL`
+		if _, err := tx.ExecContext(ctx, updateQuery, id, at); err != nil {
+			return fmt.Errorf("marking refresh token used failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return revocation.RefreshToken{}, err
+	}
+
+	token := revocation.RefreshToken{
+		ID:        raw.ID,
+		FamilyID:  raw.FamilyID,
+		Subject:   raw.Subject,
+		Scopes:    strings.Fields(raw.Scopes),
+		CreatedAt: raw.CreatedAt,
+		ExpiresAt: raw.ExpiresAt,
+		UsedAt:    raw.UsedAt,
+		RevokedAt: raw.RevokedAt,
+	}
+	if len(raw.User) > 0 {
+		token.User = &auth.User{}
+		if err := json.Unmarshal(raw.User, token.User); err != nil {
+			return revocation.RefreshToken{}, fmt.Errorf("decoding refresh token user failed: %w", err)
+		}
+	}
+	return token, nil
+}
+
+// RevokeRefreshFamily revokes all refresh tokens of the family.
+func (db *DB) RevokeRefreshFamily(ctx context.Context, familyID string, at time.Time) error {
+	const query = `
+		UPDATE refresh_tokens
+		SET revoked_at = $2
+		WHERE family_id = $1
+		AND revoked_at IS NULL`
+	if _, err := db.db.ExecContext(ctx, query, familyID, at); err != nil {
+		return fmt.Errorf("revoking refresh token family failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/elisasre/go-common/v2/sqlxutil"
+	"github.com/lib/pq"
+)
+
+// RotationChannel is the LISTEN/NOTIFY channel where kid of the new key is published after rotation.
+const RotationChannel = "jwt_keys_rotated"
+
+// rotationLockID is the advisory lock key used for coordinating key rotation between replicas.
+const rotationLockID int64 = 0x6a77745f6b657973
+
+// TryLock runs fn while holding transaction level advisory lock dedicated for key rotation.
+// If another replica holds the lock, fn isn't run and false is returned. Keys are listed and rotated
+// within the locking transaction when DB is called with ctx given to fn, so TryLock needs only one
+// connection and the rotation is committed together with releasing the lock.
+func (db *DB) TryLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	locked := false
+	err := db.db.WithTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
+		if err := tx.GetContext(ctx, &locked, `SELECT pg_try_advisory_xact_lock($1)`, rotationLockID); err != nil {
+			return fmt.Errorf("acquiring key rotation lock failed: %w", err)
+		}
+		if !locked {
+			return nil
+		}
+		return fn(context.WithValue(ctx, lockTxKey{}, tx))
+	})
+	return locked, err
+}
+
+type lockTxKey struct{}
+
+// withTx runs fn in the transaction of TryLock when ctx is from it, otherwise in a new transaction.
+func (db *DB) withTx(ctx context.Context, fn func(ctx context.Context, tx *sqlxutil.Tx) error) error {
+	if tx, ok := ctx.Value(lockTxKey{}).(*sqlxutil.Tx); ok {
+		return fn(ctx, tx)
+	}
+	return db.db.WithTx(ctx, fn)
+}
+
+// selector returns the transaction of TryLock when ctx is from it, otherwise the database.
+func (db *DB) selector(ctx context.Context) interface {
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+} {
+	if tx, ok := ctx.Value(lockTxKey{}).(*sqlxutil.Tx); ok {
+		return tx
+	}
+	return db.db
+}
+
+// RotationListener listens key rotations made by any replica using Postgres LISTEN/NOTIFY.
+type RotationListener struct {
+	dsn          string
+	minReconnect time.Duration
+	maxReconnect time.Duration
+}
+
+// NewRotationListener creates RotationListener which opens its own connection using dsn.
+func NewRotationListener(dsn string) *RotationListener {
+	return &RotationListener{
+		dsn:          dsn,
+		minReconnect: 10 * time.Second,
+		maxReconnect: time.Minute,
+	}
+}
+
+// Notify starts listening RotationChannel. Returned channel receives a value after every rotation
+// and after reconnecting since notifications might have been missed. Channel is closed when ctx is done.
+func (l *RotationListener) Notify(ctx context.Context) (<-chan struct{}, error) {
+	listener := pq.NewListener(l.dsn, l.minReconnect, l.maxReconnect, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Error("jwt key rotation listener error", slog.String("error", err.Error()))
+		}
+	})
+	if err := listener.Listen(RotationChannel); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("listening %s failed: %w", RotationChannel, err)
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		defer func() { _ = listener.Close() }()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+				// nil notification means reconnect, it's forwarded as well
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			case <-time.After(l.maxReconnect):
+				go func() { _ = listener.Ping() }()
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/mfa"
+	"github.com/elisasre/go-common/v2/sqlxutil"
+)
+
+// WebAuthnSchema contains tables required by mfa.CredentialStore methods.
+const WebAuthnSchema = `
+	CREATE TABLE IF NOT EXISTS webauthn_credentials (
+		id bytea PRIMARY KEY,
+		user_id bytea NOT NULL,
+		public_key bytea NOT NULL,
+		sign_count bigint NOT NULL DEFAULT 0,
+		aaguid bytea,
+		transports text NOT NULL DEFAULT '',
+		attestation_type text NOT NULL DEFAULT '',
+		backup_eligible boolean NOT NULL DEFAULT false,
+		backup_state boolean NOT NULL DEFAULT false,
+		created_at timestamp with time zone NOT NULL,
+		last_used_at timestamp with time zone
+	);
+	CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);`
+
+type rawCredential struct {
+	ID              []byte     `db:"id"`
+	UserID          []byte     `db:"user_id"`
+	PublicKey       []byte     `db:"public_key"`
+	SignCount       int64      `db:"sign_count"`
+	AAGUID          []byte     `db:"aaguid"`
+	Transports      string     `db:"transports"`
+	AttestationType string     `db:"attestation_type"`
+	BackupEligible  bool       `db:"backup_eligible"`
+	BackupState     bool       `db:"backup_state"`
+	CreatedAt       time.Time  `db:"created_at"`
+	LastUsedAt      *time.Time `db:"last_used_at"`
+}
+
+func (r rawCredential) credential() mfa.Credential {
+	return mfa.Credential{
+		ID:              r.ID,
+		UserID:          r.UserID,
+		PublicKey:       r.PublicKey,
+		SignCount:       uint32(r.SignCount), //nolint:gosec // G115: column only contains uint32 values
+		AAGUID:          r.AAGUID,
+		Transports:      strings.Fields(r.Transports),
+		AttestationType: r.AttestationType,
+		BackupEligible:  r.BackupEligible,
+		BackupState:     r.BackupState,
+		CreatedAt:       r.CreatedAt,
+		LastUsedAt:      r.LastUsedAt,
+	}
+}
+
+// SaveCredential stores new WebAuthn credential.
+func (db *DB) SaveCredential(ctx context.Context, cred mfa.Credential) error {
+	const query = `
+		INSERT INTO webauthn_credentials (
+			id,
+			user_id,
+			public_key,
+			sign_count,
+			aaguid,
+			transports,
+			attestation_type,
+			backup_eligible,
+			backup_state,
+			created_at
+		) VALUES (
+			:id,
+			:user_id,
+			:public_key,
+			:sign_count,
+			:aaguid,
+			:transports,
+			:attestation_type,
+			:backup_eligible,
+			:backup_state,
+			:created_at
+		)`
+	_, err := db.db.NamedExecContext(ctx, query, rawCredential{
+		ID:              cred.ID,
+		UserID:          cred.UserID,
+		PublicKey:       cred.PublicKey,
+		SignCount:       int64(cred.SignCount),
+		AAGUID:          cred.AAGUID,
+		Transports:      strings.Join(cred.Transports, " "),
+		AttestationType: cred.AttestationType,
+		BackupEligible:  cred.BackupEligible,
+		BackupState:     cred.BackupState,
+		CreatedAt:       cred.CreatedAt,
+	})
+	if errors.Is(sqlxutil.ConflictWrap(err), sqlxutil.ErrConflict) {
+		return mfa.ErrCredentialExists
+	}
+	if err != nil {
+		return fmt.Errorf("saving credential failed: %w", err)
+	}
+	return nil
+}
+
+// Credential returns WebAuthn credential by id.
+func (db *DB) Credential(ctx context.Context, id []byte) (mfa.Credential, error) {
+	const query = `SELECT * FROM webauthn_credentials WHERE id = $1`
+	var raw rawCredential
+	if err := db.db.GetContext(ctx, &raw, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return mfa.Credential{}, mfa.ErrCredentialNotFound
+		}
+		return mfa.Credential{}, fmt.Errorf("selecting credential failed: %w", err)
+	}
+	return raw.credential(), nil
+}
+
+// UserCredentials returns WebAuthn credentials of the user ordered by creation time.
+func (db *DB) UserCredentials(ctx context.Context, userID []byte) ([]mfa.Credential, error) {
+	const query = `
+		SELECT * FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+	var raws []rawCredential
+	if err := db.db.SelectContext(ctx, &raws, query, userID); err != nil {
+		return nil, fmt.Errorf("selecting user credentials failed: %w", err)
+	}
+	creds := make([]mfa.Credential, 0, len(raws))
+	for _, raw := range raws {
+		creds = append(creds, raw.credential())
+	}
+	return creds, nil
+}
+
+// UseCredential updates signature counter of WebAuthn credential. Counter is compared in the
+// same statement so concurrent assertions made with cloned authenticator can't both succeed.
+func (db *DB) UseCredential(ctx context.Context, id []byte, signCount uint32, backupState bool, usedAt time.Time) error {
+	const query = `
+		UPDATE webauthn_credentials
+		SET sign_count = $2, backup_state = $3, last_used_at = $4
+		WHERE id = $1
+		AND ($2 = 0 OR sign_count < $2)`
+	res, err := db.db.ExecContext(ctx, query, id, int64(signCount), backupState, usedAt)
+	if err != nil {
+		return fmt.Errorf("updating credential failed: %w", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("updating credential failed: %w", err)
+	}
+	if rows == 0 {
+		if _, err := db.Credential(ctx, id); err != nil {
+			return err
+		}
+		return mfa.ErrCounterRollback
+	}
+	return nil
+}
+
+// DeleteCredential removes WebAuthn credential.
+func (db *DB) DeleteCredential(ctx context.Context, id []byte) error {
+	const query = `DELETE FROM webauthn_credentials WHERE id = $1`
+	if _, err := db.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("deleting credential failed: %w", err)
+	}
+	return nil
+}
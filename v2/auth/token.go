@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"slices"
 	"strings"
@@ -27,16 +29,26 @@ var AllScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopeGroups, Sco
 
 // SignClaims contains claims that are passed to SignExpires func.
 type SignClaims struct {
-	Aud    string
-	Exp    int64
-	Iat    int64
+	Aud string
+	// Audiences are added to Aud for tokens with multiple audiences.
+	Audiences []string
+	Exp       int64
+	Iat       int64
+	// Nbf is set as nbf claim when not zero.
+	Nbf    int64
 	Issuer string
 	Nonce  string
 	Scopes []string
+	// ID is used as jti claim, random ID is generated when empty.
+	ID string
+	// Subject overrides sub claim which is derived from the user by default.
+	Subject string
+	// Actor is set as act claim when token is issued to party acting on behalf of the user.
+	Actor *Actor
 }
 
-// SignAlgo const.
-const SignAlgo = "RS256"
+// SignAlgo is the default signing algorithm used with keys which don't define their own.
+const SignAlgo = AlgRS256
 
 // NewToken constructs new token which is passed for application.
 func NewToken(user *User) *Token {
@@ -48,62 +60,77 @@ type UserJWTClaims struct {
 	*User
 	jwt.RegisteredClaims
 	Nonce string `json:"nonce,omitempty"`
+	// Scope contains space separated scopes granted to the token.
+	Scope string `json:"scope,omitempty"`
+	// Actor identifies party acting on behalf of the user, see ActorChain.
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// Scopes returns scopes granted to the token.
+func (c *UserJWTClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
 }
 
 // SignExpires makes new jwt token using expiration time and secret.
+// Claims of the user are included according to DefaultScopeClaims, the user itself is not modified.
 func (t *Token) SignExpires(key JWTKey, claim SignClaims) (string, error) {
-	t.User.Email = common.Ptr(strings.ToLower(common.ValOrZero(t.User.Email)))
-	sub := t.User.MakeSub()
-	if claim.Iat == 0 {
-		claim.Iat = time.Now().Unix()
+	user := User{}
+	if t.User != nil {
+		user = *t.User
 	}
-
-	if !slices.Contains(claim.Scopes, ScopeOpenID) {
-		return "", fmt.Errorf("token must contain '%s' scope", ScopeOpenID)
+	user.Email = common.Ptr(strings.ToLower(common.ValOrZero(user.Email)))
+	sub := user.MakeSub()
+	if claim.Subject != "" {
+		sub = claim.Subject
 	}
-
-	if !slices.Contains(claim.Scopes, ScopeInternal) {
-		t.User.Internal = nil
+	if claim.Iat == 0 {
+		claim.Iat = time.Now().Unix()
 	}
 
-	if !slices.Contains(claim.Scopes, ScopeEmail) {
-		t.User.Email = nil
-		t.User.EmailVerified = nil
+	if claim.ID == "" {
+		id, err := NewTokenID()
+		if err != nil {
+			return "", err
+		}
+		claim.ID = id
 	}
 
-	if !slices.Contains(claim.Scopes, ScopeGroups) {
-		t.User.Groups = nil
+	if !slices.Contains(claim.Scopes, ScopeOpenID) {
+		return "", fmt.Errorf("token must contain '%s' scope", ScopeOpenID)
 	}
 
-	if !slices.Contains(claim.Scopes, ScopeProfile) {
-		t.User.Name = nil
+	aud := jwt.ClaimStrings{}
+	if claim.Aud != "" {
+		aud = append(aud, claim.Aud)
 	}
-
+	aud = append(aud, claim.Audiences...)
 	claims := UserJWTClaims{
-		t.User,
-		jwt.RegisteredClaims{
+		User: &user,
+		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   sub,
-			Audience:  jwt.ClaimStrings{claim.Aud},
+			Audience:  aud,
 			ExpiresAt: jwt.NewNumericDate(time.Unix(claim.Exp, 0)),
 			Issuer:    claim.Issuer,
 			IssuedAt:  jwt.NewNumericDate(time.Unix(claim.Iat, 0)),
+			ID:        claim.ID,
 		},
-		claim.Nonce,
+		Nonce: claim.Nonce,
+		Scope: strings.Join(claim.Scopes, " "),
+		Actor: claim.Actor,
 	}
-	method := jwt.SigningMethodRS256
-	token := jwt.Token{
-		Header: map[string]interface{}{
-			"typ": "JWT",
-			"alg": method.Alg(),
-			"kid": key.KID,
-		},
-		Claims: claims,
-		Method: method,
+	if claim.Nbf != 0 {
+		claims.NotBefore = jwt.NewNumericDate(time.Unix(claim.Nbf, 0))
 	}
-	if key.PrivateKey == nil {
-		return "", fmt.Errorf("privatekey is nil for key %s", key.KID)
+	return SignWithClaims(key, claims, WithScopeClaims(DefaultScopeClaims))
+}
+
+// NewTokenID generates random token identifier which can be used as jti claim.
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating token id failed: %w", err)
 	}
-	return token.SignedString(key.PrivateKey)
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
 func findKidFromArray(keys []JWTKey, kid interface{}) (JWTKey, error) {
@@ -121,28 +148,5 @@ func findKidFromArray(keys []JWTKey, kid interface{}) (JWTKey, error) {
 
 // ParseToken will validate jwt token and return user with jwt claims.
 func ParseToken(raw string, keys []JWTKey, options ...jwt.ParserOption) (*UserJWTClaims, error) {
-	parsed, err := jwt.ParseWithClaims(raw, &UserJWTClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if t.Method.Alg() != SignAlgo {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		if val, ok := t.Header["kid"]; ok {
-			key, err := findKidFromArray(keys, val)
-			if err != nil {
-				return nil, err
-			}
-			return key.PublicKey, nil
-		}
-		return nil, fmt.Errorf("could not find kid from headers")
-	}, options...)
-	if err != nil {
-		return nil, err
-	} else if !parsed.Valid {
-		return nil, fmt.Errorf("jwt token was not valid")
-	}
-
-	claims, ok := parsed.Claims.(*UserJWTClaims)
-	if !ok {
-		return nil, fmt.Errorf("could not parse struct")
-	}
-	return claims, nil
+	return ParseWithClaims[UserJWTClaims](raw, keys, options...)
 }
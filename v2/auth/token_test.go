@@ -1,6 +1,7 @@
 package auth_test
 
 import (
+	"crypto"
 	"fmt"
 	"strings"
 	"testing"
@@ -165,3 +166,101 @@ func TestIssuer(t *testing.T) {
 	_, err = auth.ParseToken(token, []auth.JWTKey{key}, jwt.WithIssuer("http://localhost"))
 	require.NoError(t, err)
 }
+
+func TestTokenAlgorithms(t *testing.T) {
+	for _, alg := range auth.SupportedAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			key, err := auth.GenerateNewKeyPairWithAlgorithm(alg)
+			require.NoError(t, err)
+			require.Equal(t, alg, key.Alg())
+
+			token, err := auth.NewToken(&auth.User{}).SignExpires(key, auth.SignClaims{
+				Aud:    "internal",
+				Exp:    time.Now().Add(time.Hour).Unix(),
+				Issuer: "http://localhost",
+				Scopes: auth.AllScopes,
+			})
+			require.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+			require.NoError(t, err)
+			require.Equal(t, alg, parsed.Header["alg"])
+
+			_, err = auth.ParseToken(token, []auth.JWTKey{key})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestTokenAlgorithmMismatch(t *testing.T) {
+	key, err := auth.GenerateNewKeyPairWithAlgorithm(auth.AlgPS256)
+	require.NoError(t, err)
+
+	token, err := auth.NewToken(&auth.User{}).SignExpires(key, auth.SignClaims{
+		Aud:    "internal",
+		Exp:    time.Now().Add(time.Hour).Unix(),
+		Scopes: auth.AllScopes,
+	})
+	require.NoError(t, err)
+
+	// same RSA key configured for RS256 must not accept PS256 tokens
+	key.Algorithm = auth.AlgRS256
+	_, err = auth.ParseToken(token, []auth.JWTKey{key})
+	require.ErrorIs(t, err, jwt.ErrSignatureInvalid)
+
+	_, err = auth.GenerateNewKeyPairWithAlgorithm("HS256")
+	require.ErrorIs(t, err, auth.ErrUnsupportedAlgorithm)
+}
+
+// opaqueSigner hides the concrete key type like KMS backed signers do.
+type opaqueSigner struct{ crypto.Signer }
+
+func TestTokenCustomSigner(t *testing.T) {
+	key, err := auth.GenerateNewKeyPairWithAlgorithm(auth.AlgES256)
+	require.NoError(t, err)
+	key.PrivateKey = opaqueSigner{key.PrivateKey}
+
+	token, err := auth.NewToken(&auth.User{}).SignExpires(key, auth.SignClaims{
+		Aud:    "internal",
+		Exp:    time.Now().Add(time.Hour).Unix(),
+		Scopes: auth.AllScopes,
+	})
+	require.NoError(t, err)
+	_, err = auth.ParseToken(token, []auth.JWTKey{key})
+	require.NoError(t, err)
+}
+
+func TestScopeClaim(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+
+	token, err := auth.NewToken(&auth.User{}).SignExpires(key, auth.SignClaims{
+		Exp:    time.Now().Add(time.Hour).Unix(),
+		Scopes: []string{auth.ScopeOpenID, auth.ScopeGroups},
+	})
+	require.NoError(t, err)
+	claims, err := auth.ParseToken(token, []auth.JWTKey{key})
+	require.NoError(t, err)
+	require.Equal(t, []string{auth.ScopeOpenID, auth.ScopeGroups}, claims.Scopes())
+}
+
+func TestMFAMethod(t *testing.T) {
+	key, err := auth.GenerateNewKeyPair()
+	require.NoError(t, err)
+
+	user := &auth.User{Email: common.Ptr("email@company.com")}
+	require.False(t, user.TokenMFA())
+	user.SetMFA(auth.MFAMethodWebAuthn)
+
+	token, err := auth.NewToken(user).SignExpires(key, auth.SignClaims{
+		Aud:    "internal",
+		Exp:    time.Now().Add(time.Hour).Unix(),
+		Issuer: "http://localhost",
+		Scopes: auth.AllScopes,
+	})
+	require.NoError(t, err)
+	claims, err := auth.ParseToken(token, []auth.JWTKey{key})
+	require.NoError(t, err)
+	require.True(t, claims.TokenMFA())
+	require.Equal(t, auth.MFAMethodWebAuthn, claims.TokenMFAMethod())
+}
@@ -12,9 +12,17 @@ type Internal struct {
 	Cluster     *string `json:"cluster,omitempty"`
 	ChangeLimit *int    `json:"limit,omitempty"`
 	MFA         *bool   `json:"mfa"`
+	MFAMethod   string  `json:"mfa_method,omitempty"`
 	EmployeeID  string  `json:"employeeid,omitempty"`
 }
 
+// MFA methods stored in Internal.MFAMethod.
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodWebAuthn     = "webauthn"
+	MFAMethodRecoveryCode = "recovery_code"
+)
+
 // User contains struct for single user.
 type User struct {
 	Groups        []string  `json:"groups,omitempty"`
@@ -61,3 +69,20 @@ func (u User) TokenMFA() bool {
 	}
 	return common.ValOrZero(u.Internal.MFA)
 }
+
+// TokenMFAMethod returns MFA method used in current JWT or empty string if MFA wasn't used.
+func (u User) TokenMFAMethod() string {
+	if !u.TokenMFA() {
+		return ""
+	}
+	return u.Internal.MFAMethod
+}
+
+// SetMFA marks that user has completed MFA using given method.
+func (u *User) SetMFA(method string) {
+	if u.Internal == nil {
+		u.Internal = &Internal{}
+	}
+	u.Internal.MFA = common.Ptr(true)
+	u.Internal.MFAMethod = method
+}
@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -51,8 +53,14 @@ type Backoff struct {
 	Duration time.Duration
 	// Maximum number of tries.
 	MaxTries int
+	// MaxRetryAfter caps delays requested by server with Retry-After or rate limit reset headers
+	// when 429 or 503 response is retried. Zero means DefaultMaxRetryAfter.
+	MaxRetryAfter time.Duration
 }
 
+// DefaultMaxRetryAfter is the default of Backoff.MaxRetryAfter.
+const DefaultMaxRetryAfter = time.Minute
+
 func requestHeaders(httpreq *http.Request, request Request, bearerToken []byte) *http.Request {
 	if len(request.Body) > 0 {
 		httpreq.Body = io.NopCloser(bytes.NewReader(request.Body))
@@ -92,74 +100,161 @@ func MakeRequest(ctx context.Context, request Request, output interface{}, clien
 		request.StopRetryCodes = append(request.StopRetryCodes, http.StatusTooManyRequests)
 	}
 
-	err := SleepUntil(backoff, func() (bool, error) {
-		httpreq, err := http.NewRequestWithContext(ctx, request.Method, request.URL, nil)
-		if err != nil {
-			slog.Error("creating http request failed",
-				slog.String("method", request.Method),
-				slog.String("url", request.URL),
-				slog.String("error", err.Error()),
-			)
-			return false, err
-		}
+	maxRetryAfter := backoff.MaxRetryAfter
+	if maxRetryAfter <= 0 {
+		maxRetryAfter = DefaultMaxRetryAfter
+	}
+	err := sleepUntil(ctx, backoff, func() (bool, time.Duration, error) {
+		done, delay, err := makeRequest(ctx, request, output, client, bearerToken, httpresp)
+		return done, min(delay, maxRetryAfter), err
+	})
+	return httpresp, err
+}
 
-		httpreq = requestHeaders(httpreq, request, bearerToken)
+func makeRequest(ctx context.Context, request Request, output any, client HTTPClient, bearerToken []byte, httpresp *Response) (bool, time.Duration, error) {
+	httpreq, err := http.NewRequestWithContext(ctx, request.Method, request.URL, nil)
+	if err != nil {
+		slog.Error("creating http request failed",
+			slog.String("method", request.Method),
+			slog.String("url", request.URL),
+			slog.String("error", err.Error()),
+		)
+		return false, 0, err
+	}
 
-		resp, err := client.Do(httpreq)
-		if err != nil {
-			slog.Error("http request failed",
-				slog.String("method", request.Method),
-				slog.String("url", request.URL),
-				slog.String("error", err.Error()),
-			)
-			if !request.RetryOnContextDeadline && errors.Is(err, context.DeadlineExceeded) {
-				return true, err
-			}
-			return false, err
-		}
-		defer resp.Body.Close()
-		httpresp.StatusCode = resp.StatusCode
-		responseBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return false, err
-		}
-		httpresp.Body = responseBody
-		httpresp.Headers = resp.Header
+	httpreq = requestHeaders(httpreq, request, bearerToken)
 
-		l := slog.With(
-			slog.Int("status_code", resp.StatusCode),
+	resp, err := client.Do(httpreq)
+	if err != nil {
+		slog.Error("http request failed",
 			slog.String("method", request.Method),
 			slog.String("url", request.URL),
-			slog.String("body", string(responseBody)),
+			slog.String("error", err.Error()),
 		)
+		if !request.RetryOnContextDeadline && errors.Is(err, context.DeadlineExceeded) {
+			return true, 0, err
+		}
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+	httpresp.StatusCode = resp.StatusCode
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, 0, err
+	}
+	httpresp.Body = responseBody
+	httpresp.Headers = resp.Header
+
+	l := slog.With(
+		slog.Int("status_code", resp.StatusCode),
+		slog.String("method", request.Method),
+		slog.String("url", request.URL),
+		slog.String("body", string(responseBody)),
+	)
 
-		if slices.Contains(request.OKCode, resp.StatusCode) {
-			if output != nil {
-				err = request.Unmarshaler(httpresp.Body, &output)
-				if err != nil {
-					return true, fmt.Errorf("could not unmarshal %w", err)
-				}
+	if slices.Contains(request.OKCode, resp.StatusCode) {
+		if output != nil {
+			err = request.Unmarshaler(httpresp.Body, &output)
+			if err != nil {
+				return true, 0, fmt.Errorf("could not unmarshal %w", err)
 			}
-			return true, nil
 		}
+		return true, 0, nil
+	}
 
-		if slices.Contains(request.StopRetryCodes, resp.StatusCode) {
-			// Check if custom retry logic wants to override the stop behavior
-			if request.ShouldRetry != nil && request.ShouldRetry(httpresp) {
-				l.Warn("custom retry logic overriding stop retry code",
-					slog.Int("status_code", resp.StatusCode))
-				return false, err // Continue retrying
-			}
-			status := http.StatusText(resp.StatusCode)
-			l.Warn("skipping retry",
-				slog.String("status", status))
-			return true, errors.New(status)
+	if slices.Contains(request.StopRetryCodes, resp.StatusCode) {
+		// Check if custom retry logic wants to override the stop behavior
+		if request.ShouldRetry != nil && request.ShouldRetry(httpresp) {
+			l.Warn("custom retry logic overriding stop retry code",
+				slog.Int("status_code", resp.StatusCode))
+			return false, retryDelay(resp), err // Continue retrying
 		}
+		status := http.StatusText(resp.StatusCode)
+		l.Warn("skipping retry",
+			slog.String("status", status))
+		return true, 0, errors.New(status)
+	}
 
-		l.Warn("retrying")
-		return false, err
-	})
-	return httpresp, err
+	l.Warn("retrying")
+	return false, retryDelay(resp), err
+}
+
+// retryDelay returns delay requested by server for 429 and 503 responses.
+func retryDelay(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	delay, _ := RetryAfter(resp.Header, time.Now())
+	return delay
+}
+
+// RetryAfter returns delay requested by server in Retry-After header. If it is missing reset time
+// of exhausted quota is read from IETF RateLimit header or from RateLimit-Reset and X-RateLimit-Reset
+// headers which may contain either seconds or unix timestamp.
+func RetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return max(time.Duration(secs)*time.Second, 0), true
+		}
+		if at, err := http.ParseTime(v); err == nil {
+			return max(at.Sub(now), 0), true
+		}
+	}
+	if d, ok := rateLimitReset(h.Get("RateLimit")); ok {
+		return d, true
+	}
+	for _, name := range []string{"RateLimit-Reset", "X-RateLimit-Reset"} {
+		v, err := strconv.ParseInt(h.Get(name), 10, 64)
+		if err != nil {
+			continue
+		}
+		// values which can't be delays are unix timestamps
+		if v > int64(365*24*time.Hour/time.Second) {
+			return max(time.Unix(v, 0).Sub(now), 0), true
+		}
+		return max(time.Duration(v)*time.Second, 0), true
+	}
+	return 0, false
+}
+
+// rateLimitReset returns the longest reset time of exhausted policies in RateLimit header,
+// e.g. "default";r=0;t=30. Reset time of any policy is used when none of them is exhausted.
+func rateLimitReset(v string) (time.Duration, bool) {
+	var exhausted, other int64 = -1, -1
+	for item := range strings.SplitSeq(v, ",") {
+		var r, t int64 = -1, -1
+		for param := range strings.SplitSeq(item, ";") {
+			key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "r":
+				r = n
+			case "t":
+				t = n
+			}
+		}
+		if t < 0 {
+			continue
+		}
+		if r == 0 {
+			exhausted = max(exhausted, t)
+		}
+		other = max(other, t)
+	}
+	switch {
+	case exhausted >= 0:
+		return time.Duration(exhausted) * time.Second, true
+	case other >= 0:
+		return time.Duration(other) * time.Second, true
+	default:
+		return 0, false
+	}
 }
 
 // ErrTimeout is returned if SleepUntil condition isn't met.
@@ -171,18 +266,33 @@ type ConditionFunc func() (done bool, err error)
 
 // SleepUntil waits for condition to succeeds.
 func SleepUntil(backoff Backoff, condition ConditionFunc) error {
+	return sleepUntil(context.Background(), backoff, func() (bool, time.Duration, error) {
+		ok, err := condition()
+		return ok, 0, err
+	})
+}
+
+// sleepUntil works like SleepUntil but condition may request longer delay than backoff before next try.
+// Waiting is stopped when ctx is done.
+func sleepUntil(ctx context.Context, backoff Backoff, condition func() (done bool, delay time.Duration, err error)) error {
 	var err error
 	for backoff.MaxTries > 0 {
 		var ok bool
-		if ok, err = condition(); ok {
+		var delay time.Duration
+		if ok, delay, err = condition(); ok {
 			return err
 		}
 		if backoff.MaxTries == 1 {
 			break
 		}
 		backoff.MaxTries--
-		time.Sleep(backoff.Duration)
-
+		timer := time.NewTimer(max(backoff.Duration, delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
 	}
 	if err != nil {
 		return err
@@ -146,3 +146,100 @@ func TestBearerTokenFileMock(t *testing.T) {
 	assert.Equal(t, helloWorld, string(body.Body))
 	assert.Equal(t, 200, body.StatusCode)
 }
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+		found   bool
+	}{
+		{"missing", map[string]string{}, 0, false},
+		{"seconds", map[string]string{"Retry-After": "30"}, 30 * time.Second, true},
+		{"http date", map[string]string{"Retry-After": now.Add(2 * time.Minute).Format(http.TimeFormat)}, 2 * time.Minute, true},
+		{"date in past", map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)}, 0, true},
+		{"retry after wins", map[string]string{"Retry-After": "5", "X-Ratelimit-Reset": "60"}, 5 * time.Second, true},
+		{"ietf exhausted policy", map[string]string{"RateLimit": `"per-second";r=3;t=1, "per-hour";r=0;t=1800`}, 30 * time.Minute, true},
+		{"ietf", map[string]string{"RateLimit": `"default";r=5;t=10`}, 10 * time.Second, true},
+		{"draft reset", map[string]string{"RateLimit-Reset": "15"}, 15 * time.Second, true},
+		{"reset timestamp", map[string]string{"X-Ratelimit-Reset": fmt.Sprint(now.Add(45 * time.Second).Unix())}, 45 * time.Second, true},
+		{"invalid", map[string]string{"Retry-After": "soon", "X-Ratelimit-Reset": "later"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			got, found := httputil.RetryAfter(h, now)
+			require.Equal(t, tt.found, found)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMakeRequestRetryAfter(t *testing.T) {
+	var calls []time.Time
+	client := &httputil.MockClient{
+		DoFunc: func(*http.Request) (*http.Response, error) {
+			calls = append(calls, time.Now())
+			if len(calls) == 1 {
+				return &http.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Header:     http.Header{"Retry-After": []string{"1"}},
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+		},
+	}
+	request := httputil.Request{
+		URL:         "http://localhost/",
+		Method:      http.MethodGet,
+		OKCode:      []int{http.StatusOK},
+		ShouldRetry: func(resp *httputil.Response) bool { return resp.StatusCode == http.StatusTooManyRequests },
+	}
+
+	resp, err := httputil.MakeRequest(context.Background(), request, nil, client, httputil.Backoff{Duration: time.Millisecond, MaxTries: 2})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, calls, 2)
+	require.GreaterOrEqual(t, calls[1].Sub(calls[0]), time.Second, "Retry-After is honored instead of backoff")
+
+	// requested delay is capped
+	calls = nil
+	_, err = httputil.MakeRequest(context.Background(), request, nil, client, httputil.Backoff{
+		Duration:      time.Millisecond,
+		MaxTries:      2,
+		MaxRetryAfter: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.Less(t, calls[1].Sub(calls[0]), 500*time.Millisecond)
+
+	// waiting for long delay stops when ctx is done
+	calls = nil
+	slow := &httputil.MockClient{DoFunc: func(*http.Request) (*http.Response, error) {
+		calls = append(calls, time.Now())
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": []string{"86400"}},
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = httputil.MakeRequest(ctx, request, nil, slow, httputil.Backoff{Duration: time.Millisecond, MaxTries: 2})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+	require.Len(t, calls, 1)
+
+	// 429 still stops retries by default
+	calls = nil
+	request.ShouldRetry = nil
+	resp, err = httputil.MakeRequest(context.Background(), request, nil, client, httputil.Backoff{Duration: time.Millisecond, MaxTries: 2})
+	require.Error(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Len(t, calls, 1)
+}
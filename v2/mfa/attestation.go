@@ -0,0 +1,145 @@
+package mfa
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Attestation types stored in Credential.AttestationType.
+const (
+	AttestationNone  = "none"
+	AttestationSelf  = "self"
+	AttestationBasic = "basic"
+)
+
+var (
+	ErrInvalidAttestation     = errors.New("invalid attestation")
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+	ErrUntrustedAttestation   = errors.New("attestation is not trusted")
+)
+
+// oidAAGUID is the FIDO extension containing AAGUID of the authenticator model in attestation certificates.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+type packedStatement struct {
+	Alg COSEAlgorithm `cbor:"alg"`
+	Sig []byte        `cbor:"sig"`
+	X5C [][]byte      `cbor:"x5c,omitempty"`
+}
+
+// verifyAttestation verifies attestation statement and returns attestation type.
+// Only none and packed formats are supported.
+func (w *WebAuthn) verifyAttestation(obj *attestationObject, ad *authData, clientDataHash []byte, key *publicKey) (string, error) {
+	switch obj.Fmt {
+	case "none":
+		var stmt map[string]cbor.RawMessage
+		if err := cborDec.Unmarshal(obj.AttStmt, &stmt); err != nil || len(stmt) != 0 {
+			return "", fmt.Errorf("%w: none attestation must have empty statement", ErrInvalidAttestation)
+		}
+		if w.roots != nil {
+			return "", ErrUntrustedAttestation
+		}
+		return AttestationNone, nil
+	case "packed":
+		return w.verifyPacked(obj, ad, clientDataHash, key)
+	default:
+		return "", fmt.Errorf("%w: '%s'", ErrUnsupportedAttestation, obj.Fmt)
+	}
+}
+
+func (w *WebAuthn) verifyPacked(obj *attestationObject, ad *authData, clientDataHash []byte, key *publicKey) (string, error) {
+	var stmt packedStatement
+	if err := cborDec.Unmarshal(obj.AttStmt, &stmt); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+	signed := slices.Concat(obj.AuthData, clientDataHash)
+
+	if len(stmt.X5C) == 0 {
+		// self attestation is signed with the credential key itself
+		if stmt.Alg != key.alg {
+			return "", fmt.Errorf("%w: algorithm does not match credential key", ErrInvalidAttestation)
+		}
+		if err := key.verify(signed, stmt.Sig); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+		}
+		if w.roots != nil {
+			return "", ErrUntrustedAttestation
+		}
+		return AttestationSelf, nil
+	}
+
+	certs := make([]*x509.Certificate, 0, len(stmt.X5C))
+	for _, der := range stmt.X5C {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+		}
+		certs = append(certs, cert)
+	}
+	leaf := certs[0]
+	alg, err := x509Algorithm(stmt.Alg)
+	if err != nil {
+		return "", err
+	}
+	if err := leaf.CheckSignature(alg, signed, stmt.Sig); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+	if err := checkAttestationCert(leaf, ad.aaguid); err != nil {
+		return "", err
+	}
+
+	if w.roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, c := range certs[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         w.roots,
+			Intermediates: intermediates,
+			CurrentTime:   now(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrUntrustedAttestation, err)
+		}
+	}
+	return AttestationBasic, nil
+}
+
+// checkAttestationCert checks packed attestation certificate requirements from WebAuthn specification.
+func checkAttestationCert(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 {
+		return fmt.Errorf("%w: certificate version must be 3", ErrInvalidAttestation)
+	}
+	if !slices.Contains(cert.Subject.OrganizationalUnit, "Authenticator Attestation") {
+		return fmt.Errorf("%w: certificate subject OU must be 'Authenticator Attestation'", ErrInvalidAttestation)
+	}
+	if cert.IsCA {
+		return fmt.Errorf("%w: certificate must not be CA", ErrInvalidAttestation)
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return fmt.Errorf("%w: AAGUID extension must not be critical", ErrInvalidAttestation)
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil || !bytes.Equal(value, aaguid) {
+			return fmt.Errorf("%w: AAGUID does not match certificate", ErrInvalidAttestation)
+		}
+	}
+	return nil
+}
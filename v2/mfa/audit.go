@@ -0,0 +1,30 @@
+package mfa
+
+import (
+	"context"
+
+	"github.com/elisasre/go-common/v2/audit"
+)
+
+// WithAuditRecorder records audit.ActionMFAVerify event for every validated code.
+// Actor is the authenticated user found from the context, secrets and codes are never recorded.
+func WithAuditRecorder(r audit.Recorder) Opt {
+	return func(t *TOTP) {
+		t.recorder = r
+	}
+}
+
+// WithWebAuthnAuditRecorder records audit.ActionMFAVerify event for every finished login ceremony.
+func WithWebAuthnAuditRecorder(r audit.Recorder) WebAuthnOpt {
+	return func(w *WebAuthn) {
+		w.recorder = r
+	}
+}
+
+func recordVerify(ctx context.Context, r audit.Recorder, method, target string, err error) {
+	audit.RecordResult(ctx, r, audit.Event{
+		Action:   audit.ActionMFAVerify,
+		Target:   target,
+		Metadata: map[string]string{"method": method},
+	}, err)
+}
@@ -0,0 +1,290 @@
+package mfa
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSEAlgorithm is COSE algorithm identifier from the IANA registry.
+type COSEAlgorithm int
+
+// Supported public key credential algorithms.
+const (
+	AlgES256 COSEAlgorithm = -7
+	AlgEdDSA COSEAlgorithm = -8
+	AlgES384 COSEAlgorithm = -35
+	AlgES512 COSEAlgorithm = -36
+	AlgRS256 COSEAlgorithm = -257
+)
+
+// DefaultAlgorithms are offered to authenticators in order of preference.
+var DefaultAlgorithms = []COSEAlgorithm{AlgES256, AlgEdDSA, AlgRS256}
+
+var (
+	ErrUnsupportedKey  = errors.New("unsupported public key")
+	ErrInvalidSig      = errors.New("invalid signature")
+	ErrInvalidAuthData = errors.New("invalid authenticator data")
+)
+
+// COSE key parameters from RFC 9053.
+const (
+	coseKty     = 1
+	coseAlg     = 3
+	coseCrv     = -1
+	coseX       = -2
+	coseY       = -3
+	coseRSAN    = -1
+	coseRSAE    = -2
+	coseKtyOKP  = 1
+	coseKtyEC2  = 2
+	coseKtyRSA  = 3
+	coseP256    = 1
+	coseP384    = 2
+	coseP521    = 3
+	coseEd25519 = 6
+)
+
+// cborDec rejects duplicate map keys so ambiguous keys and statements can't be smuggled past verification.
+var cborDec = func() cbor.DecMode {
+	dm, err := cbor.DecOptions{DupMapKey: cbor.DupMapKeyEnforcedAPF}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return dm
+}()
+
+// publicKey is parsed COSE_Key.
+type publicKey struct {
+	alg COSEAlgorithm
+	key crypto.PublicKey
+}
+
+func parseCOSEKey(data []byte) (*publicKey, error) {
+	var m map[int]cbor.RawMessage
+	if err := cborDec.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+	var kty, alg int
+	if err := decodeParam(m, coseKty, &kty); err != nil {
+		return nil, err
+	}
+	if err := decodeParam(m, coseAlg, &alg); err != nil {
+		return nil, err
+	}
+
+	pk := &publicKey{alg: COSEAlgorithm(alg)}
+	switch {
+	case kty == coseKtyEC2 && (pk.alg == AlgES256 || pk.alg == AlgES384 || pk.alg == AlgES512):
+		var crv int
+		var x, y []byte
+		if err := decodeParams(m, map[int]any{coseCrv: &crv, coseX: &x, coseY: &y}); err != nil {
+			return nil, err
+		}
+		curve, ok := map[int]elliptic.Curve{coseP256: elliptic.P256(), coseP384: elliptic.P384(), coseP521: elliptic.P521()}[crv]
+		if !ok || curve != algCurve(pk.alg) {
+			return nil, fmt.Errorf("%w: curve %d with algorithm %d", ErrUnsupportedKey, crv, alg)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("%w: invalid coordinate length", ErrUnsupportedKey)
+		}
+		// parsing uncompressed point rejects points which are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+		}
+		pk.key = key
+	case kty == coseKtyOKP && pk.alg == AlgEdDSA:
+		var crv int
+		var x []byte
+		if err := decodeParams(m, map[int]any{coseCrv: &crv, coseX: &x}); err != nil {
+			return nil, err
+		}
+		if crv != coseEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid Ed25519 key", ErrUnsupportedKey)
+		}
+		pk.key = ed25519.PublicKey(x)
+	case kty == coseKtyRSA && pk.alg == AlgRS256:
+		var n, e []byte
+		if err := decodeParams(m, map[int]any{coseRSAN: &n, coseRSAE: &e}); err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA exponent", ErrUnsupportedKey)
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RSA key is smaller than 2048 bits", ErrUnsupportedKey)
+		}
+		pk.key = key
+	default:
+		return nil, fmt.Errorf("%w: key type %d with algorithm %d", ErrUnsupportedKey, kty, alg)
+	}
+	return pk, nil
+}
+
+func decodeParam(m map[int]cbor.RawMessage, label int, v any) error {
+	raw, ok := m[label]
+	if !ok {
+		return fmt.Errorf("%w: missing parameter %d", ErrUnsupportedKey, label)
+	}
+	if err := cborDec.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: parameter %d: %w", ErrUnsupportedKey, label, err)
+	}
+	return nil
+}
+
+func decodeParams(m map[int]cbor.RawMessage, params map[int]any) error {
+	for label, v := range params {
+		if err := decodeParam(m, label, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func algCurve(alg COSEAlgorithm) elliptic.Curve {
+	switch alg {
+	case AlgES384:
+		return elliptic.P384()
+	case AlgES512:
+		return elliptic.P521()
+	default:
+		return elliptic.P256()
+	}
+}
+
+// verify checks signature over data using the key.
+func (pk *publicKey) verify(data, sig []byte) error {
+	var ok bool
+	switch key := pk.key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest(pk.alg, data), sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest(pk.alg, data), sig) == nil
+	}
+	if !ok {
+		return ErrInvalidSig
+	}
+	return nil
+}
+
+func digest(alg COSEAlgorithm, data []byte) []byte {
+	switch alg {
+	case AlgES384:
+		sum := sha512.Sum384(data)
+		return sum[:]
+	case AlgES512:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+}
+
+// x509Algorithm maps COSE algorithm to x509 signature algorithm for verifying attestation certificates.
+func x509Algorithm(alg COSEAlgorithm) (x509.SignatureAlgorithm, error) {
+	switch alg {
+	case AlgES256:
+		return x509.ECDSAWithSHA256, nil
+	case AlgES384:
+		return x509.ECDSAWithSHA384, nil
+	case AlgES512:
+		return x509.ECDSAWithSHA512, nil
+	case AlgEdDSA:
+		return x509.PureEd25519, nil
+	case AlgRS256:
+		return x509.SHA256WithRSA, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("%w: algorithm %d", ErrUnsupportedKey, alg)
+	}
+}
+
+// Authenticator data flags.
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackupState    = 0x10
+	flagAttestedData   = 0x40
+	flagExtensions     = 0x80
+)
+
+// authData is parsed authenticator data.
+type authData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthData(data []byte) (*authData, error) {
+	const headerLen = 32 + 1 + 4
+	if len(data) < headerLen {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidAuthData)
+	}
+	ad := &authData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[headerLen:]
+
+	if ad.flags&flagAttestedData != 0 {
+		const fixedLen = 16 + 2
+		if len(rest) < fixedLen {
+			return nil, fmt.Errorf("%w: attested credential data is too short", ErrInvalidAuthData)
+		}
+		ad.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[fixedLen:]
+		if idLen > 1023 || len(rest) < idLen {
+			return nil, fmt.Errorf("%w: invalid credential id length", ErrInvalidAuthData)
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		var key cbor.RawMessage
+		next, err := cborDec.UnmarshalFirst(rest, &key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: credential public key: %w", ErrInvalidAuthData, err)
+		}
+		ad.publicKey = rest[:len(rest)-len(next)]
+		rest = next
+	}
+
+	if ad.flags&flagExtensions != 0 {
+		var ext map[string]cbor.RawMessage
+		next, err := cborDec.UnmarshalFirst(rest, &ext)
+		if err != nil {
+			return nil, fmt.Errorf("%w: extensions: %w", ErrInvalidAuthData, err)
+		}
+		rest = next
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidAuthData, len(rest))
+	}
+	return ad, nil
+}
@@ -1,84 +1,270 @@
+// Package mfa provides TOTP (RFC 6238) and WebAuthn based multi-factor authentication with replay protection,
+// enrollment helpers and single-use recovery codes.
 package mfa
 
 import (
-	"bytes"
+	"context"
 	"crypto/hmac"
-	"crypto/sha1" //nolint:gosec // G501: Blocklisted import crypto/md5: weak cryptographic primitive
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // G505: SHA1 is the default TOTP algorithm, HMAC-SHA1 is still secure
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/base32"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/elisasre/go-common/v2/auth"
 )
 
 // now can be used to mock time in tests.
 var now = time.Now
 
-// Validates TOTP mfa with given secret and token.
-func Validate(secret string, token string) error {
-	curToken, err := getTOTPToken(secret)
-	if err != nil {
-		return err
+// Algorithm is the HMAC hash function used for generating codes.
+type Algorithm string
+
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+var (
+	ErrInvalidCode      = errors.New("token is invalid or expired")
+	ErrCodeReused       = errors.New("token has already been used")
+	ErrInvalidSecret    = errors.New("invalid secret")
+	ErrInvalidAlgorithm = errors.New("invalid algorithm")
+	ErrInvalidDigits    = errors.New("invalid number of digits")
+)
+
+// TOTP generates and validates time-based one-time passwords.
+type TOTP struct {
+	digits    int
+	period    time.Duration
+	algorithm Algorithm
+	skew      int
+	store     UsedCodeStore
+	recorder  audit.Recorder
+}
+
+type Opt func(*TOTP)
+
+// WithDigits sets length of codes, defaults to 6.
+func WithDigits(digits int) Opt {
+	return func(t *TOTP) {
+		t.digits = digits
 	}
-	if curToken != token {
-		return fmt.Errorf("token is invalid or expired")
+}
+
+// WithPeriod sets how long each code is valid, defaults to 30 seconds.
+func WithPeriod(d time.Duration) Opt {
+	return func(t *TOTP) {
+		t.period = d
 	}
-	return nil
 }
 
-// Append extra 0s if the length of otp is less than 6
-// If otp is "1234", it will return it as "001234".
-func prefix0(otp string) string {
-	if len(otp) == 6 {
-		return otp
+// WithAlgorithm sets HMAC algorithm, defaults to SHA1 which is the only one supported by all authenticator apps.
+func WithAlgorithm(alg Algorithm) Opt {
+	return func(t *TOTP) {
+		t.algorithm = alg
 	}
-	for i := 6 - len(otp); i > 0; i-- {
-		otp = "0" + otp
+}
+
+// WithSkew sets how many periods before and after the current one are accepted, defaults to 1.
+func WithSkew(steps int) Opt {
+	return func(t *TOTP) {
+		t.skew = steps
+	}
+}
+
+// WithUsedCodeStore enables replay protection, every code can be used only once
+// and codes older than the last used code are rejected.
+func WithUsedCodeStore(store UsedCodeStore) Opt {
+	return func(t *TOTP) {
+		t.store = store
+	}
+}
+
+// New creates TOTP with given options.
+func New(opts ...Opt) *TOTP {
+	t := &TOTP{
+		digits:    6,
+		period:    30 * time.Second,
+		algorithm: SHA1,
+		skew:      1,
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
-	return otp
+	return t
 }
 
-func getHOTPToken(secret string, interval int64) (string, error) {
-	// Converts secret to base32 Encoding. Base32 encoding desires a 32-character
-	// subset of the twenty-six letters A–Z and ten digits 0–9
-	key, err := base32.StdEncoding.DecodeString(strings.ToUpper(secret))
+// Validate validates TOTP code using default settings without replay protection.
+// Only the code of the current period is accepted.
+func Validate(secret string, token string) error {
+	return New(WithSkew(0)).Validate(context.Background(), secret, token)
+}
+
+// Generate returns code for given time.
+func (t *TOTP) Generate(secret string, at time.Time) (string, error) {
+	key, err := decodeSecret(secret)
 	if err != nil {
 		return "", err
 	}
-	bs := make([]byte, 8)
-	binary.BigEndian.PutUint64(bs, uint64(interval))
+	return t.hotp(key, t.step(at))
+}
+
+// Validate checks code against secret accepting codes within configured skew.
+// Comparison is constant-time. If UsedCodeStore is configured code is marked used,
+// the store is keyed with hash of the secret so secrets are never stored.
+func (t *TOTP) Validate(ctx context.Context, secret, code string) error {
+	err := t.validate(ctx, secret, code)
+	recordVerify(ctx, t.recorder, auth.MFAMethodTOTP, "", err)
+	return err
+}
+
+func (t *TOTP) validate(ctx context.Context, secret, code string) error {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return err
+	}
+	if len(code) != t.digits {
+		return ErrInvalidCode
+	}
+
+	current := t.step(now())
+	matched := int64(-1)
+	for i := -t.skew; i <= t.skew; i++ {
+		expected, err := t.hotp(key, current+int64(i))
+		if err != nil {
+			return err
+		}
+		// loop isn't stopped on match so timing doesn't reveal which step matched
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			matched = current + int64(i)
+		}
+	}
+	if matched < 0 {
+		return ErrInvalidCode
+	}
+
+	if t.store != nil {
+		// code can't be accepted after its step has left the skew window
+		expiresAt := time.Unix((matched+int64(t.skew)+1)*t.periodSeconds(), 0)
+		unused, err := t.store.MarkUsed(ctx, secretID(key), matched, expiresAt)
+		if err != nil {
+			return fmt.Errorf("marking code used failed: %w", err)
+		}
+		if !unused {
+			return ErrCodeReused
+		}
+	}
+	return nil
+}
 
-	// Signing the value using HMAC-SHA1 Algorithm
-	hash := hmac.New(sha1.New, key)
-	hash.Write(bs)
-	h := hash.Sum(nil)
+func (t *TOTP) step(at time.Time) int64 {
+	return at.Unix() / t.periodSeconds()
+}
 
-	// We're going to use a subset of the generated hash.
-	// Using the last nibble (half-byte) to choose the index to start from.
-	// This number is always appropriate as it's maximum decimal 15, the hash will
-	// have the maximum index 19 (20 bytes of SHA1) and we need 4 bytes.
-	o := h[19] & 15
+func (t *TOTP) periodSeconds() int64 {
+	return max(int64(t.period/time.Second), 1)
+}
 
-	var header uint32
-	// Get 32 bit chunk from hash starting at the o
-	r := bytes.NewReader(h[o : o+4])
-	err = binary.Read(r, binary.BigEndian, &header)
+// hotp computes RFC 4226 code for counter.
+func (t *TOTP) hotp(key []byte, counter int64) (string, error) {
+	newHash, err := t.hash()
 	if err != nil {
 		return "", err
 	}
-	// Ignore most significant bits as per RFC 4226.
-	// Takes division from one million to generate a remainder less than < 7 digits
-	h12 := (int(header) & 0x7fffffff) % 1000000
+	mac := hmac.New(newHash, key)
+	_ = binary.Write(mac, binary.BigEndian, uint64(counter))
+	h := mac.Sum(nil)
 
-	// Converts number as a string
-	otp := strconv.Itoa(h12)
+	// dynamic truncation, last nibble selects offset of 31 bit value
+	o := h[len(h)-1] & 0xf
+	value := binary.BigEndian.Uint32(h[o:o+4]) & 0x7fffffff
 
-	return prefix0(otp), nil
+	if t.digits < 1 || t.digits > 10 {
+		return "", fmt.Errorf("%w: %d", ErrInvalidDigits, t.digits)
+	}
+	mod := uint64(1)
+	for range t.digits {
+		mod *= 10
+	}
+	code := strconv.FormatUint(uint64(value)%mod, 10)
+	return strings.Repeat("0", t.digits-len(code)) + code, nil
+}
+
+func (t *TOTP) hash() (func() hash.Hash, error) {
+	switch t.algorithm {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidAlgorithm, t.algorithm)
+	}
+}
+
+// GenerateSecret returns new random base32 encoded secret which length matches the HMAC algorithm as RFC 6238 recommends.
+func (t *TOTP) GenerateSecret() (string, error) {
+	size := 20
+	switch t.algorithm {
+	case SHA1:
+	case SHA256:
+		size = 32
+	case SHA512:
+		size = 64
+	default:
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidAlgorithm, t.algorithm)
+	}
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating secret failed: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// URI returns otpauth:// key URI used for enrolling the secret to authenticator apps.
+func (t *TOTP) URI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", string(t.algorithm))
+	q.Set("digits", strconv.Itoa(t.digits))
+	q.Set("period", strconv.FormatInt(t.periodSeconds(), 10))
+	return "otpauth://totp/" + url.PathEscape(issuer) + ":" + url.PathEscape(account) + "?" + q.Encode()
+}
+
+// QRCode returns PNG image of the otpauth:// URI for scanning it with authenticator app.
+func (t *TOTP) QRCode(issuer, account, secret string) ([]byte, error) {
+	return QRCodePNG(t.URI(issuer, account, secret), 8)
+}
+
+// decodeSecret decodes base32 secret, padding, spaces and lower case letters are accepted.
+func decodeSecret(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSecret, err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: secret is empty", ErrInvalidSecret)
+	}
+	return key, nil
 }
 
-func getTOTPToken(secret string) (string, error) {
-	// The TOTP token is just a HOTP token seeded with every 30 seconds.
-	interval := now().Unix() / 30
-	return getHOTPToken(secret, interval)
+func secretID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
 }
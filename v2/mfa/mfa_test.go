@@ -1,9 +1,18 @@
 package mfa
 
 import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"image/png"
+	"net/url"
 	"testing"
 	"time"
 
+	"github.com/elisasre/go-common/v2"
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/auth/middleware"
 	"github.com/stretchr/testify/require"
 )
 
@@ -18,4 +27,129 @@ func TestValidate(t *testing.T) {
 
 	err := Validate(secret, code)
 	require.NoError(t, err)
+
+	// package level Validate accepts only the current period
+	for _, offset := range []time.Duration{-30 * time.Second, 30 * time.Second} {
+		code, err := New().Generate(secret, now().Add(offset))
+		require.NoError(t, err)
+		require.ErrorIs(t, Validate(secret, code), ErrInvalidCode, "offset %s", offset)
+	}
+}
+
+func TestRFC6238Vectors(t *testing.T) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	secrets := map[Algorithm]string{
+		SHA1:   enc.EncodeToString([]byte("12345678901234567890")),
+		SHA256: enc.EncodeToString([]byte("12345678901234567890123456789012")),
+		SHA512: enc.EncodeToString([]byte("1234567890123456789012345678901234567890123456789012345678901234")),
+	}
+	vectors := []struct {
+		unix  int64
+		codes map[Algorithm]string
+	}{
+		{59, map[Algorithm]string{SHA1: "94287082", SHA256: "46119246", SHA512: "90693936"}},
+		{1111111109, map[Algorithm]string{SHA1: "07081804", SHA256: "68084774", SHA512: "25091201"}},
+		{1234567890, map[Algorithm]string{SHA1: "89005924", SHA256: "91819424", SHA512: "93441116"}},
+		{2000000000, map[Algorithm]string{SHA1: "69279037", SHA256: "90698825", SHA512: "38618901"}},
+	}
+	for _, v := range vectors {
+		for alg, want := range v.codes {
+			code, err := New(WithDigits(8), WithAlgorithm(alg)).Generate(secrets[alg], time.Unix(v.unix, 0))
+			require.NoError(t, err)
+			require.Equal(t, want, code, "%s at %d", alg, v.unix)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	const secret = "QT7TBTDOLMKLRYIHV7U4JQMDSY77FYXV" //nolint: gosec
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = time.Now })
+	totp := New(WithPeriod(60*time.Second), WithSkew(2))
+
+	for _, offset := range []time.Duration{-2 * time.Minute, -time.Minute, 0, time.Minute, 2 * time.Minute} {
+		code, err := totp.Generate(secret, at.Add(offset))
+		require.NoError(t, err)
+		require.NoError(t, totp.Validate(context.Background(), secret, code), "offset %s", offset)
+	}
+	for _, offset := range []time.Duration{-3 * time.Minute, 3 * time.Minute} {
+		code, err := totp.Generate(secret, at.Add(offset))
+		require.NoError(t, err)
+		require.ErrorIs(t, totp.Validate(context.Background(), secret, code), ErrInvalidCode, "offset %s", offset)
+	}
+
+	require.ErrorIs(t, totp.Validate(context.Background(), secret, "12345"), ErrInvalidCode)
+	require.ErrorIs(t, totp.Validate(context.Background(), "not base32!", "123456"), ErrInvalidSecret)
+	require.ErrorIs(t, New(WithAlgorithm("MD5")).Validate(context.Background(), secret, "123456"), ErrInvalidAlgorithm)
+}
+
+func TestValidateReplay(t *testing.T) {
+	const secret = "QT7TBTDOLMKLRYIHV7U4JQMDSY77FYXV" //nolint: gosec
+	at := time.Now()
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = time.Now })
+	totp := New(WithUsedCodeStore(NewMemoryStore()))
+
+	current, err := totp.Generate(secret, at)
+	require.NoError(t, err)
+	previous, err := totp.Generate(secret, at.Add(-30*time.Second))
+	require.NoError(t, err)
+
+	require.NoError(t, totp.Validate(context.Background(), secret, current))
+	require.ErrorIs(t, totp.Validate(context.Background(), secret, current), ErrCodeReused)
+	// older code is rejected once a newer one has been used
+	require.ErrorIs(t, totp.Validate(context.Background(), secret, previous), ErrCodeReused)
+
+	next, err := totp.Generate(secret, at.Add(30*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, totp.Validate(context.Background(), secret, next))
+}
+
+func TestValidateAudit(t *testing.T) {
+	const secret = "QT7TBTDOLMKLRYIHV7U4JQMDSY77FYXV" //nolint: gosec
+	sink := audit.NewMemorySink()
+	totp := New(WithAuditRecorder(audit.New(sink)))
+	ctx := middleware.WithClaims(context.Background(), &auth.UserJWTClaims{
+		User: &auth.User{Email: common.Ptr("user@example.com")},
+	})
+
+	code, err := totp.Generate(secret, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, totp.Validate(ctx, secret, code))
+	require.ErrorIs(t, totp.Validate(ctx, secret, "000000x"), ErrInvalidCode)
+
+	events := sink.Find(audit.ActionMFAVerify)
+	require.Len(t, events, 2)
+	require.Equal(t, audit.OutcomeSuccess, events[0].Outcome)
+	require.Equal(t, "email=user@example.com", events[0].Actor)
+	require.Equal(t, map[string]string{"method": auth.MFAMethodTOTP}, events[0].Metadata)
+	require.Equal(t, audit.OutcomeFailure, events[1].Outcome)
+	require.Equal(t, ErrInvalidCode.Error(), events[1].Reason)
+}
+
+func TestEnrollment(t *testing.T) {
+	totp := New(WithAlgorithm(SHA256), WithDigits(8), WithPeriod(60*time.Second))
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	key, err := decodeSecret(secret)
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+
+	uri, err := url.Parse(totp.URI("Example Corp", "alice@example.com", secret))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, "totp", uri.Host)
+	require.Equal(t, "/Example Corp:alice@example.com", uri.Path)
+	q := uri.Query()
+	require.Equal(t, secret, q.Get("secret"))
+	require.Equal(t, "Example Corp", q.Get("issuer"))
+	require.Equal(t, "SHA256", q.Get("algorithm"))
+	require.Equal(t, "8", q.Get("digits"))
+	require.Equal(t, "60", q.Get("period"))
+
+	img, err := totp.QRCode("Example Corp", "alice@example.com", secret)
+	require.NoError(t, err)
+	_, err = png.Decode(bytes.NewReader(img))
+	require.NoError(t, err)
 }
@@ -0,0 +1,76 @@
+package mfatest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/mfa"
+	"github.com/stretchr/testify/require"
+)
+
+func RunCredentialSuite(t *testing.T, store mfa.CredentialStore) {
+	ctx := context.Background()
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	cred := mfa.Credential{
+		ID:              []byte("credential-1"),
+		UserID:          []byte("user-1"),
+		PublicKey:       []byte{0xa5, 0x01, 0x02},
+		SignCount:       1,
+		AAGUID:          make([]byte, 16),
+		Transports:      []string{"internal", "hybrid"},
+		AttestationType: mfa.AttestationNone,
+		BackupEligible:  true,
+		CreatedAt:       createdAt,
+	}
+
+	_, err := store.Credential(ctx, cred.ID)
+	require.ErrorIs(t, err, mfa.ErrCredentialNotFound)
+	require.NoError(t, store.SaveCredential(ctx, cred))
+	require.ErrorIs(t, store.SaveCredential(ctx, cred), mfa.ErrCredentialExists)
+
+	got, err := store.Credential(ctx, cred.ID)
+	require.NoError(t, err)
+	require.Equal(t, cred.UserID, got.UserID)
+	require.Equal(t, cred.PublicKey, got.PublicKey)
+	require.Equal(t, cred.Transports, got.Transports)
+	require.Equal(t, cred.SignCount, got.SignCount)
+	require.True(t, got.BackupEligible)
+	require.True(t, cred.CreatedAt.Equal(got.CreatedAt))
+	require.Nil(t, got.LastUsedAt)
+
+	second := cred
+	second.ID = []byte("credential-2")
+	second.CreatedAt = createdAt.Add(time.Second)
+	require.NoError(t, store.SaveCredential(ctx, second))
+	other := cred
+	other.ID = []byte("credential-3")
+	other.UserID = []byte("user-2")
+	require.NoError(t, store.SaveCredential(ctx, other))
+
+	creds, err := store.UserCredentials(ctx, cred.UserID)
+	require.NoError(t, err)
+	require.Len(t, creds, 2)
+	require.Equal(t, cred.ID, creds[0].ID)
+	require.Equal(t, second.ID, creds[1].ID)
+
+	// counter must increase
+	usedAt := createdAt.Add(time.Minute)
+	require.NoError(t, store.UseCredential(ctx, cred.ID, 2, true, usedAt))
+	require.ErrorIs(t, store.UseCredential(ctx, cred.ID, 2, true, usedAt), mfa.ErrCounterRollback)
+	require.ErrorIs(t, store.UseCredential(ctx, []byte("unknown"), 1, false, usedAt), mfa.ErrCredentialNotFound)
+	got, err = store.Credential(ctx, cred.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), got.SignCount)
+	require.True(t, got.BackupState)
+	require.NotNil(t, got.LastUsedAt)
+	require.True(t, usedAt.Equal(*got.LastUsedAt))
+
+	require.NoError(t, store.DeleteCredential(ctx, cred.ID))
+	require.NoError(t, store.DeleteCredential(ctx, cred.ID), "deleting twice is allowed")
+	_, err = store.Credential(ctx, cred.ID)
+	require.ErrorIs(t, err, mfa.ErrCredentialNotFound)
+	creds, err = store.UserCredentials(ctx, cred.UserID)
+	require.NoError(t, err)
+	require.Len(t, creds, 1)
+}
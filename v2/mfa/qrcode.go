@@ -0,0 +1,22 @@
+package mfa
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/skip2/go-qrcode"
+)
+
+var ErrQRCodeTooLong = errors.New("content is too long for QR code")
+
+// QRCodePNG encodes content as QR code with error correction level M and returns it as PNG image
+// where each module is moduleSize pixels.
+func QRCodePNG(content string, moduleSize int) ([]byte, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		// content length is the only reason for encoding to fail
+		return nil, fmt.Errorf("%w: %w", ErrQRCodeTooLong, err)
+	}
+	// negative size is the size of a single module in pixels
+	return qr.PNG(-max(moduleSize, 1))
+}
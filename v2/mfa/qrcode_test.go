@@ -0,0 +1,31 @@
+package mfa_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/elisasre/go-common/v2/mfa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQRCodePNG(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		modules int
+	}{
+		{"hello", 21},
+		{"otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example", 37},
+	} {
+		data, err := mfa.QRCodePNG(tc.content, 4)
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		// symbol with 4 module quiet zone on both sides
+		require.Equal(t, (tc.modules+8)*4, img.Bounds().Dx(), tc.content)
+	}
+
+	_, err := mfa.QRCodePNG(strings.Repeat("a", 3000), 4)
+	require.ErrorIs(t, err, mfa.ErrQRCodeTooLong)
+}
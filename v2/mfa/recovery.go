@@ -0,0 +1,61 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// recoveryCodeBytes gives 80 bits of entropy, enough for codes hashed with unsalted SHA-256.
+const recoveryCodeBytes = 10
+
+// GenerateRecoveryCodes returns n recovery codes to show to the user once and their hashes to store.
+// Codes are formatted as xxxx-xxxx-xxxx-xxxx.
+func GenerateRecoveryCodes(n int) (codes, hashes []string, err error) {
+	codes = make([]string, 0, n)
+	hashes = make([]string, 0, n)
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for range n {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("generating recovery code failed: %w", err)
+		}
+		raw := strings.ToLower(enc.EncodeToString(b))
+		parts := make([]string, 0, len(raw)/4)
+		for i := 0; i < len(raw); i += 4 {
+			parts = append(parts, raw[i:i+4])
+		}
+		code := strings.Join(parts, "-")
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns hash of the normalized code, separators and letter case are ignored.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// UseRecoveryCode checks code against stored hashes in constant time.
+// When code is valid the remaining hashes, which must replace the stored ones, are returned along with true.
+func UseRecoveryCode(hashes []string, code string) ([]string, bool) {
+	h := []byte(HashRecoveryCode(code))
+	match := -1
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare(h, []byte(stored)) == 1 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return hashes, false
+	}
+	return slices.Delete(slices.Clone(hashes), match, match+1), true
+}
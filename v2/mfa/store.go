@@ -0,0 +1,159 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCredentialNotFound = errors.New("credential not found")
+	ErrCredentialExists   = errors.New("credential already exists")
+)
+
+// UsedCodeStore records used TOTP steps for replay protection.
+type UsedCodeStore interface {
+	// MarkUsed atomically records step as used for key and reports whether it was accepted.
+	// Step must be rejected if the same or a later step has already been used for the key.
+	// Entry is no longer needed after expiresAt.
+	MarkUsed(ctx context.Context, key string, step int64, expiresAt time.Time) (bool, error)
+}
+
+type usedStep struct {
+	step      int64
+	expiresAt time.Time
+}
+
+// Credential is registered WebAuthn public key credential.
+type Credential struct {
+	ID              []byte
+	UserID          []byte
+	PublicKey       []byte // COSE_Key
+	SignCount       uint32
+	AAGUID          []byte
+	Transports      []string
+	AttestationType string
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      *time.Time
+}
+
+// CredentialStore persists WebAuthn credentials.
+type CredentialStore interface {
+	// SaveCredential stores new credential or returns ErrCredentialExists if credential ID is already registered.
+	SaveCredential(ctx context.Context, cred Credential) error
+	// Credential returns credential by ID or ErrCredentialNotFound.
+	Credential(ctx context.Context, id []byte) (Credential, error)
+	// UserCredentials returns all credentials of the user.
+	UserCredentials(ctx context.Context, userID []byte) ([]Credential, error)
+	// UseCredential atomically records successful assertion. ErrCounterRollback must be returned
+	// if signCount is non-zero and not greater than stored counter.
+	UseCredential(ctx context.Context, id []byte, signCount uint32, backupState bool, usedAt time.Time) error
+	// DeleteCredential removes credential, deleting unknown credential is not an error.
+	DeleteCredential(ctx context.Context, id []byte) error
+}
+
+// MemoryStore is UsedCodeStore and CredentialStore for single instance deployments and tests.
+type MemoryStore struct {
+	mu          sync.Mutex
+	steps       map[string]usedStep
+	credentials map[string]Credential
+}
+
+// NewMemoryStore creates empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		steps:       map[string]usedStep{},
+		credentials: map[string]Credential{},
+	}
+}
+
+func (m *MemoryStore) MarkUsed(_ context.Context, key string, step int64, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := time.Now()
+	for k, s := range m.steps {
+		if s.expiresAt.Before(current) {
+			delete(m.steps, k)
+		}
+	}
+
+	if s, ok := m.steps[key]; ok && s.step >= step {
+		return false, nil
+	}
+	m.steps[key] = usedStep{step: step, expiresAt: expiresAt}
+	return true, nil
+}
+
+func (m *MemoryStore) SaveCredential(_ context.Context, cred Credential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.credentials[string(cred.ID)]; ok {
+		return ErrCredentialExists
+	}
+	m.credentials[string(cred.ID)] = cloneCredential(cred)
+	return nil
+}
+
+func (m *MemoryStore) Credential(_ context.Context, id []byte) (Credential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cred, ok := m.credentials[string(id)]
+	if !ok {
+		return Credential{}, ErrCredentialNotFound
+	}
+	return cloneCredential(cred), nil
+}
+
+func (m *MemoryStore) UserCredentials(_ context.Context, userID []byte) ([]Credential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	creds := []Credential{}
+	for _, cred := range m.credentials {
+		if string(cred.UserID) == string(userID) {
+			creds = append(creds, cloneCredential(cred))
+		}
+	}
+	slices.SortFunc(creds, func(a, b Credential) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return creds, nil
+}
+
+func (m *MemoryStore) UseCredential(_ context.Context, id []byte, signCount uint32, backupState bool, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cred, ok := m.credentials[string(id)]
+	if !ok {
+		return ErrCredentialNotFound
+	}
+	if signCount != 0 && signCount <= cred.SignCount {
+		return ErrCounterRollback
+	}
+	cred.SignCount = signCount
+	cred.BackupState = backupState
+	cred.LastUsedAt = &usedAt
+	m.credentials[string(id)] = cred
+	return nil
+}
+
+func (m *MemoryStore) DeleteCredential(_ context.Context, id []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.credentials, string(id))
+	return nil
+}
+
+func cloneCredential(c Credential) Credential {
+	c.ID = slices.Clone(c.ID)
+	c.UserID = slices.Clone(c.UserID)
+	c.PublicKey = slices.Clone(c.PublicKey)
+	c.AAGUID = slices.Clone(c.AAGUID)
+	c.Transports = slices.Clone(c.Transports)
+	if c.LastUsedAt != nil {
+		usedAt := *c.LastUsedAt
+		c.LastUsedAt = &usedAt
+	}
+	return c
+}
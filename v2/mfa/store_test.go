@@ -0,0 +1,12 @@
+package mfa_test
+
+import (
+	"testing"
+
+	"github.com/elisasre/go-common/v2/mfa"
+	"github.com/elisasre/go-common/v2/mfa/mfatest"
+)
+
+func TestMemoryCredentialStore(t *testing.T) {
+	mfatest.RunCredentialSuite(t, mfa.NewMemoryStore())
+}
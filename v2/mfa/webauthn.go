@@ -0,0 +1,522 @@
+package mfa
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/elisasre/go-common/v2/auth"
+)
+
+var (
+	ErrSessionExpired       = errors.New("webauthn session has expired")
+	ErrSessionUsed          = errors.New("webauthn session has already been used")
+	ErrInvalidClientData    = errors.New("invalid client data")
+	ErrChallengeMismatch    = errors.New("challenge does not match")
+	ErrInvalidOrigin        = errors.New("origin is not allowed")
+	ErrInvalidRPID          = errors.New("relying party id does not match")
+	ErrUserNotPresent       = errors.New("user presence is required")
+	ErrUserNotVerified      = errors.New("user verification is required")
+	ErrCredentialNotAllowed = errors.New("credential is not allowed")
+	ErrCounterRollback      = errors.New("signature counter did not increase, authenticator may be cloned")
+	ErrMissingCredStore     = errors.New("missing credential store")
+)
+
+// UserVerification is the WebAuthn user verification requirement.
+type UserVerification string
+
+const (
+	UserVerificationRequired    UserVerification = "required"
+	UserVerificationPreferred   UserVerification = "preferred"
+	UserVerificationDiscouraged UserVerification = "discouraged"
+)
+
+const (
+	credentialType    = "public-key"
+	clientDataCreate  = "webauthn.create"
+	clientDataGet     = "webauthn.get"
+	challengeSize     = 32
+	defaultWebTimeout = 5 * time.Minute
+)
+
+// WebAuthn implements relying party side of WebAuthn registration and authentication ceremonies.
+// Passkeys and security keys registered with it can be used as second factor alongside TOTP.
+type WebAuthn struct {
+	rpID             string
+	rpName           string
+	origins          []string
+	store            CredentialStore
+	usedSessions     UsedCodeStore
+	timeout          time.Duration
+	userVerification UserVerification
+	algorithms       []COSEAlgorithm
+	roots            *x509.CertPool
+	recorder         audit.Recorder
+}
+
+type WebAuthnOpt func(*WebAuthn)
+
+// WithOrigins sets origins allowed in client data, defaults to https://<rpID>.
+func WithOrigins(origins ...string) WebAuthnOpt {
+	return func(w *WebAuthn) {
+		w.origins = origins
+	}
+}
+
+// WithTimeout sets how long user has time to complete ceremony, defaults to 5 minutes.
+func WithTimeout(d time.Duration) WebAuthnOpt {
+	return func(w *WebAuthn) {
+		w.timeout = d
+	}
+}
+
+// WithUserVerification sets user verification requirement, defaults to preferred.
+// When required, assertions without user verification flag are rejected.
+func WithUserVerification(uv UserVerification) WebAuthnOpt {
+	return func(w *WebAuthn) {
+		w.userVerification = uv
+	}
+}
+
+// WithAlgorithms sets accepted credential algorithms in order of preference, defaults to DefaultAlgorithms.
+func WithAlgorithms(algs ...COSEAlgorithm) WebAuthnOpt {
+	return func(w *WebAuthn) {
+		w.algorithms = algs
+	}
+}
+
+// WithAttestationRoots requests direct attestation and accepts only authenticators which
+// attestation certificate chains to one of the roots. By default any authenticator is accepted.
+func WithAttestationRoots(roots *x509.CertPool) WebAuthnOpt {
+	return func(w *WebAuthn) {
+		w.roots = roots
+	}
+}
+
+// WithUsedSessionStore sets store which records finished sessions so that every session can be used only once.
+// Defaults to the credential store if it implements UsedCodeStore, otherwise to in-memory store which
+// protects only single instance. Deployments with multiple instances should use shared store.
+func WithUsedSessionStore(store UsedCodeStore) WebAuthnOpt {
+	return func(w *WebAuthn) {
+		w.usedSessions = store
+	}
+}
+
+// NewWebAuthn creates WebAuthn relying party. The rpID is the effective domain of the site,
+// e.g. example.com, and rpName is shown to users by authenticators.
+func NewWebAuthn(rpID, rpName string, store CredentialStore, opts ...WebAuthnOpt) (*WebAuthn, error) {
+	if store == nil {
+		return nil, ErrMissingCredStore
+	}
+	w := &WebAuthn{
+		rpID:             rpID,
+		rpName:           rpName,
+		origins:          []string{"https://" + rpID},
+		store:            store,
+		timeout:          defaultWebTimeout,
+		userVerification: UserVerificationPreferred,
+		algorithms:       DefaultAlgorithms,
+	}
+	if used, ok := store.(UsedCodeStore); ok {
+		w.usedSessions = used
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.usedSessions == nil {
+		w.usedSessions = NewMemoryStore()
+	}
+	return w, nil
+}
+
+// Base64URL is byte slice encoded as unpadded base64url in JSON as WebAuthn JSON serialization expects.
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// RelyingParty identifies the site to authenticators.
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity identifies user account. ID must be stable and must not contain personal information.
+type UserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+// CredentialParameter is accepted credential type and algorithm.
+type CredentialParameter struct {
+	Type string        `json:"type"`
+	Alg  COSEAlgorithm `json:"alg"`
+}
+
+// CredentialDescriptor identifies existing credential.
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection contains requirements for authenticators used in registration.
+type AuthenticatorSelection struct {
+	ResidentKey      string           `json:"residentKey"`
+	UserVerification UserVerification `json:"userVerification"`
+}
+
+// CreationOptions is passed to navigator.credentials.create() in the browser,
+// PublicKeyCredential.parseCreationOptionsFromJSON() accepts it as is.
+type CreationOptions struct {
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is passed to navigator.credentials.get() in the browser,
+// PublicKeyCredential.parseRequestOptionsFromJSON() accepts it as is.
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification UserVerification       `json:"userVerification"`
+}
+
+// AttestationResponse is the authenticator response of registration ceremony.
+type AttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+	Transports        []string  `json:"transports,omitempty"`
+}
+
+// RegistrationResponse is JSON serialized PublicKeyCredential returned by navigator.credentials.create().
+type RegistrationResponse struct {
+	ID       string              `json:"id"`
+	RawID    Base64URL           `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+// AssertionResponse is the authenticator response of authentication ceremony.
+type AssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle,omitempty"`
+}
+
+// AuthenticationResponse is JSON serialized PublicKeyCredential returned by navigator.credentials.get().
+type AuthenticationResponse struct {
+	ID       string            `json:"id"`
+	RawID    Base64URL         `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// WebAuthnSession is ceremony state which must be kept on server side, e.g. in user session,
+// between begin and finish calls. Every session can be used only once.
+type WebAuthnSession struct {
+	Challenge          Base64URL   `json:"challenge"`
+	UserID             Base64URL   `json:"user_id,omitempty"`
+	AllowedCredentials []Base64URL `json:"allowed_credentials,omitempty"`
+	ExpiresAt          time.Time   `json:"expires_at"`
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// BeginRegistration starts registering new credential for user.
+// Credentials already registered for the user are excluded so same authenticator isn't registered twice.
+func (w *WebAuthn) BeginRegistration(ctx context.Context, user UserEntity) (*CreationOptions, *WebAuthnSession, error) {
+	existing, err := w.store.UserCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing user credentials failed: %w", err)
+	}
+	session, err := w.newSession(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := &CreationOptions{
+		RP:                 RelyingParty{ID: w.rpID, Name: w.rpName},
+		User:               user,
+		Challenge:          session.Challenge,
+		Timeout:            w.timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: w.userVerification,
+		},
+		Attestation: "none",
+	}
+	if w.roots != nil {
+		opts.Attestation = "direct"
+	}
+	for _, alg := range w.algorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameter{Type: credentialType, Alg: alg})
+	}
+	return opts, session, nil
+}
+
+// FinishRegistration verifies registration response and saves the new credential.
+func (w *WebAuthn) FinishRegistration(ctx context.Context, session *WebAuthnSession, resp *RegistrationResponse) (*Credential, error) {
+	if err := w.useSession(ctx, session); err != nil {
+		return nil, err
+	}
+	if err := w.verifyClientData(session, resp.Response.ClientDataJSON, clientDataCreate); err != nil {
+		return nil, err
+	}
+
+	var obj attestationObject
+	if err := cborDec.Unmarshal(resp.Response.AttestationObject, &obj); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+	ad, err := w.verifyAuthData(obj.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.flags&flagAttestedData == 0 {
+		return nil, fmt.Errorf("%w: missing attested credential data", ErrInvalidAuthData)
+	}
+	if !bytes.Equal(ad.credentialID, resp.RawID) {
+		return nil, fmt.Errorf("%w: credential id does not match", ErrInvalidAuthData)
+	}
+	key, err := parseCOSEKey(ad.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(w.algorithms, key.alg) {
+		return nil, fmt.Errorf("%w: algorithm %d is not allowed", ErrUnsupportedKey, key.alg)
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	attType, err := w.verifyAttestation(&obj, ad, clientDataHash[:], key)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := Credential{
+		ID:              slices.Clone(ad.credentialID),
+		UserID:          slices.Clone(session.UserID),
+		PublicKey:       slices.Clone(ad.publicKey),
+		SignCount:       ad.signCount,
+		AAGUID:          slices.Clone(ad.aaguid),
+		Transports:      resp.Response.Transports,
+		AttestationType: attType,
+		BackupEligible:  ad.flags&flagBackupEligible != 0,
+		BackupState:     ad.flags&flagBackupState != 0,
+		CreatedAt:       now().UTC().Truncate(time.Microsecond),
+	}
+	if err := w.store.SaveCredential(ctx, cred); err != nil {
+		return nil, fmt.Errorf("saving credential failed: %w", err)
+	}
+	return &cred, nil
+}
+
+// BeginLogin starts authentication ceremony. When userID is nil any discoverable credential (passkey)
+// is accepted and user is identified by the credential, otherwise only credentials of the user are allowed.
+func (w *WebAuthn) BeginLogin(ctx context.Context, userID []byte) (*RequestOptions, *WebAuthnSession, error) {
+	session, err := w.newSession(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &RequestOptions{
+		Challenge:        session.Challenge,
+		Timeout:          w.timeout.Milliseconds(),
+		RPID:             w.rpID,
+		UserVerification: w.userVerification,
+	}
+	if userID != nil {
+		creds, err := w.store.UserCredentials(ctx, userID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("listing user credentials failed: %w", err)
+		}
+		if len(creds) == 0 {
+			return nil, nil, ErrCredentialNotFound
+		}
+		opts.AllowCredentials = descriptors(creds)
+		for _, c := range creds {
+			session.AllowedCredentials = append(session.AllowedCredentials, c.ID)
+		}
+	}
+	return opts, session, nil
+}
+
+// FinishLogin verifies assertion and updates signature counter of the credential.
+// Returned credential identifies the user, tokens issued after it should be marked
+// with auth.User.SetMFA(auth.MFAMethodWebAuthn).
+func (w *WebAuthn) FinishLogin(ctx context.Context, session *WebAuthnSession, resp *AuthenticationResponse) (*Credential, error) {
+	cred, err := w.finishLogin(ctx, session, resp)
+	recordVerify(ctx, w.recorder, auth.MFAMethodWebAuthn, base64.RawURLEncoding.EncodeToString(resp.RawID), err)
+	return cred, err
+}
+
+func (w *WebAuthn) finishLogin(ctx context.Context, session *WebAuthnSession, resp *AuthenticationResponse) (*Credential, error) {
+	if err := w.useSession(ctx, session); err != nil {
+		return nil, err
+	}
+	if len(session.AllowedCredentials) > 0 && !slices.ContainsFunc(session.AllowedCredentials, func(id Base64URL) bool {
+		return bytes.Equal(id, resp.RawID)
+	}) {
+		return nil, ErrCredentialNotAllowed
+	}
+	cred, err := w.store.Credential(ctx, resp.RawID)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != nil && !bytes.Equal(session.UserID, cred.UserID) {
+		return nil, ErrCredentialNotAllowed
+	}
+	// discoverable credentials must return user handle which then must match the owner of the credential
+	userHandle := resp.Response.UserHandle
+	if (session.UserID == nil && len(userHandle) == 0) || (len(userHandle) > 0 && !bytes.Equal(userHandle, cred.UserID)) {
+		return nil, ErrCredentialNotAllowed
+	}
+
+	if err := w.verifyClientData(session, resp.Response.ClientDataJSON, clientDataGet); err != nil {
+		return nil, err
+	}
+	ad, err := w.verifyAuthData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if (ad.flags&flagBackupEligible != 0) != cred.BackupEligible {
+		return nil, fmt.Errorf("%w: backup eligibility changed", ErrInvalidAuthData)
+	}
+
+	key, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := slices.Concat([]byte(resp.Response.AuthenticatorData), clientDataHash[:])
+	if err := key.verify(signed, resp.Response.Signature); err != nil {
+		return nil, err
+	}
+
+	// authenticators which don't implement counter always return zero
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return nil, ErrCounterRollback
+	}
+	usedAt := now().UTC().Truncate(time.Microsecond)
+	backupState := ad.flags&flagBackupState != 0
+	if err := w.store.UseCredential(ctx, cred.ID, ad.signCount, backupState, usedAt); err != nil {
+		return nil, err
+	}
+	cred.SignCount = ad.signCount
+	cred.BackupState = backupState
+	cred.LastUsedAt = &usedAt
+	return &cred, nil
+}
+
+func (w *WebAuthn) newSession(userID []byte) (*WebAuthnSession, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("generating challenge failed: %w", err)
+	}
+	return &WebAuthnSession{
+		Challenge: challenge,
+		UserID:    userID,
+		ExpiresAt: now().Add(w.timeout),
+	}, nil
+}
+
+// useSession marks session used before the response is verified, so failed attempts consume the session too.
+func (w *WebAuthn) useSession(ctx context.Context, session *WebAuthnSession) error {
+	if now().After(session.ExpiresAt) {
+		return ErrSessionExpired
+	}
+	key := "webauthn:" + base64.RawURLEncoding.EncodeToString(session.Challenge)
+	unused, err := w.usedSessions.MarkUsed(ctx, key, 1, session.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("marking session used failed: %w", err)
+	}
+	if !unused {
+		return ErrSessionUsed
+	}
+	return nil
+}
+
+func (w *WebAuthn) verifyClientData(session *WebAuthnSession, raw []byte, typ string) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidClientData, err)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("%w: unexpected type '%s'", ErrInvalidClientData, cd.Type)
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(challenge, session.Challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+	if cd.CrossOrigin || !slices.Contains(w.origins, cd.Origin) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidOrigin, cd.Origin)
+	}
+	return nil
+}
+
+func (w *WebAuthn) verifyAuthData(data []byte) (*authData, error) {
+	ad, err := parseAuthData(data)
+	if err != nil {
+		return nil, err
+	}
+	rpIDHash := sha256.Sum256([]byte(w.rpID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return nil, ErrInvalidRPID
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+	if w.userVerification == UserVerificationRequired && ad.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+	if ad.flags&flagBackupState != 0 && ad.flags&flagBackupEligible == 0 {
+		return nil, fmt.Errorf("%w: backup state set without backup eligibility", ErrInvalidAuthData)
+	}
+	return ad, nil
+}
+
+func descriptors(creds []Credential) []CredentialDescriptor {
+	out := make([]CredentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		out = append(out, CredentialDescriptor{Type: credentialType, ID: c.ID, Transports: c.Transports})
+	}
+	return out
+}
@@ -0,0 +1,562 @@
+package mfa
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/audit"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// authenticator is software authenticator implementing the client side of ceremonies.
+type authenticator struct {
+	alg    COSEAlgorithm
+	signer crypto.Signer
+	id     []byte
+	aaguid []byte
+	count  uint32
+	flags  byte
+}
+
+func newAuthenticator(t *testing.T, alg COSEAlgorithm) *authenticator {
+	t.Helper()
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	require.NoError(t, err)
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	require.NoError(t, err)
+	return &authenticator{
+		alg:    alg,
+		signer: signer,
+		id:     id,
+		aaguid: make([]byte, 16),
+		flags:  flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *authenticator) coseKey(t *testing.T) []byte {
+	t.Helper()
+	var m map[int]any
+	switch key := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		point, err := key.Bytes()
+		require.NoError(t, err)
+		m = map[int]any{coseKty: coseKtyEC2, coseAlg: a.alg, coseCrv: coseP256, coseX: point[1:33], coseY: point[33:]}
+	case ed25519.PublicKey:
+		m = map[int]any{coseKty: coseKtyOKP, coseAlg: a.alg, coseCrv: coseEd25519, coseX: []byte(key)}
+	case *rsa.PublicKey:
+		m = map[int]any{coseKty: coseKtyRSA, coseAlg: a.alg, coseRSAN: key.N.Bytes(), coseRSAE: big.NewInt(int64(key.E)).Bytes()}
+	}
+	data, err := cbor.Marshal(m)
+	require.NoError(t, err)
+	return data
+}
+
+func (a *authenticator) authData(t *testing.T, rpID string, attested bool) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedData
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	if attested {
+		data = append(data, a.aaguid...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id))) //nolint:gosec // G115: test id is short
+		data = append(data, a.id...)
+		data = append(data, a.coseKey(t)...)
+	}
+	return data
+}
+
+func (a *authenticator) sign(t *testing.T, data []byte) []byte {
+	t.Helper()
+	return signWith(t, a.signer, data)
+}
+
+func signWith(t *testing.T, signer crypto.Signer, data []byte) []byte {
+	t.Helper()
+	var sig []byte
+	var err error
+	switch signer.(type) {
+	case ed25519.PrivateKey:
+		sig, err = signer.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		sum := sha256.Sum256(data)
+		sig, err = signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	require.NoError(t, err)
+	return sig
+}
+
+func clientDataJSON(t *testing.T, typ string, challenge []byte, origin string) []byte {
+	t.Helper()
+	data, err := json.Marshal(clientData{
+		Type:      typ,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    origin,
+	})
+	require.NoError(t, err)
+	return data
+}
+
+type registration struct {
+	rpID      string
+	origin    string
+	typ       string
+	challenge []byte
+	format    string
+	statement func(authData, clientDataHash []byte) map[string]any
+}
+
+func (a *authenticator) register(t *testing.T, opts *CreationOptions, r registration) *RegistrationResponse {
+	t.Helper()
+	r.rpID = valueOr(r.rpID, opts.RP.ID)
+	r.origin = valueOr(r.origin, testOrigin)
+	r.typ = valueOr(r.typ, clientDataCreate)
+	r.format = valueOr(r.format, "none")
+	if r.challenge == nil {
+		r.challenge = opts.Challenge
+	}
+
+	cd := clientDataJSON(t, r.typ, r.challenge, r.origin)
+	ad := a.authData(t, r.rpID, true)
+	stmt := map[string]any{}
+	if r.statement != nil {
+		cdHash := sha256.Sum256(cd)
+		stmt = r.statement(ad, cdHash[:])
+	}
+	obj, err := cbor.Marshal(map[string]any{"fmt": r.format, "attStmt": stmt, "authData": ad})
+	require.NoError(t, err)
+	return &RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.id),
+		RawID: a.id,
+		Type:  credentialType,
+		Response: AttestationResponse{
+			ClientDataJSON:    cd,
+			AttestationObject: obj,
+			Transports:        []string{"internal"},
+		},
+	}
+}
+
+func (a *authenticator) login(t *testing.T, opts *RequestOptions, userHandle []byte) *AuthenticationResponse {
+	t.Helper()
+	a.count++
+	cd := clientDataJSON(t, clientDataGet, opts.Challenge, testOrigin)
+	ad := a.authData(t, opts.RPID, false)
+	cdHash := sha256.Sum256(cd)
+	return &AuthenticationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.id),
+		RawID: a.id,
+		Type:  credentialType,
+		Response: AssertionResponse{
+			ClientDataJSON:    cd,
+			AuthenticatorData: ad,
+			Signature:         a.sign(t, slices.Concat(ad, cdHash[:])),
+			UserHandle:        userHandle,
+		},
+	}
+}
+
+func valueOr(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+func newTestWebAuthn(t *testing.T, opts ...WebAuthnOpt) *WebAuthn {
+	t.Helper()
+	w, err := NewWebAuthn(testRPID, "Example", NewMemoryStore(), opts...)
+	require.NoError(t, err)
+	return w
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	ctx := context.Background()
+	user := UserEntity{ID: []byte("user-1"), Name: "alice@example.com", DisplayName: "Alice"}
+
+	for _, alg := range []COSEAlgorithm{AlgES256, AlgEdDSA, AlgRS256} {
+		sink := audit.NewMemorySink()
+		w := newTestWebAuthn(t, WithWebAuthnAuditRecorder(audit.New(sink)))
+		a := newAuthenticator(t, alg)
+
+		opts, session, err := w.BeginRegistration(ctx, user)
+		require.NoError(t, err)
+		require.Equal(t, testRPID, opts.RP.ID)
+		require.Len(t, opts.PubKeyCredParams, len(DefaultAlgorithms))
+		cred, err := w.FinishRegistration(ctx, session, a.register(t, opts, registration{}))
+		require.NoError(t, err, "algorithm %d", alg)
+		require.Equal(t, user.ID, Base64URL(cred.UserID))
+		require.Equal(t, AttestationNone, cred.AttestationType)
+
+		// registered credential is excluded from new registrations
+		opts, _, err = w.BeginRegistration(ctx, user)
+		require.NoError(t, err)
+		require.Len(t, opts.ExcludeCredentials, 1)
+		require.Equal(t, a.id, []byte(opts.ExcludeCredentials[0].ID))
+
+		// login with known user
+		reqOpts, session, err := w.BeginLogin(ctx, user.ID)
+		require.NoError(t, err)
+		require.Len(t, reqOpts.AllowCredentials, 1)
+		resp := a.login(t, reqOpts, nil)
+		cred, err = w.FinishLogin(ctx, session, resp)
+		require.NoError(t, err, "algorithm %d", alg)
+		require.Equal(t, uint32(1), cred.SignCount)
+		require.NotNil(t, cred.LastUsedAt)
+
+		_, err = w.FinishLogin(ctx, session, resp)
+		require.ErrorIs(t, err, ErrSessionUsed, "replayed assertion")
+
+		// passkey login without known user
+		for _, tc := range []struct {
+			userHandle []byte
+			err        error
+		}{
+			{nil, ErrCredentialNotAllowed}, // user handle is required
+			{[]byte("user-2"), ErrCredentialNotAllowed},
+			{user.ID, nil},
+		} {
+			reqOpts, session, err = w.BeginLogin(ctx, nil)
+			require.NoError(t, err)
+			require.Empty(t, reqOpts.AllowCredentials)
+			cred, err = w.FinishLogin(ctx, session, a.login(t, reqOpts, tc.userHandle))
+			require.ErrorIs(t, err, tc.err)
+		}
+		require.Equal(t, []byte(user.ID), cred.UserID)
+
+		events := sink.Find(audit.ActionMFAVerify)
+		require.Len(t, events, 5)
+		outcomes := make([]audit.Outcome, 0, len(events))
+		for _, e := range events {
+			require.Equal(t, base64.RawURLEncoding.EncodeToString(a.id), e.Target)
+			outcomes = append(outcomes, e.Outcome)
+		}
+		require.Equal(t, []audit.Outcome{
+			audit.OutcomeSuccess, audit.OutcomeFailure, audit.OutcomeFailure, audit.OutcomeFailure, audit.OutcomeSuccess,
+		}, outcomes)
+	}
+}
+
+func TestWebAuthnLoginErrors(t *testing.T) {
+	ctx := context.Background()
+	user := UserEntity{ID: []byte("user-1"), Name: "alice@example.com"}
+	w := newTestWebAuthn(t, WithUserVerification(UserVerificationRequired))
+	a := newAuthenticator(t, AlgES256)
+	opts, session, err := w.BeginRegistration(ctx, user)
+	require.NoError(t, err)
+	_, err = w.FinishRegistration(ctx, session, a.register(t, opts, registration{}))
+	require.NoError(t, err)
+
+	_, _, err = w.BeginLogin(ctx, []byte("user-2"))
+	require.ErrorIs(t, err, ErrCredentialNotFound)
+
+	// every attempt needs new session
+	begin := func() (*RequestOptions, *WebAuthnSession) {
+		reqOpts, session, err := w.BeginLogin(ctx, user.ID)
+		require.NoError(t, err)
+		return reqOpts, session
+	}
+
+	reqOpts, session := begin()
+	resp := a.login(t, reqOpts, nil)
+	resp.Response.Signature[len(resp.Response.Signature)-1] ^= 1
+	_, err = w.FinishLogin(ctx, session, resp)
+	require.ErrorIs(t, err, ErrInvalidSig)
+
+	a.flags = flagUserPresent
+	reqOpts, session = begin()
+	_, err = w.FinishLogin(ctx, session, a.login(t, reqOpts, nil))
+	require.ErrorIs(t, err, ErrUserNotVerified)
+
+	a.flags = flagUserPresent | flagUserVerified | flagBackupEligible
+	reqOpts, session = begin()
+	_, err = w.FinishLogin(ctx, session, a.login(t, reqOpts, nil))
+	require.ErrorIs(t, err, ErrInvalidAuthData, "backup eligibility can't change")
+
+	a.flags = flagUserPresent | flagUserVerified
+	a.count = 0
+	reqOpts, session = begin()
+	_, err = w.FinishLogin(ctx, session, a.login(t, reqOpts, nil))
+	require.NoError(t, err)
+	a.count = 0
+	reqOpts, session = begin()
+	_, err = w.FinishLogin(ctx, session, a.login(t, reqOpts, nil))
+	require.ErrorIs(t, err, ErrCounterRollback, "counter went back to 1")
+
+	other := newAuthenticator(t, AlgES256)
+	reqOpts, session = begin()
+	_, err = w.FinishLogin(ctx, session, other.login(t, reqOpts, nil))
+	require.ErrorIs(t, err, ErrCredentialNotAllowed)
+
+	// failed attempt uses the session
+	_, err = w.FinishLogin(ctx, session, a.login(t, reqOpts, nil))
+	require.ErrorIs(t, err, ErrSessionUsed)
+
+	reqOpts, session = begin()
+	now = func() time.Time { return time.Now().Add(time.Hour) }
+	t.Cleanup(func() { now = time.Now })
+	_, err = w.FinishLogin(ctx, session, a.login(t, reqOpts, nil))
+	require.ErrorIs(t, err, ErrSessionExpired)
+}
+
+func TestWebAuthnSessionReplay(t *testing.T) {
+	ctx := context.Background()
+	user := UserEntity{ID: []byte("user-1"), Name: "alice@example.com"}
+	// instances share the store like replicas sharing database
+	store := NewMemoryStore()
+	w1, err := NewWebAuthn(testRPID, "Example", store)
+	require.NoError(t, err)
+	w2, err := NewWebAuthn(testRPID, "Example", NewMemoryStore(), WithUsedSessionStore(store))
+	require.NoError(t, err)
+	a := newAuthenticator(t, AlgES256)
+
+	opts, session, err := w1.BeginRegistration(ctx, user)
+	require.NoError(t, err)
+	reg := a.register(t, opts, registration{})
+	_, err = w1.FinishRegistration(ctx, session, reg)
+	require.NoError(t, err)
+	_, err = w2.FinishRegistration(ctx, session, reg)
+	require.ErrorIs(t, err, ErrSessionUsed)
+
+	reqOpts, session, err := w1.BeginLogin(ctx, user.ID)
+	require.NoError(t, err)
+	// session is used even if authenticator counter doesn't protect against replay
+	a.count = 0
+	resp := a.login(t, reqOpts, nil)
+	_, err = w1.FinishLogin(ctx, session, resp)
+	require.NoError(t, err)
+	for _, w := range []*WebAuthn{w1, w2} {
+		a.count = 0
+		_, err = w.FinishLogin(ctx, session, resp)
+		require.ErrorIs(t, err, ErrSessionUsed)
+	}
+}
+
+func TestWebAuthnRegistrationErrors(t *testing.T) {
+	ctx := context.Background()
+	user := UserEntity{ID: []byte("user-1"), Name: "alice@example.com"}
+
+	tests := []struct {
+		name  string
+		flags byte
+		reg   registration
+		err   error
+	}{
+		{name: "wrong origin", reg: registration{origin: "https://evil.example.com"}, err: ErrInvalidOrigin},
+		{name: "wrong challenge", reg: registration{challenge: []byte("other")}, err: ErrChallengeMismatch},
+		{name: "wrong type", reg: registration{typ: clientDataGet}, err: ErrInvalidClientData},
+		{name: "wrong rp id", reg: registration{rpID: "evil.example.com"}, err: ErrInvalidRPID},
+		{name: "user not present", flags: flagUserVerified, err: ErrUserNotPresent},
+		{name: "unsupported format", reg: registration{format: "tpm"}, err: ErrUnsupportedAttestation},
+		{
+			name: "none with statement",
+			reg: registration{statement: func(_, _ []byte) map[string]any {
+				return map[string]any{"alg": AlgES256}
+			}},
+			err: ErrInvalidAttestation,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newTestWebAuthn(t)
+			a := newAuthenticator(t, AlgES256)
+			if tc.flags != 0 {
+				a.flags = tc.flags
+			}
+			opts, session, err := w.BeginRegistration(ctx, user)
+			require.NoError(t, err)
+			_, err = w.FinishRegistration(ctx, session, a.register(t, opts, tc.reg))
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	t.Run("algorithm not allowed", func(t *testing.T) {
+		w := newTestWebAuthn(t, WithAlgorithms(AlgES256))
+		a := newAuthenticator(t, AlgEdDSA)
+		opts, session, err := w.BeginRegistration(ctx, user)
+		require.NoError(t, err)
+		_, err = w.FinishRegistration(ctx, session, a.register(t, opts, registration{}))
+		require.ErrorIs(t, err, ErrUnsupportedKey)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		w := newTestWebAuthn(t)
+		a := newAuthenticator(t, AlgES256)
+		opts, session, err := w.BeginRegistration(ctx, user)
+		require.NoError(t, err)
+		_, err = w.FinishRegistration(ctx, session, a.register(t, opts, registration{}))
+		require.NoError(t, err)
+		_, err = w.FinishRegistration(ctx, session, a.register(t, opts, registration{}))
+		require.ErrorIs(t, err, ErrSessionUsed)
+		opts, session, err = w.BeginRegistration(ctx, user)
+		require.NoError(t, err)
+		_, err = w.FinishRegistration(ctx, session, a.register(t, opts, registration{}))
+		require.ErrorIs(t, err, ErrCredentialExists)
+	})
+}
+
+func TestWebAuthnPackedAttestation(t *testing.T) {
+	ctx := context.Background()
+	user := UserEntity{ID: []byte("user-1"), Name: "alice@example.com"}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	attKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	attCert := func(aaguid []byte) []byte {
+		value, err := asn1.Marshal(aaguid)
+		require.NoError(t, err)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber:          big.NewInt(2),
+			Subject:               pkix.Name{CommonName: "Test Authenticator", OrganizationalUnit: []string{"Authenticator Attestation"}},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			ExtraExtensions:       []pkix.Extension{{Id: oidAAGUID, Value: value}},
+		}, ca, attKey.Public(), caKey)
+		require.NoError(t, err)
+		return der
+	}
+
+	self := func(a *authenticator) func(ad, cdHash []byte) map[string]any {
+		return func(ad, cdHash []byte) map[string]any {
+			return map[string]any{"alg": a.alg, "sig": a.sign(t, slices.Concat(ad, cdHash))}
+		}
+	}
+	basic := func(cert []byte) func(ad, cdHash []byte) map[string]any {
+		return func(ad, cdHash []byte) map[string]any {
+			return map[string]any{"alg": AlgES256, "sig": signWith(t, attKey, slices.Concat(ad, cdHash)), "x5c": [][]byte{cert}}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		opts     []WebAuthnOpt
+		stmt     func(a *authenticator) func(ad, cdHash []byte) map[string]any
+		attType  string
+		expected error
+	}{
+		{name: "self", stmt: self, attType: AttestationSelf},
+		{
+			name: "self with wrong key",
+			stmt: func(*authenticator) func(ad, cdHash []byte) map[string]any {
+				return self(newAuthenticator(t, AlgES256))
+			},
+			expected: ErrInvalidAttestation,
+		},
+		{
+			name:     "self with roots",
+			opts:     []WebAuthnOpt{WithAttestationRoots(roots)},
+			stmt:     self,
+			expected: ErrUntrustedAttestation,
+		},
+		{
+			name: "basic",
+			opts: []WebAuthnOpt{WithAttestationRoots(roots)},
+			stmt: func(a *authenticator) func(ad, cdHash []byte) map[string]any {
+				return basic(attCert(a.aaguid))
+			},
+			attType: AttestationBasic,
+		},
+		{
+			name: "basic with other roots",
+			opts: []WebAuthnOpt{WithAttestationRoots(x509.NewCertPool())},
+			stmt: func(a *authenticator) func(ad, cdHash []byte) map[string]any {
+				return basic(attCert(a.aaguid))
+			},
+			expected: ErrUntrustedAttestation,
+		},
+		{
+			name: "basic with wrong aaguid",
+			stmt: func(*authenticator) func(ad, cdHash []byte) map[string]any {
+				return basic(attCert(slices.Repeat([]byte{1}, 16)))
+			},
+			expected: ErrInvalidAttestation,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newTestWebAuthn(t, tc.opts...)
+			a := newAuthenticator(t, AlgES256)
+			opts, session, err := w.BeginRegistration(ctx, user)
+			require.NoError(t, err)
+			cred, err := w.FinishRegistration(ctx, session, a.register(t, opts, registration{format: "packed", statement: tc.stmt(a)}))
+			if tc.expected != nil {
+				require.ErrorIs(t, err, tc.expected)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.attType, cred.AttestationType)
+		})
+	}
+}
+
+func TestWebAuthnJSON(t *testing.T) {
+	w := newTestWebAuthn(t)
+	opts, session, err := w.BeginRegistration(context.Background(), UserEntity{ID: []byte{0xfb, 0xff}, Name: "alice"})
+	require.NoError(t, err)
+
+	data, err := json.Marshal(opts)
+	require.NoError(t, err)
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(data, &raw))
+	require.Equal(t, "-_8", raw["user"].(map[string]any)["id"]) //nolint:forcetypeassert // panics on test failure
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(opts.Challenge), raw["challenge"])
+
+	data, err = json.Marshal(session)
+	require.NoError(t, err)
+	var decoded WebAuthnSession
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, session.Challenge, decoded.Challenge)
+	require.Equal(t, session.UserID, decoded.UserID)
+}
@@ -0,0 +1,94 @@
+package concurrency
+
+import (
+	"math"
+	"time"
+)
+
+// Algorithm adjusts concurrency limit from latencies of finished requests.
+// Update is called while the limiter is locked so implementations don't need their own locking.
+type Algorithm interface {
+	// Update returns new limit after request which took rtt finished while inflight requests were running.
+	// Dropped is true when the request failed because of overload, e.g. it timed out.
+	Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// AIMD increases limit by one after successful requests and decreases it multiplicatively on overload.
+type AIMD struct {
+	// Backoff is the factor limit is multiplied with on overload, defaults to 0.9.
+	Backoff float64
+	// LatencyThreshold treats slower requests as overload, zero considers only dropped requests.
+	LatencyThreshold time.Duration
+}
+
+// Update implements Algorithm.
+func (a *AIMD) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	backoff := a.Backoff
+	if backoff <= 0 || backoff >= 1 {
+		backoff = 0.9
+	}
+	if dropped || (a.LatencyThreshold > 0 && rtt > a.LatencyThreshold) {
+		return limit * backoff
+	}
+	// limit grows only when it is actually used
+	if float64(inflight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Gradient compares short term average latency to long term average and reduces limit when
+// latency grows, it needs no latency threshold but reacts slower than AIMD.
+type Gradient struct {
+	// Tolerance is how many times the long term average latency may be exceeded before limit is reduced, defaults to 1.5.
+	Tolerance float64
+	// Smoothing is the weight of new limit, defaults to 0.2.
+	Smoothing float64
+	// LongWindow is the number of samples in long term average, defaults to 600.
+	LongWindow int
+
+	short, long float64
+}
+
+const gradientShortWindow = 10
+
+// Update implements Algorithm.
+func (g *Gradient) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	tolerance, smoothing, window := g.Tolerance, g.Smoothing, g.LongWindow
+	if tolerance < 1 {
+		tolerance = 1.5
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+	if window <= 0 {
+		window = 600
+	}
+
+	sample := float64(rtt)
+	if g.long == 0 {
+		g.short, g.long = sample, sample
+	}
+	g.short = ema(g.short, sample, gradientShortWindow)
+	g.long = ema(g.long, sample, window)
+	// long term average follows quickly when latency drops so that limit can grow again
+	if g.long/g.short > 2 {
+		g.long *= 0.95
+	}
+
+	if dropped {
+		return limit * 0.9
+	}
+	// limit isn't grown when less than half of it is used
+	if float64(inflight) < limit/2 {
+		return limit
+	}
+	gradient := math.Max(0.5, math.Min(1, tolerance*g.long/g.short))
+	next := limit*gradient + math.Sqrt(limit)
+	return limit*(1-smoothing) + next*smoothing
+}
+
+func ema(avg, sample float64, window int) float64 {
+	factor := 2 / float64(window+1)
+	return avg*(1-factor) + sample*factor
+}
@@ -0,0 +1,37 @@
+package concurrency_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/middleware/concurrency"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAIMD(t *testing.T) {
+	a := &concurrency.AIMD{LatencyThreshold: 100 * time.Millisecond}
+	require.InDelta(t, 11, a.Update(10, 10*time.Millisecond, 5, false), 0.001)
+	require.InDelta(t, 10, a.Update(10, 10*time.Millisecond, 2, false), 0.001, "limit isn't grown when it isn't used")
+	require.InDelta(t, 9, a.Update(10, 10*time.Millisecond, 5, true), 0.001)
+	require.InDelta(t, 9, a.Update(10, time.Second, 5, false), 0.001, "slow request is overload")
+	require.InDelta(t, 5, (&concurrency.AIMD{Backoff: 0.5}).Update(10, time.Second, 5, true), 0.001)
+}
+
+func TestGradient(t *testing.T) {
+	g := &concurrency.Gradient{}
+	limit := 20.0
+	for range 100 {
+		limit = g.Update(limit, 10*time.Millisecond, int(limit), false)
+	}
+	require.Greater(t, limit, 20.0, "limit grows while latency is stable")
+
+	grown := limit
+	for range 20 {
+		limit = g.Update(limit, 100*time.Millisecond, int(limit), false)
+	}
+	require.Less(t, limit, grown, "limit shrinks when latency grows")
+
+	shrunk := limit
+	require.InDelta(t, shrunk, g.Update(shrunk, 10*time.Millisecond, 1, false), 0.001, "limit isn't changed when it isn't used")
+	require.InDelta(t, shrunk*0.9, g.Update(shrunk, 10*time.Millisecond, int(shrunk), true), 0.001)
+}
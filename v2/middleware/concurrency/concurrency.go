@@ -0,0 +1,265 @@
+// Package concurrency limits the number of requests processed at the same time with a limit
+// which adapts to observed latency. Requests exceeding the limit are rejected immediately or after
+// a short wait in a priority queue so that overloaded services shed load instead of queueing it.
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Priority decides which requests are shed first, lower priorities can use only part of the limit.
+type Priority int
+
+const (
+	// PriorityHigh can use the whole limit and is dequeued first.
+	PriorityHigh Priority = iota
+	// PriorityNormal can use 90% of the limit, it is the default priority.
+	PriorityNormal
+	// PriorityLow can use 50% of the limit.
+	PriorityLow
+)
+
+var shares = [...]float64{PriorityHigh: 1, PriorityNormal: 0.9, PriorityLow: 0.5}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+func (p Priority) valid() Priority {
+	if p < PriorityHigh || p > PriorityLow {
+		return PriorityNormal
+	}
+	return p
+}
+
+// ErrLimitExceeded is returned when request is rejected because of the limit.
+var ErrLimitExceeded = errors.New("concurrency limit exceeded")
+
+// Limiter limits concurrent requests with limit adjusted by Algorithm.
+type Limiter struct {
+	alg        Algorithm
+	limit      float64
+	minLimit   int
+	maxLimit   int
+	queueSize  int
+	maxWait    time.Duration
+	retryAfter time.Duration
+	priority   PriorityFunc
+	name       string
+
+	inflight int
+	queue    [len(shares)][]*waiter
+	queued   int
+	mu       sync.Mutex
+
+	limitGauge    prometheus.Gauge
+	inflightGauge prometheus.Gauge
+	queueGauge    prometheus.Gauge
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+type Opt func(*Limiter)
+
+// WithAlgorithm sets algorithm adjusting the limit, defaults to AIMD with 0.9 backoff.
+func WithAlgorithm(alg Algorithm) Opt {
+	return func(l *Limiter) {
+		l.alg = alg
+	}
+}
+
+// WithLimits sets initial limit and bounds within which algorithm can adjust it, defaults to 20, 1 and 1000.
+func WithLimits(initial, minLimit, maxLimit int) Opt {
+	return func(l *Limiter) {
+		l.limit = float64(initial)
+		l.minLimit = minLimit
+		l.maxLimit = maxLimit
+	}
+}
+
+// WithQueue lets up to size requests wait at most maxWait for capacity, by default requests are rejected immediately.
+func WithQueue(size int, maxWait time.Duration) Opt {
+	return func(l *Limiter) {
+		l.queueSize = size
+		l.maxWait = maxWait
+	}
+}
+
+// WithRetryAfter sets value of Retry-After header of rejected requests, defaults to 1 second.
+func WithRetryAfter(d time.Duration) Opt {
+	return func(l *Limiter) {
+		l.retryAfter = d
+	}
+}
+
+// WithPriority sets function which classifies requests for middlewares, by default all requests are PriorityNormal.
+// The request context contains values set by preceding middlewares, e.g. claims of the user.
+func WithPriority(fn PriorityFunc) Opt {
+	return func(l *Limiter) {
+		l.priority = fn
+	}
+}
+
+// WithName sets limiter label of metrics, defaults to "default".
+func WithName(name string) Opt {
+	return func(l *Limiter) {
+		l.name = name
+	}
+}
+
+// New creates Limiter.
+func New(opts ...Opt) *Limiter {
+	l := &Limiter{
+		alg:        &AIMD{},
+		limit:      20,
+		minLimit:   1,
+		maxLimit:   1000,
+		retryAfter: time.Second,
+		priority:   func(*http.Request) Priority { return PriorityNormal },
+		name:       "default",
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.minLimit = max(l.minLimit, 1)
+	l.maxLimit = max(l.maxLimit, l.minLimit)
+	l.limit = math.Min(math.Max(l.limit, float64(l.minLimit)), float64(l.maxLimit))
+	l.limitGauge = limitGauge.WithLabelValues(l.name)
+	l.inflightGauge = inflightGauge.WithLabelValues(l.name)
+	l.queueGauge = queueGauge.WithLabelValues(l.name)
+	l.updateGauges()
+	return l
+}
+
+// Limit returns the current limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Inflight returns the number of acquired and not yet released requests.
+func (l *Limiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
+
+// Acquire reserves capacity for request of priority p. If limit is reached request waits in the queue when
+// it is configured and ErrLimitExceeded is returned if capacity isn't freed in time. Returned release must be
+// called once the request has finished, dropped tells that the request failed because of overload.
+func (l *Limiter) Acquire(ctx context.Context, p Priority) (release func(dropped bool), err error) {
+	p = p.valid()
+	l.mu.Lock()
+	if !l.waiting(p) && l.inflight < l.capacity(p) {
+		l.inflight++
+		l.updateGauges()
+		l.mu.Unlock()
+		return l.releaser(), nil
+	}
+	if l.queued >= l.queueSize || l.maxWait <= 0 {
+		l.mu.Unlock()
+		rejected.WithLabelValues(l.name, p.String()).Inc()
+		return nil, ErrLimitExceeded
+	}
+	w := &waiter{ready: make(chan struct{})}
+	l.queue[p] = append(l.queue[p], w)
+	l.queued++
+	l.updateGauges()
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return l.releaser(), nil
+	case <-timer.C:
+		err = ErrLimitExceeded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// capacity may have been granted while timing out
+	if w.granted {
+		return l.releaser(), nil
+	}
+	l.queue[p] = slices.DeleteFunc(l.queue[p], func(q *waiter) bool { return q == w })
+	l.queued--
+	l.updateGauges()
+	rejected.WithLabelValues(l.name, p.String()).Inc()
+	return nil, err
+}
+
+func (l *Limiter) releaser() func(bool) {
+	start := time.Now()
+	var once sync.Once
+	return func(dropped bool) {
+		once.Do(func() {
+			l.release(time.Since(start), dropped)
+		})
+	}
+}
+
+func (l *Limiter) release(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := l.alg.Update(l.limit, rtt, l.inflight, dropped)
+	l.limit = math.Min(math.Max(limit, float64(l.minLimit)), float64(l.maxLimit))
+	l.inflight--
+	l.dispatch()
+	l.updateGauges()
+}
+
+// dispatch grants capacity to queued requests in priority order.
+func (l *Limiter) dispatch() {
+	for p := range l.queue {
+		for len(l.queue[p]) > 0 && l.inflight < l.capacity(Priority(p)) {
+			w := l.queue[p][0]
+			l.queue[p] = l.queue[p][1:]
+			l.queued--
+			l.inflight++
+			w.granted = true
+			close(w.ready)
+		}
+	}
+}
+
+// waiting reports whether requests of priority p or higher are queued, they must be served first.
+func (l *Limiter) waiting(p Priority) bool {
+	for _, q := range l.queue[:p+1] {
+		if len(q) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Limiter) capacity(p Priority) int {
+	return max(1, int(l.limit*shares[p]))
+}
+
+func (l *Limiter) updateGauges() {
+	l.limitGauge.Set(math.Floor(l.limit))
+	l.inflightGauge.Set(float64(l.inflight))
+	l.queueGauge.Set(float64(l.queued))
+}
@@ -0,0 +1,173 @@
+package concurrency_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/middleware/concurrency"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// fixed keeps the limit unchanged.
+type fixed struct{}
+
+func (fixed) Update(limit float64, _ time.Duration, _ int, _ bool) float64 { return limit }
+
+func TestAcquire(t *testing.T) {
+	ctx := context.Background()
+	l := concurrency.New(concurrency.WithAlgorithm(fixed{}), concurrency.WithLimits(10, 1, 100))
+
+	var releases []func(bool)
+	for range 9 {
+		release, err := l.Acquire(ctx, concurrency.PriorityNormal)
+		require.NoError(t, err)
+		releases = append(releases, release)
+	}
+	require.Equal(t, 9, l.Inflight())
+	_, err := l.Acquire(ctx, concurrency.PriorityNormal)
+	require.ErrorIs(t, err, concurrency.ErrLimitExceeded)
+	_, err = l.Acquire(ctx, concurrency.PriorityLow)
+	require.ErrorIs(t, err, concurrency.ErrLimitExceeded)
+
+	// high priority can use the remaining 10% of the limit
+	release, err := l.Acquire(ctx, concurrency.PriorityHigh)
+	require.NoError(t, err)
+	_, err = l.Acquire(ctx, concurrency.PriorityHigh)
+	require.ErrorIs(t, err, concurrency.ErrLimitExceeded)
+
+	release(false)
+	release(false)
+	for _, release := range releases {
+		release(false)
+	}
+	require.Equal(t, 0, l.Inflight())
+
+	for range 5 {
+		_, err := l.Acquire(ctx, concurrency.PriorityLow)
+		require.NoError(t, err)
+	}
+	_, err = l.Acquire(ctx, concurrency.PriorityLow)
+	require.ErrorIs(t, err, concurrency.ErrLimitExceeded)
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(concurrency.Collectors()...)
+	l := concurrency.New(
+		concurrency.WithName("queue"),
+		concurrency.WithAlgorithm(fixed{}),
+		concurrency.WithLimits(1, 1, 1),
+		concurrency.WithQueue(2, time.Second),
+	)
+	queued := func() float64 { return metric(t, reg, "http_concurrency_queue_depth", "queue") }
+	release, err := l.Acquire(ctx, concurrency.PriorityNormal)
+	require.NoError(t, err)
+
+	order := make(chan concurrency.Priority, 2)
+	acquire := func(p concurrency.Priority) {
+		release, err := l.Acquire(ctx, p)
+		if err == nil {
+			order <- p
+			release(false)
+		}
+	}
+	go acquire(concurrency.PriorityLow)
+	require.Eventually(t, func() bool { return queued() == 1 }, time.Second, time.Millisecond)
+	go acquire(concurrency.PriorityHigh)
+	require.Eventually(t, func() bool { return queued() == 2 }, time.Second, time.Millisecond)
+
+	_, err = l.Acquire(ctx, concurrency.PriorityHigh)
+	require.ErrorIs(t, err, concurrency.ErrLimitExceeded, "queue is full")
+
+	release(false)
+	require.Equal(t, concurrency.PriorityHigh, <-order)
+	require.Equal(t, concurrency.PriorityLow, <-order)
+	require.Equal(t, 0, l.Inflight())
+}
+
+func TestQueueTimeout(t *testing.T) {
+	l := concurrency.New(
+		concurrency.WithAlgorithm(fixed{}),
+		concurrency.WithLimits(1, 1, 1),
+		concurrency.WithQueue(1, 10*time.Millisecond),
+	)
+	release, err := l.Acquire(context.Background(), concurrency.PriorityNormal)
+	require.NoError(t, err)
+	defer release(false)
+
+	_, err = l.Acquire(context.Background(), concurrency.PriorityNormal)
+	require.ErrorIs(t, err, concurrency.ErrLimitExceeded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.Acquire(ctx, concurrency.PriorityNormal)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, l.Inflight())
+}
+
+func TestAdapt(t *testing.T) {
+	ctx := context.Background()
+	l := concurrency.New(concurrency.WithLimits(4, 2, 5))
+
+	var releases []func(bool)
+	for range 3 {
+		release, err := l.Acquire(ctx, concurrency.PriorityHigh)
+		require.NoError(t, err)
+		releases = append(releases, release)
+	}
+	releases[0](false)
+	releases[0](false) // release is idempotent
+	require.Equal(t, 5, l.Limit())
+	releases[1](false)
+	require.Equal(t, 5, l.Limit(), "limit is capped by max")
+
+	for range 10 {
+		release, err := l.Acquire(ctx, concurrency.PriorityHigh)
+		require.NoError(t, err)
+		release(true)
+	}
+	require.Equal(t, 2, l.Limit(), "limit is capped by min")
+	releases[2](false)
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(concurrency.Collectors()...)
+	l := concurrency.New(concurrency.WithName("metrics"), concurrency.WithLimits(2, 2, 2))
+
+	rejected := metric(t, reg, "http_concurrency_rejected_total", "metrics")
+	release, err := l.Acquire(context.Background(), concurrency.PriorityNormal)
+	require.NoError(t, err)
+	_, err = l.Acquire(context.Background(), concurrency.PriorityLow)
+	require.ErrorIs(t, err, concurrency.ErrLimitExceeded)
+	require.InDelta(t, 2, metric(t, reg, "http_concurrency_limit", "metrics"), 0)
+	require.InDelta(t, 1, metric(t, reg, "http_concurrency_inflight_requests", "metrics"), 0)
+	require.InDelta(t, 0, metric(t, reg, "http_concurrency_queue_depth", "metrics"), 0)
+	require.InDelta(t, rejected+1, metric(t, reg, "http_concurrency_rejected_total", "metrics"), 0)
+
+	release(false)
+	require.InDelta(t, 0, metric(t, reg, "http_concurrency_inflight_requests", "metrics"), 0)
+}
+
+// metric returns value of gauge or counter name with limiter label, zero if it hasn't been created.
+func metric(t *testing.T, reg prometheus.Gatherer, name, limiter string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "limiter" && label.GetValue() == limiter {
+					return m.GetGauge().GetValue() + m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package concurrency
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var limitGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name:      "limit",
+		Subsystem: "http_concurrency",
+		Help:      "Current adaptive concurrency limit, partitioned by limiter.",
+	},
+	[]string{"limiter"},
+)
+
+var inflightGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name:      "inflight_requests",
+		Subsystem: "http_concurrency",
+		Help:      "How many requests are currently being processed, partitioned by limiter.",
+	},
+	[]string{"limiter"},
+)
+
+var queueGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name:      "queue_depth",
+		Subsystem: "http_concurrency",
+		Help:      "How many requests are waiting for capacity, partitioned by limiter.",
+	},
+	[]string{"limiter"},
+)
+
+var rejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name:      "rejected_total",
+		Subsystem: "http_concurrency",
+		Help:      "How many requests were shed, partitioned by limiter and priority.",
+	},
+	[]string{"limiter", "priority"},
+)
+
+// Collectors returns Limiter metrics which can be registered, e.g. with metrics.New(concurrency.Collectors()...).
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{limitGauge, inflightGauge, queueGauge, rejected}
+}
@@ -0,0 +1,82 @@
+package concurrency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+// PriorityFunc classifies request, for example by route or by user.
+type PriorityFunc func(r *http.Request) Priority
+
+// Handler returns net/http middleware which rejects requests exceeding the limit with 503.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := l.Acquire(r.Context(), l.priority(r))
+		if err != nil {
+			l.reject(w)
+			return
+		}
+		rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() { release(dropped(r.Context(), rw.status)) }()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Gin returns gin middleware which rejects requests exceeding the limit with 503.
+func (l *Limiter) Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l.serveGin(c, l.priority(c.Request))
+	}
+}
+
+// GinPriority returns gin middleware which uses fixed priority, e.g. for a route group.
+func (l *Limiter) GinPriority(p Priority) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l.serveGin(c, p)
+	}
+}
+
+func (l *Limiter) serveGin(c *gin.Context, p Priority) {
+	release, err := l.Acquire(c.Request.Context(), p)
+	if err != nil {
+		l.reject(c.Writer)
+		c.Abort()
+		return
+	}
+	defer func() { release(dropped(c.Request.Context(), c.Writer.Status())) }()
+	c.Next()
+}
+
+func (l *Limiter) reject(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.retryAfter.Seconds()))))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(httputil.ErrorResponse{Code: http.StatusServiceUnavailable, Message: ErrLimitExceeded.Error()})
+}
+
+// dropped tells algorithm that request failed because the service or its dependencies are overloaded.
+func dropped(ctx context.Context, status int) bool {
+	return status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout ||
+		errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,77 @@
+package concurrency_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/middleware/concurrency"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	l := concurrency.New(concurrency.WithAlgorithm(fixed{}), concurrency.WithLimits(1, 1, 1))
+	var inner *httptest.ResponseRecorder
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nested" {
+			inner = httptest.NewRecorder()
+			l.Handler(http.NotFoundHandler()).ServeHTTP(inner, httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nested", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, http.StatusServiceUnavailable, inner.Code)
+	require.Equal(t, "1", inner.Header().Get("Retry-After"))
+	require.JSONEq(t, `{"code":503,"message":"concurrency limit exceeded"}`, inner.Body.String())
+	require.Equal(t, 0, l.Inflight())
+}
+
+func TestGin(t *testing.T) {
+	l := concurrency.New(concurrency.WithLimits(4, 1, 10))
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(l.Gin())
+	r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/overloaded", func(c *gin.Context) { c.Status(http.StatusServiceUnavailable) })
+	r.GET("/nested", func(c *gin.Context) {
+		// nested request fits within the reduced limit alongside the outer one
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ok", nil))
+		c.Status(w.Code)
+	})
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/overloaded", nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Empty(t, w.Header().Get("Retry-After"), "response of the handler isn't replaced")
+	}
+	require.Equal(t, 2, l.Limit(), "overload reduces limit")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nested", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGinPriority(t *testing.T) {
+	l := concurrency.New(concurrency.WithAlgorithm(fixed{}), concurrency.WithLimits(2, 2, 2), concurrency.WithRetryAfter(1500*time.Millisecond))
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var inner *httptest.ResponseRecorder
+	r.GET("/low", l.GinPriority(concurrency.PriorityLow), func(c *gin.Context) {
+		inner = httptest.NewRecorder()
+		r.ServeHTTP(inner, httptest.NewRequest(http.MethodGet, "/low", nil))
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/low", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusServiceUnavailable, inner.Code)
+	require.Equal(t, "2", inner.Header().Get("Retry-After"))
+}
@@ -1,6 +1,7 @@
 package csrf
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/url"
 	"slices"
 
+	"github.com/elisasre/go-common/v2/audit"
 	"github.com/elisasre/go-common/v2/httputil"
 	"github.com/gin-gonic/gin"
 )
@@ -119,10 +121,27 @@ func New(excludePaths []string) gin.HandlerFunc {
 	}
 }
 
+type config struct {
+	recorder audit.Recorder
+}
+
+type Opt func(*config)
+
+// WithAuditRecorder records audit.ActionCSRFReject event for every rejected request.
+func WithAuditRecorder(r audit.Recorder) Opt {
+	return func(c *config) {
+		c.recorder = r
+	}
+}
+
 // NewV2 creates new CSRF middleware for gin using Go 1.25's built-in CrossOriginProtection.
 // trustedOrigins should contain the list of trusted origins (e.g., "https://example.com").
 // excludePaths contains URL patterns that should bypass CSRF protection.
-func NewV2(trustedOrigins []string, excludePaths []string) (gin.HandlerFunc, error) {
+func NewV2(trustedOrigins []string, excludePaths []string, opts ...Opt) (gin.HandlerFunc, error) {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 	cop := http.NewCrossOriginProtection()
 	for _, origin := range trustedOrigins {
 		if err := cop.AddTrustedOrigin(origin); err != nil {
@@ -136,6 +155,13 @@ func NewV2(trustedOrigins []string, excludePaths []string) (gin.HandlerFunc, err
 
 	return func(c *gin.Context) {
 		if err := cop.Check(c.Request); err != nil {
+			audit.RecordResult(context.WithoutCancel(c.Request.Context()), cfg.recorder, audit.Event{
+				Action:   audit.ActionCSRFReject,
+				Target:   c.Request.URL.Path,
+				Outcome:  audit.OutcomeDenied,
+				Reason:   err.Error(),
+				Metadata: map[string]string{"origin": c.GetHeader("Origin")},
+			}, nil)
 			c.AbortWithStatusJSON(http.StatusForbidden, httputil.ErrorResponse{Code: 403, Message: err.Error()})
 			return
 		}
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/elisasre/go-common/v2/audit"
 	"github.com/elisasre/go-common/v2/middleware/csrf"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -318,6 +319,30 @@ func TestNewV2(t *testing.T) {
 	}
 }
 
+func TestNewV2Audit(t *testing.T) {
+	sink := audit.NewMemorySink()
+	middleware, err := csrf.NewV2([]string{"https://example.com"}, nil, csrf.WithAuditRecorder(audit.New(sink)))
+	require.NoError(t, err)
+	r := gin.New()
+	r.Use(middleware)
+	r.POST("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, origin := range []string{"https://example.com", "https://evil.example.org"} {
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/ping", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Sec-Fetch-Site", "cross-site")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	events := sink.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, audit.ActionCSRFReject, events[0].Action)
+	require.Equal(t, audit.OutcomeDenied, events[0].Outcome)
+	require.Equal(t, "/ping", events[0].Target)
+	require.Equal(t, "https://evil.example.org", events[0].Metadata["origin"])
+	require.NotEmpty(t, events[0].Reason)
+}
+
 func TestNewV2Errors(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -0,0 +1,116 @@
+package ratelimit
+
+import "math"
+
+// state of a single limit, meaning of the fields depends on algorithm:
+// GCRA uses a as theoretical arrival time, token bucket uses a as tokens and b as time of the last
+// refill and sliding window uses a as window index, b as count of the window and c as count of the previous one.
+// Times are in microseconds, the same algorithms are implemented in Lua for RedisLimiter.
+type state struct {
+	a, b, c float64
+}
+
+type stepFunc func(s state, found bool, now float64, l Limit) (state, limitResult)
+
+func stepFor(alg Algorithm) stepFunc {
+	switch alg {
+	case SlidingWindow:
+		return slidingWindowStep
+	case TokenBucket:
+		return tokenBucketStep
+	default:
+		return gcraStep
+	}
+}
+
+func period(l Limit) float64 {
+	return float64(l.Period.Microseconds())
+}
+
+func gcraStep(s state, found bool, now float64, l Limit) (state, limitResult) {
+	interval := period(l) / float64(l.Rate)
+	tolerance := interval * float64(l.burst())
+	tat := now
+	if found && s.a > now {
+		tat = s.a
+	}
+	newTAT := tat + interval
+	allowAt := newTAT - tolerance
+	if now < allowAt {
+		return s, limitResult{retry: allowAt - now, reset: tat - now}
+	}
+	return state{a: newTAT}, limitResult{
+		allowed:   true,
+		remaining: floor((tolerance - (newTAT - now)) / interval),
+		reset:     newTAT - now,
+	}
+}
+
+func tokenBucketStep(s state, found bool, now float64, l Limit) (state, limitResult) {
+	burst := float64(l.burst())
+	perToken := period(l) / float64(l.Rate)
+	tokens := burst
+	if found {
+		tokens = math.Min(burst, s.a+(now-s.b)/perToken)
+	}
+	if tokens < 1 {
+		return s, limitResult{retry: (1 - tokens) * perToken, reset: (burst - tokens) * perToken}
+	}
+	tokens--
+	return state{a: tokens, b: now}, limitResult{
+		allowed:   true,
+		remaining: floor(tokens),
+		reset:     (burst - tokens) * perToken,
+	}
+}
+
+func slidingWindowStep(s state, found bool, now float64, l Limit) (state, limitResult) {
+	p := period(l)
+	rate := float64(l.Rate)
+	window := math.Floor(now / p)
+	elapsed := now - window*p
+	cur, prev := 0.0, 0.0
+	switch {
+	case found && s.a == window:
+		cur, prev = s.b, s.c
+	case found && s.a == window-1:
+		prev = s.b
+	}
+	estimate := prev*(p-elapsed)/p + cur
+	if estimate+1 > rate {
+		var retry float64
+		if cur+1 <= rate && prev > 0 {
+			// previous window slides out enough within the current window
+			retry = p*(1-(rate-cur-1)/prev) - elapsed
+		} else {
+			wait := 0.0
+			if cur > 0 {
+				wait = math.Max(0, p*(1-(rate-1)/cur))
+			}
+			retry = p - elapsed + wait
+		}
+		return s, limitResult{retry: retry, reset: slidingWindowReset(cur, prev, p, elapsed)}
+	}
+	cur++
+	return state{a: window, b: cur, c: prev}, limitResult{
+		allowed:   true,
+		remaining: floor(rate - estimate - 1),
+		reset:     slidingWindowReset(cur, prev, p, elapsed),
+	}
+}
+
+func slidingWindowReset(cur, prev, p, elapsed float64) float64 {
+	switch {
+	case cur > 0:
+		return 2*p - elapsed
+	case prev > 0:
+		return p - elapsed
+	default:
+		return 0
+	}
+}
+
+// floor tolerates rounding errors of divisions which would make e.g. 2.9999999 remaining requests to 2.
+func floor(v float64) int {
+	return int(math.Floor(v + 1e-6))
+}
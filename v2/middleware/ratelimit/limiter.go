@@ -0,0 +1,194 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Algorithm selects how requests are counted.
+type Algorithm string
+
+const (
+	// GCRA (generic cell rate algorithm) spaces requests evenly and allows bursts up to Limit.Burst.
+	GCRA Algorithm = "gcra"
+	// SlidingWindow counts requests in current and previous window weighted by overlap with the last period.
+	// It is an approximation which doesn't allow bursts, Limit.Burst is ignored.
+	SlidingWindow Algorithm = "sliding_window"
+	// TokenBucket refills bucket of Limit.Burst tokens with Limit.Rate tokens per period.
+	TokenBucket Algorithm = "token_bucket"
+)
+
+var (
+	ErrInvalidLimit     = errors.New("rate and period of limit must be positive")
+	ErrInvalidAlgorithm = errors.New("unknown rate limit algorithm")
+	ErrNilClient        = errors.New("redis client is nil")
+)
+
+// Limit allows Rate requests per Period.
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	// Burst is the number of requests which can be made at once, defaults to Rate.
+	Burst int
+}
+
+// PerSecond allows rate requests per second.
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second, Burst: rate}
+}
+
+// PerMinute allows rate requests per minute.
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute, Burst: rate}
+}
+
+// PerHour allows rate requests per hour.
+func PerHour(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Hour, Burst: rate}
+}
+
+// PerDay allows rate requests per day.
+func PerDay(rate int) Limit {
+	return Limit{Rate: rate, Period: 24 * time.Hour, Burst: rate}
+}
+
+func (l Limit) burst() int {
+	if l.Burst <= 0 {
+		return l.Rate
+	}
+	return l.Burst
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 || l.Period <= 0 {
+		return fmt.Errorf("%w: %d/%s", ErrInvalidLimit, l.Rate, l.Period)
+	}
+	return nil
+}
+
+// id identifies state of the limit, changing any parameter starts from a clean state.
+func (l Limit) id() string {
+	return fmt.Sprintf("%d:%d:%d", l.Rate, l.Period.Milliseconds(), l.burst())
+}
+
+// Result is the outcome of Limiter.Allow.
+type Result struct {
+	// Limit is the most restrictive limit: the rejecting limit with longest RetryAfter
+	// or the limit with least remaining requests when request was allowed.
+	Limit     Limit
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long caller must wait before next request can be allowed, zero when allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long it takes until Limit is fully available again.
+	ResetAfter time.Duration
+}
+
+// Limiter decides whether request identified by key is allowed. When multiple limits are given
+// request is allowed only when all of them allow it and rejected requests don't consume any of them.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limits ...Limit) (Result, error)
+}
+
+type config struct {
+	algorithm Algorithm
+	prefix    string
+	now       func() time.Time
+}
+
+type Opt func(*config)
+
+// WithAlgorithm sets algorithm, defaults to GCRA.
+func WithAlgorithm(alg Algorithm) Opt {
+	return func(c *config) {
+		c.algorithm = alg
+	}
+}
+
+// WithPrefix sets prefix of keys, defaults to "ratelimit:".
+func WithPrefix(prefix string) Opt {
+	return func(c *config) {
+		c.prefix = prefix
+	}
+}
+
+// WithClock sets time source of MemoryLimiter, RedisLimiter always uses time of the Redis server
+// so that all instances agree on it.
+func WithClock(now func() time.Time) Opt {
+	return func(c *config) {
+		c.now = now
+	}
+}
+
+func newConfig(opts []Opt) (*config, error) {
+	cfg := &config{algorithm: GCRA, prefix: "ratelimit:", now: time.Now}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	switch cfg.algorithm {
+	case GCRA, SlidingWindow, TokenBucket:
+		return cfg, nil
+	default:
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidAlgorithm, cfg.algorithm)
+	}
+}
+
+// key of the limit, key given by the caller is used as hash tag so that all limits
+// of the key are stored in the same Redis Cluster slot.
+func (c *config) key(key string, l Limit) string {
+	return c.prefix + "{" + key + "}:" + string(c.algorithm) + ":" + l.id()
+}
+
+// limitResult contains outcome of single limit with durations in microseconds.
+type limitResult struct {
+	allowed   bool
+	remaining int
+	retry     float64
+	reset     float64
+}
+
+func combine(limits []Limit, results []limitResult) Result {
+	allowed := true
+	for _, r := range results {
+		allowed = allowed && r.allowed
+	}
+	best := -1
+	for i, r := range results {
+		switch {
+		case best < 0:
+			best = i
+		case !allowed && !r.allowed && (results[best].allowed || r.retry > results[best].retry):
+			best = i
+		case allowed && r.remaining < results[best].remaining:
+			best = i
+		}
+	}
+	r := results[best]
+	res := Result{
+		Limit:      limits[best],
+		Allowed:    allowed,
+		Remaining:  max(r.remaining, 0),
+		ResetAfter: micros(r.reset),
+	}
+	if !allowed {
+		res.Remaining = 0
+		res.RetryAfter = micros(r.retry)
+	}
+	return res
+}
+
+func micros(us float64) time.Duration {
+	return time.Duration(math.Ceil(max(us, 0))) * time.Microsecond
+}
+
+func validateLimits(limits []Limit) error {
+	for _, l := range limits {
+		if err := l.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
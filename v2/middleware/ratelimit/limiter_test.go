@@ -0,0 +1,183 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/elisasre/go-common/v2/middleware/ratelimit"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+var algorithms = []ratelimit.Algorithm{ratelimit.GCRA, ratelimit.SlidingWindow, ratelimit.TokenBucket}
+
+type testClock struct {
+	now     time.Time
+	advance func(time.Duration)
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	if c.advance != nil {
+		c.advance(d)
+	}
+}
+
+type newLimiterFunc func(t *testing.T, clock *testClock, alg ratelimit.Algorithm) ratelimit.Limiter
+
+func newMemoryLimiter(t *testing.T, clock *testClock, alg ratelimit.Algorithm) ratelimit.Limiter {
+	t.Helper()
+	l, err := ratelimit.NewMemoryLimiter(ratelimit.WithAlgorithm(alg), ratelimit.WithClock(func() time.Time { return clock.now }))
+	require.NoError(t, err)
+	return l
+}
+
+func newRedisLimiter(t *testing.T, clock *testClock, alg ratelimit.Algorithm) ratelimit.Limiter {
+	t.Helper()
+	s := miniredis.RunT(t)
+	s.SetTime(clock.now)
+	clock.advance = func(d time.Duration) {
+		s.SetTime(clock.now)
+		s.FastForward(d)
+	}
+	l, err := ratelimit.NewRedisLimiter(redis.NewClient(&redis.Options{Addr: s.Addr()}), ratelimit.WithAlgorithm(alg))
+	require.NoError(t, err)
+	return l
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	runLimiterSuite(t, newMemoryLimiter)
+}
+
+func TestRedisLimiter(t *testing.T) {
+	runLimiterSuite(t, newRedisLimiter)
+
+	l, err := ratelimit.NewRedisLimiter(nil)
+	require.NoError(t, err)
+	_, err = l.Allow(context.Background(), "key", ratelimit.PerSecond(1))
+	require.ErrorIs(t, err, ratelimit.ErrNilClient)
+}
+
+func runLimiterSuite(t *testing.T, newLimiter newLimiterFunc) {
+	t.Helper()
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, alg := range algorithms {
+		t.Run(string(alg), func(t *testing.T) {
+			clock := &testClock{now: start}
+			l := newLimiter(t, clock, alg)
+			limit := ratelimit.Limit{Rate: 3, Period: time.Second}
+
+			for i := range 3 {
+				res, err := l.Allow(ctx, "user-1", limit)
+				require.NoError(t, err)
+				require.True(t, res.Allowed)
+				require.Equal(t, 2-i, res.Remaining)
+				require.Zero(t, res.RetryAfter)
+				require.Equal(t, limit, res.Limit)
+			}
+			res, err := l.Allow(ctx, "user-1", limit)
+			require.NoError(t, err)
+			require.False(t, res.Allowed)
+			require.Zero(t, res.Remaining)
+			require.Positive(t, res.RetryAfter)
+			require.LessOrEqual(t, res.RetryAfter, 2*limit.Period)
+			require.Positive(t, res.ResetAfter)
+			require.LessOrEqual(t, res.ResetAfter, 2*limit.Period)
+
+			// other keys are not affected
+			other, err := l.Allow(ctx, "user-2", limit)
+			require.NoError(t, err)
+			require.True(t, other.Allowed)
+
+			retryAfter := res.RetryAfter
+			clock.Advance(retryAfter - time.Millisecond)
+			res, err = l.Allow(ctx, "user-1", limit)
+			require.NoError(t, err)
+			require.False(t, res.Allowed, "request before retry after is rejected")
+			clock.Advance(time.Millisecond)
+			res, err = l.Allow(ctx, "user-1", limit)
+			require.NoError(t, err)
+			require.True(t, res.Allowed, "request after retry after is allowed")
+
+			// limit is fully available after reset
+			clock.Advance(2 * limit.Period)
+			res, err = l.Allow(ctx, "user-1", limit)
+			require.NoError(t, err)
+			require.Equal(t, 2, res.Remaining)
+		})
+	}
+
+	t.Run("multiple limits", func(t *testing.T) {
+		for _, alg := range algorithms {
+			clock := &testClock{now: start}
+			l := newLimiter(t, clock, alg)
+			perSecond, perMinute := ratelimit.PerSecond(5), ratelimit.PerMinute(3)
+
+			res, err := l.Allow(ctx, "user-1", perSecond, perMinute)
+			require.NoError(t, err)
+			require.Equal(t, perMinute, res.Limit, "limit with least remaining requests is reported")
+			require.Equal(t, 2, res.Remaining)
+			for range 2 {
+				res, err = l.Allow(ctx, "user-1", perSecond, perMinute)
+				require.NoError(t, err)
+				require.True(t, res.Allowed)
+			}
+			res, err = l.Allow(ctx, "user-1", perSecond, perMinute)
+			require.NoError(t, err)
+			require.False(t, res.Allowed, alg)
+			require.Equal(t, perMinute, res.Limit)
+			require.Greater(t, res.RetryAfter, time.Second)
+
+			// rejected request didn't consume the per second limit
+			res, err = l.Allow(ctx, "user-1", perSecond)
+			require.NoError(t, err)
+			require.Equal(t, 1, res.Remaining, alg)
+		}
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		limit := ratelimit.Limit{Rate: 1, Period: time.Second, Burst: 3}
+		for alg, allowed := range map[ratelimit.Algorithm]int{ratelimit.GCRA: 3, ratelimit.TokenBucket: 3, ratelimit.SlidingWindow: 1} {
+			clock := &testClock{now: start}
+			l := newLimiter(t, clock, alg)
+			n := 0
+			for range 5 {
+				res, err := l.Allow(ctx, "user-1", limit)
+				require.NoError(t, err)
+				if res.Allowed {
+					n++
+				}
+			}
+			require.Equal(t, allowed, n, alg)
+		}
+	})
+
+	t.Run("invalid limits", func(t *testing.T) {
+		l := newLimiter(t, &testClock{now: start}, ratelimit.GCRA)
+		res, err := l.Allow(ctx, "user-1")
+		require.NoError(t, err)
+		require.True(t, res.Allowed)
+		_, err = l.Allow(ctx, "user-1", ratelimit.PerHour(0))
+		require.ErrorIs(t, err, ratelimit.ErrInvalidLimit)
+		_, err = l.Allow(ctx, "user-1", ratelimit.Limit{Rate: 1})
+		require.ErrorIs(t, err, ratelimit.ErrInvalidLimit)
+	})
+}
+
+func TestInvalidAlgorithm(t *testing.T) {
+	_, err := ratelimit.NewMemoryLimiter(ratelimit.WithAlgorithm("leaky"))
+	require.ErrorIs(t, err, ratelimit.ErrInvalidAlgorithm)
+	_, err = ratelimit.NewRedisLimiter(nil, ratelimit.WithAlgorithm("leaky"))
+	require.ErrorIs(t, err, ratelimit.ErrInvalidAlgorithm)
+}
+
+func TestPeriods(t *testing.T) {
+	require.Equal(t, ratelimit.Limit{Rate: 10, Period: time.Second, Burst: 10}, ratelimit.PerSecond(10))
+	require.Equal(t, time.Minute, ratelimit.PerMinute(1).Period)
+	require.Equal(t, time.Hour, ratelimit.PerHour(1).Period)
+	require.Equal(t, 24*time.Hour, ratelimit.PerDay(1).Period)
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	state     state
+	expiresAt time.Time
+}
+
+// MemoryLimiter keeps limits in process memory. It is meant for single instance services
+// and tests, limits of services with multiple replicas should be kept in Redis.
+type MemoryLimiter struct {
+	cfg       *config
+	step      stepFunc
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryLimiter creates MemoryLimiter. Expired entries are removed periodically during Allow.
+func NewMemoryLimiter(opts ...Opt) (*MemoryLimiter, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryLimiter{
+		cfg:       cfg,
+		step:      stepFor(cfg.algorithm),
+		entries:   map[string]memoryEntry{},
+		lastSweep: cfg.now(),
+	}, nil
+}
+
+// Allow implements Limiter.
+func (m *MemoryLimiter) Allow(_ context.Context, key string, limits ...Limit) (Result, error) {
+	if len(limits) == 0 {
+		return Result{Allowed: true}, nil
+	}
+	if err := validateLimits(limits); err != nil {
+		return Result{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.cfg.now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(now)
+	}
+	nowMicros := float64(now.UnixMicro())
+
+	keys := make([]string, len(limits))
+	states := make([]state, len(limits))
+	results := make([]limitResult, len(limits))
+	allowed := true
+	for i, l := range limits {
+		keys[i] = m.cfg.key(key, l)
+		e, found := m.entries[keys[i]]
+		found = found && now.Before(e.expiresAt)
+		states[i], results[i] = m.step(e.state, found, nowMicros, l)
+		allowed = allowed && results[i].allowed
+	}
+	if allowed {
+		for i := range limits {
+			m.entries[keys[i]] = memoryEntry{state: states[i], expiresAt: now.Add(micros(results[i].reset))}
+		}
+	}
+	return combine(limits, results), nil
+}
+
+func (m *MemoryLimiter) sweep(now time.Time) {
+	for k, e := range m.entries {
+		if !now.Before(e.expiresAt) {
+			delete(m.entries, k)
+		}
+	}
+	m.lastSweep = now
+}
@@ -1,45 +1,90 @@
 package ratelimit
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/elisasre/go-common/v2/httputil"
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis_rate/v10"
 	"github.com/redis/go-redis/v9"
 )
 
 type (
 	KeyFunc func(*gin.Context) (key string, limit *int, err error)
-	ErrFunc func(*gin.Context, error) (shouldReturn bool)
+	// LimitFunc returns key of the request and limits applied to it, request is not limited when limits is empty.
+	LimitFunc func(*gin.Context) (key string, limits []Limit, err error)
+	ErrFunc   func(*gin.Context, error) (shouldReturn bool)
 )
 
 const (
 	HeaderReset     = "X-Ratelimit-Reset"
 	HeaderLimit     = "X-Ratelimit-Limit"
 	HeaderRemaining = "X-Ratelimit-Remaining"
+	// HeaderRateLimit and HeaderRateLimitPolicy are defined in IETF draft RateLimit header fields for HTTP.
+	HeaderRateLimit       = "RateLimit"
+	HeaderRateLimitPolicy = "RateLimit-Policy"
+	HeaderRetryAfter      = "Retry-After"
 )
 
+type middlewareConfig struct {
+	ietfHeaders bool
+}
+
+type MiddlewareOpt func(*middlewareConfig)
+
+// WithIETFHeaders adds RateLimit-Policy header describing every limit and RateLimit header
+// describing the most restrictive one, e.g. RateLimit: "10-per-1s";r=3;t=1.
+func WithIETFHeaders() MiddlewareOpt {
+	return func(c *middlewareConfig) {
+		c.ietfHeaders = true
+	}
+}
+
 // New creates a distributed rate limiter middleware using redis for state management.
-func New(rdb *redis.Client, key KeyFunc, errFunc ErrFunc) gin.HandlerFunc {
-	limiter := redis_rate.NewLimiter(rdb)
+// Limit returned by key is the number of requests allowed per minute. Nil rdb is reported
+// to errFunc as ErrNilClient for every limited request.
+func New(rdb *redis.Client, key KeyFunc, errFunc ErrFunc, opts ...MiddlewareOpt) gin.HandlerFunc {
+	var client redis.UniversalClient
+	if rdb != nil {
+		client = rdb
+	}
+	// GCRA is always valid algorithm
+	limiter, _ := NewRedisLimiter(client)
+	return Middleware(limiter, func(c *gin.Context) (string, []Limit, error) {
+		k, limit, err := key(c)
+		if err != nil || limit == nil {
+			return k, nil, err
+		}
+		return k, []Limit{PerMinute(*limit)}, nil
+	}, errFunc, opts...)
+}
+
+// Middleware creates rate limiter middleware using given limiter. Requests are rejected with 400
+// if limits can't be resolved and with 429 and Retry-After header when limit is exceeded.
+// Limiter errors are passed to errFunc which decides whether request is aborted or allowed.
+func Middleware(limiter Limiter, limitFunc LimitFunc, errFunc ErrFunc, opts ...MiddlewareOpt) gin.HandlerFunc {
+	cfg := &middlewareConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
-		key, limit, err := key(c)
+		key, limits, err := limitFunc(c)
 		if err != nil {
 			c.JSON(400, httputil.ErrorResponse{Code: 400, Message: err.Error()})
 			c.Abort()
 			return
 		}
 
-		if limit == nil || rdb == nil {
+		if len(limits) == 0 {
 			c.Next()
 			return
 		}
 
-		res, err := limiter.Allow(ctx, key, redis_rate.PerMinute(*limit))
+		res, err := limiter.Allow(ctx, key, limits...)
 		if err != nil {
 			if shouldReturn := errFunc(c, err); shouldReturn {
 				return
@@ -47,9 +92,14 @@ func New(rdb *redis.Client, key KeyFunc, errFunc ErrFunc) gin.HandlerFunc {
 		} else {
 			reset := time.Now().Add(res.ResetAfter)
 			c.Header(HeaderReset, strconv.Itoa(int(reset.Unix())))
-			c.Header(HeaderLimit, strconv.Itoa(*limit))
+			c.Header(HeaderLimit, strconv.Itoa(res.Limit.Rate))
 			c.Header(HeaderRemaining, strconv.Itoa(res.Remaining))
-			if res.Allowed <= 0 {
+			if cfg.ietfHeaders {
+				c.Header(HeaderRateLimitPolicy, policyHeader(limits))
+				c.Header(HeaderRateLimit, fmt.Sprintf("%s;r=%d;t=%d", policyName(res.Limit), res.Remaining, seconds(res.ResetAfter)))
+			}
+			if !res.Allowed {
+				c.Header(HeaderRetryAfter, strconv.FormatInt(seconds(res.RetryAfter), 10))
 				c.JSON(http.StatusTooManyRequests, httputil.ErrorResponse{Code: http.StatusTooManyRequests, Message: "rate limit exceeded"})
 				c.Abort()
 				return
@@ -59,3 +109,20 @@ func New(rdb *redis.Client, key KeyFunc, errFunc ErrFunc) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func policyName(l Limit) string {
+	return fmt.Sprintf("\"%d-per-%ds\"", l.Rate, seconds(l.Period))
+}
+
+func policyHeader(limits []Limit) string {
+	policies := make([]string, 0, len(limits))
+	for _, l := range limits {
+		policies = append(policies, fmt.Sprintf("%s;q=%d;w=%d", policyName(l), l.Rate, seconds(l.Period)))
+	}
+	return strings.Join(policies, ", ")
+}
+
+// seconds rounds up so that clients don't retry too early.
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
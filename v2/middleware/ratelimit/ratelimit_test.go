@@ -67,6 +67,8 @@ func TestRedisRateLimiterAlways(t *testing.T) {
 	assert.Equal(t, `{"code":429,"message":"rate limit exceeded"}`, w3.Body.String())
 	assert.Equal(t, "2", w3.Result().Header.Get(ratelimit.HeaderLimit))
 	assert.Equal(t, "0", w3.Result().Header.Get(ratelimit.HeaderRemaining))
+	assert.Equal(t, "30", w3.Result().Header.Get(ratelimit.HeaderRetryAfter))
+	assert.Empty(t, w3.Result().Header.Get(ratelimit.HeaderRateLimit), "IETF headers are optional")
 }
 
 func TestRedisRateLimiterSkip(t *testing.T) {
@@ -167,10 +169,65 @@ func TestRedisRateLimiterNil(t *testing.T) {
 	req, err := http.NewRequest("GET", "/healthz", nil)
 	require.Equal(t, err, nil)
 	router.ServeHTTP(w, req)
-	assert.Equal(t, 200, w.Code)
-	assert.Equal(t, "ok", w.Body.String())
+	// missing client must not disable rate limiting silently
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, `{"code":400,"message":"redis client is nil"}`, w.Body.String())
 	assert.Equal(t, "", w.Result().Header.Get(ratelimit.HeaderLimit))
 	assert.Equal(t, "", w.Result().Header.Get(ratelimit.HeaderRemaining))
 }
 
 func intPtr(i int) *int { return &i }
+
+func TestMiddlewareMultipleLimits(t *testing.T) {
+	limiter, err := ratelimit.NewMemoryLimiter(ratelimit.WithAlgorithm(ratelimit.TokenBucket))
+	require.NoError(t, err)
+	mw := ratelimit.Middleware(limiter,
+		func(c *gin.Context) (string, []ratelimit.Limit, error) {
+			return testUser, []ratelimit.Limit{ratelimit.PerSecond(10), ratelimit.PerHour(2)}, nil
+		},
+		func(c *gin.Context, err error) bool {
+			t.Fatal(err)
+			return true
+		},
+	)
+	router := setupRouter(mw)
+
+	codes := []int{}
+	for range 3 {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		codes = append(codes, w.Code)
+		assert.Equal(t, "2", w.Result().Header.Get(ratelimit.HeaderLimit), "most restrictive limit is reported")
+	}
+	require.Equal(t, []int{200, 200, 429}, codes)
+}
+
+func TestMiddlewareIETFHeaders(t *testing.T) {
+	limiter, err := ratelimit.NewMemoryLimiter()
+	require.NoError(t, err)
+	mw := ratelimit.Middleware(limiter,
+		func(c *gin.Context) (string, []ratelimit.Limit, error) {
+			return testUser, []ratelimit.Limit{ratelimit.PerSecond(10), ratelimit.PerHour(2)}, nil
+		},
+		func(c *gin.Context, err error) bool {
+			t.Fatal(err)
+			return true
+		},
+		ratelimit.WithIETFHeaders(),
+	)
+	router := setupRouter(mw)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `"10-per-1s";q=10;w=1, "2-per-3600s";q=2;w=3600`, w.Header().Get(ratelimit.HeaderRateLimitPolicy))
+	require.Equal(t, `"2-per-3600s";r=1;t=1800`, w.Header().Get(ratelimit.HeaderRateLimit))
+	require.Empty(t, w.Header().Get(ratelimit.HeaderRetryAfter))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, `"2-per-3600s";r=0;t=3600`, w.Header().Get(ratelimit.HeaderRateLimit))
+	require.Equal(t, "1800", w.Header().Get(ratelimit.HeaderRetryAfter))
+}
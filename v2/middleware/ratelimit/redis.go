@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Lua implementations of the algorithms in algorithm.go, step returns new state, whether the request
+// is allowed, remaining requests, retry after and reset after in microseconds.
+const (
+	gcraLua = `
+local function step(s, found, now, rate, period, burst)
+	local interval = period / rate
+	local tolerance = interval * burst
+	local tat = now
+	if found and s[1] > now then tat = s[1] end
+	local new_tat = tat + interval
+	local allow_at = new_tat - tolerance
+	if now < allow_at then
+		return s, false, 0, allow_at - now, tat - now
+	end
+	return {new_tat, 0, 0}, true, floor((tolerance - (new_tat - now)) / interval), 0, new_tat - now
+end
+`
+	tokenBucketLua = `
+local function step(s, found, now, rate, period, burst)
+	local per_token = period / rate
+	local tokens = burst
+	if found then tokens = math.min(burst, s[1] + (now - s[2]) / per_token) end
+	if tokens < 1 then
+		return s, false, 0, (1 - tokens) * per_token, (burst - tokens) * per_token
+	end
+	tokens = tokens - 1
+	return {tokens, now, 0}, true, floor(tokens), 0, (burst - tokens) * per_token
+end
+`
+	slidingWindowLua = `
+local function reset_after(cur, prev, period, elapsed)
+	if cur > 0 then return 2 * period - elapsed end
+	if prev > 0 then return period - elapsed end
+	return 0
+end
+
+local function step(s, found, now, rate, period, burst)
+	local window = math.floor(now / period)
+	local elapsed = now - window * period
+	local cur, prev = 0, 0
+	if found and s[1] == window then
+		cur, prev = s[2], s[3]
+	elseif found and s[1] == window - 1 then
+		prev = s[2]
+	end
+	local estimate = prev * (period - elapsed) / period + cur
+	if estimate + 1 > rate then
+		local retry
+		if cur + 1 <= rate and prev > 0 then
+			retry = period * (1 - (rate - cur - 1) / prev) - elapsed
+		else
+			local wait = 0
+			if cur > 0 then wait = math.max(0, period * (1 - (rate - 1) / cur)) end
+			retry = period - elapsed + wait
+		end
+		return s, false, 0, retry, reset_after(cur, prev, period, elapsed)
+	end
+	cur = cur + 1
+	return {window, cur, prev}, true, floor(rate - estimate - 1), 0, reset_after(cur, prev, period, elapsed)
+end
+`
+	// KEYS contains key of every limit and ARGV rate, period in microseconds and burst of every limit.
+	// State is stored only when all limits allow the request.
+	allowLua = `
+local function floor(v)
+	return math.floor(v + 1e-6)
+end
+%s
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local allowed = true
+local states = {}
+local out = {}
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 3 - 2])
+	local period = tonumber(ARGV[i * 3 - 1])
+	local burst = tonumber(ARGV[i * 3])
+	local v = redis.call("HMGET", key, "a", "b", "c")
+	local s = {tonumber(v[1]) or 0, tonumber(v[2]) or 0, tonumber(v[3]) or 0}
+	local ns, ok, remaining, retry, reset = step(s, v[1] ~= false, now, rate, period, burst)
+	allowed = allowed and ok
+	states[i] = {ns, reset}
+	out[#out + 1] = ok and 1 or 0
+	out[#out + 1] = remaining
+	out[#out + 1] = math.ceil(retry)
+	out[#out + 1] = math.ceil(reset)
+end
+if allowed then
+	for i, key in ipairs(KEYS) do
+		local ns = states[i][1]
+		redis.call("HSET", key,
+			"a", string.format("%%.17g", ns[1]),
+			"b", string.format("%%.17g", ns[2]),
+			"c", string.format("%%.17g", ns[3]))
+		redis.call("PEXPIRE", key, math.max(1, math.ceil(states[i][2] / 1000)))
+	end
+end
+return out
+`
+)
+
+var scripts = map[Algorithm]*redis.Script{
+	GCRA:          redis.NewScript(fmt.Sprintf(allowLua, gcraLua)),
+	TokenBucket:   redis.NewScript(fmt.Sprintf(allowLua, tokenBucketLua)),
+	SlidingWindow: redis.NewScript(fmt.Sprintf(allowLua, slidingWindowLua)),
+}
+
+// RedisLimiter keeps limits in Redis so they are shared by all instances of the service.
+// Every call is a single atomic script execution.
+type RedisLimiter struct {
+	rdb    redis.UniversalClient
+	cfg    *config
+	script *redis.Script
+}
+
+// NewRedisLimiter creates RedisLimiter. Allow returns ErrNilClient if rdb is nil.
+func NewRedisLimiter(rdb redis.UniversalClient, opts ...Opt) (*RedisLimiter, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisLimiter{rdb: rdb, cfg: cfg, script: scripts[cfg.algorithm]}, nil
+}
+
+// Allow implements Limiter.
+func (r *RedisLimiter) Allow(ctx context.Context, key string, limits ...Limit) (Result, error) {
+	if r.rdb == nil {
+		return Result{}, ErrNilClient
+	}
+	if len(limits) == 0 {
+		return Result{Allowed: true}, nil
+	}
+	if err := validateLimits(limits); err != nil {
+		return Result{}, err
+	}
+
+	keys := make([]string, 0, len(limits))
+	args := make([]any, 0, 3*len(limits))
+	for _, l := range limits {
+		keys = append(keys, r.cfg.key(key, l))
+		args = append(args, l.Rate, l.Period.Microseconds(), l.burst())
+	}
+	out, err := r.script.Run(ctx, r.rdb, keys, args...).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(out) != 4*len(limits) {
+		return Result{}, fmt.Errorf("rate limit script returned %d values for %d limits", len(out), len(limits))
+	}
+	results := make([]limitResult, len(limits))
+	for i := range results {
+		v := out[4*i : 4*i+4]
+		results[i] = limitResult{
+			allowed:   v[0] == 1,
+			remaining: int(v[1]),
+			retry:     float64(v[2]),
+			reset:     float64(v[3]),
+		}
+	}
+	return combine(limits, results), nil
+}
@@ -0,0 +1,61 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// MemoryStore keeps sessions in process memory. Sessions are lost on restart and
+// aren't shared between replicas so it is meant for testing and single instance services.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]memoryEntry{}}
+}
+
+// LoadSession returns data of the session.
+func (s *MemoryStore) LoadSession(_ context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[id]
+	if !ok || time.Now().After(e.expiresAt) {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), e.data...), nil
+}
+
+// SaveSession creates or replaces the session. Expired sessions are removed at most once a minute.
+func (s *MemoryStore) SaveSession(_ context.Context, id string, data []byte, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.sessions {
+			if now.After(e.expiresAt) {
+				delete(s.sessions, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.sessions[id] = memoryEntry{data: append([]byte(nil), data...), expiresAt: expiresAt}
+	return nil
+}
+
+// DeleteSession removes the session.
+func (s *MemoryStore) DeleteSession(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
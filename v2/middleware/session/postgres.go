@@ -0,0 +1,74 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elisasre/go-common/v2/sqlxutil"
+)
+
+// PostgresSchema contains table required by PostgresStore.
+const PostgresSchema = `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id text PRIMARY KEY,
+		data bytea NOT NULL,
+		expires_at timestamp with time zone NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);`
+
+// PostgresStore keeps sessions in sessions table, see PostgresSchema.
+type PostgresStore struct {
+	db *sqlxutil.DB
+}
+
+// NewPostgresStore creates PostgresStore. Expired rows are not returned but
+// DeleteExpired should be called periodically to remove them.
+func NewPostgresStore(db *sqlxutil.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// LoadSession returns data of the session.
+func (s *PostgresStore) LoadSession(ctx context.Context, id string) ([]byte, error) {
+	const query = `SELECT data FROM sessions WHERE id = $1 AND expires_at > now()`
+	var data []byte
+	if err := s.db.GetContext(ctx, &data, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("selecting session failed: %w", err)
+	}
+	return data, nil
+}
+
+// SaveSession creates or replaces the session.
+func (s *PostgresStore) SaveSession(ctx context.Context, id string, data []byte, expiresAt time.Time) error {
+	const query = `
+		INSERT INTO sessions (id, data, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at`
+	if _, err := s.db.ExecContext(ctx, query, id, data, expiresAt); err != nil {
+		return fmt.Errorf("saving session failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession removes the session.
+func (s *PostgresStore) DeleteSession(ctx context.Context, id string) error {
+	const query = `DELETE FROM sessions WHERE id = $1`
+	if _, err := s.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("deleting session failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired removes expired sessions and returns how many were removed.
+func (s *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+	const query = `DELETE FROM sessions WHERE expires_at <= now()`
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired sessions failed: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps sessions in Redis, keys expire together with sessions.
+type RedisStore struct {
+	rdb    redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore creates RedisStore. Keys are prefixed with given prefix, defaults to "session:" when empty.
+func NewRedisStore(rdb redis.UniversalClient, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "session:"
+	}
+	return &RedisStore{rdb: rdb, prefix: prefix}
+}
+
+// LoadSession returns data of the session.
+func (s *RedisStore) LoadSession(ctx context.Context, id string) ([]byte, error) {
+	data, err := s.rdb.Get(ctx, s.prefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting session from redis failed: %w", err)
+	}
+	return data, nil
+}
+
+// SaveSession creates or replaces the session.
+func (s *RedisStore) SaveSession(ctx context.Context, id string, data []byte, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return s.DeleteSession(ctx, id)
+	}
+	if err := s.rdb.Set(ctx, s.prefix+id, data, ttl).Err(); err != nil {
+		return fmt.Errorf("setting session to redis failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession removes the session.
+func (s *RedisStore) DeleteSession(ctx context.Context, id string) error {
+	if err := s.rdb.Del(ctx, s.prefix+id).Err(); err != nil {
+		return fmt.Errorf("deleting session from redis failed: %w", err)
+	}
+	return nil
+}
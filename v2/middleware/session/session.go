@@ -0,0 +1,488 @@
+// Package session provides gin middleware for browser sessions. Session data is kept either in
+// the cookie itself or in server-side Store, in both cases the cookie is encrypted and authenticated
+// with secretbox so keys can be rotated by adding new primary key and keeping old ones for decryption.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/elisasre/go-common/v2/httputil"
+	"github.com/elisasre/go-common/v2/secretbox"
+	"github.com/gin-gonic/gin"
+)
+
+// maxCookieSize is the smallest cookie size limit browsers are required to support.
+const maxCookieSize = 4096
+
+var (
+	ErrMissingBox     = errors.New("missing secretbox for encrypting cookies")
+	ErrNotFound       = errors.New("session not found")
+	ErrCookieTooLarge = errors.New("session cookie is too large, use server-side store")
+)
+
+// Store keeps session data on server side. Implementations only see hashed session IDs.
+type Store interface {
+	// LoadSession returns data of the session or ErrNotFound if it doesn't exist or has expired.
+	LoadSession(ctx context.Context, id string) ([]byte, error)
+	// SaveSession creates or replaces the session.
+	SaveSession(ctx context.Context, id string, data []byte, expiresAt time.Time) error
+	// DeleteSession removes the session, deleting missing session is not an error.
+	DeleteSession(ctx context.Context, id string) error
+}
+
+// Manager loads and saves sessions.
+type Manager struct {
+	box         *secretbox.Box
+	store       Store
+	cookieName  string
+	path        string
+	domain      string
+	secure      bool
+	sameSite    http.SameSite
+	idleTimeout time.Duration
+	absTimeout  time.Duration
+}
+
+type Opt func(*Manager)
+
+// WithStore keeps session data in store and only session ID in the cookie.
+// By default all data is kept in the cookie which limits its size to about 3 kilobytes.
+func WithStore(s Store) Opt {
+	return func(m *Manager) {
+		m.store = s
+	}
+}
+
+// WithCookieName sets name of the session cookie, defaults to "session".
+func WithCookieName(name string) Opt {
+	return func(m *Manager) {
+		m.cookieName = name
+	}
+}
+
+// WithCookiePath sets path attribute of the cookie, defaults to "/".
+func WithCookiePath(path string) Opt {
+	return func(m *Manager) {
+		m.path = path
+	}
+}
+
+// WithCookieDomain sets domain attribute of the cookie, by default cookie is sent only to the origin host.
+func WithCookieDomain(domain string) Opt {
+	return func(m *Manager) {
+		m.domain = domain
+	}
+}
+
+// WithSameSite sets SameSite attribute of the cookie, defaults to http.SameSiteLaxMode.
+func WithSameSite(s http.SameSite) Opt {
+	return func(m *Manager) {
+		m.sameSite = s
+	}
+}
+
+// WithInsecureCookies allows cookies over plain HTTP, it should be used only in local development.
+func WithInsecureCookies() Opt {
+	return func(m *Manager) {
+		m.secure = false
+	}
+}
+
+// WithIdleTimeout sets how long session is valid without requests, defaults to 30 minutes.
+func WithIdleTimeout(d time.Duration) Opt {
+	return func(m *Manager) {
+		m.idleTimeout = d
+	}
+}
+
+// WithAbsoluteTimeout sets maximum lifetime of session regardless of activity, defaults to 12 hours.
+func WithAbsoluteTimeout(d time.Duration) Opt {
+	return func(m *Manager) {
+		m.absTimeout = d
+	}
+}
+
+// New creates Manager. Box encrypts the cookies, use multiple keys for rotation.
+func New(box *secretbox.Box, opts ...Opt) (*Manager, error) {
+	if box == nil {
+		return nil, ErrMissingBox
+	}
+	m := &Manager{
+		box:         box,
+		cookieName:  "session",
+		path:        "/",
+		secure:      true,
+		sameSite:    http.SameSiteLaxMode,
+		idleTimeout: 30 * time.Minute,
+		absTimeout:  12 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
+}
+
+type contextKey struct{}
+
+// FromContext returns session stored by Manager.Middleware.
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(contextKey{}).(*Session)
+	return s, ok
+}
+
+// Middleware loads session of the request and saves it before response headers are written.
+// Invalid and expired sessions are replaced with a new empty session. New sessions are saved
+// only if they are modified so anonymous requests don't create sessions.
+func (m *Manager) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		s, err := m.load(ctx, c.Request)
+		if err != nil {
+			ctxlog.Error(ctx, "loading session failed", slog.String("error", err.Error()))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, httputil.ErrorResponse{
+				Code:    http.StatusInternalServerError,
+				Message: "internal server error",
+			})
+			return
+		}
+
+		w := &writer{ResponseWriter: c.Writer}
+		w.save = func() {
+			if err := m.save(ctx, w.ResponseWriter, s); err != nil {
+				ctxlog.Error(ctx, "saving session failed", slog.String("error", err.Error()))
+			}
+		}
+		c.Writer = w
+		c.Request = c.Request.WithContext(context.WithValue(ctx, contextKey{}, s))
+		c.Next()
+		w.flushSession()
+	}
+}
+
+func (m *Manager) load(ctx context.Context, r *http.Request) (*Session, error) {
+	now := time.Now()
+	s, err := m.readCookie(r)
+	if err != nil {
+		ctxlog.Debug(ctx, "ignoring invalid session cookie", slog.String("error", err.Error()))
+	}
+	if s != nil && m.store != nil {
+		data, err := m.store.LoadSession(ctx, storeKey(s.id))
+		switch {
+		case errors.Is(err, ErrNotFound):
+			s = nil
+		case err != nil:
+			return nil, fmt.Errorf("loading session failed: %w", err)
+		default:
+			if err := json.Unmarshal(data, &s.data); err != nil {
+				return nil, fmt.Errorf("decoding session failed: %w", err)
+			}
+		}
+	}
+	if s != nil && m.expired(s, now) {
+		if m.store != nil {
+			if err := m.store.DeleteSession(ctx, storeKey(s.id)); err != nil {
+				return nil, fmt.Errorf("deleting expired session failed: %w", err)
+			}
+		}
+		s = nil
+	}
+	if s != nil {
+		return s, nil
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		id:   id,
+		data: record{CreatedAt: now, LastSeenAt: now},
+		new:  true,
+	}, nil
+}
+
+// cookieValue is the cookie payload when data is kept in the cookie.
+type cookieValue struct {
+	ID   string `json:"id"`
+	Data record `json:"data"`
+}
+
+// readCookie decrypts the cookie. With server-side store only session ID is returned.
+func (m *Manager) readCookie(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.cookieName)
+	if errors.Is(err, http.ErrNoCookie) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("decoding cookie failed: %w", err)
+	}
+	payload, err := m.box.Open(sealed, []byte(m.cookieName))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting cookie failed: %w", err)
+	}
+	if m.store != nil {
+		return &Session{id: string(payload)}, nil
+	}
+
+	var v cookieValue
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil, fmt.Errorf("decoding session failed: %w", err)
+	}
+	return &Session{id: v.ID, data: v.Data}, nil
+}
+
+func (m *Manager) expired(s *Session, now time.Time) bool {
+	return now.After(m.expiresAt(s))
+}
+
+func (m *Manager) expiresAt(s *Session) time.Time {
+	idle := s.data.LastSeenAt.Add(m.idleTimeout)
+	abs := s.data.CreatedAt.Add(m.absTimeout)
+	if idle.Before(abs) {
+		return idle
+	}
+	return abs
+}
+
+// touchInterval limits how often unmodified sessions are saved to extend idle timeout.
+func (m *Manager) touchInterval() time.Duration {
+	return min(time.Minute, m.idleTimeout/4)
+}
+
+func (m *Manager) save(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	if m.store != nil && s.oldID != "" {
+		if err := m.store.DeleteSession(ctx, storeKey(s.oldID)); err != nil {
+			return fmt.Errorf("deleting previous session failed: %w", err)
+		}
+	}
+	if s.destroyed {
+		if m.store != nil {
+			if err := m.store.DeleteSession(ctx, storeKey(s.id)); err != nil {
+				return fmt.Errorf("deleting session failed: %w", err)
+			}
+		}
+		m.setCookie(w, "", -1, time.Time{})
+		return nil
+	}
+
+	now := time.Now()
+	if !s.dirty && (s.new || now.Sub(s.data.LastSeenAt) < m.touchInterval()) {
+		return nil
+	}
+	s.data.LastSeenAt = now
+	expiresAt := m.expiresAt(s)
+
+	var payload []byte
+	if m.store == nil {
+		data, err := json.Marshal(cookieValue{ID: s.id, Data: s.data})
+		if err != nil {
+			return fmt.Errorf("encoding session failed: %w", err)
+		}
+		payload = data
+	} else {
+		data, err := json.Marshal(s.data)
+		if err != nil {
+			return fmt.Errorf("encoding session failed: %w", err)
+		}
+		if err := m.store.SaveSession(ctx, storeKey(s.id), data, expiresAt); err != nil {
+			return fmt.Errorf("saving session failed: %w", err)
+		}
+		payload = []byte(s.id)
+	}
+
+	sealed, err := m.box.Seal(payload, []byte(m.cookieName))
+	if err != nil {
+		return fmt.Errorf("encrypting session failed: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(sealed)
+	if len(value) > maxCookieSize {
+		return fmt.Errorf("%w: %d bytes", ErrCookieTooLarge, len(value))
+	}
+	m.setCookie(w, value, int(time.Until(expiresAt).Seconds()), expiresAt)
+	return nil
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, value string, maxAge int, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName,
+		Value:    value,
+		Path:     m.path,
+		Domain:   m.domain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   m.secure,
+		HttpOnly: true,
+		SameSite: m.sameSite,
+	})
+}
+
+// storeKey hashes session ID so leaked store contents can't be used as session cookies.
+func storeKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating session id failed: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// record is the persisted session data.
+type record struct {
+	Values     map[string]string `json:"values,omitempty"`
+	Flashes    []string          `json:"flashes,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	LastSeenAt time.Time         `json:"last_seen_at"`
+}
+
+// Session is the session of a single request, it is not safe for concurrent use.
+type Session struct {
+	id        string
+	oldID     string
+	data      record
+	new       bool
+	dirty     bool
+	destroyed bool
+}
+
+// ID returns the session identifier.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IsNew reports whether session was created for this request.
+func (s *Session) IsNew() bool {
+	return s.new
+}
+
+// CreatedAt returns when session was created, absolute timeout is counted from it.
+func (s *Session) CreatedAt() time.Time {
+	return s.data.CreatedAt
+}
+
+// Get returns value of the key.
+func (s *Session) Get(key string) (string, bool) {
+	v, ok := s.data.Values[key]
+	return v, ok
+}
+
+// Values returns copy of all values.
+func (s *Session) Values() map[string]string {
+	return maps.Clone(s.data.Values)
+}
+
+// Set sets value of the key.
+func (s *Session) Set(key, value string) {
+	if s.data.Values == nil {
+		s.data.Values = map[string]string{}
+	}
+	s.data.Values[key] = value
+	s.dirty = true
+}
+
+// Delete removes the key.
+func (s *Session) Delete(key string) {
+	if _, ok := s.data.Values[key]; ok {
+		delete(s.data.Values, key)
+		s.dirty = true
+	}
+}
+
+// AddFlash adds message which is shown once on a following request.
+func (s *Session) AddFlash(msg string) {
+	s.data.Flashes = append(s.data.Flashes, msg)
+	s.dirty = true
+}
+
+// Flashes returns and removes pending flash messages.
+func (s *Session) Flashes() []string {
+	flashes := s.data.Flashes
+	if len(flashes) > 0 {
+		s.data.Flashes = nil
+		s.dirty = true
+	}
+	return flashes
+}
+
+// Regenerate changes session ID while keeping its data. It must be called whenever privileges
+// change, for example on login. With WithStore the data under the old ID is deleted so session ID
+// known before the change can't be used after it. Without store the data lives in the cookie and
+// a copy of the old cookie stays valid until it expires by WithIdleTimeout or WithAbsoluteTimeout,
+// so use a store when that matters.
+func (s *Session) Regenerate() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if s.oldID == "" && !s.new {
+		s.oldID = s.id
+	}
+	s.id = id
+	s.dirty = true
+	return nil
+}
+
+// Destroy removes all data of the session and clears the cookie, for example on logout.
+func (s *Session) Destroy() {
+	s.data.Values = nil
+	s.data.Flashes = nil
+	s.destroyed = true
+}
+
+// writer saves session when handler starts writing the response, after that cookies can't be set.
+type writer struct {
+	gin.ResponseWriter
+	save  func()
+	saved bool
+}
+
+func (w *writer) flushSession() {
+	if !w.saved {
+		w.saved = true
+		w.save()
+	}
+}
+
+func (w *writer) WriteHeader(code int) {
+	w.flushSession()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *writer) WriteHeaderNow() {
+	w.flushSession()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	w.flushSession()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	w.flushSession()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *writer) Flush() {
+	w.flushSession()
+	w.ResponseWriter.Flush()
+}
@@ -0,0 +1,242 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/middleware/session"
+	"github.com/elisasre/go-common/v2/secretbox"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func newBox(t *testing.T, ids ...string) *secretbox.Box {
+	t.Helper()
+	opts := []secretbox.Opt{}
+	for _, id := range ids {
+		opts = append(opts, secretbox.WithKey(id, []byte(strings.Repeat(id, secretbox.KeySize)[:secretbox.KeySize])))
+	}
+	// last key is the primary so older keys are only used for decryption
+	opts = append(opts, secretbox.WithPrimary(ids[len(ids)-1]))
+	box, err := secretbox.New(opts...)
+	require.NoError(t, err)
+	return box
+}
+
+func setupRouter(t *testing.T, opts ...session.Opt) *gin.Engine {
+	t.Helper()
+	return setupRouterWithBox(t, newBox(t, "1"), opts...)
+}
+
+func setupRouterWithBox(t *testing.T, box *secretbox.Box, opts ...session.Opt) *gin.Engine {
+	t.Helper()
+	m, err := session.New(box, append([]session.Opt{session.WithInsecureCookies()}, opts...)...)
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/get", func(c *gin.Context) {
+		s, _ := session.FromContext(c.Request.Context())
+		v, _ := s.Get("user")
+		c.String(http.StatusOK, v)
+	})
+	r.POST("/login", func(c *gin.Context) {
+		s, _ := session.FromContext(c.Request.Context())
+		if err := s.Regenerate(); err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		s.Set("user", c.Query("user"))
+		s.AddFlash("welcome " + c.Query("user"))
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/flashes", func(c *gin.Context) {
+		s, _ := session.FromContext(c.Request.Context())
+		c.JSON(http.StatusOK, s.Flashes())
+	})
+	r.POST("/logout", func(c *gin.Context) {
+		s, _ := session.FromContext(c.Request.Context())
+		s.Destroy()
+		c.Redirect(http.StatusFound, "/")
+	})
+	return r
+}
+
+func do(r http.Handler, method, path string, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	req := httptest.NewRequest(method, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session" {
+			return w, c
+		}
+	}
+	return w, nil
+}
+
+func TestCookieSession(t *testing.T) {
+	testSession(t, setupRouter(t))
+}
+
+func TestStoreSession(t *testing.T) {
+	store := session.NewMemoryStore()
+	r := setupRouter(t, session.WithStore(store))
+	cookie := testSession(t, r)
+
+	// cookie only contains encrypted session ID
+	require.Less(t, len(cookie.Value), 200)
+}
+
+func testSession(t *testing.T, r *gin.Engine) *http.Cookie {
+	t.Helper()
+
+	// anonymous requests don't create sessions
+	w, cookie := do(r, http.MethodGet, "/get", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, cookie)
+
+	w, cookie = do(r, http.MethodPost, "/login?user=alice", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.NotNil(t, cookie)
+	require.True(t, cookie.HttpOnly)
+	require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	w, _ = do(r, http.MethodGet, "/get", cookie)
+	require.Equal(t, "alice", w.Body.String())
+
+	// flashes are shown once
+	w, next := do(r, http.MethodGet, "/flashes", cookie)
+	require.JSONEq(t, `["welcome alice"]`, w.Body.String())
+	require.NotNil(t, next)
+	w, _ = do(r, http.MethodGet, "/flashes", next)
+	require.JSONEq(t, `null`, w.Body.String())
+	cookie = next
+
+	w, cleared := do(r, http.MethodPost, "/logout", cookie)
+	require.Equal(t, http.StatusFound, w.Code)
+	require.NotNil(t, cleared)
+	require.Empty(t, cleared.Value)
+	require.Negative(t, cleared.MaxAge)
+	return cookie
+}
+
+func TestRegenerate(t *testing.T) {
+	r := setupRouter(t, session.WithStore(session.NewMemoryStore()))
+
+	_, first := do(r, http.MethodPost, "/login?user=alice", nil)
+	require.NotNil(t, first)
+	_, second := do(r, http.MethodPost, "/login?user=bob", first)
+	require.NotNil(t, second)
+
+	w, _ := do(r, http.MethodGet, "/get", second)
+	require.Equal(t, "bob", w.Body.String())
+	// session ID from before the privilege change is no longer valid
+	w, _ = do(r, http.MethodGet, "/get", first)
+	require.Empty(t, w.Body.String())
+}
+
+func TestDestroyDeletesStoredSession(t *testing.T) {
+	r := setupRouter(t, session.WithStore(session.NewMemoryStore()))
+
+	_, cookie := do(r, http.MethodPost, "/login?user=alice", nil)
+	do(r, http.MethodPost, "/logout", cookie)
+
+	// replaying the cookie doesn't restore the session
+	w, _ := do(r, http.MethodGet, "/get", cookie)
+	require.Empty(t, w.Body.String())
+}
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []session.Opt
+	}{
+		{name: "idle", opts: []session.Opt{session.WithIdleTimeout(100 * time.Millisecond)}},
+		{name: "absolute", opts: []session.Opt{session.WithAbsoluteTimeout(100 * time.Millisecond)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, store := range []session.Opt{nil, session.WithStore(session.NewMemoryStore())} {
+				opts := tt.opts
+				if store != nil {
+					opts = append(opts, store)
+				}
+				r := setupRouter(t, opts...)
+				_, cookie := do(r, http.MethodPost, "/login?user=alice", nil)
+				w, _ := do(r, http.MethodGet, "/get", cookie)
+				require.Equal(t, "alice", w.Body.String())
+
+				time.Sleep(150 * time.Millisecond)
+				w, _ = do(r, http.MethodGet, "/get", cookie)
+				require.Empty(t, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIdleTimeoutExtendedByActivity(t *testing.T) {
+	r := setupRouter(t, session.WithIdleTimeout(200*time.Millisecond))
+	_, cookie := do(r, http.MethodPost, "/login?user=alice", nil)
+
+	for range 4 {
+		time.Sleep(80 * time.Millisecond)
+		w, next := do(r, http.MethodGet, "/get", cookie)
+		require.Equal(t, "alice", w.Body.String())
+		require.NotNil(t, next, "unmodified session must be touched to extend idle timeout")
+		cookie = next
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := setupRouterWithBox(t, newBox(t, "1"))
+	_, cookie := do(old, http.MethodPost, "/login?user=alice", nil)
+
+	rotated := setupRouterWithBox(t, newBox(t, "1", "2"))
+	w, _ := do(rotated, http.MethodGet, "/get", cookie)
+	require.Equal(t, "alice", w.Body.String())
+
+	// after old key is removed its cookies are no longer accepted
+	removed := setupRouterWithBox(t, newBox(t, "2"))
+	w, _ = do(removed, http.MethodGet, "/get", cookie)
+	require.Empty(t, w.Body.String())
+}
+
+func TestTamperedCookie(t *testing.T) {
+	r := setupRouter(t)
+	_, cookie := do(r, http.MethodPost, "/login?user=alice", nil)
+	cookie.Value = cookie.Value[:len(cookie.Value)-4] + "AAAA"
+
+	w, _ := do(r, http.MethodGet, "/get", cookie)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Body.String())
+}
+
+type failingStore struct{ *session.MemoryStore }
+
+func (failingStore) LoadSession(context.Context, string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestStoreError(t *testing.T) {
+	r := setupRouter(t, session.WithStore(session.NewMemoryStore()))
+	_, cookie := do(r, http.MethodPost, "/login?user=alice", nil)
+
+	// store failure must not silently log users out
+	r = setupRouter(t, session.WithStore(failingStore{session.NewMemoryStore()}))
+	w, _ := do(r, http.MethodGet, "/get", cookie)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestNew(t *testing.T) {
+	_, err := session.New(nil)
+	require.ErrorIs(t, err, session.ErrMissingBox)
+}
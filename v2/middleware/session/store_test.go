@@ -0,0 +1,87 @@
+package session_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/elisasre/go-common/v2/middleware/session"
+	"github.com/elisasre/go-common/v2/sqlxutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	postgrestc "github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func runStoreSuite(t *testing.T, store session.Store, expire func(time.Duration)) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := store.LoadSession(ctx, "missing")
+	require.ErrorIs(t, err, session.ErrNotFound)
+	require.NoError(t, store.DeleteSession(ctx, "missing"))
+
+	require.NoError(t, store.SaveSession(ctx, "a", []byte(`{"v":1}`), time.Now().Add(time.Hour)))
+	data, err := store.LoadSession(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"v":1}`), data)
+
+	require.NoError(t, store.SaveSession(ctx, "a", []byte(`{"v":2}`), time.Now().Add(2*time.Second)))
+	data, err = store.LoadSession(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"v":2}`), data)
+
+	expire(3 * time.Second)
+	_, err = store.LoadSession(ctx, "a")
+	require.ErrorIs(t, err, session.ErrNotFound)
+
+	require.NoError(t, store.SaveSession(ctx, "b", []byte(`{}`), time.Now().Add(time.Hour)))
+	require.NoError(t, store.DeleteSession(ctx, "b"))
+	_, err = store.LoadSession(ctx, "b")
+	require.ErrorIs(t, err, session.ErrNotFound)
+}
+
+func TestMemoryStore(t *testing.T) {
+	runStoreSuite(t, session.NewMemoryStore(), func(d time.Duration) { time.Sleep(d) })
+}
+
+func TestRedisStore(t *testing.T) {
+	s, err := miniredis.Run()
+	require.NoError(t, err)
+	defer s.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	runStoreSuite(t, session.NewRedisStore(rdb, ""), s.FastForward)
+	require.Empty(t, s.Keys())
+}
+
+func TestPostgresStore(t *testing.T) {
+	ctx := context.Background()
+	postgresContainer, err := postgrestc.Run(ctx,
+		"postgres:16",
+		postgrestc.WithDatabase("postgres"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(5*time.Second)),
+	)
+	require.NoError(t, err)
+
+	dsn, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+	db, err := sqlx.Open("postgres", dsn)
+	require.NoError(t, err)
+	_, err = db.Exec(session.PostgresSchema)
+	require.NoError(t, err)
+
+	store := session.NewPostgresStore(sqlxutil.Instrument(db))
+	runStoreSuite(t, store, func(d time.Duration) { time.Sleep(d) })
+
+	require.NoError(t, store.SaveSession(ctx, "expired", []byte(`{}`), time.Now().Add(-time.Minute)))
+	n, err := store.DeleteExpired(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+}
@@ -0,0 +1,98 @@
+package secretbox
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	kdfNone     byte = 0
+	kdfArgon2id byte = 1
+	kdfScrypt   byte = 2
+
+	saltSize        = 16
+	kdfParamsSize   = 9
+	maxArgonMemory  = 1024 * 1024 // 1 GiB
+	maxArgonTime    = 16
+	maxArgonThreads = 64
+	maxScryptLogN   = 22
+	maxScryptR      = 32
+	maxScryptP      = 16
+)
+
+// KDF derives encryption keys from passphrases. Parameters are stored in the ciphertext header,
+// to change them add a new key with the new parameters and keep the old one for decrypting.
+type KDF interface {
+	id() byte
+	encode() []byte
+	validate() error
+	derive(passphrase, salt []byte) ([]byte, error)
+}
+
+// Argon2id is the recommended KDF for passphrases, Memory is in KiB.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultArgon2id follows the second recommended option of RFC 9106.
+var DefaultArgon2id = Argon2id{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+func (k Argon2id) id() byte { return kdfArgon2id }
+
+func (k Argon2id) encode() []byte {
+	b := binary.BigEndian.AppendUint32(nil, k.Time)
+	b = binary.BigEndian.AppendUint32(b, k.Memory)
+	return append(b, k.Threads)
+}
+
+func (k Argon2id) validate() error {
+	if k.Time == 0 || k.Time > maxArgonTime || k.Memory == 0 || k.Memory > maxArgonMemory ||
+		k.Threads == 0 || k.Threads > maxArgonThreads {
+		return fmt.Errorf("%w: invalid argon2id parameters", ErrInvalidParams)
+	}
+	return nil
+}
+
+func (k Argon2id) derive(passphrase, salt []byte) ([]byte, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey(passphrase, salt, k.Time, k.Memory, k.Threads, KeySize), nil
+}
+
+// Scrypt can be used where Argon2id isn't allowed, N is 2^LogN.
+type Scrypt struct {
+	LogN uint8
+	R    uint32
+	P    uint32
+}
+
+// DefaultScrypt uses parameters recommended for interactive logins in 2017, N=32768 r=8 p=1.
+var DefaultScrypt = Scrypt{LogN: 15, R: 8, P: 1}
+
+func (k Scrypt) id() byte { return kdfScrypt }
+
+func (k Scrypt) encode() []byte {
+	b := []byte{k.LogN}
+	b = binary.BigEndian.AppendUint32(b, k.R)
+	return binary.BigEndian.AppendUint32(b, k.P)
+}
+
+func (k Scrypt) validate() error {
+	if k.LogN == 0 || k.LogN > maxScryptLogN || k.R == 0 || k.R > maxScryptR || k.P == 0 || k.P > maxScryptP {
+		return fmt.Errorf("%w: invalid scrypt parameters", ErrInvalidParams)
+	}
+	return nil
+}
+
+func (k Scrypt) derive(passphrase, salt []byte) ([]byte, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	return scrypt.Key(passphrase, salt, 1<<k.LogN, int(k.R), int(k.P), KeySize)
+}
@@ -0,0 +1,31 @@
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5" //nolint:gosec // needed for decrypting legacy values
+	"encoding/hex"
+	"fmt"
+)
+
+// legacyDecrypt decrypts output of auth.Encrypt: AES-256-GCM keyed with hex encoded MD5 of passphrase,
+// nonce prepended to ciphertext.
+func legacyDecrypt(data []byte, passphrase string) ([]byte, error) {
+	sum := md5.Sum([]byte(passphrase)) //nolint:gosec // needed for decrypting legacy values
+	block, err := aes.NewCipher([]byte(hex.EncodeToString(sum[:])))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,318 @@
+// Package secretbox provides versioned authenticated encryption for secrets at rest.
+//
+// Ciphertexts start with a header containing format version, key ID and KDF parameters,
+// so keys can be rotated, for example to increase KDF costs, without breaking existing data.
+// Data is encrypted with XChaCha20-Poly1305, the header and optional associated data are authenticated.
+// Output of deprecated auth.Encrypt can still be decrypted using WithLegacyPassphrase.
+package secretbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// Version is the current format version.
+	Version byte = 1
+	// KeySize is the size of raw keys.
+	KeySize = chacha20poly1305.KeySize
+	// SaltSize is the size of salts used with passphrases.
+	SaltSize = saltSize
+
+	magic      = "SBX"
+	flagStream = byte(1)
+	// fixedHeaderSize covers magic, version, flags, kdf and key ID length.
+	fixedHeaderSize = len(magic) + 4
+	maxKeyIDSize    = 255
+)
+
+var (
+	ErrInvalidCiphertext  = errors.New("invalid ciphertext")
+	ErrUnsupportedVersion = errors.New("unsupported secretbox version")
+	ErrUnknownKey         = errors.New("unknown key id")
+	ErrNoKeys             = errors.New("no keys configured")
+	ErrInvalidKey         = errors.New("invalid key")
+	ErrInvalidParams      = errors.New("invalid kdf parameters")
+)
+
+// key is raw or derived encryption key, kdf, salt and params are set for keys derived from passphrases.
+type key struct {
+	secret []byte
+	kdf    byte
+	salt   []byte
+	params []byte
+}
+
+// Box encrypts with its primary key and decrypts with any configured key.
+type Box struct {
+	keys    map[string]key
+	primary string
+	legacy  string
+}
+
+type Opt func(*Box) error
+
+// WithKey adds raw KeySize bytes long key. First added key is the primary key unless WithPrimary is used.
+func WithKey(id string, secret []byte) Opt {
+	return func(b *Box) error {
+		if len(secret) != KeySize {
+			return fmt.Errorf("%w: key %s must be %d bytes", ErrInvalidKey, id, KeySize)
+		}
+		return b.addKey(id, key{secret: secret})
+	}
+}
+
+// WithPassphrase adds key derived from passphrase and SaltSize bytes long salt using given KDF, see GenerateSalt.
+// Key is derived once when Box is created. Ciphertexts store the salt and KDF parameters, but Open accepts
+// only those configured here so that forged ciphertexts can't make Box derive keys with other parameters.
+// Changing the salt or KDF parameters therefore requires adding a new key and re-encrypting the data.
+func WithPassphrase(id, passphrase string, salt []byte, kdf KDF) Opt {
+	return func(b *Box) error {
+		if passphrase == "" {
+			return fmt.Errorf("%w: passphrase of %s must not be empty", ErrInvalidKey, id)
+		}
+		if len(salt) != SaltSize {
+			return fmt.Errorf("%w: salt of %s must be %d bytes", ErrInvalidKey, id, SaltSize)
+		}
+		if kdf == nil {
+			return fmt.Errorf("%w: kdf of %s must not be nil", ErrInvalidParams, id)
+		}
+		secret, err := kdf.derive([]byte(passphrase), salt)
+		if err != nil {
+			return err
+		}
+		return b.addKey(id, key{secret: secret, kdf: kdf.id(), salt: bytes.Clone(salt), params: kdf.encode()})
+	}
+}
+
+// WithPrimary selects key used for encryption.
+func WithPrimary(id string) Opt {
+	return func(b *Box) error {
+		b.primary = id
+		return nil
+	}
+}
+
+// WithLegacyPassphrase enables decrypting values encrypted with auth.Encrypt using given passphrase.
+// Legacy values don't support associated data, it's ignored for them.
+func WithLegacyPassphrase(passphrase string) Opt {
+	return func(b *Box) error {
+		b.legacy = passphrase
+		return nil
+	}
+}
+
+// New creates Box using given keys.
+func New(opts ...Opt) (*Box, error) {
+	b := &Box{keys: map[string]key{}}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+	if len(b.keys) == 0 && b.legacy == "" {
+		return nil, ErrNoKeys
+	}
+	if _, ok := b.keys[b.primary]; !ok && len(b.keys) > 0 {
+		return nil, fmt.Errorf("%w: primary '%s'", ErrUnknownKey, b.primary)
+	}
+	return b, nil
+}
+
+func (b *Box) addKey(id string, k key) error {
+	if len(id) > maxKeyIDSize {
+		return fmt.Errorf("%w: key id is longer than %d bytes", ErrInvalidKey, maxKeyIDSize)
+	}
+	if _, ok := b.keys[id]; ok {
+		return fmt.Errorf("%w: duplicate key id '%s'", ErrInvalidKey, id)
+	}
+	if len(b.keys) == 0 && b.primary == "" {
+		b.primary = id
+	}
+	b.keys[id] = k
+	return nil
+}
+
+// GenerateKey returns new random key for WithKey.
+func GenerateKey() ([]byte, error) {
+	k := make([]byte, KeySize)
+	if _, err := rand.Read(k); err != nil {
+		return nil, fmt.Errorf("generating key failed: %w", err)
+	}
+	return k, nil
+}
+
+// GenerateSalt returns new random salt for WithPassphrase.
+func GenerateSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating salt failed: %w", err)
+	}
+	return salt, nil
+}
+
+// Seal encrypts plaintext with primary key. Associated data is authenticated but not stored,
+// the same associated data must be given to Open.
+func (b *Box) Seal(plaintext, aad []byte) ([]byte, error) {
+	h, aead, err := b.sealHeader(0, chacha20poly1305.NonceSizeX)
+	if err != nil {
+		return nil, err
+	}
+	hb := h.marshal()
+	return aead.Seal(hb, h.nonce, plaintext, concat(hb, aad)), nil
+}
+
+// Open decrypts ciphertext created by Seal, or by auth.Encrypt if WithLegacyPassphrase is used.
+func (b *Box) Open(ciphertext, aad []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(magic)) {
+		if b.legacy != "" {
+			return legacyDecrypt(ciphertext, b.legacy)
+		}
+		return nil, ErrInvalidCiphertext
+	}
+
+	plaintext, err := b.open(ciphertext, aad)
+	if err != nil && b.legacy != "" {
+		// random nonce of legacy ciphertext might start with magic
+		if legacy, legacyErr := legacyDecrypt(ciphertext, b.legacy); legacyErr == nil {
+			return legacy, nil
+		}
+	}
+	return plaintext, err
+}
+
+func (b *Box) open(ciphertext, aad []byte) ([]byte, error) {
+	h, hb, err := readHeader(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	if h.flags&flagStream != 0 {
+		return nil, fmt.Errorf("%w: streamed ciphertext must be read with NewReader", ErrInvalidCiphertext)
+	}
+	aead, err := b.openAEAD(h)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, h.nonce, ciphertext[len(hb):], concat(hb, aad))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	}
+	return plaintext, nil
+}
+
+// KeyID returns ID of the key used for encrypting ciphertext, it can be used for finding values
+// which should be re-encrypted after rotating primary key. Legacy ciphertexts have empty ID.
+func KeyID(ciphertext []byte) (string, error) {
+	if !bytes.HasPrefix(ciphertext, []byte(magic)) {
+		return "", nil
+	}
+	h, _, err := readHeader(bytes.NewReader(ciphertext))
+	if err != nil {
+		return "", err
+	}
+	return h.kid, nil
+}
+
+// sealHeader creates header for new ciphertext with random nonce of given size.
+func (b *Box) sealHeader(flags byte, nonceSize int) (header, aeadCipher, error) {
+	k, ok := b.keys[b.primary]
+	if !ok {
+		return header{}, nil, ErrNoKeys
+	}
+	h := header{flags: flags, kdf: k.kdf, kid: b.primary, salt: k.salt, params: k.params, nonce: make([]byte, nonceSize)}
+	if _, err := rand.Read(h.nonce); err != nil {
+		return header{}, nil, fmt.Errorf("generating nonce failed: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(k.secret)
+	return h, aead, err
+}
+
+// openAEAD returns cipher for key described by header. Salt and KDF parameters of the header
+// must match the key, keys are never derived while decrypting.
+func (b *Box) openAEAD(h header) (aeadCipher, error) {
+	k, ok := b.keys[h.kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownKey, h.kid)
+	}
+	if h.kdf != k.kdf || !bytes.Equal(h.salt, k.salt) || !bytes.Equal(h.params, k.params) {
+		return nil, fmt.Errorf("%w: salt or kdf parameters of '%s' don't match ciphertext", ErrInvalidCiphertext, h.kid)
+	}
+	return chacha20poly1305.NewX(k.secret)
+}
+
+type aeadCipher interface {
+	NonceSize() int
+	Overhead() int
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// header is the unencrypted prefix of every ciphertext:
+// "SBX" | version | flags | kdf | key id length | key id | salt and kdf params (kdf only) | nonce.
+type header struct {
+	flags  byte
+	kdf    byte
+	kid    string
+	salt   []byte
+	params []byte
+	nonce  []byte
+}
+
+func (h header) marshal() []byte {
+	b := make([]byte, 0, fixedHeaderSize+len(h.kid)+len(h.salt)+len(h.params)+len(h.nonce))
+	b = append(b, magic...)
+	b = append(b, Version, h.flags, h.kdf, byte(len(h.kid)))
+	b = append(b, h.kid...)
+	b = append(b, h.salt...)
+	b = append(b, h.params...)
+	return append(b, h.nonce...)
+}
+
+// readHeader reads header from r and returns it along with its raw bytes.
+func readHeader(r io.Reader) (header, []byte, error) {
+	raw := make([]byte, fixedHeaderSize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return header{}, nil, fmt.Errorf("%w: reading header failed: %w", ErrInvalidCiphertext, err)
+	}
+	if !bytes.HasPrefix(raw, []byte(magic)) {
+		return header{}, nil, ErrInvalidCiphertext
+	}
+	if v := raw[len(magic)]; v != Version {
+		return header{}, nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	h := header{flags: raw[len(magic)+1], kdf: raw[len(magic)+2]}
+
+	rest := int(raw[len(magic)+3])
+	if h.kdf != kdfNone {
+		rest += saltSize + kdfParamsSize
+	}
+	nonceSize := chacha20poly1305.NonceSizeX
+	if h.flags&flagStream != 0 {
+		nonceSize = streamNoncePrefixSize
+	}
+	rest += nonceSize
+
+	tail := make([]byte, rest)
+	if _, err := io.ReadFull(r, tail); err != nil {
+		return header{}, nil, fmt.Errorf("%w: reading header failed: %w", ErrInvalidCiphertext, err)
+	}
+	raw = append(raw, tail...)
+
+	kidLen := int(raw[len(magic)+3])
+	h.kid, tail = string(tail[:kidLen]), tail[kidLen:]
+	if h.kdf != kdfNone {
+		h.salt, h.params, tail = tail[:saltSize], tail[saltSize:saltSize+kdfParamsSize], tail[saltSize+kdfParamsSize:]
+	}
+	h.nonce = tail
+	return h, raw, nil
+}
+
+// concat returns new slice containing a followed by b.
+func concat(a, b []byte) []byte {
+	return append(append(make([]byte, 0, len(a)+len(b)), a...), b...)
+}
@@ -0,0 +1,179 @@
+package secretbox_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/auth"
+	"github.com/elisasre/go-common/v2/secretbox"
+	"github.com/stretchr/testify/require"
+)
+
+// cheap parameters keep tests fast, they must not be used in production.
+var (
+	testArgon2id = secretbox.Argon2id{Time: 1, Memory: 64, Threads: 1}
+	testScrypt   = secretbox.Scrypt{LogN: 4, R: 8, P: 1}
+)
+
+var testSalt = []byte("0123456789abcdef")
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	k, err := secretbox.GenerateKey()
+	require.NoError(t, err)
+	return k
+}
+
+func TestSealOpen(t *testing.T) {
+	for name, opt := range map[string]secretbox.Opt{
+		"key":      secretbox.WithKey("k1", newKey(t)),
+		"argon2id": secretbox.WithPassphrase("p1", "passphrase", testSalt, testArgon2id),
+		"scrypt":   secretbox.WithPassphrase("s1", "passphrase", testSalt, testScrypt),
+	} {
+		t.Run(name, func(t *testing.T) {
+			box, err := secretbox.New(opt)
+			require.NoError(t, err)
+
+			plaintext := []byte("supersecret")
+			ciphertext, err := box.Seal(plaintext, []byte("row-1"))
+			require.NoError(t, err)
+			require.NotContains(t, string(ciphertext), string(plaintext))
+
+			again, err := box.Seal(plaintext, []byte("row-1"))
+			require.NoError(t, err)
+			require.NotEqual(t, ciphertext, again)
+
+			decrypted, err := box.Open(ciphertext, []byte("row-1"))
+			require.NoError(t, err)
+			require.Equal(t, plaintext, decrypted)
+
+			_, err = box.Open(ciphertext, []byte("row-2"))
+			require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext)
+
+			tampered := append([]byte(nil), ciphertext...)
+			tampered[len(tampered)-1] ^= 1
+			_, err = box.Open(tampered, []byte("row-1"))
+			require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext)
+
+			tampered = append([]byte(nil), ciphertext...)
+			tampered[3] = 99
+			_, err = box.Open(tampered, []byte("row-1"))
+			require.ErrorIs(t, err, secretbox.ErrUnsupportedVersion)
+		})
+	}
+}
+
+func TestPassphraseFromOtherBox(t *testing.T) {
+	box1, err := secretbox.New(secretbox.WithPassphrase("p1", "passphrase", testSalt, testArgon2id))
+	require.NoError(t, err)
+	ciphertext, err := box1.Seal([]byte("data"), nil)
+	require.NoError(t, err)
+
+	box2, err := secretbox.New(secretbox.WithPassphrase("p1", "passphrase", testSalt, testArgon2id))
+	require.NoError(t, err)
+	plaintext, err := box2.Open(ciphertext, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), plaintext)
+
+	box3, err := secretbox.New(secretbox.WithPassphrase("p1", "wrong", testSalt, testArgon2id))
+	require.NoError(t, err)
+	_, err = box3.Open(ciphertext, nil)
+	require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext)
+
+	// salt and kdf parameters of the header must match the configured ones
+	for name, opt := range map[string]secretbox.Opt{
+		"params": secretbox.WithPassphrase("p1", "passphrase", testSalt, secretbox.Argon2id{Time: 2, Memory: 128, Threads: 1}),
+		"salt":   secretbox.WithPassphrase("p1", "passphrase", []byte("fedcba9876543210"), testArgon2id),
+		"kdf":    secretbox.WithPassphrase("p1", "passphrase", testSalt, testScrypt),
+	} {
+		box, err := secretbox.New(opt)
+		require.NoError(t, err)
+		_, err = box.Open(ciphertext, nil)
+		require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext, name)
+	}
+}
+
+func TestForgedKDFParams(t *testing.T) {
+	box, err := secretbox.New(secretbox.WithPassphrase("p1", "passphrase", testSalt, testArgon2id))
+	require.NoError(t, err)
+	ciphertext, err := box.Seal([]byte("data"), nil)
+	require.NoError(t, err)
+
+	// header: magic, version, flags, kdf, key id length, key id, salt, time, memory, threads
+	params := len("SBX") + 4 + len("p1") + secretbox.SaltSize
+	forged := append([]byte(nil), ciphertext...)
+	copy(forged[params:], []byte{0xff, 0xff, 0xff, 0xff, 0x00, 0x10, 0x00, 0x00, 0xff})
+	start := time.Now()
+	_, err = box.Open(forged, nil)
+	require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext)
+	require.Less(t, time.Since(start), time.Second, "key must not be derived with forged parameters")
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKey := newKey(t), newKey(t)
+	oldBox, err := secretbox.New(secretbox.WithKey("2024", oldKey))
+	require.NoError(t, err)
+	ciphertext, err := oldBox.Seal([]byte("data"), nil)
+	require.NoError(t, err)
+
+	box, err := secretbox.New(
+		secretbox.WithKey("2024", oldKey),
+		secretbox.WithKey("2025", newKey),
+		secretbox.WithPrimary("2025"),
+	)
+	require.NoError(t, err)
+
+	kid, err := secretbox.KeyID(ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, "2024", kid)
+	plaintext, err := box.Open(ciphertext, nil)
+	require.NoError(t, err)
+
+	rotated, err := box.Seal(plaintext, nil)
+	require.NoError(t, err)
+	kid, err = secretbox.KeyID(rotated)
+	require.NoError(t, err)
+	require.Equal(t, "2025", kid)
+
+	_, err = oldBox.Open(rotated, nil)
+	require.ErrorIs(t, err, secretbox.ErrUnknownKey)
+}
+
+func TestLegacy(t *testing.T) {
+	legacy, err := auth.Encrypt([]byte("supersecret"), "passphrase") //nolint:staticcheck // legacy format is still supported
+	require.NoError(t, err)
+
+	box, err := secretbox.New(secretbox.WithLegacyPassphrase("passphrase"))
+	require.NoError(t, err)
+	plaintext, err := box.Open(legacy, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte("supersecret"), plaintext)
+
+	kid, err := secretbox.KeyID(legacy)
+	require.NoError(t, err)
+	require.Empty(t, kid)
+
+	box, err = secretbox.New(secretbox.WithKey("k1", newKey(t)))
+	require.NoError(t, err)
+	_, err = box.Open(legacy, nil)
+	require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext)
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := secretbox.New()
+	require.ErrorIs(t, err, secretbox.ErrNoKeys)
+	_, err = secretbox.New(secretbox.WithKey("k1", []byte("short")))
+	require.ErrorIs(t, err, secretbox.ErrInvalidKey)
+	_, err = secretbox.New(secretbox.WithKey("k1", newKey(t)), secretbox.WithKey("k1", newKey(t)))
+	require.ErrorIs(t, err, secretbox.ErrInvalidKey)
+	_, err = secretbox.New(secretbox.WithKey("k1", newKey(t)), secretbox.WithPrimary("k2"))
+	require.ErrorIs(t, err, secretbox.ErrUnknownKey)
+	_, err = secretbox.New(secretbox.WithPassphrase("p1", "passphrase", testSalt, secretbox.Argon2id{}))
+	require.ErrorIs(t, err, secretbox.ErrInvalidParams)
+	_, err = secretbox.New(secretbox.WithPassphrase("p1", "passphrase", testSalt, secretbox.Argon2id{Time: 1000, Memory: 64, Threads: 1}))
+	require.ErrorIs(t, err, secretbox.ErrInvalidParams)
+	_, err = secretbox.New(secretbox.WithPassphrase("p1", "passphrase", testSalt, secretbox.Scrypt{LogN: 40, R: 8, P: 1}))
+	require.ErrorIs(t, err, secretbox.ErrInvalidParams)
+	_, err = secretbox.New(secretbox.WithPassphrase("p1", "passphrase", []byte("short"), testArgon2id))
+	require.ErrorIs(t, err, secretbox.ErrInvalidKey)
+}
@@ -0,0 +1,183 @@
+package secretbox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// ChunkSize is the plaintext size of stream chunks.
+	ChunkSize = 64 * 1024
+
+	// stream nonce is prefix | uint32 chunk counter | last chunk flag
+	streamNoncePrefixSize = chacha20poly1305.NonceSizeX - 5
+	maxChunks             = 1<<32 - 1
+)
+
+var ErrTruncated = errors.New("stream is truncated")
+
+// NewWriter returns writer which encrypts data written to it in ChunkSize chunks and writes them to w.
+// Each chunk is authenticated separately and the last chunk is marked, so reordering and truncation are detected.
+// Close must be called to write the last chunk, it doesn't close w.
+func (b *Box) NewWriter(w io.Writer, aad []byte) (io.WriteCloser, error) {
+	h, aead, err := b.sealHeader(flagStream, streamNoncePrefixSize)
+	if err != nil {
+		return nil, err
+	}
+	hb := h.marshal()
+	if _, err := w.Write(hb); err != nil {
+		return nil, err
+	}
+	return &streamWriter{
+		w:     w,
+		aead:  aead,
+		nonce: h.nonce,
+		aad:   concat(hb, aad),
+		buf:   make([]byte, 0, ChunkSize),
+	}, nil
+}
+
+type streamWriter struct {
+	w       io.Writer
+	aead    aeadCipher
+	nonce   []byte
+	aad     []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("secretbox: write to closed writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		// chunk is flushed only when more data follows so the last chunk can be marked in Close
+		if len(s.buf) == ChunkSize {
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(s.buf[len(s.buf):ChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+func (s *streamWriter) flush(last bool) error {
+	if s.counter == maxChunks {
+		return errors.New("secretbox: stream is too large")
+	}
+	chunk := s.aead.Seal(nil, chunkNonce(s.nonce, s.counter, last), s.buf, s.aad)
+	s.counter++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(chunk)
+	return err
+}
+
+// NewReader returns reader which decrypts stream written by NewWriter.
+// Data is returned only after its chunk has been authenticated, ErrTruncated is returned if stream ends before the last chunk.
+func (b *Box) NewReader(r io.Reader, aad []byte) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	h, hb, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if h.flags&flagStream == 0 {
+		return nil, fmt.Errorf("%w: ciphertext isn't a stream, use Open", ErrInvalidCiphertext)
+	}
+	aead, err := b.openAEAD(h)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{
+		r:     br,
+		aead:  aead,
+		nonce: h.nonce,
+		aad:   concat(hb, aad),
+		chunk: make([]byte, ChunkSize+aead.Overhead()),
+	}, nil
+}
+
+type streamReader struct {
+	r       *bufio.Reader
+	aead    aeadCipher
+	nonce   []byte
+	aad     []byte
+	chunk   []byte
+	plain   []byte
+	counter uint32
+	done    bool
+	err     error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk.
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.r, s.chunk)
+	last := false
+	switch {
+	case errors.Is(err, io.EOF):
+		return ErrTruncated
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := s.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+
+	plain, err := s.aead.Open(s.chunk[:0], chunkNonce(s.nonce, s.counter, last), s.chunk[:n], s.aad)
+	if err != nil {
+		if last {
+			return ErrTruncated
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidCiphertext, err)
+	}
+	s.counter++
+	s.plain = plain
+	s.done = last
+	return nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, chacha20poly1305.NonceSizeX)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
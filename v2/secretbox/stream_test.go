@@ -0,0 +1,83 @@
+package secretbox_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/elisasre/go-common/v2/secretbox"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptStream(t *testing.T, box *secretbox.Box, plaintext, aad []byte) []byte {
+	t.Helper()
+	out := &bytes.Buffer{}
+	w, err := box.NewWriter(out, aad)
+	require.NoError(t, err)
+	// odd sized writes exercise chunk boundaries
+	for p := plaintext; len(p) > 0; {
+		n := min(len(p), 10000)
+		_, err := w.Write(p[:n])
+		require.NoError(t, err)
+		p = p[n:]
+	}
+	require.NoError(t, w.Close())
+	return out.Bytes()
+}
+
+func decryptStream(box *secretbox.Box, ciphertext, aad []byte) ([]byte, error) {
+	r, err := box.NewReader(bytes.NewReader(ciphertext), aad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStream(t *testing.T) {
+	box, err := secretbox.New(secretbox.WithKey("k1", newKey(t)))
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, secretbox.ChunkSize - 1, secretbox.ChunkSize, secretbox.ChunkSize + 1, 3*secretbox.ChunkSize + 123} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		require.NoError(t, err)
+
+		ciphertext := encryptStream(t, box, plaintext, []byte("blob"))
+		decrypted, err := decryptStream(box, ciphertext, []byte("blob"))
+		require.NoError(t, err, "size %d", size)
+		require.Equal(t, plaintext, append([]byte{}, decrypted...), "size %d", size)
+
+		_, err = decryptStream(box, ciphertext, []byte("other"))
+		require.Error(t, err)
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	box, err := secretbox.New(secretbox.WithKey("k1", newKey(t)))
+	require.NoError(t, err)
+	plaintext := make([]byte, 2*secretbox.ChunkSize+100)
+	ciphertext := encryptStream(t, box, plaintext, nil)
+	header := len(ciphertext) - len(plaintext) - 3*16
+
+	// cut at chunk boundary
+	_, err = decryptStream(box, ciphertext[:header+secretbox.ChunkSize+16], nil)
+	require.ErrorIs(t, err, secretbox.ErrTruncated)
+
+	// cut in the middle of the last chunk
+	_, err = decryptStream(box, ciphertext[:len(ciphertext)-1], nil)
+	require.ErrorIs(t, err, secretbox.ErrTruncated)
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[header+10] ^= 1
+	_, err = decryptStream(box, tampered, nil)
+	require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext)
+
+	// stream and single message formats can't be mixed
+	_, err = box.Open(ciphertext, nil)
+	require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext)
+	sealed, err := box.Seal([]byte("data"), nil)
+	require.NoError(t, err)
+	_, err = box.NewReader(bytes.NewReader(sealed), nil)
+	require.ErrorIs(t, err, secretbox.ErrInvalidCiphertext)
+}
@@ -3,7 +3,10 @@ package sqlutil
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
+	"log/slog"
 	"sync"
+	"time"
 )
 
 // AuthProvider is used for refreshing DB credentials.
@@ -12,48 +15,206 @@ type AuthProvider interface {
 	IsAuthErr(error) bool
 }
 
+// Expirer can be implemented by AuthProvider to enable proactive credential refresh.
+// Expiry returns expiry time of the credentials returned by the latest DSN call or zero time if unknown.
+type Expirer interface {
+	Expiry() time.Time
+}
+
+const (
+	refreshReasonInitial   = "initial"
+	refreshReasonExpiry    = "expiry"
+	refreshReasonAuthError = "auth_error"
+
+	// minRefreshDelay keeps scheduled refreshes from spinning when provider returns credentials
+	// which already are within refresh window.
+	minRefreshDelay = time.Second
+	// refreshRetryDelay is how long scheduled refresh waits after failure before trying again.
+	refreshRetryDelay = 10 * time.Second
+)
+
 // AuthRefreshDriver wraps a sql.Driver with automatic credentials reloading.
 type AuthRefreshDriver struct {
-	driver    driver.Driver
-	auth      AuthProvider
-	latestDSN string
-	lock      sync.Mutex
+	driver        driver.Driver
+	auth          AuthProvider
+	refreshBefore time.Duration
+	recycleConns  bool
+	onRefresh     func()
+
+	// mu guards latestDSN, expiry, generation, timer and closed, it's never held while dialing or fetching credentials.
+	mu         sync.RWMutex
+	latestDSN  string
+	expiry     time.Time
+	generation uint64
+	timer      *time.Timer
+	closed     bool
+	// refreshMu serializes credential refreshes so concurrent dials don't all hit the AuthProvider.
+	refreshMu sync.Mutex
+}
+
+type DriverOpt func(*AuthRefreshDriver)
+
+// WithRefreshBefore sets how long before credential expiry DSN is refreshed proactively, defaults to 1 minute.
+// Only applies to AuthProviders implementing Expirer. Refresh is scheduled with a timer so credentials
+// are rotated also when the pool is idle and doesn't dial new connections.
+func WithRefreshBefore(d time.Duration) DriverOpt {
+	return func(ard *AuthRefreshDriver) {
+		ard.refreshBefore = d
+	}
+}
+
+// WithConnRecycling makes connections opened with old credentials invalid after credentials have been rotated.
+// database/sql then closes them when they are returned to or taken from the pool instead of reusing them.
+func WithConnRecycling() DriverOpt {
+	return func(ard *AuthRefreshDriver) {
+		ard.recycleConns = true
+	}
+}
+
+// WithOnRefresh sets hook which is called after credentials have been rotated.
+func WithOnRefresh(fn func()) DriverOpt {
+	return func(ard *AuthRefreshDriver) {
+		ard.onRefresh = fn
+	}
 }
 
 // NewAuthRefreshDriver wraps given sql.Driver and uses AuthLoader to fetch new DNS in case of auth error.
-func NewAuthRefreshDriver(d driver.Driver, a AuthProvider) driver.Driver {
-	return &AuthRefreshDriver{
-		driver: d,
-		auth:   a,
+func NewAuthRefreshDriver(d driver.Driver, a AuthProvider, opts ...DriverOpt) driver.Driver {
+	ard := &AuthRefreshDriver{
+		driver:        d,
+		auth:          a,
+		refreshBefore: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(ard)
 	}
+	return ard
 }
 
 // Open tries opening new connection and automatically refreshes credentials on Auth error.
-func (d *AuthRefreshDriver) Open(_ string) (conn driver.Conn, err error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
-
-	dsn := d.latestDSN
-	if dsn == "" {
-		if dsn, err = d.refreshDSN(); err != nil {
-			return nil, err
-		}
+// Credentials are also refreshed proactively when they are about to expire.
+func (d *AuthRefreshDriver) Open(_ string) (driver.Conn, error) {
+	dsn, gen, err := d.currentDSN()
+	if err != nil {
+		return nil, err
 	}
 
-	conn, err = d.driver.Open(dsn)
+	conn, err := d.driver.Open(dsn)
 	if d.auth.IsAuthErr(err) {
-		if dsn, err = d.refreshDSN(); err != nil {
+		authFailures.Inc()
+		if dsn, gen, err = d.refresh(gen, refreshReasonAuthError); err != nil {
 			return nil, err
 		}
 		conn, err = d.driver.Open(dsn)
+		if d.auth.IsAuthErr(err) {
+			authFailures.Inc()
+		}
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return conn, err
+	if d.recycleConns {
+		return &recyclingConn{Conn: conn, gen: gen, d: d}, nil
+	}
+	return conn, nil
 }
 
-func (d *AuthRefreshDriver) refreshDSN() (dsn string, err error) {
-	d.latestDSN, err = d.auth.DSN()
-	return d.latestDSN, err
+// currentDSN returns latest DSN, refreshing it first if it's missing or about to expire.
+func (d *AuthRefreshDriver) currentDSN() (string, uint64, error) {
+	d.mu.RLock()
+	dsn, expiry, gen := d.latestDSN, d.expiry, d.generation
+	d.mu.RUnlock()
+
+	switch {
+	case dsn == "":
+		return d.refresh(gen, refreshReasonInitial)
+	case !expiry.IsZero() && time.Until(expiry) < d.refreshBefore:
+		return d.refresh(gen, refreshReasonExpiry)
+	default:
+		return dsn, gen, nil
+	}
+}
+
+// refresh fetches new DSN unless another goroutine already rotated credentials after seenGen was observed.
+func (d *AuthRefreshDriver) refresh(seenGen uint64, reason string) (string, uint64, error) {
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+
+	d.mu.RLock()
+	dsn, gen := d.latestDSN, d.generation
+	d.mu.RUnlock()
+	if gen != seenGen && dsn != "" {
+		return dsn, gen, nil
+	}
+
+	if inv, ok := d.auth.(Invalidator); ok && reason == refreshReasonAuthError {
+		inv.Invalidate()
+	}
+
+	newDSN, err := d.auth.DSN()
+	if err != nil {
+		refreshes.WithLabelValues(reason, "error").Inc()
+		return "", gen, err
+	}
+	refreshes.WithLabelValues(reason, "success").Inc()
+
+	var expiry time.Time
+	if e, ok := d.auth.(Expirer); ok {
+		expiry = e.Expiry()
+	}
+
+	d.mu.Lock()
+	rotated := newDSN != d.latestDSN
+	if rotated {
+		d.generation++
+	}
+	d.latestDSN, d.expiry, gen = newDSN, expiry, d.generation
+	d.mu.Unlock()
+
+	if !expiry.IsZero() {
+		d.scheduleRefresh(time.Until(expiry)-d.refreshBefore, gen)
+	}
+
+	if rotated && dsn != "" && d.onRefresh != nil {
+		d.onRefresh()
+	}
+	return newDSN, gen, nil
+}
+
+// scheduleRefresh refreshes credentials after delay unless they have been rotated by someone else before it.
+func (d *AuthRefreshDriver) scheduleRefresh(delay time.Duration, gen uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(max(delay, minRefreshDelay), func() {
+		if _, _, err := d.refresh(gen, refreshReasonExpiry); err != nil {
+			slog.Error("refreshing database credentials failed", slog.String("error", err.Error()))
+			d.scheduleRefresh(refreshRetryDelay, gen)
+		}
+	})
+}
+
+// Close stops scheduled credential refreshes. sql.DB.Close calls it when the driver is used as connector.
+func (d *AuthRefreshDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	return nil
+}
+
+func (d *AuthRefreshDriver) currentGeneration() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.generation
 }
 
 // OpenConnector return pointer to driver itself which implements also driver.Connector.
@@ -64,3 +225,96 @@ func (d *AuthRefreshDriver) Connect(ctx context.Context) (driver.Conn, error) {
 
 // Driver return pointer to itself.
 func (d *AuthRefreshDriver) Driver() driver.Driver { return d }
+
+var errUnsupportedTxOptions = errors.New("driver does not support non-default transaction options")
+
+// recyclingConn marks itself invalid when credentials have been rotated after it was opened.
+// All optional driver interfaces are forwarded to the wrapped connection.
+type recyclingConn struct {
+	driver.Conn
+	gen uint64
+	d   *AuthRefreshDriver
+}
+
+func (c *recyclingConn) expired() bool {
+	return c.gen != c.d.currentGeneration()
+}
+
+// IsValid implements driver.Validator.
+func (c *recyclingConn) IsValid() bool {
+	if c.expired() {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// ResetSession implements driver.SessionResetter.
+func (c *recyclingConn) ResetSession(ctx context.Context) error {
+	if c.expired() {
+		return driver.ErrBadConn
+	}
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// PrepareContext implements driver.ConnPrepareContext.
+func (c *recyclingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Prepare(query)
+}
+
+// BeginTx implements driver.ConnBeginTx.
+func (c *recyclingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errUnsupportedTxOptions
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Begin() //nolint:staticcheck // fallback for drivers without ConnBeginTx
+}
+
+// ExecContext implements driver.ExecerContext.
+func (c *recyclingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+// QueryContext implements driver.QueryerContext.
+func (c *recyclingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+// Ping implements driver.Pinger.
+func (c *recyclingConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker.
+func (c *recyclingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,202 @@
+package sqlutil_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/sqlutil"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// passwordDriver accepts only DSNs containing current password.
+type passwordDriver struct {
+	mu       sync.Mutex
+	password string
+	dials    atomic.Int32
+}
+
+func (d *passwordDriver) setPassword(p string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.password = p
+}
+
+func (d *passwordDriver) Open(dsn string) (driver.Conn, error) {
+	d.dials.Add(1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !strings.Contains(dsn, "password="+d.password) {
+		return nil, &pq.Error{Code: "28P01", Message: "password authentication failed"}
+	}
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, fmt.Errorf("not implemented") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, fmt.Errorf("not implemented") }
+
+// rotatingAuth returns new password on every DSN call.
+type rotatingAuth struct {
+	calls       atomic.Int32
+	invalidated atomic.Int32
+	validFor    time.Duration
+	expiry      atomic.Int64
+}
+
+func (a *rotatingAuth) DSN() (string, error) {
+	n := a.calls.Add(1)
+	a.expiry.Store(time.Now().Add(a.validFor).UnixNano())
+	return fmt.Sprintf("password=p%d", n), nil
+}
+
+func (a *rotatingAuth) Expiry() time.Time      { return time.Unix(0, a.expiry.Load()) }
+func (a *rotatingAuth) Invalidate()            { a.invalidated.Add(1) }
+func (*rotatingAuth) IsAuthErr(err error) bool { return sqlutil.IsAuthenticationError(err) }
+
+func TestAuthRefreshDriverAuthError(t *testing.T) {
+	pd := &passwordDriver{password: "p1"}
+	auth := &rotatingAuth{validFor: time.Hour}
+	d := sqlutil.NewAuthRefreshDriver(pd, auth)
+
+	_, err := d.Open("")
+	require.NoError(t, err)
+	_, err = d.Open("")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, auth.calls.Load(), "DSN should be reused")
+
+	pd.setPassword("p2")
+	_, err = d.Open("")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, auth.calls.Load())
+	require.EqualValues(t, 1, auth.invalidated.Load())
+}
+
+func TestAuthRefreshDriverProactiveRefresh(t *testing.T) {
+	pd := &passwordDriver{password: "p1"}
+	auth := &rotatingAuth{validFor: 30 * time.Second}
+	refreshed := atomic.Int32{}
+	d := sqlutil.NewAuthRefreshDriver(pd, auth,
+		sqlutil.WithRefreshBefore(time.Minute),
+		sqlutil.WithOnRefresh(func() { refreshed.Add(1) }),
+	)
+	defer d.(io.Closer).Close()
+
+	_, err := d.Open("")
+	require.NoError(t, err)
+
+	// credentials expire within refresh window so they are refreshed before dialing
+	pd.setPassword("p2")
+	_, err = d.Open("")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, auth.calls.Load())
+	require.EqualValues(t, 2, pd.dials.Load(), "no failed dial expected")
+	require.EqualValues(t, 1, refreshed.Load())
+}
+
+func TestAuthRefreshDriverIdleRefresh(t *testing.T) {
+	pd := &passwordDriver{password: "p1"}
+	auth := &rotatingAuth{validFor: time.Minute}
+	refreshed := atomic.Int32{}
+	d := sqlutil.NewAuthRefreshDriver(pd, auth,
+		sqlutil.WithRefreshBefore(time.Minute),
+		sqlutil.WithConnRecycling(),
+		sqlutil.WithOnRefresh(func() { refreshed.Add(1) }),
+	)
+
+	conn, err := d.Open("")
+	require.NoError(t, err)
+	v, ok := conn.(driver.Validator)
+	require.True(t, ok)
+
+	// credentials are refreshed by timer without dialing and pooled connection is recycled
+	require.Eventually(t, func() bool { return !v.IsValid() }, 5*time.Second, 10*time.Millisecond)
+	require.EqualValues(t, 1, pd.dials.Load())
+	require.EqualValues(t, 1, refreshed.Load())
+
+	require.NoError(t, d.(io.Closer).Close())
+	calls := auth.calls.Load()
+	time.Sleep(1500 * time.Millisecond)
+	require.Equal(t, calls, auth.calls.Load(), "refresh is not scheduled after close")
+}
+
+func TestAuthRefreshDriverConcurrentOpen(t *testing.T) {
+	pd := &passwordDriver{password: "p1"}
+	auth := &rotatingAuth{validFor: time.Hour}
+	d := sqlutil.NewAuthRefreshDriver(pd, auth)
+
+	wg := sync.WaitGroup{}
+	for range 20 {
+		wg.Go(func() {
+			_, err := d.Open("")
+			assert.NoError(t, err)
+		})
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, auth.calls.Load(), "only single refresh expected")
+}
+
+func TestAuthRefreshDriverConnRecycling(t *testing.T) {
+	pd := &passwordDriver{password: "p1"}
+	auth := &rotatingAuth{validFor: time.Hour}
+	d := sqlutil.NewAuthRefreshDriver(pd, auth, sqlutil.WithConnRecycling())
+
+	conn, err := d.Open("")
+	require.NoError(t, err)
+	v, ok := conn.(driver.Validator)
+	require.True(t, ok)
+	require.True(t, v.IsValid())
+
+	pd.setPassword("p2")
+	newConn, err := d.Open("")
+	require.NoError(t, err)
+
+	require.False(t, v.IsValid(), "connection opened with old credentials should be recycled")
+	r, ok := conn.(driver.SessionResetter)
+	require.True(t, ok)
+	require.ErrorIs(t, r.ResetSession(context.Background()), driver.ErrBadConn)
+	v, ok = newConn.(driver.Validator)
+	require.True(t, ok)
+	require.True(t, v.IsValid())
+
+	connector, ok := d.(driver.Connector)
+	require.True(t, ok)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	require.NoError(t, db.PingContext(context.Background()))
+}
+
+func TestCollectors(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	for _, c := range sqlutil.Collectors() {
+		require.NoError(t, reg.Register(c))
+	}
+
+	pd := &passwordDriver{password: "p2"}
+	d := sqlutil.NewAuthRefreshDriver(pd, &rotatingAuth{validFor: time.Hour})
+	_, err := d.Open("")
+	require.NoError(t, err)
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			values[mf.GetName()] += m.GetCounter().GetValue()
+		}
+	}
+	require.GreaterOrEqual(t, values["db_auth_failures_total"], 1.0)
+	require.GreaterOrEqual(t, values["db_auth_credential_refreshes_total"], 2.0)
+}
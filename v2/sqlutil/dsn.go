@@ -0,0 +1,101 @@
+package sqlutil
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnConfig contains connection parameters used for building libpq compatible DSN.
+type ConnConfig struct {
+	Host    string
+	Port    int
+	User    string
+	DBName  string
+	SSLMode string
+	// Params contains additional connection parameters, e.g. application_name.
+	Params map[string]string
+}
+
+// DSN returns key/value DSN with all values escaped. Password is given separately since it's usually short lived.
+func (c ConnConfig) DSN(user, password string) string {
+	params := map[string]string{
+		"connect_timeout": "10",
+	}
+	maps.Copy(params, c.Params)
+	params["host"] = c.Host
+	params["port"] = strconv.Itoa(c.Port)
+	params["user"] = user
+	params["password"] = password
+	params["dbname"] = c.DBName
+	params["sslmode"] = c.SSLMode
+
+	parts := make([]string, 0, len(params))
+	for _, k := range slices.Sorted(maps.Keys(params)) {
+		if params[k] == "" {
+			continue
+		}
+		parts = append(parts, k+"="+quoteDSNValue(params[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteDSNValue quotes value as described in https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-CONNSTRING-KEYWORD-VALUE.
+func quoteDSNValue(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(v) + "'"
+}
+
+// Invalidator can be implemented by AuthProvider to drop cached credentials.
+// AuthRefreshDriver calls Invalidate before requesting new DSN after an auth error.
+type Invalidator interface {
+	Invalidate()
+}
+
+// credentials are cached by providers until they are about to expire.
+type credentials struct {
+	User     string
+	Password string
+	Expiry   time.Time
+}
+
+// credentialCache caches credentials until they are about to expire.
+type credentialCache struct {
+	creds *credentials
+	mu    sync.Mutex
+}
+
+// get returns cached credentials unless they expire within refreshBefore, in which case fetch is called.
+func (c *credentialCache) get(refreshBefore time.Duration, fetch func() (credentials, error)) (credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.creds != nil && (c.creds.Expiry.IsZero() || time.Until(c.creds.Expiry) > refreshBefore) {
+		return *c.creds, nil
+	}
+
+	creds, err := fetch()
+	if err != nil {
+		return credentials{}, err
+	}
+	c.creds = &creds
+	return creds, nil
+}
+
+func (c *credentialCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creds = nil
+}
+
+func (c *credentialCache) expiry() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds == nil {
+		return time.Time{}
+	}
+	return c.creds.Expiry
+}
@@ -0,0 +1,32 @@
+package sqlutil_test
+
+import (
+	"testing"
+
+	"github.com/elisasre/go-common/v2/sqlutil"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnConfigDSN(t *testing.T) {
+	conn := sqlutil.ConnConfig{
+		Host:    "db.example.com",
+		Port:    5432,
+		DBName:  "app",
+		SSLMode: "verify-full",
+		Params:  map[string]string{"application_name": "my app"},
+	}
+
+	password := `p@ss wo'rd\=`
+	dsn := conn.DSN("user", password)
+	require.Equal(t,
+		`application_name='my app' connect_timeout='10' dbname='app' host='db.example.com' `+
+			`password='p@ss wo\'rd\\=' port='5432' sslmode='verify-full' user='user'`,
+		dsn,
+	)
+
+	// lib/pq must be able to parse escaped values back to the original ones.
+	connector, err := pq.NewConnector(dsn)
+	require.NoError(t, err)
+	require.NotNil(t, connector)
+}
@@ -0,0 +1,46 @@
+package sqlutil
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FileAuth implements AuthProvider using password read from a file which is rotated externally,
+// e.g. mounted Kubernetes secret. Files are re-read every time new DSN is requested.
+type FileAuth struct {
+	Conn         ConnConfig
+	PasswordFile string
+	// UsernameFile is optional and overrides Conn.User when set.
+	UsernameFile string
+}
+
+// DSN reads current credentials from files and returns DSN.
+func (f *FileAuth) DSN() (string, error) {
+	password, err := readTrimmed(f.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("reading password file failed: %w", err)
+	}
+
+	user := f.Conn.User
+	if f.UsernameFile != "" {
+		if user, err = readTrimmed(f.UsernameFile); err != nil {
+			return "", fmt.Errorf("reading username file failed: %w", err)
+		}
+	}
+
+	return f.Conn.DSN(user, password), nil
+}
+
+// IsAuthErr checks if given error is know auth error.
+func (*FileAuth) IsAuthErr(err error) bool {
+	return IsAuthenticationError(err)
+}
+
+func readTrimmed(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,38 @@
+package sqlutil_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elisasre/go-common/v2/sqlutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileAuth(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	usernameFile := filepath.Join(dir, "username")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("first\n"), 0o600))
+	require.NoError(t, os.WriteFile(usernameFile, []byte("rotated-user\n"), 0o600))
+
+	a := &sqlutil.FileAuth{
+		Conn:         sqlutil.ConnConfig{Host: "localhost", Port: 5432, User: "static-user"},
+		PasswordFile: passwordFile,
+	}
+	dsn, err := a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "password='first'")
+	require.Contains(t, dsn, "user='static-user'")
+
+	require.NoError(t, os.WriteFile(passwordFile, []byte("second"), 0o600))
+	a.UsernameFile = usernameFile
+	dsn, err = a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "password='second'")
+	require.Contains(t, dsn, "user='rotated-user'")
+
+	a.PasswordFile = filepath.Join(dir, "missing")
+	_, err = a.DSN()
+	require.Error(t, err)
+}
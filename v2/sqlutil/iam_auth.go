@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -16,22 +18,53 @@ type BuildAuthTokenFn func(ctx context.Context,
 	creds aws.CredentialsProvider,
 	optFns ...func(options *auth.BuildAuthTokenOptions)) (string, error)
 
-// IAMAuth implements AuthProvider and generates IAM DB credentials.
+// RDSTokenValidity is the lifetime of RDS IAM authentication tokens.
+const RDSTokenValidity = 15 * time.Minute
+
+// IAMAuth implements AuthProvider and generates IAM DB credentials for AWS RDS.
+// Tokens are cached until RefreshBefore of their expiry, AWS configuration is loaded once.
 type IAMAuth struct {
 	Host, DBUser, DBPassword, DBRegion, SSLMode, DBName string
 	Port                                                int
 	BuildAuthToken                                      BuildAuthTokenFn
+	// RefreshBefore defines how long before expiry cached token is replaced, defaults to 5 minutes.
+	RefreshBefore time.Duration
+
+	cfg   *aws.Config
+	cfgMu sync.Mutex
+	cache credentialCache
+}
+
+func (i *IAMAuth) loadConfig(ctx context.Context) (aws.Config, error) {
+	i.cfgMu.Lock()
+	defer i.cfgMu.Unlock()
+	if i.cfg != nil {
+		return *i.cfg, nil
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	i.cfg = &cfg
+	return cfg, nil
 }
 
+// RefreshPassword generates new auth token and stores it to DBPassword.
 func (i *IAMAuth) RefreshPassword() error {
 	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := i.loadConfig(ctx)
 	if err != nil {
 		return err
 	}
 
+	buildAuthToken := i.BuildAuthToken
+	if buildAuthToken == nil {
+		buildAuthToken = auth.BuildAuthToken
+	}
+
 	endpoint := fmt.Sprintf("%s:%d", i.Host, i.Port)
-	authToken, err := i.BuildAuthToken(ctx, endpoint, i.DBRegion, i.DBUser, cfg.Credentials)
+	authToken, err := buildAuthToken(ctx, endpoint, i.DBRegion, i.DBUser, cfg.Credentials)
 	if err != nil {
 		return err
 	}
@@ -41,13 +74,39 @@ func (i *IAMAuth) RefreshPassword() error {
 	return nil
 }
 
-// DSN will trigger reparsing of configuration and return DSN or an error.
+// DSN returns DSN with cached auth token or generates new token if cached one is about to expire.
 func (i *IAMAuth) DSN() (string, error) {
-	if err := i.RefreshPassword(); err != nil {
+	creds, err := i.cache.get(durationOrDefault(i.RefreshBefore, 5*time.Minute), func() (credentials, error) {
+		expiry := time.Now().Add(RDSTokenValidity)
+		if err := i.RefreshPassword(); err != nil {
+			return credentials{}, err
+		}
+		return credentials{User: i.DBUser, Password: i.DBPassword, Expiry: expiry}, nil
+	})
+	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=10",
-		i.Host, i.Port, i.DBUser, i.DBPassword, i.DBName, i.SSLMode), nil
+	return i.connConfig().DSN(creds.User, creds.Password), nil
+}
+
+// Invalidate drops cached token so that next DSN call generates a new one.
+func (i *IAMAuth) Invalidate() {
+	i.cache.invalidate()
+}
+
+// Expiry returns expiry time of the cached token.
+func (i *IAMAuth) Expiry() time.Time {
+	return i.cache.expiry()
+}
+
+func (i *IAMAuth) connConfig() ConnConfig {
+	return ConnConfig{
+		Host:    i.Host,
+		Port:    i.Port,
+		User:    i.DBUser,
+		DBName:  i.DBName,
+		SSLMode: i.SSLMode,
+	}
 }
 
 // IsAuthErr checks if given error is know auth error.
@@ -55,6 +114,13 @@ func (*IAMAuth) IsAuthErr(err error) bool {
 	return IsAuthenticationError(err)
 }
 
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
 // IsAuthenticationError checks if given error is know auth error.
 func IsAuthenticationError(err error) bool {
 	var pqError *pq.Error
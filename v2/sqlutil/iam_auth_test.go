@@ -127,3 +127,36 @@ func TestIAMAuth(t *testing.T) {
 	err = testDB.Ping()
 	require.NoError(t, err, "failed to ping db")
 }
+
+func TestIAMAuthTokenCache(t *testing.T) {
+	calls := 0
+	a := &sqlutil.IAMAuth{
+		Host:     "db.example.com",
+		Port:     5432,
+		DBUser:   "app",
+		DBRegion: "mock",
+		SSLMode:  "require",
+		DBName:   "postgres",
+		BuildAuthToken: func(ctx context.Context,
+			endpoint, region, dbUser string,
+			creds aws.CredentialsProvider,
+			optFns ...func(options *auth.BuildAuthTokenOptions),
+		) (string, error) {
+			calls++
+			return fmt.Sprintf("%s/?Action=connect&DBUser=%s&X-Amz-Signature=%d", endpoint, dbUser, calls), nil
+		},
+	}
+
+	dsn, err := a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "password='db.example.com:5432/?Action=connect&DBUser=app&X-Amz-Signature=1'")
+
+	_, err = a.DSN()
+	require.NoError(t, err)
+	require.Equal(t, 1, calls, "token should be cached")
+
+	a.Invalidate()
+	dsn, err = a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "X-Amz-Signature=2'")
+}
@@ -0,0 +1,25 @@
+package sqlutil
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var refreshes = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name:      "credential_refreshes_total",
+		Subsystem: "db_auth",
+		Help:      "How many times AuthRefreshDriver refreshed DB credentials, partitioned by reason and result.",
+	},
+	[]string{"reason", "result"},
+)
+
+var authFailures = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name:      "failures_total",
+		Subsystem: "db_auth",
+		Help:      "How many connection attempts failed with authentication error.",
+	},
+)
+
+// Collectors returns AuthRefreshDriver metrics which can be registered, e.g. with metrics.New(sqlutil.Collectors()...).
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{refreshes, authFailures}
+}
@@ -0,0 +1,168 @@
+package sqlutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/elisasre/go-common/v2/httputil"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// GCPSQLLoginScope is the OAuth2 scope required for Cloud SQL IAM database authentication.
+	GCPSQLLoginScope = "https://www.googleapis.com/auth/sqlservice.login"
+	// AzureOSSRDBMSScope is the OAuth2 scope required for Azure Database for PostgreSQL Entra ID authentication.
+	AzureOSSRDBMSScope = "https://ossrdbms-aad.database.windows.net/.default"
+	// AzureAuthorityHost is the default Microsoft Entra ID authority.
+	AzureAuthorityHost = "https://login.microsoftonline.com"
+	// AzureIMDSEndpoint is the default Azure Instance Metadata Service token endpoint used with managed identities.
+	AzureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+var ErrMissingTokenSource = errors.New("missing token source")
+
+// tokenClient is used for token requests so that a hanging token endpoint can't block DSN forever.
+var tokenClient = &http.Client{Timeout: 10 * time.Second}
+
+// TokenAuth implements AuthProvider using OAuth2 access token as DB password.
+// Tokens are cached until RefreshBefore of their expiry.
+type TokenAuth struct {
+	Conn        ConnConfig
+	TokenSource oauth2.TokenSource
+	// RefreshBefore defines how long before expiry cached token is replaced, defaults to 5 minutes.
+	RefreshBefore time.Duration
+
+	cache credentialCache
+}
+
+// NewGCPAuth creates TokenAuth for Cloud SQL IAM database authentication using Application Default Credentials.
+// For service accounts conn.User is the account email without ".gserviceaccount.com" suffix.
+// Cancelling ctx after NewGCPAuth has returned doesn't affect later token fetches.
+func NewGCPAuth(ctx context.Context, conn ConnConfig) (*TokenAuth, error) {
+	// token source keeps ctx for all later fetches
+	ctx = context.WithoutCancel(ctx)
+	if _, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); !ok {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, tokenClient)
+	}
+	ts, err := google.DefaultTokenSource(ctx, GCPSQLLoginScope)
+	if err != nil {
+		return nil, fmt.Errorf("loading GCP default credentials failed: %w", err)
+	}
+	return &TokenAuth{Conn: conn, TokenSource: ts}, nil
+}
+
+// NewAzureAuth creates TokenAuth for Azure Database for PostgreSQL using client credentials.
+// Empty authorityHost defaults to AzureAuthorityHost.
+func NewAzureAuth(conn ConnConfig, authorityHost, tenantID, clientID, clientSecret string) *TokenAuth {
+	if authorityHost == "" {
+		authorityHost = AzureAuthorityHost
+	}
+	cfg := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     authorityHost + "/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token",
+		Scopes:       []string{AzureOSSRDBMSScope},
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+	// cfg.TokenSource would cache tokens by itself which would prevent Invalidate from working.
+	return &TokenAuth{Conn: conn, TokenSource: tokenSourceFunc(func() (*oauth2.Token, error) {
+		return cfg.Token(context.WithValue(context.Background(), oauth2.HTTPClient, tokenClient))
+	})}
+}
+
+// NewAzureManagedIdentityAuth creates TokenAuth for Azure Database for PostgreSQL using managed identity.
+// Empty endpoint defaults to AzureIMDSEndpoint and empty clientID selects system assigned identity.
+func NewAzureManagedIdentityAuth(conn ConnConfig, endpoint, clientID string) *TokenAuth {
+	if endpoint == "" {
+		endpoint = AzureIMDSEndpoint
+	}
+	return &TokenAuth{
+		Conn: conn,
+		TokenSource: &azureIMDSTokenSource{
+			endpoint: endpoint,
+			clientID: clientID,
+			client:   tokenClient,
+		},
+	}
+}
+
+// DSN returns DSN with cached access token or fetches new token if cached one is about to expire.
+func (t *TokenAuth) DSN() (string, error) {
+	if t.TokenSource == nil {
+		return "", ErrMissingTokenSource
+	}
+
+	creds, err := t.cache.get(durationOrDefault(t.RefreshBefore, 5*time.Minute), func() (credentials, error) {
+		tok, err := t.TokenSource.Token()
+		if err != nil {
+			return credentials{}, fmt.Errorf("fetching access token failed: %w", err)
+		}
+		return credentials{User: t.Conn.User, Password: tok.AccessToken, Expiry: tok.Expiry}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return t.Conn.DSN(creds.User, creds.Password), nil
+}
+
+// Invalidate drops cached token so that next DSN call fetches a new one.
+func (t *TokenAuth) Invalidate() {
+	t.cache.invalidate()
+}
+
+// Expiry returns expiry time of the cached token.
+func (t *TokenAuth) Expiry() time.Time {
+	return t.cache.expiry()
+}
+
+// IsAuthErr checks if given error is know auth error.
+func (*TokenAuth) IsAuthErr(err error) bool {
+	return IsAuthenticationError(err)
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (fn tokenSourceFunc) Token() (*oauth2.Token, error) { return fn() }
+
+// azureIMDSTokenSource fetches managed identity tokens from Azure Instance Metadata Service.
+type azureIMDSTokenSource struct {
+	endpoint string
+	clientID string
+	client   httputil.HTTPClient
+}
+
+func (s *azureIMDSTokenSource) Token() (*oauth2.Token, error) {
+	q := url.Values{}
+	q.Set("api-version", "2018-02-01")
+	q.Set("resource", "https://ossrdbms-aad.database.windows.net")
+	if s.clientID != "" {
+		q.Set("client_id", s.clientID)
+	}
+
+	var out struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}
+	_, err := httputil.MakeRequest(context.Background(), httputil.Request{
+		Method:  http.MethodGet,
+		URL:     s.endpoint + "?" + q.Encode(),
+		Headers: map[string]string{"Metadata": "true"},
+		OKCode:  []int{http.StatusOK},
+	}, &out, s.client, httputil.Backoff{Duration: time.Second, MaxTries: 3})
+	if err != nil {
+		return nil, fmt.Errorf("fetching managed identity token failed: %w", err)
+	}
+
+	expiresOn, err := out.ExpiresOn.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expires_on in managed identity token: %w", err)
+	}
+	return &oauth2.Token{AccessToken: out.AccessToken, Expiry: time.Unix(expiresOn, 0)}, nil
+}
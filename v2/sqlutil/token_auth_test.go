@@ -0,0 +1,122 @@
+package sqlutil_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/sqlutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// tokenEndpoint is a stand-in for OAuth2 token endpoints which returns sequential tokens.
+func tokenEndpoint(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	calls := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("token-%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+			"expires_on":   fmt.Sprint(time.Now().Add(time.Duration(expiresIn) * time.Second).Unix()),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+func TestTokenAuthCaching(t *testing.T) {
+	srv, calls := tokenEndpoint(t, 3600)
+	conn := sqlutil.ConnConfig{Host: "localhost", Port: 5432, User: "app@tenant"}
+	a := sqlutil.NewAzureAuth(conn, srv.URL, "tenant", "client", "secret")
+
+	dsn, err := a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "password='token-1'")
+	require.Contains(t, dsn, "user='app@tenant'")
+
+	dsn, err = a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "password='token-1'")
+	require.EqualValues(t, 1, calls.Load())
+
+	a.Invalidate()
+	dsn, err = a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "password='token-2'")
+}
+
+func TestTokenAuthRefreshBeforeExpiry(t *testing.T) {
+	srv, calls := tokenEndpoint(t, 60)
+	a := sqlutil.NewAzureAuth(sqlutil.ConnConfig{}, srv.URL, "tenant", "client", "secret")
+
+	// Token expires within default refresh window so every call fetches a new one.
+	_, err := a.DSN()
+	require.NoError(t, err)
+	_, err = a.DSN()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, calls.Load())
+}
+
+func TestAzureManagedIdentityAuth(t *testing.T) {
+	srv, _ := tokenEndpoint(t, 3600)
+	a := sqlutil.NewAzureManagedIdentityAuth(sqlutil.ConnConfig{User: "identity"}, srv.URL, "client-id")
+
+	dsn, err := a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "password='token-1'")
+}
+
+func TestGCPAuth(t *testing.T) {
+	srv, _ := tokenEndpoint(t, 3600)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	credsJSON, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "app@project.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    srv.URL,
+	})
+	require.NoError(t, err)
+	credsFile := filepath.Join(t.TempDir(), "creds.json")
+	require.NoError(t, os.WriteFile(credsFile, credsJSON, 0o600))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credsFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a, err := sqlutil.NewGCPAuth(ctx, sqlutil.ConnConfig{User: "app@project.iam"})
+	require.NoError(t, err)
+	// ctx is only used for loading credentials
+	cancel()
+	dsn, err := a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "password='token-1'")
+}
+
+func TestTokenAuthErrors(t *testing.T) {
+	_, err := (&sqlutil.TokenAuth{}).DSN()
+	require.ErrorIs(t, err, sqlutil.ErrMissingTokenSource)
+
+	a := &sqlutil.TokenAuth{TokenSource: oauth2.ReuseTokenSource(nil, errTokenSource{})}
+	_, err = a.DSN()
+	require.Error(t, err)
+}
+
+type errTokenSource struct{}
+
+func (errTokenSource) Token() (*oauth2.Token, error) { return nil, fmt.Errorf("boom") }
@@ -0,0 +1,246 @@
+package sqlutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elisasre/go-common/v2/httputil"
+	"golang.org/x/sync/singleflight"
+)
+
+var ErrMissingVaultToken = errors.New("missing vault token")
+
+// VaultAuth implements AuthProvider using dynamic database credentials from Vault's database secrets engine.
+// Leases are renewed when possible and new credentials are requested when lease can't be extended anymore.
+type VaultAuth struct {
+	Conn ConnConfig
+	// Address of the Vault server, e.g. https://vault:8200.
+	Address string
+	// Token or TokenFile is used for authenticating to Vault, TokenFile is re-read on every request.
+	Token     string
+	TokenFile string
+	// Mount is the database secrets engine mount path, defaults to "database".
+	Mount string
+	Role  string
+	// RenewAfter is the fraction of lease duration after which lease is renewed, defaults to 2/3.
+	RenewAfter float64
+	Client     httputil.HTTPClient
+
+	lease *vaultLease
+	mu    sync.Mutex
+	group singleflight.Group
+}
+
+type vaultLease struct {
+	id        string
+	renewable bool
+	user      string
+	password  string
+	issued    time.Time
+	duration  time.Duration
+}
+
+func (l *vaultLease) renewAt(fraction float64) time.Time {
+	return l.issued.Add(time.Duration(float64(l.duration) * fraction))
+}
+
+func (l *vaultLease) expiry() time.Time {
+	return l.issued.Add(l.duration)
+}
+
+type vaultSecret struct {
+	LeaseID       string `json:"lease_id"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+	Data          struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"data"`
+}
+
+// DSN returns DSN with current lease credentials, renewing lease or requesting new credentials when needed.
+// Vault is called without holding the lock and concurrent callers share the same request.
+func (v *VaultAuth) DSN() (string, error) {
+	fraction := v.RenewAfter
+	if fraction <= 0 || fraction >= 1 {
+		fraction = 2.0 / 3.0
+	}
+
+	v.mu.Lock()
+	lease := v.lease
+	v.mu.Unlock()
+	if lease != nil && time.Now().Before(lease.renewAt(fraction)) {
+		return v.Conn.DSN(lease.user, lease.password), nil
+	}
+
+	res, err, _ := v.group.Do("lease", func() (any, error) {
+		return v.refresh(context.Background(), lease, fraction)
+	})
+	if err != nil {
+		return "", err
+	}
+	lease = res.(*vaultLease)
+	return v.Conn.DSN(lease.user, lease.password), nil
+}
+
+// refresh renews old lease or requests new credentials if it can't be renewed.
+// Old lease is revoked on best effort basis when it is replaced.
+func (v *VaultAuth) refresh(ctx context.Context, old *vaultLease, fraction float64) (*vaultLease, error) {
+	v.mu.Lock()
+	current := v.lease
+	v.mu.Unlock()
+	// another caller may have refreshed the lease after old was read
+	if current != nil && current != old && time.Now().Before(current.renewAt(fraction)) {
+		return current, nil
+	}
+
+	if old != nil {
+		if renewed, err := v.renew(ctx, old, fraction); err == nil {
+			v.setLease(old, renewed)
+			return renewed, nil
+		}
+	}
+
+	lease, err := v.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.setLease(old, lease)
+	if old != nil {
+		if err := v.revoke(ctx, old); err != nil {
+			slog.Warn("revoking vault lease failed", slog.String("lease_id", old.id), slog.String("error", err.Error()))
+		}
+	}
+	return lease, nil
+}
+
+// setLease replaces old with lease unless it was invalidated meanwhile.
+func (v *VaultAuth) setLease(old, lease *vaultLease) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.lease == old {
+		v.lease = lease
+	}
+}
+
+// Invalidate drops current lease so that next DSN call requests new credentials.
+func (v *VaultAuth) Invalidate() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lease = nil
+}
+
+// Expiry returns expiry time of the current lease or zero time if there isn't one.
+func (v *VaultAuth) Expiry() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.lease == nil {
+		return time.Time{}
+	}
+	return v.lease.expiry()
+}
+
+// IsAuthErr checks if given error is know auth error.
+func (*VaultAuth) IsAuthErr(err error) bool {
+	return IsAuthenticationError(err)
+}
+
+func (v *VaultAuth) fetch(ctx context.Context) (*vaultLease, error) {
+	mount := v.Mount
+	if mount == "" {
+		mount = "database"
+	}
+
+	var secret vaultSecret
+	issued := time.Now()
+	if err := v.request(ctx, http.MethodGet, "/v1/"+strings.Trim(mount, "/")+"/creds/"+v.Role, nil, &secret); err != nil {
+		return nil, fmt.Errorf("reading vault database credentials failed: %w", err)
+	}
+
+	return &vaultLease{
+		id:        secret.LeaseID,
+		renewable: secret.Renewable,
+		user:      secret.Data.Username,
+		password:  secret.Data.Password,
+		issued:    issued,
+		duration:  time.Duration(secret.LeaseDuration) * time.Second,
+	}, nil
+}
+
+// renew returns extended copy of lease. Error is returned if lease isn't renewable or the remaining duration
+// after renewal would already be past the renewal point, which happens when max TTL is reached.
+func (v *VaultAuth) renew(ctx context.Context, lease *vaultLease, fraction float64) (*vaultLease, error) {
+	if !lease.renewable {
+		return nil, errors.New("lease is not renewable")
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"lease_id":  lease.id,
+		"increment": int64(lease.duration.Seconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var secret vaultSecret
+	issued := time.Now()
+	if err := v.request(ctx, http.MethodPut, "/v1/sys/leases/renew", body, &secret); err != nil {
+		return nil, fmt.Errorf("renewing vault lease failed: %w", err)
+	}
+
+	duration := time.Duration(secret.LeaseDuration) * time.Second
+	if float64(duration) < float64(lease.duration)*(1-fraction) {
+		return nil, errors.New("lease max TTL reached")
+	}
+
+	renewed := *lease
+	renewed.issued = issued
+	renewed.duration = duration
+	return &renewed, nil
+}
+
+// revoke revokes lease so that its credentials don't stay valid until the lease expires.
+func (v *VaultAuth) revoke(ctx context.Context, lease *vaultLease) error {
+	body, err := json.Marshal(map[string]any{"lease_id": lease.id})
+	if err != nil {
+		return err
+	}
+	return v.request(ctx, http.MethodPut, "/v1/sys/leases/revoke", body, nil)
+}
+
+func (v *VaultAuth) request(ctx context.Context, method, path string, body []byte, out any) error {
+	token := v.Token
+	if v.TokenFile != "" {
+		data, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return fmt.Errorf("reading vault token file failed: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return ErrMissingVaultToken
+	}
+
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	_, err := httputil.MakeRequest(ctx, httputil.Request{
+		Method:         method,
+		URL:            strings.TrimSuffix(v.Address, "/") + path,
+		Body:           body,
+		Headers:        map[string]string{"X-Vault-Token": token, "Content-Type": "application/json"},
+		OKCode:         []int{http.StatusOK, http.StatusNoContent},
+		StopRetryCodes: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	}, out, client, httputil.Backoff{Duration: time.Second, MaxTries: 3})
+	return err
+}
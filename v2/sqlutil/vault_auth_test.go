@@ -0,0 +1,135 @@
+package sqlutil_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/sqlutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type vaultStub struct {
+	creds, renews atomic.Int32
+	revoked       atomic.Value
+	// renewDuration is returned as lease_duration on renewal.
+	renewDuration atomic.Int64
+}
+
+func (v *vaultStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "root" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/database/creds/app":
+		n := v.creds.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"lease_id":       fmt.Sprintf("database/creds/app/%d", n),
+			"lease_duration": 1,
+			"renewable":      true,
+			"data": map[string]string{
+				"username": fmt.Sprintf("v-app-%d", n),
+				"password": fmt.Sprintf("secret-%d", n),
+			},
+		})
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/sys/leases/renew":
+		var body struct {
+			LeaseID string `json:"lease_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		v.renews.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"lease_id":       body.LeaseID,
+			"lease_duration": v.renewDuration.Load(),
+			"renewable":      true,
+		})
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/sys/leases/revoke":
+		var body struct {
+			LeaseID string `json:"lease_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		v.revoked.Store(body.LeaseID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultAuth(t *testing.T) {
+	stub := &vaultStub{}
+	stub.renewDuration.Store(1)
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	a := &sqlutil.VaultAuth{
+		Conn:       sqlutil.ConnConfig{Host: "localhost", Port: 5432},
+		Address:    srv.URL,
+		Token:      "root",
+		Role:       "app",
+		RenewAfter: 0.01,
+	}
+
+	dsn, err := a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "user='v-app-1'")
+	require.Contains(t, dsn, "password='secret-1'")
+	require.WithinDuration(t, time.Now().Add(time.Second), a.Expiry(), 100*time.Millisecond)
+
+	// lease is renewed after renewal point and credentials stay the same
+	time.Sleep(20 * time.Millisecond)
+	dsn, err = a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "user='v-app-1'")
+	require.EqualValues(t, 1, stub.renews.Load())
+
+	// lease has reached max TTL so new credentials are requested
+	stub.renewDuration.Store(0)
+	time.Sleep(20 * time.Millisecond)
+	dsn, err = a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "user='v-app-2'")
+	require.EqualValues(t, 2, stub.renews.Load())
+	require.Equal(t, "database/creds/app/1", stub.revoked.Load(), "replaced lease is revoked")
+
+	a.Invalidate()
+	require.True(t, a.Expiry().IsZero())
+	dsn, err = a.DSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "user='v-app-3'")
+}
+
+func TestVaultAuthConcurrent(t *testing.T) {
+	stub := &vaultStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	a := &sqlutil.VaultAuth{Address: srv.URL, Token: "root", Role: "app"}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			_, err := a.DSN()
+			assert.NoError(t, err)
+		})
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, stub.creds.Load(), "concurrent callers share the request")
+}
+
+func TestVaultAuthErrors(t *testing.T) {
+	srv := httptest.NewServer(&vaultStub{})
+	defer srv.Close()
+
+	_, err := (&sqlutil.VaultAuth{Address: srv.URL, Role: "app"}).DSN()
+	require.ErrorIs(t, err, sqlutil.ErrMissingVaultToken)
+
+	_, err = (&sqlutil.VaultAuth{Address: srv.URL, Token: "wrong", Role: "app"}).DSN()
+	require.Error(t, err)
+}
@@ -0,0 +1,244 @@
+package sqlxutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultLagQuery returns replication lag of a Postgres standby in seconds.
+// Lag is reported as zero when all received WAL has been replayed so idle primaries don't make replicas look stale.
+const DefaultLagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+
+// Cluster routes queries between a primary and read replicas.
+// Reads are balanced round-robin between healthy replicas and fall back to primary when none are available.
+// Writes, prepared named statements and transactions always go to primary.
+type Cluster struct {
+	primary  *DB
+	replicas []*replica
+	next     atomic.Uint64
+
+	rywWindow time.Duration
+	maxLag    time.Duration
+	lagQuery  string
+}
+
+type replica struct {
+	db      *DB
+	healthy atomic.Bool
+}
+
+type ClusterOpt func(*Cluster)
+
+// WithReadYourWritesWindow sets how long reads are pinned to primary after a write, defaults to 5 seconds.
+// Pinning only applies to contexts created with WithReadYourWrites.
+func WithReadYourWritesWindow(d time.Duration) ClusterOpt {
+	return func(c *Cluster) {
+		c.rywWindow = d
+	}
+}
+
+// WithMaxReplicationLag sets replication lag after which replica is taken out of rotation, defaults to 10 seconds.
+func WithMaxReplicationLag(d time.Duration) ClusterOpt {
+	return func(c *Cluster) {
+		c.maxLag = d
+	}
+}
+
+// WithLagQuery overrides query used for measuring replication lag, defaults to DefaultLagQuery.
+// Query must return single numeric column containing lag in seconds.
+func WithLagQuery(query string) ClusterOpt {
+	return func(c *Cluster) {
+		c.lagQuery = query
+	}
+}
+
+// NewCluster creates Cluster from primary and replica connections.
+// All replicas are considered healthy until CheckReplicas says otherwise.
+func NewCluster(primary *DB, replicas []*DB, opts ...ClusterOpt) *Cluster {
+	c := &Cluster{
+		primary:   primary,
+		replicas:  make([]*replica, 0, len(replicas)),
+		rywWindow: 5 * time.Second,
+		maxLag:    10 * time.Second,
+		lagQuery:  DefaultLagQuery,
+	}
+	for _, db := range replicas {
+		r := &replica{db: db}
+		r.healthy.Store(true)
+		c.replicas = append(c.replicas, r)
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type rywKey struct{}
+
+type rywSession struct {
+	lastWrite atomic.Int64
+}
+
+// WithReadYourWrites returns context which remembers writes made through Cluster.
+// Reads using the returned context, or any context derived from it, go to primary for the read-your-writes window after a write.
+// Typically called once per incoming request.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(rywKey{}).(*rywSession); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, rywKey{}, &rywSession{})
+}
+
+type primaryKey struct{}
+
+// WithPrimary returns context which forces all reads made through Cluster to primary.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// Primary returns primary connection.
+func (c *Cluster) Primary() *DB { return c.primary }
+
+// Reader returns connection which should be used for reads with given context.
+// It can be passed to helpers like DynamicSelect which take Preparer.
+func (c *Cluster) Reader(ctx context.Context) *DB {
+	if c.pinned(ctx) {
+		return c.primary
+	}
+
+	n := uint64(len(c.replicas))
+	start := c.next.Add(1)
+	for i := range n {
+		if r := c.replicas[(start+i)%n]; r.healthy.Load() {
+			return r.db
+		}
+	}
+	return c.primary
+}
+
+func (c *Cluster) pinned(ctx context.Context) bool {
+	if forced, _ := ctx.Value(primaryKey{}).(bool); forced {
+		return true
+	}
+	s, ok := ctx.Value(rywKey{}).(*rywSession)
+	if !ok {
+		return false
+	}
+	last := s.lastWrite.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < c.rywWindow
+}
+
+// writer marks context as written and returns primary.
+func (c *Cluster) writer(ctx context.Context) *DB {
+	if s, ok := ctx.Value(rywKey{}).(*rywSession); ok {
+		s.lastWrite.Store(time.Now().UnixNano())
+	}
+	return c.primary
+}
+
+func (c *Cluster) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.Reader(ctx).QueryContext(ctx, query, args...)
+}
+
+func (c *Cluster) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	return c.Reader(ctx).QueryxContext(ctx, query, args...)
+}
+
+func (c *Cluster) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	return c.Reader(ctx).QueryRowxContext(ctx, query, args...)
+}
+
+func (c *Cluster) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	return c.Reader(ctx).SelectContext(ctx, dest, query, args...)
+}
+
+func (c *Cluster) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	return c.Reader(ctx).GetContext(ctx, dest, query, args...)
+}
+
+func (c *Cluster) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.writer(ctx).ExecContext(ctx, query, args...)
+}
+
+func (c *Cluster) NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error) {
+	return c.writer(ctx).NamedExecContext(ctx, query, arg)
+}
+
+// NamedQueryContext is executed on primary since named queries are commonly used with RETURNING.
+func (c *Cluster) NamedQueryContext(ctx context.Context, query string, arg any) (*sqlx.Rows, error) {
+	return c.writer(ctx).NamedQueryContext(ctx, query, arg)
+}
+
+// PrepareNamedContext prepares statement on primary which makes Cluster usable with CreateNamed.
+// Use Reader for read only statements.
+func (c *Cluster) PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error) {
+	return c.writer(ctx).PrepareNamedContext(ctx, query)
+}
+
+// instrumentation makes statements prepared through Cluster instrumented like the primary.
+func (c *Cluster) instrumentation() *Instrumentation { return c.primary.instrumentation() }
+
+// BeginTxx starts transaction on primary.
+func (c *Cluster) BeginTxx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	return c.writer(ctx).BeginTxx(ctx, opts)
+}
+
+// WithTx runs fn inside transaction on primary.
+// Context passed to fn forces reads made through Cluster to primary as well.
+func (c *Cluster) WithTx(ctx context.Context, fn func(ctx context.Context, tx *Tx) error) error {
+	return c.writer(ctx).WithTx(WithPrimary(ctx), fn)
+}
+
+// CheckReplicas measures replication lag of every replica and takes replicas
+// which are unreachable or lagging more than allowed out of rotation.
+// It never returns an error so it can be used directly with ticker module.
+func (c *Cluster) CheckReplicas(ctx context.Context) error {
+	for i, r := range c.replicas {
+		healthy, lag, err := c.checkReplica(ctx, r)
+		if prev := r.healthy.Swap(healthy); prev == healthy {
+			continue
+		}
+
+		attrs := []slog.Attr{slog.Int("replica", i), slog.Duration("lag", lag)}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		if healthy {
+			ctxlog.LogAttrs(ctx, slog.LevelInfo, "replica back in rotation", attrs...)
+		} else {
+			ctxlog.LogAttrs(ctx, slog.LevelWarn, "replica taken out of rotation", attrs...)
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) checkReplica(ctx context.Context, r *replica) (bool, time.Duration, error) {
+	var seconds float64
+	// underlying sqlx.DB is used so health checks don't pollute query metrics
+	if err := r.db.DB.GetContext(ctx, &seconds, c.lagQuery); err != nil {
+		return false, 0, fmt.Errorf("measuring replication lag failed: %w", err)
+	}
+	lag := time.Duration(seconds * float64(time.Second))
+	return lag <= c.maxLag, lag, nil
+}
+
+// HealthyReplicas returns number of replicas currently in rotation.
+func (c *Cluster) HealthyReplicas() int {
+	n := 0
+	for _, r := range c.replicas {
+		if r.healthy.Load() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,224 @@
+package sqlxutil_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/sqlxutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func init() {
+	sql.Register("sqlxutil-cluster-fake", clusterDriver{})
+}
+
+func TestClusterRouting(t *testing.T) {
+	reset := newClusterState()
+	defer reset()
+	c := newTestCluster(t, sqlxutil.WithReadYourWritesWindow(time.Hour))
+	ctx := context.Background()
+
+	var v int64
+	for range 4 {
+		require.NoError(t, c.GetContext(ctx, &v, "SELECT 1"))
+	}
+	require.Equal(t, map[string]int{"replica1": 2, "replica2": 2}, state.queries())
+
+	_, err := c.ExecContext(ctx, "UPDATE users SET name = 'foo'")
+	require.NoError(t, err)
+	err = c.WithTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
+		// reads inside transaction are forced to primary
+		return c.GetContext(ctx, &v, "SELECT 1")
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, state.queries()["primary"])
+
+	// writes without read-your-writes context don't pin reads
+	require.NoError(t, c.GetContext(ctx, &v, "SELECT 1"))
+	require.Equal(t, 2, state.queries()["primary"])
+
+	ctx = sqlxutil.WithReadYourWrites(ctx)
+	require.NoError(t, c.GetContext(ctx, &v, "SELECT 1"))
+	require.Equal(t, 2, state.queries()["primary"])
+	_, err = c.ExecContext(ctx, "UPDATE users SET name = 'bar'")
+	require.NoError(t, err)
+	require.NoError(t, c.GetContext(ctx, &v, "SELECT 1"))
+	require.Equal(t, 4, state.queries()["primary"])
+	require.Same(t, c.Primary(), c.Reader(sqlxutil.WithPrimary(context.Background())))
+}
+
+func TestClusterReadYourWritesWindow(t *testing.T) {
+	reset := newClusterState()
+	defer reset()
+	c := newTestCluster(t, sqlxutil.WithReadYourWritesWindow(10*time.Millisecond))
+	ctx := sqlxutil.WithReadYourWrites(context.Background())
+
+	_, err := c.ExecContext(ctx, "UPDATE users SET name = 'foo'")
+	require.NoError(t, err)
+	require.Same(t, c.Primary(), c.Reader(ctx))
+
+	time.Sleep(20 * time.Millisecond)
+	require.NotSame(t, c.Primary(), c.Reader(ctx))
+}
+
+func TestClusterCheckReplicas(t *testing.T) {
+	reset := newClusterState()
+	defer reset()
+	c := newTestCluster(t, sqlxutil.WithMaxReplicationLag(5*time.Second))
+	ctx := context.Background()
+
+	state.setLag("replica1", 30)
+	require.NoError(t, c.CheckReplicas(ctx))
+	require.Equal(t, 1, c.HealthyReplicas())
+	for range 3 {
+		require.Equal(t, "replica2", dsnOf(t, c.Reader(ctx)))
+	}
+
+	state.setLag("replica2", -1) // unreachable
+	require.NoError(t, c.CheckReplicas(ctx))
+	require.Equal(t, 0, c.HealthyReplicas())
+	require.Same(t, c.Primary(), c.Reader(ctx), "primary is used when no replicas are healthy")
+
+	state.setLag("replica1", 0)
+	state.setLag("replica2", 1)
+	require.NoError(t, c.CheckReplicas(ctx))
+	require.Equal(t, 2, c.HealthyReplicas())
+}
+
+func TestClusterCreateNamed(t *testing.T) {
+	reset := newClusterState()
+	defer reset()
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	primary := sqlx.MustOpen("sqlxutil-cluster-fake", "primary")
+	t.Cleanup(func() { _ = primary.Close() })
+	c := sqlxutil.NewCluster(sqlxutil.Instrument(primary, sqlxutil.WithTracerProvider(tp)), nil)
+
+	m := &sqlxutil.Model{}
+	require.NoError(t, sqlxutil.CreateNamed(context.Background(), c, m, "INSERT INTO users (created_at) VALUES (:created_at) RETURNING id"))
+	require.Equal(t, uint64(1), m.ID)
+	require.Equal(t, 1, state.queries()["primary"])
+	require.Len(t, sr.Ended(), 1)
+	require.Equal(t, "INSERT users", sr.Ended()[0].Name())
+}
+
+func newTestCluster(t *testing.T, opts ...sqlxutil.ClusterOpt) *sqlxutil.Cluster {
+	t.Helper()
+	open := func(name string) *sqlxutil.DB {
+		db := sqlx.MustOpen("sqlxutil-cluster-fake", name)
+		t.Cleanup(func() { _ = db.Close() })
+		return sqlxutil.Instrument(db)
+	}
+	return sqlxutil.NewCluster(open("primary"), []*sqlxutil.DB{open("replica1"), open("replica2")}, opts...)
+}
+
+func dsnOf(t *testing.T, db *sqlxutil.DB) string {
+	t.Helper()
+	var v int64
+	before := state.queries()
+	require.NoError(t, db.GetContext(context.Background(), &v, "SELECT 1"))
+	for name, n := range state.queries() {
+		if before[name] != n {
+			return name
+		}
+	}
+	return ""
+}
+
+// clusterState records queries per DSN and returns configured replication lag for every query.
+type clusterState struct {
+	mu     sync.Mutex
+	counts map[string]int
+	lags   map[string]int64
+}
+
+var state *clusterState
+
+func newClusterState() func() {
+	state = &clusterState{counts: map[string]int{}, lags: map[string]int64{}}
+	return func() { state = nil }
+}
+
+func (s *clusterState) queries() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int, len(s.counts))
+	for k, v := range s.counts {
+		out[k] = v
+	}
+	return out
+}
+
+func (s *clusterState) setLag(dsn string, lag int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lags[dsn] = lag
+}
+
+func (s *clusterState) query(dsn, query string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lag := s.lags[dsn]
+	if query == sqlxutil.DefaultLagQuery {
+		if lag < 0 {
+			return 0, errors.New("connection refused")
+		}
+		return lag, nil
+	}
+	s.counts[dsn]++
+	return 1, nil
+}
+
+type clusterDriver struct{}
+
+func (clusterDriver) Open(dsn string) (driver.Conn, error) { return clusterConn{dsn: dsn}, nil }
+
+type clusterConn struct{ dsn string }
+
+func (c clusterConn) Prepare(q string) (driver.Stmt, error) {
+	return clusterStmt{dsn: c.dsn, query: q}, nil
+}
+func (clusterConn) Close() error              { return nil }
+func (clusterConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type clusterStmt struct{ dsn, query string }
+
+func (clusterStmt) Close() error  { return nil }
+func (clusterStmt) NumInput() int { return -1 }
+func (s clusterStmt) Exec([]driver.Value) (driver.Result, error) {
+	_, err := state.query(s.dsn, s.query)
+	return driver.RowsAffected(1), err
+}
+
+func (s clusterStmt) Query([]driver.Value) (driver.Rows, error) {
+	v, err := state.query(s.dsn, s.query)
+	if err != nil {
+		return nil, err
+	}
+	return &valueRows{v: v}, nil
+}
+
+type valueRows struct {
+	v    int64
+	done bool
+}
+
+func (*valueRows) Columns() []string { return []string{"v"} }
+func (*valueRows) Close() error      { return nil }
+func (r *valueRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.v
+	return nil
+}
@@ -0,0 +1,575 @@
+package sqlxutil
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/elisasre/go-common/v2/sqlxutil"
+
+// QueryDuration is the default latency histogram used by instrumented databases.
+// It must be registered, for example with metrics.New(sqlxutil.QueryDuration), to be exposed.
+var QueryDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:      "query_duration_seconds",
+		Subsystem: "db",
+		Help:      "The SQL query latencies in seconds, partitioned by operation and table.",
+	},
+	[]string{"operation", "table"},
+)
+
+// Instrumentation holds tracing, metrics and slow query logging configuration shared by DB, Tx and NamedStmt.
+type Instrumentation struct {
+	tracer        trace.Tracer
+	duration      *prometheus.HistogramVec
+	slowThreshold time.Duration
+	system        attribute.KeyValue
+}
+
+type InstrumentOpt func(*Instrumentation)
+
+// WithTracerProvider sets trace.TracerProvider used for creating query spans, defaults to otel.GetTracerProvider().
+func WithTracerProvider(tp trace.TracerProvider) InstrumentOpt {
+	return func(i *Instrumentation) {
+		i.tracer = tp.Tracer(instrumentationName)
+	}
+}
+
+// WithHistogram overrides QueryDuration, histogram must have operation and table labels.
+func WithHistogram(h *prometheus.HistogramVec) InstrumentOpt {
+	return func(i *Instrumentation) {
+		i.duration = h
+	}
+}
+
+// WithSlowQueryThreshold sets the duration after which queries are logged as slow.
+// Zero disables slow query logging.
+func WithSlowQueryThreshold(d time.Duration) InstrumentOpt {
+	return func(i *Instrumentation) {
+		i.slowThreshold = d
+	}
+}
+
+// WithDBSystem sets db.system span attribute, defaults to postgresql.
+func WithDBSystem(system string) InstrumentOpt {
+	return func(i *Instrumentation) {
+		i.system = semconv.DBSystemKey.String(system)
+	}
+}
+
+// NewInstrumentation creates Instrumentation with given options.
+func NewInstrumentation(opts ...InstrumentOpt) *Instrumentation {
+	i := &Instrumentation{
+		tracer:        otel.GetTracerProvider().Tracer(instrumentationName),
+		duration:      QueryDuration,
+		slowThreshold: time.Second,
+		system:        semconv.DBSystemPostgreSQL,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// observe starts span for query and returns function which must be called with the query result.
+func (i *Instrumentation) observe(ctx context.Context, query string) (context.Context, func(error)) {
+	normalized := NormalizeQuery(query)
+	op, table := parseQuery(normalized)
+	spanName := op
+	if table != "" {
+		spanName = op + " " + table
+	}
+
+	ctx, span := i.tracer.Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			i.system,
+			semconv.DBQueryText(normalized),
+			semconv.DBOperationName(op),
+			semconv.DBCollectionName(table),
+		),
+	)
+	start := time.Now()
+
+	return ctx, func(err error) {
+		elapsed := time.Since(start)
+		defer span.End()
+
+		i.duration.WithLabelValues(op, table).Observe(elapsed.Seconds())
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		if i.slowThreshold > 0 && elapsed >= i.slowThreshold {
+			ctxlog.LogAttrs(ctx, slog.LevelWarn, "slow query",
+				slog.String("query", normalized),
+				slog.String("operation", op),
+				slog.String("table", table),
+				slog.Duration("duration", elapsed),
+			)
+		}
+	}
+}
+
+var (
+	stringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numericLiteral = regexp.MustCompile(`\$?\b\d+(?:\.\d+)?\b`)
+	whitespace     = regexp.MustCompile(`\s+`)
+	tableAfter     = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE|JOIN)\s+("?[\w.]+"?)`)
+)
+
+// NormalizeQuery collapses whitespace and replaces string and numeric literals with '?'
+// so that queries can be used as low cardinality span attributes.
+func NormalizeQuery(query string) string {
+	q := stringLiteral.ReplaceAllString(query, "?")
+	q = numericLiteral.ReplaceAllStringFunc(q, func(m string) string {
+		// $1 style placeholders are kept as they are not literals
+		if strings.HasPrefix(m, "$") {
+			return m
+		}
+		return "?"
+	})
+	q = whitespace.ReplaceAllString(q, " ")
+	return strings.TrimSpace(q)
+}
+
+// parseQuery extracts operation and primary table from normalized query.
+func parseQuery(query string) (op, table string) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "UNKNOWN", ""
+	}
+
+	op = strings.ToUpper(fields[0])
+	if op == "WITH" {
+		// CTEs are named after the statement following the last CTE definition.
+		for _, f := range fields[1:] {
+			switch u := strings.ToUpper(f); u {
+			case "SELECT", "INSERT", "UPDATE", "DELETE":
+				op = u
+			}
+		}
+	}
+
+	if m := tableAfter.FindStringSubmatch(query); m != nil {
+		table = strings.Trim(m[1], `"`)
+	}
+	return op, table
+}
+
+// DB wraps sqlx.DB with tracing, metrics and slow query logging. All query methods of sqlx.DB
+// are instrumented, with and without context. Transactions started with Begin or BeginTx and
+// statements prepared with Prepare, Preparex or PrepareNamed return the underlying types which
+// aren't instrumented, use BeginTxx and NamedStmt instead.
+type DB struct {
+	*sqlx.DB
+	inst *Instrumentation
+}
+
+// Instrument wraps db so that every query creates a span, records latency and logs slow queries.
+func Instrument(db *sqlx.DB, opts ...InstrumentOpt) *DB {
+	return &DB{DB: db, inst: NewInstrumentation(opts...)}
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (res sql.Result, err error) {
+	ctx, done := db.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (rows *sql.Rows, err error) {
+	ctx, done := db.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return db.DB.QueryContext(ctx, query, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (db *DB) QueryxContext(ctx context.Context, query string, args ...any) (rows *sqlx.Rows, err error) {
+	ctx, done := db.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return db.DB.QueryxContext(ctx, query, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (db *DB) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	ctx, done := db.inst.observe(ctx, query)
+	row := db.DB.QueryRowxContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+func (db *DB) SelectContext(ctx context.Context, dest any, query string, args ...any) (err error) {
+	ctx, done := db.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return db.DB.SelectContext(ctx, dest, query, args...)
+}
+
+func (db *DB) GetContext(ctx context.Context, dest any, query string, args ...any) (err error) {
+	ctx, done := db.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return db.DB.GetContext(ctx, dest, query, args...)
+}
+
+func (db *DB) NamedExecContext(ctx context.Context, query string, arg any) (res sql.Result, err error) {
+	ctx, done := db.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return db.DB.NamedExecContext(ctx, query, arg)
+}
+
+func (db *DB) NamedQueryContext(ctx context.Context, query string, arg any) (rows *sqlx.Rows, err error) {
+	ctx, done := db.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return db.DB.NamedQueryContext(ctx, query, arg) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, done := db.inst.observe(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+func (db *DB) MustExecContext(ctx context.Context, query string, args ...any) sql.Result {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (db *DB) Queryx(query string, args ...any) (*sqlx.Rows, error) {
+	return db.QueryxContext(context.Background(), query, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+func (db *DB) QueryRowx(query string, args ...any) *sqlx.Row {
+	return db.QueryRowxContext(context.Background(), query, args...)
+}
+
+func (db *DB) Select(dest any, query string, args ...any) error {
+	return db.SelectContext(context.Background(), dest, query, args...)
+}
+
+func (db *DB) Get(dest any, query string, args ...any) error {
+	return db.GetContext(context.Background(), dest, query, args...)
+}
+
+func (db *DB) NamedExec(query string, arg any) (sql.Result, error) {
+	return db.NamedExecContext(context.Background(), query, arg)
+}
+
+func (db *DB) NamedQuery(query string, arg any) (*sqlx.Rows, error) {
+	return db.NamedQueryContext(context.Background(), query, arg) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (db *DB) MustExec(query string, args ...any) sql.Result {
+	return db.MustExecContext(context.Background(), query, args...)
+}
+
+// BeginTxx begins instrumented transaction.
+func (db *DB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, inst: db.inst}, nil
+}
+
+// Beginx begins instrumented transaction.
+func (db *DB) Beginx() (*Tx, error) {
+	return db.BeginTxx(context.Background(), nil)
+}
+
+// MustBeginTx begins instrumented transaction and panics on error.
+func (db *DB) MustBeginTx(ctx context.Context, opts *sql.TxOptions) *Tx {
+	tx, err := db.BeginTxx(ctx, opts)
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+// MustBegin begins instrumented transaction and panics on error.
+func (db *DB) MustBegin() *Tx {
+	return db.MustBeginTx(context.Background(), nil)
+}
+
+// NamedStmt wraps prepared statement with the same instrumentation as db.
+// Statements prepared through CreateNamed, DynamicSelect and DynamicGet are wrapped automatically.
+func (db *DB) NamedStmt(stmt *sqlx.NamedStmt) *NamedStmt {
+	return &NamedStmt{NamedStmt: stmt, inst: db.inst}
+}
+
+// WithTx is the instrumented counterpart of package level WithTx.
+func (db *DB) WithTx(ctx context.Context, fn func(ctx context.Context, tx *Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction failed: %w", err)
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rolling back transaction failed: %w", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction failed: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) instrumentation() *Instrumentation { return db.inst }
+
+// Tx wraps sqlx.Tx with tracing, metrics and slow query logging. All query methods of sqlx.Tx
+// are instrumented, with and without context. Statements prepared with Prepare, Preparex or
+// PrepareNamed aren't instrumented, use NamedStmt to wrap them.
+type Tx struct {
+	*sqlx.Tx
+	inst *Instrumentation
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (res sql.Result, err error) {
+	ctx, done := tx.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (rows *sql.Rows, err error) {
+	ctx, done := tx.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return tx.Tx.QueryContext(ctx, query, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (tx *Tx) QueryxContext(ctx context.Context, query string, args ...any) (rows *sqlx.Rows, err error) {
+	ctx, done := tx.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return tx.Tx.QueryxContext(ctx, query, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (tx *Tx) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	ctx, done := tx.inst.observe(ctx, query)
+	row := tx.Tx.QueryRowxContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+func (tx *Tx) SelectContext(ctx context.Context, dest any, query string, args ...any) (err error) {
+	ctx, done := tx.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return tx.Tx.SelectContext(ctx, dest, query, args...)
+}
+
+func (tx *Tx) GetContext(ctx context.Context, dest any, query string, args ...any) (err error) {
+	ctx, done := tx.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return tx.Tx.GetContext(ctx, dest, query, args...)
+}
+
+func (tx *Tx) NamedExecContext(ctx context.Context, query string, arg any) (res sql.Result, err error) {
+	ctx, done := tx.inst.observe(ctx, query)
+	defer func() { done(err) }()
+	return tx.Tx.NamedExecContext(ctx, query, arg)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, done := tx.inst.observe(ctx, query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+func (tx *Tx) MustExecContext(ctx context.Context, query string, args ...any) sql.Result {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.QueryContext(context.Background(), query, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (tx *Tx) Queryx(query string, args ...any) (*sqlx.Rows, error) {
+	return tx.QueryxContext(context.Background(), query, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.QueryRowContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryRowx(query string, args ...any) *sqlx.Row {
+	return tx.QueryRowxContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) Select(dest any, query string, args ...any) error {
+	return tx.SelectContext(context.Background(), dest, query, args...)
+}
+
+func (tx *Tx) Get(dest any, query string, args ...any) error {
+	return tx.GetContext(context.Background(), dest, query, args...)
+}
+
+func (tx *Tx) NamedExec(query string, arg any) (sql.Result, error) {
+	return tx.NamedExecContext(context.Background(), query, arg)
+}
+
+func (tx *Tx) NamedQuery(query string, arg any) (rows *sqlx.Rows, err error) {
+	ctx, done := tx.inst.observe(context.Background(), query)
+	defer func() { done(err) }()
+	// sqlx.Tx has no NamedQueryContext, binding is done here so that the query can be traced
+	q, args, err := tx.BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Tx.QueryxContext(ctx, q, args...) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (tx *Tx) MustExec(query string, args ...any) sql.Result {
+	return tx.MustExecContext(context.Background(), query, args...)
+}
+
+// NamedStmt wraps prepared statement with the same instrumentation as tx.
+func (tx *Tx) NamedStmt(stmt *sqlx.NamedStmt) *NamedStmt {
+	return &NamedStmt{NamedStmt: stmt, inst: tx.inst}
+}
+
+func (tx *Tx) instrumentation() *Instrumentation { return tx.inst }
+
+// NamedStmt wraps sqlx.NamedStmt with tracing, metrics and slow query logging, with and without context.
+type NamedStmt struct {
+	*sqlx.NamedStmt
+	inst *Instrumentation
+}
+
+func (n *NamedStmt) ExecContext(ctx context.Context, arg any) (res sql.Result, err error) {
+	ctx, done := n.inst.observe(ctx, n.QueryString)
+	defer func() { done(err) }()
+	return n.NamedStmt.ExecContext(ctx, arg)
+}
+
+func (n *NamedStmt) QueryContext(ctx context.Context, arg any) (rows *sql.Rows, err error) {
+	ctx, done := n.inst.observe(ctx, n.QueryString)
+	defer func() { done(err) }()
+	return n.NamedStmt.QueryContext(ctx, arg) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (n *NamedStmt) QueryxContext(ctx context.Context, arg any) (rows *sqlx.Rows, err error) {
+	ctx, done := n.inst.observe(ctx, n.QueryString)
+	defer func() { done(err) }()
+	return n.NamedStmt.QueryxContext(ctx, arg) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (n *NamedStmt) QueryRowxContext(ctx context.Context, arg any) *sqlx.Row {
+	ctx, done := n.inst.observe(ctx, n.QueryString)
+	row := n.NamedStmt.QueryRowxContext(ctx, arg)
+	done(row.Err())
+	return row
+}
+
+func (n *NamedStmt) SelectContext(ctx context.Context, dest any, arg any) (err error) {
+	ctx, done := n.inst.observe(ctx, n.QueryString)
+	defer func() { done(err) }()
+	return n.NamedStmt.SelectContext(ctx, dest, arg)
+}
+
+func (n *NamedStmt) GetContext(ctx context.Context, dest any, arg any) (err error) {
+	ctx, done := n.inst.observe(ctx, n.QueryString)
+	defer func() { done(err) }()
+	return n.NamedStmt.GetContext(ctx, dest, arg)
+}
+
+func (n *NamedStmt) QueryRowContext(ctx context.Context, arg any) *sqlx.Row {
+	return n.QueryRowxContext(ctx, arg)
+}
+
+func (n *NamedStmt) MustExecContext(ctx context.Context, arg any) sql.Result {
+	res, err := n.ExecContext(ctx, arg)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (n *NamedStmt) Exec(arg any) (sql.Result, error) {
+	return n.ExecContext(context.Background(), arg)
+}
+
+func (n *NamedStmt) Query(arg any) (*sql.Rows, error) {
+	return n.QueryContext(context.Background(), arg) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (n *NamedStmt) Queryx(arg any) (*sqlx.Rows, error) {
+	return n.QueryxContext(context.Background(), arg) //nolint:sqlclosecheck // rows are closed by the caller
+}
+
+func (n *NamedStmt) QueryRow(arg any) *sqlx.Row {
+	return n.QueryRowxContext(context.Background(), arg)
+}
+
+func (n *NamedStmt) QueryRowx(arg any) *sqlx.Row {
+	return n.QueryRowxContext(context.Background(), arg)
+}
+
+func (n *NamedStmt) Select(dest any, arg any) error {
+	return n.SelectContext(context.Background(), dest, arg)
+}
+
+func (n *NamedStmt) Get(dest any, arg any) error {
+	return n.GetContext(context.Background(), dest, arg)
+}
+
+func (n *NamedStmt) MustExec(arg any) sql.Result {
+	return n.MustExecContext(context.Background(), arg)
+}
+
+// namedStatement is the subset of NamedStmt used by package level helpers.
+type namedStatement interface {
+	GetContext(ctx context.Context, dest any, arg any) error
+	SelectContext(ctx context.Context, dest any, arg any) error
+	Close() error
+}
+
+type instrumented interface {
+	instrumentation() *Instrumentation
+}
+
+// wrapNamed instruments stmt if it was prepared using instrumented DB or Tx.
+func wrapNamed(p any, stmt *sqlx.NamedStmt) namedStatement {
+	if i, ok := p.(instrumented); ok {
+		return &NamedStmt{NamedStmt: stmt, inst: i.instrumentation()}
+	}
+	return stmt
+}
@@ -0,0 +1,144 @@
+package sqlxutil_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/elisasre/go-common/v2/ctxlog"
+	"github.com/elisasre/go-common/v2/sqlxutil"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func init() {
+	sql.Register("sqlxutil-fake", fakeDriver{})
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "SELECT * FROM users WHERE id = 42",
+			expected: "SELECT * FROM users WHERE id = ?",
+		},
+		{
+			query: `
+				SELECT name
+				FROM users
+				WHERE email = 'it''s@example.com' AND score > 1.5`,
+			expected: "SELECT name FROM users WHERE email = ? AND score > ?",
+		},
+		{
+			query:    "INSERT INTO jwt_keys (k_id) VALUES ($1)",
+			expected: "INSERT INTO jwt_keys (k_id) VALUES ($1)",
+		},
+		{
+			query:    "SELECT * FROM users WHERE id = $12 LIMIT 10",
+			expected: "SELECT * FROM users WHERE id = $12 LIMIT ?",
+		},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, sqlxutil.NormalizeQuery(tc.query))
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	hist := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_query_duration_seconds"}, []string{"operation", "table"})
+
+	buf := &bytes.Buffer{}
+	ctx := ctxlog.WithLogger(context.Background(), slog.New(slog.NewJSONHandler(buf, nil)))
+
+	db := sqlxutil.Instrument(sqlx.MustOpen("sqlxutil-fake", ""),
+		sqlxutil.WithTracerProvider(tp),
+		sqlxutil.WithHistogram(hist),
+		sqlxutil.WithSlowQueryThreshold(time.Nanosecond),
+	)
+
+	var ids []uint64
+	require.NoError(t, db.SelectContext(ctx, &ids, "SELECT id FROM users WHERE name = 'foo'"))
+	require.Equal(t, []uint64{1}, ids)
+
+	err := db.WithTx(ctx, func(ctx context.Context, tx *sqlxutil.Tx) error {
+		m := &sqlxutil.Model{}
+		if err := sqlxutil.CreateNamed(ctx, tx, m, "INSERT INTO users (created_at) VALUES (:created_at) RETURNING id"); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE "users" SET name = $1`, "bar")
+		return err
+	})
+	require.NoError(t, err)
+
+	// methods without context are instrumented as well
+	var id uint64
+	require.NoError(t, db.Get(&id, "SELECT id FROM users WHERE id = $1", 1))
+	db.MustExec("DELETE FROM users WHERE id = $1", 1)
+	tx := db.MustBegin()
+	_, err = tx.NamedExec("UPDATE users SET name = :name", map[string]any{"name": "baz"})
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	names := make([]string, 0, len(sr.Ended()))
+	for _, s := range sr.Ended() {
+		names = append(names, s.Name())
+	}
+	assert.Equal(t, []string{"SELECT users", "INSERT users", "UPDATE users", "SELECT users", "DELETE users", "UPDATE users"}, names)
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(hist))
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, mfs, 1)
+	assert.Len(t, mfs[0].GetMetric(), 4)
+	assert.Contains(t, buf.String(), `"msg":"slow query"`)
+	assert.Contains(t, buf.String(), `"query":"SELECT id FROM users WHERE name = ?"`)
+}
+
+// fakeDriver returns single row with value 1 for every query.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return &fakeRows{}, nil }
+
+type fakeRows struct{ done bool }
+
+func (*fakeRows) Columns() []string { return []string{"id"} }
+func (*fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
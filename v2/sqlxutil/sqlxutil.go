@@ -80,10 +80,11 @@ type Creator interface {
 
 func CreateNamed(ctx context.Context, c Creator, m Creatable, query string) error {
 	m.Create()
-	stmt, err := c.PrepareNamedContext(ctx, query)
+	prepared, err := c.PrepareNamedContext(ctx, query)
 	if err != nil {
 		return fmt.Errorf("preparing create statement failed: %w", err)
 	}
+	stmt := wrapNamed(c, prepared)
 	defer func() {
 		if err := stmt.Close(); err != nil {
 			slog.Error("closing create statement failed", slog.String("error", err.Error()))
@@ -156,10 +157,11 @@ func (dq *DynamicQuery) Copy() DynamicQuery {
 
 func DynamicSelect(ctx context.Context, p Preparer, baseQuery string, dq DynamicQuery, args map[string]any, target any) error {
 	q := buildQuery(baseQuery, dq)
-	stmt, err := p.PrepareNamedContext(ctx, q)
+	prepared, err := p.PrepareNamedContext(ctx, q)
 	if err != nil {
 		return err
 	}
+	stmt := wrapNamed(p, prepared)
 	defer stmt.Close()
 
 	if err := stmt.SelectContext(ctx, target, args); err != nil {
@@ -170,10 +172,11 @@ func DynamicSelect(ctx context.Context, p Preparer, baseQuery string, dq Dynamic
 
 func DynamicGet(ctx context.Context, p Preparer, baseQuery string, dq DynamicQuery, args map[string]any, target any) error {
 	q := buildQuery(baseQuery, dq)
-	stmt, err := p.PrepareNamedContext(ctx, q)
+	prepared, err := p.PrepareNamedContext(ctx, q)
 	if err != nil {
 		return err
 	}
+	stmt := wrapNamed(p, prepared)
 	defer stmt.Close()
 
 	if err := stmt.GetContext(ctx, target, args); err != nil {